@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-bolo/bolo"
 	"github.com/go-bolo/emails"
@@ -30,6 +31,7 @@ type SignupBody struct {
 	Username     string `json:"username,omitempty" validate:"required"`
 	Email        string `json:"email,omitempty" validate:"required,email"`
 	ConfirmEmail string `json:"confirmEmail,omitempty" validate:"required,email,eqcsfield=Email"`
+	Password     string `json:"password,omitempty" validate:"required"`
 	DisplayName  string `json:"displayName,omitempty" validate:"required"`
 	FullName     string `json:"fullName,omitempty"`
 	Biography    string `json:"biography,omitempty"`
@@ -45,13 +47,17 @@ func (sb *SignupBody) ToJSON() string {
 	return string(jsonString)
 }
 
+// SignupFailedMessage is the signup error for usernames and emails already registered,
+// then the response does not show if the account exists
+var SignupFailedMessage = "auth.register.failed"
+
 type SignupResponse struct {
 	User   *user_models.UserModel       `json:"user"`
 	Errors []*bolo.ValidationFieldError `json:"errors,omitempty"`
 }
 
 type SetPasswordBody struct {
-	NewPassword  string `json:"newPassword,omitempty" validate:"required,min=3"`
+	NewPassword  string `json:"newPassword,omitempty" validate:"required"`
 	RNewPassword string `json:"rNewPassword,omitempty" validate:"required,eqfield=NewPassword"`
 }
 
@@ -62,7 +68,7 @@ func (b *SetPasswordBody) ToJSON() string {
 
 type ChangeOwnPasswordBody struct {
 	Password     string `json:"password,omitempty" form:"password"`
-	NewPassword  string `json:"newPassword,omitempty" form:"newPassword" validate:"required,min=3"`
+	NewPassword  string `json:"newPassword,omitempty" form:"newPassword" validate:"required"`
 	RNewPassword string `json:"rNewPassword,omitempty" form:"rNewPassword" validate:"required,eqfield=NewPassword"`
 }
 
@@ -74,7 +80,12 @@ func (b *ChangeOwnPasswordBody) ToJSON() string {
 func (ctl *AuthController) GetCurrentUser(c echo.Context) error {
 	ctx := c.(*bolo.RequestContext)
 	if ctx.IsAuthenticated {
-		return c.JSON(http.StatusOK, user_models.NewUserModelPublicFromUserModel(ctx.AuthenticatedUser.(*user_models.UserModel)))
+		record, err := getAuthenticatedUserRecord(ctx)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, user_models.NewUserModelPublicFromUserModel(record))
 	} else {
 		return c.JSON(http.StatusOK, map[string]string{})
 	}
@@ -112,8 +123,54 @@ func (ctl *AuthController) Signup(c echo.Context) error {
 	}
 
 	if !body.AcceptTerms {
-		// TODO! return a bad request
-		return errors.New("auth.register.acceptTerms.required")
+		resp := bolo.ValidationResponse{
+			Errors: []*bolo.ValidationFieldError{
+				{
+					Field:   "acceptTerms",
+					Message: "auth.register.acceptTerms.required",
+				},
+			},
+		}
+		return c.JSON(http.StatusBadRequest, resp)
+	}
+
+	passwordErrors, err := ValidateNewPassword(ctl.App, &user_models.UserModel{
+		Username: body.Username,
+		Email:    body.Email,
+	}, "password", body.Password)
+	if err != nil {
+		return err
+	}
+
+	if len(passwordErrors) > 0 {
+		return c.JSON(http.StatusBadRequest, bolo.ValidationResponse{Errors: passwordErrors})
+	}
+
+	var existentUser user_models.UserModel
+	err = user_models.UserFindOneByUsername(body.Username, &existentUser)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.Wrap(err, "AuthController.Signup error on find user by username")
+	}
+
+	if existentUser.ID == 0 {
+		err = user_models.UserFindOneByUsername(body.Email, &existentUser)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.Wrap(err, "AuthController.Signup error on find user by email")
+		}
+	}
+
+	if existentUser.ID != 0 {
+		// the response does not show if the account exists, the reason is only saved in the auth event:
+		user_models.RecordAuthEvent(ctx, user_models.AuthEventSignupFailed, existentUser.GetID(), map[string]interface{}{
+			"reason":   "already_registered",
+			"username": body.Username,
+			"email":    body.Email,
+		})
+
+		return &bolo.HTTPError{
+			Code:    http.StatusBadRequest,
+			Message: SignupFailedMessage,
+		}
 	}
 
 	userRecord := user_models.UserModel{
@@ -131,14 +188,68 @@ func (ctl *AuthController) Signup(c echo.Context) error {
 		Phone:       body.Phone,
 	}
 
-	err := userRecord.Save(ctx)
+	err = userRecord.CreateWithPassword(ctx, body.Password)
 	if err != nil {
-		return err // TODO! improve this error handler
+		return errors.Wrap(err, "AuthController.Signup error on create user")
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventSignup, userRecord.GetID(), nil)
+
+	authToken, err := user_models.CreateAuthToken(userRecord.GetID(), user_models.AccountActivationTokenType)
+	if err != nil {
+		return errors.Wrap(err, "AuthController.Signup error on create activation token")
+	}
+
+	emailSent := false
+	if ctl.App.GetPlugin("emails") != nil {
+		emailSent, err = SendAccountActivationEmail(ctx, authToken, &userRecord)
+		if err != nil {
+			return errors.Wrap(err, "AuthController.Signup error on send activation email")
+		}
+	}
+
+	if !emailSent {
+		logrus.WithFields(logrus.Fields{
+			"activationURL": authToken.GetActivationUrl(ctx),
+			"user_id":       userRecord.GetID(),
+		}).Warn("AuthController.Signup E-mail not sent, then the activation url was logged")
 	}
 
 	return c.JSON(http.StatusOK, SignupResponse{User: &userRecord})
 }
 
+// SendAccountActivationEmail queue the account activation email with the confirm url
+func SendAccountActivationEmail(ctx *bolo.RequestContext, authToken *user_models.AuthTokenModel, u *user_models.UserModel) (bool, error) {
+	email, err := emails.NewEmailWithTemplate(&emails.EmailOpts{
+		To:           u.Email,
+		TemplateName: "AccontActivationEmail",
+		Variables: emails.TemplateVariables{
+			"confirmUrl":  authToken.GetActivationUrl(ctx),
+			"username":    u.Username,
+			"displayName": u.DisplayName,
+			"fullName":    u.FullName,
+			"email":       u.Email,
+			"siteName":    system_settings.Get("siteName"),
+			"siteUrl":     ctx.AppOrigin,
+		},
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("AuthController.SendAccountActivationEmail error on create email")
+		return false, err
+	}
+	err = email.QueueToSend()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("AuthController.SendAccountActivationEmail error on QueueToSend email")
+		return false, nil
+	}
+
+	return true, nil
+}
+
 // Logout handler with supports to unAuthenticate from all strategies
 // TODO! add support for unauthenticate from all session strategies with events
 func (ctl *AuthController) Logout(c echo.Context) error {
@@ -150,15 +261,21 @@ func (ctl *AuthController) Logout(c echo.Context) error {
 		return c.JSON(http.StatusOK, bolo.EmptyResponse{})
 	}
 
+	if ctx.IsAuthenticated {
+		user_models.RecordAuthEvent(ctx, user_models.AuthEventLogout, ctx.AuthenticatedUser.GetID(), map[string]interface{}{
+			"method": "oauth2",
+		})
+	}
+
 	if authorizationToken != "" {
 		// remove the token
 		token := auth_oauth2_password.GetOauth2TokenFromAuthorization(authorizationToken)
 		if token != "" {
-			err := auth_oauth2_password.DeleteAccessToken(ctx, token)
+			err := auth_oauth2_password.RevokeAccessToken(ctx, token)
 			if err != nil {
 				logrus.WithFields(logrus.Fields{
 					"error": err,
-				}).Error("AuthController.Logout error on delete access token")
+				}).Error("AuthController.Logout error on revoke access token")
 			}
 		}
 	}
@@ -166,9 +283,621 @@ func (ctl *AuthController) Logout(c echo.Context) error {
 	return c.JSON(http.StatusOK, make(map[string]string))
 }
 
+type ActivateResponse struct {
+	User     *user_models.UserModelPublic `json:"user"`
+	Messages []*bolo.ResponseMessage      `json:"messages,omitempty"`
+}
+
 // Activate a user account with activation code
 func (ctl *AuthController) Activate(c echo.Context) error {
-	return c.String(501, "not implemented")
+	ctx := c.(*bolo.RequestContext)
+	userID := c.Param("userID")
+	token := c.QueryParam("t")
+
+	if userID == "" || token == "" {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "auth.activate.token.required",
+			Internal: errors.New("auth.activate.token.required"),
+		}
+	}
+
+	u := user_models.UserModel{}
+	err := user_models.UserFindOne(userID, &u)
+	if err != nil {
+		return errors.Wrap(err, "AuthController.Activate error on find user")
+	}
+
+	if u.ID == 0 || u.Blocked {
+		return &bolo.HTTPError{
+			Code:     http.StatusNotFound,
+			Message:  "auth.activate.user.not-found",
+			Internal: errors.New("auth.activate.user.not-found user id=" + userID),
+		}
+	}
+
+	valid, tokenRecord, err := user_models.ValidAuthTokenOfType(userID, token, user_models.AccountActivationTokenType, user_models.AccountActivationTokenLifetime)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.Wrap(err, "AuthController.Activate error on find auth token")
+	}
+
+	if !valid {
+		return &bolo.HTTPError{
+			Code:     http.StatusNotFound,
+			Message:  "auth.activate.token.invalid",
+			Internal: errors.New("auth.activate.token.invalid token=" + token),
+		}
+	}
+
+	err = u.SetActive(true)
+	if err != nil {
+		return err
+	}
+	// the activation link was sent to the user email:
+	u.EmailVerified = true
+
+	err = u.Save(ctx)
+	if err != nil {
+		return errors.Wrap(err, "AuthController.Activate error on save user")
+	}
+
+	err = tokenRecord.Delete()
+	if err != nil {
+		return errors.Wrap(err, "AuthController.Activate error on delete auth token")
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventAccountActivated, u.GetID(), nil)
+
+	ctx.AddResponseMessage(&bolo.ResponseMessage{
+		Message: "Conta ativada com sucesso",
+		Type:    "success",
+	})
+
+	if ctx.GetResponseContentType() == "application/json" {
+		return c.JSON(http.StatusOK, ActivateResponse{
+			User:     user_models.NewUserModelPublicFromUserModel(&u),
+			Messages: ctx.GetResponseMessages(),
+		})
+	}
+
+	_, err = SetUserSession(ctx.App, c, &u)
+	if err != nil {
+		return err
+	}
+
+	AddFlashMessage(c, &FlashMessage{
+		Type:    "success",
+		Message: "Conta ativada com sucesso",
+	})
+
+	return c.Redirect(http.StatusFound, "/")
+}
+
+// UnlockAccount - unlocks one account temporarily locked after login failures with the emailed token
+func (ctl *AuthController) UnlockAccount(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+	userID := c.Param("userID")
+	token := c.QueryParam("t")
+
+	if userID == "" || token == "" {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "auth.unlock.token.required",
+			Internal: errors.New("auth.unlock.token.required"),
+		}
+	}
+
+	u := user_models.UserModel{}
+	err := user_models.UserFindOne(userID, &u)
+	if err != nil {
+		return errors.Wrap(err, "AuthController.UnlockAccount error on find user")
+	}
+
+	if u.ID == 0 || u.Blocked {
+		return &bolo.HTTPError{
+			Code:     http.StatusNotFound,
+			Message:  "auth.unlock.user.not-found",
+			Internal: errors.New("auth.unlock.user.not-found user id=" + userID),
+		}
+	}
+
+	valid, tokenRecord, err := user_models.ValidAuthTokenOfType(userID, token, user_models.AccountUnlockTokenType, user_models.AccountUnlockTokenLifetime)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.Wrap(err, "AuthController.UnlockAccount error on find auth token")
+	}
+
+	if !valid {
+		return &bolo.HTTPError{
+			Code:     http.StatusNotFound,
+			Message:  "auth.unlock.token.invalid",
+			Internal: errors.New("auth.unlock.token.invalid token=" + token),
+		}
+	}
+
+	err = u.ResetLoginFailures()
+	if err != nil {
+		return errors.Wrap(err, "AuthController.UnlockAccount error on reset login failures")
+	}
+
+	err = tokenRecord.Delete()
+	if err != nil {
+		return errors.Wrap(err, "AuthController.UnlockAccount error on delete auth token")
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventAccountUnlocked, u.GetID(), map[string]interface{}{
+		"method": "email_token",
+	})
+
+	ctx.AddResponseMessage(&bolo.ResponseMessage{
+		Message: "Conta desbloqueada com sucesso",
+		Type:    "success",
+	})
+
+	if ctx.GetResponseContentType() == "application/json" {
+		return c.JSON(http.StatusOK, ActivateResponse{
+			User:     user_models.NewUserModelPublicFromUserModel(&u),
+			Messages: ctx.GetResponseMessages(),
+		})
+	}
+
+	AddFlashMessage(c, &FlashMessage{
+		Type:    "success",
+		Message: "Conta desbloqueada com sucesso, faça o login novamente.",
+	})
+
+	return c.Redirect(http.StatusFound, "/login")
+}
+
+// UnlockUser - admin endpoint to unlock one account temporarily locked after login failures
+func (ctl *AuthController) UnlockUser(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+	userID := c.Param("userID")
+
+	if !ctx.Can("manage_users") {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "Forbidden",
+			Internal: errors.New("UnlockUser forbidden"),
+		}
+	}
+
+	var record user_models.UserModel
+	err := user_models.UserFindOne(userID, &record)
+	if err != nil {
+		return err
+	}
+
+	if record.ID == 0 {
+		return echo.NotFoundHandler(c)
+	}
+
+	err = record.ResetLoginFailures()
+	if err != nil {
+		return errors.Wrap(err, "AuthController.UnlockUser error on reset login failures")
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventAccountUnlocked, record.GetID(), map[string]interface{}{
+		"method": "admin",
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// SendAccountLockedEmail creates one unlock token and notifies the user about the account lock
+func SendAccountLockedEmail(ctx *bolo.RequestContext, u *user_models.UserModel) error {
+	authToken, err := user_models.CreateAuthToken(u.GetID(), user_models.AccountUnlockTokenType)
+	if err != nil {
+		return errors.Wrap(err, "SendAccountLockedEmail error on create unlock token")
+	}
+
+	if ctx.App.GetPlugin("emails") == nil {
+		logrus.WithFields(logrus.Fields{
+			"unlockURL": authToken.GetUnlockUrl(ctx),
+			"user_id":   u.GetID(),
+		}).Warn("SendAccountLockedEmail E-mail not sent, then the unlock url was logged")
+		return nil
+	}
+
+	emails.SendEmailAsync(&emails.EmailOpts{
+		To:           u.Email,
+		TemplateName: "AuthAccountLockedEmail",
+		Variables: emails.TemplateVariables{
+			"displayName": u.DisplayName,
+			"siteName":    system_settings.Get("siteName"),
+			"siteUrl":     ctx.AppOrigin,
+			"username":    u.Username,
+			"unlockUrl":   authToken.GetUnlockUrl(ctx),
+			"ip":          u.LastFailedLoginIP,
+		},
+	})
+
+	return nil
+}
+
+type ChangeEmailBody struct {
+	Email    string `json:"email" form:"email" validate:"required,email"`
+	Password string `json:"password" form:"password"`
+}
+
+type ChangeEmailResponse struct {
+	PendingEmail string                  `json:"pendingEmail"`
+	Messages     []*bolo.ResponseMessage `json:"messages,omitempty"`
+}
+
+// ChangeEmail - saves the new address as pending and sends the confirmation link to it.
+// The user email only changes after the confirmation, see the ConfirmEmailChange
+func (ctl *AuthController) ChangeEmail(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	body := ChangeEmailBody{}
+
+	if err := c.Bind(&body); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Debug("AuthController.ChangeEmail error on bind")
+
+		if e, ok := err.(*echo.HTTPError); ok {
+			return e
+		}
+
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "Invalid data sent",
+			Internal: errors.New("Invalid data sent on ChangeEmail"),
+		}
+	}
+
+	body.Email = user_models.NormalizeEmail(body.Email)
+
+	if err := c.Validate(&body); err != nil {
+		return err
+	}
+
+	record, err := getAuthenticatedUserRecord(ctx)
+	if err != nil {
+		return err
+	}
+
+	var passwordRecord user_models.PasswordModel
+	err = user_models.FindPasswordByUserID(record.GetID(), &passwordRecord)
+	if err != nil {
+		return err
+	}
+
+	// users without password, like the social login users, only need the session:
+	if passwordRecord.ID != 0 {
+		valid, err := record.ValidPassword(body.Password)
+		if err != nil {
+			return err
+		}
+
+		if !valid {
+			return &bolo.HTTPError{
+				Code:     http.StatusUnprocessableEntity,
+				Message:  "Invalid password, current password is wrong",
+				Internal: errors.New("ChangeEmail forbidden"),
+			}
+		}
+	}
+
+	if body.Email == record.Email {
+		return c.JSON(http.StatusBadRequest, bolo.ValidationResponse{
+			Errors: []*bolo.ValidationFieldError{{
+				Field:   "email",
+				Tag:     "changed",
+				Value:   body.Email,
+				Message: "auth.change-email.same-email",
+			}},
+		})
+	}
+
+	inUse, err := user_models.IsEmailInUse(body.Email, record.ID)
+	if err != nil {
+		return err
+	}
+
+	if inUse {
+		return c.JSON(http.StatusBadRequest, bolo.ValidationResponse{
+			Errors: []*bolo.ValidationFieldError{{
+				Field:   "email",
+				Tag:     "unique",
+				Value:   body.Email,
+				Message: "auth.change-email.email-in-use",
+			}},
+		})
+	}
+
+	authToken, err := record.RequestEmailChange(body.Email)
+	if err != nil {
+		return errors.Wrap(err, "AuthController.ChangeEmail error on request email change")
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventEmailChangeReq, record.GetID(), map[string]interface{}{
+		"newEmail": body.Email,
+	})
+
+	SendEmailChangeConfirmationEmail(ctx, record, authToken)
+
+	ctx.AddResponseMessage(&bolo.ResponseMessage{
+		Message: "Enviamos um link de confirmação para o novo email",
+		Type:    "success",
+	})
+
+	return c.JSON(http.StatusOK, ChangeEmailResponse{
+		PendingEmail: record.ConfirmEmail,
+		Messages:     ctx.GetResponseMessages(),
+	})
+}
+
+// emailChangePageData - data of the email change confirmation pages, the form posts the token to the same url
+type emailChangePageData struct {
+	UserID string `json:"userId"`
+	Token  string `json:"token"`
+}
+
+// ConfirmEmailChangePage - renders the confirmation page of the link sent to the new address. The token is only
+// consumed in the ConfirmEmailChange POST, then email scanners that prefetch the link do not change the email
+func (ctl *AuthController) ConfirmEmailChangePage(c echo.Context) error {
+	return renderEmailChangePage(c, "auth/confirm-email", "Confirmar email")
+}
+
+// ConfirmEmailChange - swaps the user email with the confirmation token sent in the "t" param of the confirmation
+// form and notifies the old address with the revert link
+func (ctl *AuthController) ConfirmEmailChange(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	u, authToken, err := findEmailChangeToken(c, user_models.EmailChangeTokenType, user_models.EmailChangeTokenLifetime)
+	if err != nil {
+		return err
+	}
+
+	if authToken.Email != u.ConfirmEmail {
+		return &bolo.HTTPError{
+			Code:     http.StatusNotFound,
+			Message:  "auth.change-email.token.invalid",
+			Internal: errors.New("auth.change-email.token.invalid token email is not the pending email"),
+		}
+	}
+
+	inUse, err := user_models.IsEmailInUse(authToken.Email, u.ID)
+	if err != nil {
+		return err
+	}
+
+	if inUse {
+		return &bolo.HTTPError{
+			Code:     http.StatusConflict,
+			Message:  "auth.change-email.email-in-use",
+			Internal: errors.New("auth.change-email.email-in-use email=" + authToken.Email),
+		}
+	}
+
+	oldEmail := u.Email
+
+	revertToken, err := u.ConfirmEmailChange(authToken)
+	if err != nil {
+		return errors.Wrap(err, "AuthController.ConfirmEmailChange error on confirm email change")
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventEmailChange, u.GetID(), map[string]interface{}{
+		"oldEmail": oldEmail,
+		"newEmail": u.Email,
+	})
+
+	SendEmailChangedEmail(ctx, u, oldEmail, revertToken)
+
+	ctx.AddResponseMessage(&bolo.ResponseMessage{
+		Message: "Email alterado com sucesso",
+		Type:    "success",
+	})
+
+	if ctx.GetResponseContentType() == "application/json" {
+		return c.JSON(http.StatusOK, ActivateResponse{
+			User:     user_models.NewUserModelPublicFromUserModel(u),
+			Messages: ctx.GetResponseMessages(),
+		})
+	}
+
+	AddFlashMessage(c, &FlashMessage{
+		Type:    "success",
+		Message: "Email alterado com sucesso",
+	})
+
+	return c.Redirect(http.StatusFound, "/")
+}
+
+// RevertEmailChangePage - renders the confirmation page of the revert link sent to the old address,
+// the email is only restored in the RevertEmailChange POST
+func (ctl *AuthController) RevertEmailChangePage(c echo.Context) error {
+	return renderEmailChangePage(c, "auth/revert-email", "Restaurar email")
+}
+
+// RevertEmailChange - restores the old email with the token sent in the "t" param of the confirmation form.
+// All the user sessions are revoked because the change may come from one stolen account
+func (ctl *AuthController) RevertEmailChange(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	u, authToken, err := findEmailChangeToken(c, user_models.EmailChangeRevertTokenType, user_models.EmailChangeRevertTokenLifetime)
+	if err != nil {
+		return err
+	}
+
+	inUse, err := user_models.IsEmailInUse(authToken.Email, u.ID)
+	if err != nil {
+		return err
+	}
+
+	if inUse {
+		return &bolo.HTTPError{
+			Code:     http.StatusConflict,
+			Message:  "auth.change-email.email-in-use",
+			Internal: errors.New("auth.change-email.email-in-use email=" + authToken.Email),
+		}
+	}
+
+	changedEmail := u.Email
+
+	err = u.RevertEmailChange(authToken)
+	if err != nil {
+		return errors.Wrap(err, "AuthController.RevertEmailChange error on revert email change")
+	}
+
+	err = user_models.DeleteUserSessions(u.GetID(), "")
+	if err != nil {
+		return errors.Wrap(err, "AuthController.RevertEmailChange error on revoke sessions")
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventEmailChangeRevert, u.GetID(), map[string]interface{}{
+		"oldEmail": changedEmail,
+		"newEmail": u.Email,
+	})
+
+	ctx.AddResponseMessage(&bolo.ResponseMessage{
+		Message: "Email restaurado com sucesso, recomendamos trocar a sua senha",
+		Type:    "success",
+	})
+
+	if ctx.GetResponseContentType() == "application/json" {
+		return c.JSON(http.StatusOK, ActivateResponse{
+			User:     user_models.NewUserModelPublicFromUserModel(u),
+			Messages: ctx.GetResponseMessages(),
+		})
+	}
+
+	AddFlashMessage(c, &FlashMessage{
+		Type:    "success",
+		Message: "Email restaurado com sucesso, faça o login e troque a sua senha.",
+	})
+
+	return c.Redirect(http.StatusFound, "/login")
+}
+
+// renderEmailChangePage renders one email change confirmation page without consuming the link token
+func renderEmailChangePage(c echo.Context, template, title string) error {
+	ctx := c.(*bolo.RequestContext)
+
+	data := &emailChangePageData{
+		UserID: c.Param("userID"),
+		Token:  c.QueryParam("t"),
+	}
+
+	if data.UserID == "" || data.Token == "" {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "auth.change-email.token.required",
+			Internal: errors.New("auth.change-email.token.required"),
+		}
+	}
+
+	if ctx.GetResponseContentType() == "application/json" {
+		return c.JSON(http.StatusOK, data)
+	}
+
+	ctx.Title = title
+
+	return bolo.MinifiAndRender(http.StatusOK, template, &bolo.TemplateCTX{
+		Ctx:    ctx,
+		Record: data,
+	}, ctx)
+}
+
+// findEmailChangeToken loads the user and the valid email change token from the confirmation form params
+func findEmailChangeToken(c echo.Context, tokenType string, lifetime time.Duration) (*user_models.UserModel, *user_models.AuthTokenModel, error) {
+	userID := c.Param("userID")
+	token := c.FormValue("t")
+
+	if userID == "" || token == "" {
+		return nil, nil, &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "auth.change-email.token.required",
+			Internal: errors.New("auth.change-email.token.required"),
+		}
+	}
+
+	u := user_models.UserModel{}
+	err := user_models.UserFindOne(userID, &u)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "findEmailChangeToken error on find user")
+	}
+
+	if u.ID == 0 || u.Blocked {
+		return nil, nil, &bolo.HTTPError{
+			Code:     http.StatusNotFound,
+			Message:  "auth.change-email.user.not-found",
+			Internal: errors.New("auth.change-email.user.not-found user id=" + userID),
+		}
+	}
+
+	valid, authToken, err := user_models.ValidAuthTokenOfType(userID, token, tokenType, lifetime)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, errors.Wrap(err, "findEmailChangeToken error on find auth token")
+	}
+
+	if !valid || authToken.Email == "" {
+		return nil, nil, &bolo.HTTPError{
+			Code:     http.StatusNotFound,
+			Message:  "auth.change-email.token.invalid",
+			Internal: errors.New("auth.change-email.token.invalid token=" + token),
+		}
+	}
+
+	return &u, authToken, nil
+}
+
+// SendEmailChangeConfirmationEmail sends the confirmation link to the new address
+func SendEmailChangeConfirmationEmail(ctx *bolo.RequestContext, u *user_models.UserModel, authToken *user_models.AuthTokenModel) {
+	if ctx.App.GetPlugin("emails") == nil {
+		logrus.WithFields(logrus.Fields{
+			"confirmUrl": authToken.GetConfirmEmailUrl(ctx),
+			"user_id":    u.GetID(),
+		}).Warn("SendEmailChangeConfirmationEmail E-mail not sent, then the confirmation url was logged")
+		return
+	}
+
+	emails.SendEmailAsync(&emails.EmailOpts{
+		To:           authToken.Email,
+		TemplateName: "AuthEmailChangeConfirmationEmail",
+		Variables: emails.TemplateVariables{
+			"displayName": u.DisplayName,
+			"siteName":    system_settings.Get("siteName"),
+			"siteUrl":     ctx.AppOrigin,
+			"username":    u.Username,
+			"newEmail":    authToken.Email,
+			"confirmUrl":  authToken.GetConfirmEmailUrl(ctx),
+		},
+	})
+}
+
+// SendEmailChangedEmail notifies the old address about the email change with the revert link
+func SendEmailChangedEmail(ctx *bolo.RequestContext, u *user_models.UserModel, oldEmail string, revertToken *user_models.AuthTokenModel) {
+	if ctx.App.GetPlugin("emails") == nil {
+		logrus.WithFields(logrus.Fields{
+			"revertUrl": revertToken.GetRevertEmailUrl(ctx),
+			"user_id":   u.GetID(),
+		}).Warn("SendEmailChangedEmail E-mail not sent, then the revert url was logged")
+		return
+	}
+
+	emails.SendEmailAsync(&emails.EmailOpts{
+		To:           oldEmail,
+		TemplateName: "AuthEmailChangedEmail",
+		Variables: emails.TemplateVariables{
+			"displayName": u.DisplayName,
+			"siteName":    system_settings.Get("siteName"),
+			"siteUrl":     ctx.AppOrigin,
+			"username":    u.Username,
+			"newEmail":    u.Email,
+			"revertUrl":   revertToken.GetRevertEmailUrl(ctx),
+		},
+	})
 }
 
 // Generate one time reset password token and send it to user
@@ -249,7 +978,10 @@ func (ctl *AuthController) ChangeOwnPasswordApi(c echo.Context) error {
 		return err
 	}
 
-	record := ctx.AuthenticatedUser.(*user_models.UserModel)
+	record, err := getAuthenticatedUserRecord(ctx)
+	if err != nil {
+		return err
+	}
 
 	if body.Password == "" {
 		var passwordRecord user_models.PasswordModel
@@ -280,11 +1012,28 @@ func (ctl *AuthController) ChangeOwnPasswordApi(c echo.Context) error {
 		}
 	}
 
-	err := record.SetPassword(body.NewPassword)
+	passwordErrors, err := ValidateNewPassword(ctl.App, record, "newPassword", body.NewPassword)
 	if err != nil {
 		return err
 	}
 
+	if len(passwordErrors) > 0 {
+		return c.JSON(http.StatusBadRequest, bolo.ValidationResponse{Errors: passwordErrors})
+	}
+
+	err = record.SetPassword(body.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventPasswordChange, record.GetID(), nil)
+
+	// the current session stays active, all other sessions and tokens are revoked:
+	err = user_models.DeleteUserSessions(record.GetID(), getCurrentUserSessionID(c))
+	if err != nil {
+		return errors.Wrap(err, "AuthController.ChangeOwnPassword error on revoke other sessions")
+	}
+
 	// Notify the password change:
 	emails.SendEmailAsync(&emails.EmailOpts{
 		To:           record.Email,
@@ -349,7 +1098,10 @@ func (ctl *AuthController) ChangeOwnPassword(c echo.Context) error {
 		return err
 	}
 
-	record := ctx.AuthenticatedUser.(*user_models.UserModel)
+	record, err := getAuthenticatedUserRecord(ctx)
+	if err != nil {
+		return err
+	}
 
 	if body.Password == "" {
 		var passwordRecord user_models.PasswordModel
@@ -380,11 +1132,35 @@ func (ctl *AuthController) ChangeOwnPassword(c echo.Context) error {
 		}
 	}
 
-	err := record.SetPassword(body.NewPassword)
+	passwordErrors, err := ValidateNewPassword(ctl.App, record, "newPassword", body.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	if len(passwordErrors) > 0 {
+		for _, e := range passwordErrors {
+			AddFlashMessage(c, &FlashMessage{
+				Type:    "error",
+				Message: e.Message,
+			})
+		}
+		c.Set("status", http.StatusBadRequest)
+		return ctl.ChangeOwnPassword_Page(c)
+	}
+
+	err = record.SetPassword(body.NewPassword)
 	if err != nil {
 		return err
 	}
 
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventPasswordChange, record.GetID(), nil)
+
+	// the current session stays active, all other sessions and tokens are revoked:
+	err = user_models.DeleteUserSessions(record.GetID(), getCurrentUserSessionID(c))
+	if err != nil {
+		return errors.Wrap(err, "AuthController.ChangeOwnPassword error on revoke other sessions")
+	}
+
 	// Notify the password change:
 	emails.SendEmailAsync(&emails.EmailOpts{
 		To:           record.Email,
@@ -445,10 +1221,22 @@ func (ctl *AuthController) SetPassword(c echo.Context) error {
 		return echo.NotFoundHandler(c)
 	}
 
+	passwordErrors, err := ValidateNewPassword(ctl.App, &record, "newPassword", body.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	if len(passwordErrors) > 0 {
+		return c.JSON(http.StatusBadRequest, bolo.ValidationResponse{Errors: passwordErrors})
+	}
+
 	err = user_models.UpdateUserPasswordByUserID(userID, body.NewPassword)
 	if err != nil {
 		return err
 	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventPasswordSet, record.GetID(), nil)
+
 	return c.JSON(200, struct{}{})
 }
 
@@ -496,11 +1284,13 @@ func (ctl *AuthController) ForgotPassword_Request(c echo.Context) error {
 		}
 	}
 
-	authToken, err := user_models.CreateAuthToken(u.GetID(), "resetPassword")
+	authToken, err := user_models.CreateAuthToken(u.GetID(), user_models.ResetPasswordTokenType)
 	if err != nil {
 		return errors.Wrap(err, "AuthController.ForgotPasswordChange_Request eJSONrror on create auth token")
 	}
 
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventPasswordResetReq, u.GetID(), nil)
+
 	if ctl.App.GetPlugin("emails") != nil {
 		email, err := emails.NewEmailWithTemplate(&emails.EmailOpts{
 			To:           u.Email,
@@ -600,11 +1390,13 @@ func (ctl *AuthController) ForgotPassword_RequestWithIdentifier(c echo.Context)
 			}
 		}
 
-		authToken, err := user_models.CreateAuthToken(u.GetID(), "resetPassword")
+		authToken, err := user_models.CreateAuthToken(u.GetID(), user_models.ResetPasswordTokenType)
 		if err != nil {
 			return errors.Wrap(err, "AuthController.ForgotPassword_RequestWithIdentifier error on create auth token")
 		}
 
+		user_models.RecordAuthEvent(ctx, user_models.AuthEventPasswordResetReq, u.GetID(), nil)
+
 		emailSent := false
 		if ctl.App.GetPlugin("emails") != nil {
 			emailSent, err = SendRequestResetPasswordEmail(ctx, authToken, &u, body.ResetPrefixName)
@@ -711,7 +1503,7 @@ func (ctl *AuthController) ForgotPassword_ResetPage(c echo.Context) error {
 		}
 	}
 
-	valid, _, err := user_models.ValidAuthToken(userID, token)
+	valid, _, err := user_models.ValidAuthTokenOfType(userID, token, user_models.ResetPasswordTokenType, user_models.ResetPasswordTokenLifetime)
 	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		return errors.Wrap(err, "AuthController.ForgotPasswordChange_Request error on find auth token")
 	}
@@ -776,7 +1568,7 @@ func (ctl *AuthController) ForgotPassword_ResetPage(c echo.Context) error {
 type ForgotPassword_Process_RequestBody struct {
 	Token            string      `json:"token" form:"token" validate:"required"`
 	UserID           json.Number `json:"userID" form:"userID" validate:"required"`
-	NewPassword      string      `json:"newPassword" form:"newPassword" validate:"required,min=3"`
+	NewPassword      string      `json:"newPassword" form:"newPassword" validate:"required"`
 	RNewPassword     string      `json:"rNewPassword" form:"rNewPassword" validate:"required,eqfield=NewPassword"`
 	RedirectOnSucess string      `json:"redirectOnSucess" form:"redirectOnSucess"`
 }
@@ -815,7 +1607,7 @@ func (ctl *AuthController) ForgotPassword_Process(c echo.Context) error {
 		return errors.Wrap(err, "AuthController.ForgotPassword_Process error on find user")
 	}
 
-	valid, tokenRecord, err := user_models.ValidAuthToken(body.UserID.String(), body.Token)
+	valid, tokenRecord, err := user_models.ValidAuthTokenOfType(body.UserID.String(), body.Token, user_models.ResetPasswordTokenType, user_models.ResetPasswordTokenLifetime)
 	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		return errors.Wrap(err, "AuthController.ForgotPassword_Process error on find auth token")
 	}
@@ -828,6 +1620,15 @@ func (ctl *AuthController) ForgotPassword_Process(c echo.Context) error {
 		}
 	}
 
+	passwordErrors, err := ValidateNewPassword(ctl.App, &u, "newPassword", body.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	if len(passwordErrors) > 0 {
+		return c.JSON(http.StatusBadRequest, bolo.ValidationResponse{Errors: passwordErrors})
+	}
+
 	err = u.SetPassword(body.NewPassword)
 	if err != nil {
 		return err
@@ -838,6 +1639,13 @@ func (ctl *AuthController) ForgotPassword_Process(c echo.Context) error {
 		return err
 	}
 
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventPasswordReset, u.GetID(), nil)
+
+	err = user_models.DeleteUserSessions(u.GetID(), "")
+	if err != nil {
+		return errors.Wrap(err, "AuthController.ForgotPassword_Process error on revoke user sessions")
+	}
+
 	// Notify the password change:
 	emails.SendEmailAsync(&emails.EmailOpts{
 		To:           u.Email,
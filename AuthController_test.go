@@ -5,16 +5,19 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	approvals "github.com/approvals/go-approval-tests"
 	"github.com/go-bolo/bolo"
 	"github.com/go-bolo/user"
 	user_models "github.com/go-bolo/user/models"
 	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/go-bolo/user/security"
 	"github.com/labstack/echo/v4"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
@@ -154,6 +157,7 @@ func TestAuthController_Signup(t *testing.T) {
 					Username:     "alberto",
 					Email:        "user@linkysystems.com",
 					ConfirmEmail: "user@linkysystems.com",
+					Password:     "correct-horse-battery",
 					DisplayName:  "Alberto",
 					FullName:     "Alberto Souza",
 				},
@@ -180,6 +184,7 @@ func TestAuthController_Signup(t *testing.T) {
 					Username:     "alberto **{{",
 					Email:        "user@linkysystems.com",
 					ConfirmEmail: "user@linkysystems.com",
+					Password:     "123456",
 					DisplayName:  "Alberto",
 					FullName:     "Alberto Souza",
 				},
@@ -219,6 +224,8 @@ func TestAuthController_Signup(t *testing.T) {
 
 				if respBody.User != nil {
 					assert.NotEqual(t, respBody.User.ID, 0)
+					// the id depends on the users created by the other tests:
+					tt.expectedBody.User.ID = respBody.User.ID
 					respBody.User.UpdatedAt = tt.expectedBody.User.UpdatedAt
 					respBody.User.CreatedAt = tt.expectedBody.User.CreatedAt
 				}
@@ -233,6 +240,49 @@ func TestAuthController_Signup(t *testing.T) {
 			assert.Equal(t, tt.expectedStatus, rec.Result().StatusCode)
 		})
 	}
+
+	t.Run("already registered users receive the generic error", func(t *testing.T) {
+		u := user_models.UserModel{
+			Username: "registered",
+			Email:    "registered@linkysystems.com",
+			Active:   true,
+		}
+		err := u.Save(app.NewRequestContext(&bolo.RequestContextOpts{App: app}))
+		assert.NoError(t, err)
+		defer u.Delete()
+
+		body := user.SignupBody{
+			AcceptTerms:  true,
+			Username:     "other-username",
+			Email:        u.Email,
+			ConfirmEmail: u.Email,
+			Password:     "correct-horse-battery",
+			DisplayName:  "Other",
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/auth/signup", strings.NewReader(body.ToJSON()))
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Content-Type", "application/json")
+		ctx := bolo.NewRequestContext(&bolo.RequestContextOpts{EchoContext: e.NewContext(req, httptest.NewRecorder())})
+
+		ctl := &user.AuthController{App: app}
+		err = ctl.Signup(ctx)
+		he, ok := err.(*bolo.HTTPError)
+		if assert.True(t, ok) {
+			assert.Equal(t, http.StatusBadRequest, he.Code)
+			assert.Equal(t, user.SignupFailedMessage, he.Message)
+		}
+
+		events, _, err := user_models.QueryAuthEvents(&user_models.AuthEventQueryOpts{
+			UserID: u.GetID(),
+			Type:   user_models.AuthEventSignupFailed,
+			Limit:  10,
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, events, 1) {
+			assert.Contains(t, string(events[0].Metadata), `"reason":"already_registered"`)
+		}
+	})
 }
 
 func TestAuthController_Logout(t *testing.T) {
@@ -328,28 +378,105 @@ func TestAuthController_Logout(t *testing.T) {
 }
 
 func TestAuthController_Activate(t *testing.T) {
-	type fields struct {
-		App bolo.App
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   false,
 	}
+
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
 	type args struct {
-		c echo.Context
+		tokenType string
+		token     string
+		expired   bool
 	}
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
+		name           string
+		args           args
+		expectedStatus int
+		expectedActive bool
 	}{
-		// TODO: Add test cases.
+		{
+			name: "error with invalid token",
+			args: args{
+				tokenType: "accountActivation",
+				token:     "invalid",
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedActive: false,
+		},
+		{
+			name: "error with reset password token",
+			args: args{
+				tokenType: "resetPassword",
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedActive: false,
+		},
+		{
+			name: "error with expired token",
+			args: args{
+				tokenType: "accountActivation",
+				expired:   true,
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedActive: false,
+		},
+		{
+			name: "success",
+			args: args{
+				tokenType: "accountActivation",
+			},
+			expectedStatus: http.StatusOK,
+			expectedActive: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ctl := &user.AuthController{
-				App: tt.fields.App,
+			e := app.GetRouter()
+
+			savedToken, err := user_models.CreateAuthToken(u.GetID(), tt.args.tokenType)
+			assert.Nil(t, err)
+			defer savedToken.Delete()
+
+			token := savedToken.Token
+			if tt.args.token != "" {
+				token = tt.args.token
 			}
-			if err := ctl.Activate(tt.args.c); (err != nil) != tt.wantErr {
-				t.Errorf("AuthController.Activate() error = %v, wantErr %v", err, tt.wantErr)
+
+			if tt.args.expired {
+				err = app.GetDB().Model(savedToken).
+					Update("createdAt", time.Now().Add(-user_models.AccountActivationTokenLifetime-time.Minute)).Error
+				assert.NoError(t, err)
 			}
+
+			req := httptest.NewRequest(http.MethodGet, "/auth/"+u.GetID()+"/activate?t="+token, nil)
+			req.Header.Set(echo.HeaderAccept, "application/json")
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			var saved user_models.UserModel
+			err = user_models.UserFindOne(u.GetID(), &saved)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expectedActive, saved.Active)
 		})
 	}
 }
@@ -465,6 +592,70 @@ func TestAuthController_CheckIfResetPasswordTokenIsValid(t *testing.T) {
 	}
 }
 
+func TestAuthController_ForgotPassword_Process(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	process := func(token string) *httptest.ResponseRecorder {
+		body := `{"token": "` + token + `", "userID": ` + u.GetID() + `, "newPassword": "a-new-Password-123", "rNewPassword": "a-new-Password-123"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v2/auth/forgot-password/process", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("tokens of other flows are rejected", func(t *testing.T) {
+		for _, tokenType := range []string{user_models.MfaChallengeTokenType, user_models.MagicLinkTokenType, user_models.AccountActivationTokenType} {
+			token, err := user_models.CreateAuthToken(u.GetID(), tokenType)
+			assert.NoError(t, err)
+
+			rec := process(token.Token)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, tokenType)
+		}
+	})
+
+	t.Run("expired reset tokens are rejected", func(t *testing.T) {
+		token, err := user_models.CreateAuthToken(u.GetID(), user_models.ResetPasswordTokenType)
+		assert.NoError(t, err)
+
+		err = app.GetDB().Model(token).
+			Update("createdAt", time.Now().Add(-user_models.ResetPasswordTokenLifetime-time.Minute)).Error
+		assert.NoError(t, err)
+
+		rec := process(token.Token)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("reset the password with one reset token", func(t *testing.T) {
+		token, err := user_models.CreateAuthToken(u.GetID(), user_models.ResetPasswordTokenType)
+		assert.NoError(t, err)
+
+		rec := process(token.Token)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+}
+
 func TestAuthController_ForgotPasswordUpdate(t *testing.T) {
 	type fields struct {
 		App bolo.App
@@ -542,7 +733,7 @@ func TestNewAuthController(t *testing.T) {
 func GetCurrentUser(t *testing.T) *user_models.UserModel {
 	var currentUser user_models.UserModel
 
-	stubData, err := os.ReadFile("../_stubs/users/common-user.json")
+	stubData, err := os.ReadFile("testdata/stubs/common-user.json")
 	assert.Nil(t, err)
 	err = json.Unmarshal(stubData, &currentUser)
 	assert.Nil(t, err)
@@ -565,6 +756,7 @@ func TestAuthController_ChangePassword(t *testing.T) {
 	u := user_models.UserModel{
 		Username: gofakeit.Name(),
 		Email:    gofakeit.Email(),
+		Active:   true,
 	}
 
 	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
@@ -601,8 +793,8 @@ func TestAuthController_ChangePassword(t *testing.T) {
 				method: http.MethodPost,
 				data: strings.NewReader(`{
 					"password": "",
-					"newPassword": "new1",
-					"rNewPassword": "new1"
+					"newPassword": "correct-horse-battery",
+					"rNewPassword": "correct-horse-battery"
 				}`),
 			},
 			expectedStatus:        http.StatusOK,
@@ -719,6 +911,7 @@ func TestAuthController_SetPassword(t *testing.T) {
 	u := user_models.UserModel{
 		Username: gofakeit.UUID(),
 		Email:    gofakeit.Email(),
+		Active:   true,
 	}
 	u.SetRole("administrator")
 	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
@@ -751,10 +944,10 @@ func TestAuthController_SetPassword(t *testing.T) {
 			}, args: args{
 				user: &u,
 				body: user.SetPasswordBody{
-					NewPassword:  "new1",
-					RNewPassword: "new1",
+					NewPassword:  "correct-horse-battery",
+					RNewPassword: "correct-horse-battery",
 				},
-				url: "/auth/" + "/:userID/set-password",
+				url: "/auth/" + u.GetID() + "/set-password",
 			},
 			expectedStatus:        http.StatusOK,
 			wantErr:               false,
@@ -770,9 +963,9 @@ func TestAuthController_SetPassword(t *testing.T) {
 					NewPassword:  "new2",
 					RNewPassword: "notValid",
 				},
-				url: "/auth/" + "/:userID/set-password",
+				url: "/auth/" + u.GetID() + "/set-password",
 			},
-			expectedStatus:        http.StatusOK,
+			expectedStatus:        http.StatusUnprocessableEntity,
 			wantErr:               true,
 			expectedPasswordValid: false,
 			expectedError:         "Key: 'SetPasswordBody.RNewPassword' Error:Field validation for 'RNewPassword' failed on the 'eqfield' tag",
@@ -787,11 +980,9 @@ func TestAuthController_SetPassword(t *testing.T) {
 					NewPassword:  "1",
 					RNewPassword: "1",
 				},
-				data: strings.NewReader(`{
-				}`),
-				url: "/auth/" + "/:userID/set-password",
+				url: "/auth/" + u.GetID() + "/set-password",
 			},
-			expectedStatus:        http.StatusOK,
+			expectedStatus:        http.StatusBadRequest,
 			wantErr:               true,
 			expectedPasswordValid: false,
 			expectedError:         "Key: 'SetPasswordBody.NewPassword' Error:Field validation for 'NewPassword' failed on the 'min' tag",
@@ -802,7 +993,7 @@ func TestAuthController_SetPassword(t *testing.T) {
 			e := app.GetRouter()
 			ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
 
-			req := httptest.NewRequest(http.MethodGet, tt.args.url, tt.args.data)
+			req := httptest.NewRequest(http.MethodPost, tt.args.url, strings.NewReader(tt.args.body.ToJSON()))
 			req.Header.Set(echo.HeaderAccept, tt.args.accept)
 			// Body content type:
 			req.Header.Set(echo.HeaderContentType, "application/json")
@@ -825,6 +1016,10 @@ func TestAuthController_SetPassword(t *testing.T) {
 				approvals.VerifyString(t, rec.Body.String())
 			}
 
+			valid, err := user_models.ValidUsernamePassword(u.Email, tt.args.body.NewPassword)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedPasswordValid, valid)
+
 			// req, err := http.NewRequest(http.MethodPost, "/", strings.NewReader(tt.args.body.ToJSON()))
 
 			// assert.Nil(t, err)
@@ -859,3 +1054,368 @@ func TestAuthController_SetPassword(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthController_Unlock(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	lockout := &security.AccountLockout{
+		MaxFailures: 2,
+		Duration:    time.Minute * 15,
+		MaxDuration: time.Hour,
+		ResetTime:   time.Hour,
+	}
+
+	lockUser := func(t *testing.T, u *user_models.UserModel) {
+		locked, err := u.RegisterLoginFailure("10.0.0.1", lockout)
+		assert.NoError(t, err)
+		assert.False(t, locked)
+
+		locked, err = u.RegisterLoginFailure("10.0.0.2", lockout)
+		assert.NoError(t, err)
+		assert.True(t, locked, "failures from different IPs should lock the account")
+		assert.True(t, u.IsLocked())
+	}
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	t.Run("unlock with the emailed token", func(t *testing.T) {
+		lockUser(t, &u)
+
+		authToken, err := user_models.CreateAuthToken(u.GetID(), user_models.AccountUnlockTokenType)
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/auth/"+u.GetID()+"/unlock?t=invalid", nil)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		req = httptest.NewRequest(http.MethodGet, "/auth/"+u.GetID()+"/unlock?t="+authToken.Token, nil)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		rec = httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var record user_models.UserModel
+		err = user_models.UserFindOne(u.GetID(), &record)
+		assert.NoError(t, err)
+		assert.False(t, record.IsLocked())
+		assert.Equal(t, 0, record.LockoutCount)
+
+		// tokens are single use:
+		rec = httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("expired unlock tokens are invalid", func(t *testing.T) {
+		lockUser(t, &u)
+		defer u.ResetLoginFailures()
+
+		authToken, err := user_models.CreateAuthToken(u.GetID(), user_models.AccountUnlockTokenType)
+		assert.NoError(t, err)
+
+		err = app.GetDB().Model(authToken).
+			Update("createdAt", time.Now().Add(-user_models.AccountUnlockTokenLifetime-time.Minute)).Error
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/auth/"+u.GetID()+"/unlock?t="+authToken.Token, nil)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		var record user_models.UserModel
+		err = user_models.UserFindOne(u.GetID(), &record)
+		assert.NoError(t, err)
+		assert.True(t, record.IsLocked())
+	})
+
+	t.Run("admin unlock", func(t *testing.T) {
+		lockUser(t, &u)
+
+		other := user_models.UserModel{
+			Username: gofakeit.UUID(),
+			Email:    gofakeit.Email(),
+			Active:   true,
+		}
+		err := other.Save(ctx)
+		assert.NoError(t, err)
+		defer other.Delete()
+
+		otherToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &other)
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v2/auth/user/"+u.GetID()+"/unlock", nil)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+otherToken.AccessToken)
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		var record user_models.UserModel
+		err = user_models.UserFindOne(u.GetID(), &record)
+		assert.NoError(t, err)
+		assert.True(t, record.IsLocked())
+
+		other.SetRole("administrator")
+		err = other.Save(ctx)
+		assert.NoError(t, err)
+
+		rec = httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		record = user_models.UserModel{}
+		err = user_models.UserFindOne(u.GetID(), &record)
+		assert.NoError(t, err)
+		assert.False(t, record.IsLocked())
+	})
+}
+
+func TestAuthController_LoginFailuresCount(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	lockout := &security.AccountLockout{
+		MaxFailures: 2,
+		Duration:    time.Minute * 15,
+		MaxDuration: time.Hour,
+		ResetTime:   time.Hour,
+	}
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	// two requests with the user loaded before the failures:
+	var first, second user_models.UserModel
+	assert.NoError(t, user_models.UserFindOne(u.GetID(), &first))
+	assert.NoError(t, user_models.UserFindOne(u.GetID(), &second))
+
+	locked, err := first.RegisterLoginFailure("10.0.0.1", lockout)
+	assert.NoError(t, err)
+	assert.False(t, locked)
+
+	locked, err = second.RegisterLoginFailure("10.0.0.2", lockout)
+	assert.NoError(t, err)
+	assert.True(t, locked, "the saved failures count should be used")
+	assert.True(t, second.IsLocked())
+	assert.Equal(t, 1, second.LockoutCount)
+
+	var record user_models.UserModel
+	err = user_models.UserFindOne(u.GetID(), &record)
+	assert.NoError(t, err)
+	assert.True(t, record.IsLocked())
+	assert.Equal(t, 0, record.FailedLoginCount)
+	assert.Equal(t, "10.0.0.2", record.LastFailedLoginIP)
+}
+
+func TestAuthController_ChangeEmail(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	oldEmail := strings.ToLower(gofakeit.Email())
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    oldEmail,
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	err = u.SetPassword("123456789")
+	assert.NoError(t, err)
+
+	other := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    strings.ToLower(gofakeit.Email()),
+		Active:   true,
+	}
+	err = other.Save(ctx)
+	assert.NoError(t, err)
+	defer other.Delete()
+
+	userToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+	assert.NoError(t, err)
+
+	changeEmail := func(accessToken, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v2/auth/change-email", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		if accessToken != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+		}
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	openLink := func(action, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/"+u.GetID()+"/"+action, strings.NewReader(url.Values{"t": {token}}.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	findToken := func(tokenType string) *user_models.AuthTokenModel {
+		var token user_models.AuthTokenModel
+		err := app.GetDB().
+			Where("userId = ? AND tokenType = ?", u.GetID(), tokenType).
+			Order("id DESC").
+			First(&token).Error
+		assert.NoError(t, err)
+		return &token
+	}
+
+	findUser := func() *user_models.UserModel {
+		var record user_models.UserModel
+		err := user_models.UserFindOne(u.GetID(), &record)
+		assert.NoError(t, err)
+		return &record
+	}
+
+	newEmail := strings.ToLower(gofakeit.Email())
+
+	t.Run("requires authentication and the current password", func(t *testing.T) {
+		rec := changeEmail("", `{"email":"`+newEmail+`","password":"123456789"}`)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		rec = changeEmail(userToken.AccessToken, `{"email":"`+newEmail+`","password":"wrong"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+		rec = changeEmail(userToken.AccessToken, `{"email":"invalid","password":"123456789"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+		rec = changeEmail(userToken.AccessToken, `{"email":"`+other.Email+`","password":"123456789"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		assert.Equal(t, "", findUser().ConfirmEmail)
+	})
+
+	t.Run("the email only changes after the confirmation", func(t *testing.T) {
+		rec := changeEmail(userToken.AccessToken, `{"email":"`+strings.ToUpper(newEmail)+`","password":"123456789"}`)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var resp user.ChangeEmailResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		assert.Equal(t, newEmail, resp.PendingEmail)
+
+		record := findUser()
+		assert.Equal(t, oldEmail, record.Email)
+		assert.Equal(t, newEmail, record.ConfirmEmail)
+
+		rec = openLink("confirm-email", "invalid")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		token := findToken(user_models.EmailChangeTokenType)
+
+		// opening the link only renders the confirmation page:
+		req := httptest.NewRequest(http.MethodGet, "/auth/"+u.GetID()+"/confirm-email?t="+token.Token, nil)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		rec = httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), token.Token)
+		assert.Equal(t, oldEmail, findUser().Email)
+
+		rec = openLink("confirm-email", token.Token)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		record = findUser()
+		assert.Equal(t, newEmail, record.Email)
+		assert.Equal(t, "", record.ConfirmEmail)
+
+		// tokens are single use:
+		rec = openLink("confirm-email", token.Token)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("the old address can revert the change", func(t *testing.T) {
+		token := findToken(user_models.EmailChangeRevertTokenType)
+		assert.Equal(t, oldEmail, token.Email)
+
+		// opening the link only renders the confirmation page:
+		req := httptest.NewRequest(http.MethodGet, "/auth/"+u.GetID()+"/revert-email?t="+token.Token, nil)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, newEmail, findUser().Email)
+
+		rec = openLink("revert-email", token.Token)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, oldEmail, findUser().Email)
+
+		events, _, err := user_models.QueryAuthEvents(&user_models.AuthEventQueryOpts{
+			UserID: u.GetID(),
+			Type:   user_models.AuthEventEmailChangeRevert,
+			Limit:  10,
+		})
+		assert.NoError(t, err)
+		assert.Len(t, events, 1)
+	})
+
+	t.Run("expired confirmation tokens are invalid", func(t *testing.T) {
+		userToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+		assert.NoError(t, err)
+
+		rec := changeEmail(userToken.AccessToken, `{"email":"`+newEmail+`","password":"123456789"}`)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		token := findToken(user_models.EmailChangeTokenType)
+		err = app.GetDB().Model(token).
+			Update("createdAt", time.Now().Add(-user_models.EmailChangeTokenLifetime-time.Minute)).Error
+		assert.NoError(t, err)
+
+		rec = openLink("confirm-email", token.Token)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, oldEmail, findUser().Email)
+	})
+}
@@ -0,0 +1,105 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	user_models "github.com/go-bolo/user/models"
+	"github.com/labstack/echo/v4"
+)
+
+type AuthEventController struct {
+	App bolo.App
+}
+
+type AuthEventListJSONResponse struct {
+	bolo.BaseListReponse
+	Records []*user_models.AuthEventModel `json:"authEvent"`
+}
+
+// Query - admin endpoint to search the security audit log, filters: userId, actorId, type and since (RFC 3339)
+func (ctl *AuthEventController) Query(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.Can("manage_users") {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "Forbidden",
+			Internal: errors.New("AuthEventController.Query forbidden"),
+		}
+	}
+
+	opts := user_models.AuthEventQueryOpts{
+		UserID:  c.QueryParam("userId"),
+		ActorID: c.QueryParam("actorId"),
+		Type:    c.QueryParam("type"),
+		Limit:   ctx.GetLimit(),
+		Offset:  ctx.GetOffset(),
+	}
+
+	if since := c.QueryParam("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, bolo.ValidationResponse{
+				Errors: []*bolo.ValidationFieldError{{
+					Field:   "since",
+					Tag:     "datetime",
+					Value:   since,
+					Message: "auth.events.invalid-since",
+				}},
+			})
+		}
+		opts.Since = &t
+	}
+
+	return ctl.respondWithEvents(c, &opts)
+}
+
+// MyActivity - the authenticated user recent security events, like logins and password changes
+func (ctl *AuthEventController) MyActivity(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	return ctl.respondWithEvents(c, &user_models.AuthEventQueryOpts{
+		UserID: ctx.AuthenticatedUser.GetID(),
+		Type:   c.QueryParam("type"),
+		Limit:  ctx.GetLimit(),
+		Offset: ctx.GetOffset(),
+	})
+}
+
+func (ctl *AuthEventController) respondWithEvents(c echo.Context, opts *user_models.AuthEventQueryOpts) error {
+	ctx := c.(*bolo.RequestContext)
+
+	records, count, err := user_models.QueryAuthEvents(opts)
+	if err != nil {
+		return fmt.Errorf("AuthEventController error on query events: %w", err)
+	}
+
+	ctx.Pager.Count = count
+
+	resp := AuthEventListJSONResponse{
+		Records: records,
+	}
+	resp.Meta.Count = count
+
+	return c.JSON(http.StatusOK, &resp)
+}
+
+type NewAuthEventControllerCFG struct {
+	App bolo.App
+}
+
+func NewAuthEventController(cfg *NewAuthEventControllerCFG) *AuthEventController {
+	return &AuthEventController{App: cfg.App}
+}
@@ -0,0 +1,160 @@
+package user_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/gookit/event"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthEventController(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	triggered := []string{}
+	app.GetEvents().On(user_models.AuthEventTriggerName, event.ListenerFunc(func(e event.Event) error {
+		if r, ok := e.Get("event").(*user_models.AuthEventModel); ok {
+			triggered = append(triggered, r.Type)
+		}
+		return nil
+	}), event.Normal)
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	admin := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	admin.SetRole("administrator")
+	err = admin.Save(ctx)
+	assert.NoError(t, err)
+	defer admin.Delete()
+
+	userToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+	assert.NoError(t, err)
+	adminToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &admin)
+	assert.NoError(t, err)
+
+	request := func(method, url, accessToken, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	parse := func(t *testing.T, rec *httptest.ResponseRecorder) user.AuthEventListJSONResponse {
+		var resp user.AuthEventListJSONResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	rec := request(http.MethodPost, "/api/v2/auth/change-password", userToken.AccessToken, `{"newPassword":"a-strong-new-password","rNewPassword":"a-strong-new-password"}`)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, triggered, user_models.AuthEventPasswordChange)
+
+	t.Run("my activity", func(t *testing.T) {
+		rec := request(http.MethodGet, "/api/v2/auth/activity", userToken.AccessToken, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		resp := parse(t, rec)
+		assert.Equal(t, int64(1), resp.Meta.Count)
+		if assert.Len(t, resp.Records, 1) {
+			r := resp.Records[0]
+			assert.Equal(t, user_models.AuthEventPasswordChange, r.Type)
+			assert.Equal(t, int64(u.ID), *r.UserID)
+			assert.Equal(t, int64(u.ID), *r.ActorID)
+			assert.NotEmpty(t, r.IP)
+		}
+	})
+
+	t.Run("admin query requires permission", func(t *testing.T) {
+		rec := request(http.MethodGet, "/api/v2/auth/events", userToken.AccessToken, "")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("admin query with filters", func(t *testing.T) {
+		rec := request(http.MethodDelete, "/api/v2/auth/user/"+u.GetID()+"/sessions", adminToken.AccessToken, "")
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		rec = request(http.MethodGet, "/api/v2/auth/events?userId="+u.GetID(), adminToken.AccessToken, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		resp := parse(t, rec)
+		assert.Equal(t, int64(2), resp.Meta.Count)
+		if assert.Len(t, resp.Records, 2) {
+			// newest first:
+			assert.Equal(t, user_models.AuthEventSessionRevoke, resp.Records[0].Type)
+			assert.Equal(t, int64(admin.ID), *resp.Records[0].ActorID)
+		}
+
+		rec = request(http.MethodGet, "/api/v2/auth/events?userId="+u.GetID()+"&type="+user_models.AuthEventPasswordChange, adminToken.AccessToken, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		resp = parse(t, rec)
+		assert.Equal(t, int64(1), resp.Meta.Count)
+
+		rec = request(http.MethodGet, "/api/v2/auth/events?since=invalid", adminToken.AccessToken, "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("record without request", func(t *testing.T) {
+		r := user_models.RecordAuthEvent(nil, user_models.AuthEventAccountUnlocked, u.GetID(), map[string]interface{}{"source": "job"})
+		if assert.NotNil(t, r) {
+			assert.NotZero(t, r.ID)
+			assert.Empty(t, r.IP)
+			assert.Nil(t, r.ActorID)
+		}
+	})
+
+	t.Run("user delete removes the user events", func(t *testing.T) {
+		deleted := user_models.UserModel{
+			Username: gofakeit.UUID(),
+			Email:    gofakeit.Email(),
+			Active:   true,
+		}
+		err := deleted.Save(ctx)
+		assert.NoError(t, err)
+
+		user_models.RecordAuthEvent(ctx, user_models.AuthEventLogin, deleted.GetID(), nil)
+
+		err = deleted.Delete()
+		assert.NoError(t, err)
+
+		events, count, err := user_models.QueryAuthEvents(&user_models.AuthEventQueryOpts{UserID: deleted.GetID(), Limit: 10})
+		assert.NoError(t, err)
+		assert.Empty(t, events)
+		assert.Zero(t, count)
+	})
+}
@@ -1,11 +1,14 @@
 package user
 
 import (
-	"context"
+	"errors"
 	"fmt"
-	"log"
 
 	"github.com/go-bolo/bolo"
+	user_helpers "github.com/go-bolo/user/helpers"
+	user_models "github.com/go-bolo/user/models"
+	"github.com/go-bolo/user/security"
+	user_social "github.com/go-bolo/user/social"
 	"github.com/go-playground/validator/v10"
 	"github.com/gookit/event"
 	"github.com/gorilla/sessions"
@@ -21,14 +24,38 @@ type AuthPlugin struct {
 	// a map with valid reset page prefix:
 	ResetPrefixNames map[string]string
 
-	AuthController         *AuthController
-	SessionController      *SessionController
-	FacebookAuthController *FacebookAuthController
+	AuthController           *AuthController
+	SessionController        *SessionController
+	FacebookAuthController   *FacebookAuthController
+	MfaController            *MfaController
+	SocialAuthController     *SocialAuthController
+	AuthEventController      *AuthEventController
+	MagicLinkController      *MagicLinkController
+	WebAuthnController       *WebAuthnController
+	SigningKeyController     *SigningKeyController
+	TokenController          *PersonalAccessTokenController
+	OAuthClientController    *OAuthClientController
+	OAuthAuthorizeController *OAuthAuthorizeController
+	OpenIDController         *OpenIDController
 
 	Name string
 
-	SessionStore  sessions.Store // TODO! add more session store options as plugins
+	SessionStore  sessions.Store
 	SessionResave bool
+	// Creates the SessionStore in the bindMiddlewares step, default is the RedisSessionStoreFactory
+	SessionStoreFactory SessionStoreFactory
+
+	// Login throttle used in all login handlers, set in AuthPluginCfgs to use a custom one
+	LoginThrottle security.LoginThrottleInterface
+	// Store of the default login throttle, default is the redis store with the AUTH_THROTTLE_REDIS_ADDR_* configurations
+	LoginThrottleStore user_helpers.KVStore
+	// Password policy used in all endpoints that set passwords, set in AuthPluginCfgs to use a custom one
+	PasswordPolicy security.PasswordPolicyInterface
+	// Account lock after repeated login failures, set in AuthPluginCfgs to use a custom one
+	AccountLockout *security.AccountLockout
+
+	// Social login providers by name, available in /auth/:provider/...
+	SocialProviders map[string]user_social.Provider
 }
 
 func (p *AuthPlugin) GetName() string {
@@ -41,6 +68,41 @@ func (p *AuthPlugin) Init(app bolo.App) error {
 	p.AuthController = NewAuthController(&NewAuthControllerCFG{App: app})
 	p.SessionController = NewSessionController(&NewSessionControllerCFG{App: app})
 	p.FacebookAuthController = NewFacebookAuthController(&NewFacebookAuthControllerCFG{App: app})
+	p.MfaController = NewMfaController(&NewMfaControllerCFG{App: app})
+	p.SocialAuthController = NewSocialAuthController(&NewSocialAuthControllerCFG{App: app})
+	p.AuthEventController = NewAuthEventController(&NewAuthEventControllerCFG{App: app})
+	p.MagicLinkController = NewMagicLinkController(&NewMagicLinkControllerCFG{App: app})
+	p.WebAuthnController = NewWebAuthnController(&NewWebAuthnControllerCFG{App: app})
+	p.SigningKeyController = NewSigningKeyController(&NewSigningKeyControllerCFG{App: app})
+	p.TokenController = NewPersonalAccessTokenController(&NewPersonalAccessTokenControllerCFG{App: app})
+	p.OAuthClientController = NewOAuthClientController(&NewOAuthClientControllerCFG{App: app})
+	p.OAuthAuthorizeController = NewOAuthAuthorizeController(&NewOAuthAuthorizeControllerCFG{App: app})
+	p.OpenIDController = NewOpenIDController(&NewOpenIDControllerCFG{App: app})
+
+	if p.SocialProviders == nil {
+		p.SocialProviders = map[string]user_social.Provider{}
+	}
+
+	if p.LoginThrottle == nil {
+		if p.LoginThrottleStore != nil {
+			p.LoginThrottle = security.NewLoginThrottleWithStore(app, p.LoginThrottleStore)
+		} else {
+			p.LoginThrottle = security.NewLoginThrottle(app)
+		}
+	}
+
+	if p.PasswordPolicy == nil {
+		p.PasswordPolicy = security.NewPasswordPolicy(app)
+	}
+
+	if p.AccountLockout == nil {
+		p.AccountLockout = security.NewAccountLockout(app)
+	}
+
+	err := security.ConfigurePasswordHashers(app)
+	if err != nil {
+		return fmt.Errorf("AuthPlugin.Init error on configure password hashers: %w", err)
+	}
 
 	app.GetEvents().On("install", event.ListenerFunc(func(e event.Event) error {
 		InstallAuth(app)
@@ -64,6 +126,10 @@ func (p *AuthPlugin) Init(app bolo.App) error {
 		return p.setTemplateFunctions(app)
 	}), event.Normal)
 
+	app.GetEvents().On(user_models.UserLockedEventName, event.ListenerFunc(func(e event.Event) error {
+		return p.OnUserLocked(e)
+	}), event.Normal)
+
 	app.GetEvents().On("http-error", event.ListenerFunc(func(e event.Event) error {
 		return p.OnHTTPError(app, e)
 	}), event.Normal)
@@ -80,16 +146,61 @@ func (p *AuthPlugin) Init(app bolo.App) error {
 	return nil
 }
 
+func (p *AuthPlugin) GetLoginThrottle() security.LoginThrottleInterface {
+	return p.LoginThrottle
+}
+
+func (p *AuthPlugin) GetPasswordPolicy() security.PasswordPolicyInterface {
+	return p.PasswordPolicy
+}
+
+func (p *AuthPlugin) GetAccountLockout() *security.AccountLockout {
+	return p.AccountLockout
+}
+
+// OnUserLocked sends the unlock link to the user after one account lock
+func (p *AuthPlugin) OnUserLocked(e event.Event) error {
+	ctx, _ := e.Get("ctx").(*bolo.RequestContext)
+	u, _ := e.Get("user").(*user_models.UserModel)
+	if ctx == nil || u == nil {
+		return nil
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"userId":      u.ID,
+		"ip":          u.LastFailedLoginIP,
+		"lockedUntil": u.LockedUntil,
+	}).Warn("AuthPlugin account locked after repeated login failures")
+
+	return SendAccountLockedEmail(ctx, u)
+}
+
+// RegisterSocialProvider adds or replaces one social login provider
+func (p *AuthPlugin) RegisterSocialProvider(provider user_social.Provider) {
+	if p.SocialProviders == nil {
+		p.SocialProviders = map[string]user_social.Provider{}
+	}
+
+	p.SocialProviders[provider.GetName()] = provider
+}
+
+// GetSocialProvider returns the social login provider or nil if it is not registered
+func (p *AuthPlugin) GetSocialProvider(name string) user_social.Provider {
+	return p.SocialProviders[name]
+}
+
 func (p *AuthPlugin) bindMiddlewares(app bolo.App) error {
 	logrus.Debug("AuthPlugin.bindMiddlewares " + p.GetName())
 
-	var err error
-
 	cfgs := app.GetConfiguration()
 
-	store, err := redisstore.NewRedisStore(context.Background(), SessionDBWriter)
+	if p.SessionStoreFactory == nil {
+		p.SessionStoreFactory = RedisSessionStoreFactory
+	}
+
+	store, err := p.SessionStoreFactory(app)
 	if err != nil {
-		log.Fatal("failed to create redis store: ", err)
+		return fmt.Errorf("AuthPlugin.bindMiddlewares error on create session store: %w", err)
 	}
 
 	p.SessionStore = store
@@ -106,8 +217,8 @@ func (r *AuthPlugin) BindRoutes(app bolo.App) error {
 	logrus.Debug(r.GetName() + " BindRoutes")
 
 	router := app.SetRouterGroup("auth", "/auth")
-	router.GET("/change-password", r.AuthController.ChangeOwnPassword_Page) // ok
-	router.POST("/change-password", r.AuthController.ChangeOwnPassword)     // ok
+	router.GET("/change-password", r.AuthController.ChangeOwnPassword_Page, fullAccessTokenMiddleware) // ok
+	router.POST("/change-password", r.AuthController.ChangeOwnPassword, fullAccessTokenMiddleware)     // ok
 	router.POST("/logout", r.AuthController.Logout)
 	router.GET("/logout", r.AuthController.Logout)
 	// Step 1 to reset password
@@ -116,28 +227,119 @@ func (r *AuthPlugin) BindRoutes(app bolo.App) error {
 	// Step 2 to reset password
 	router.GET("/:userID/forgot-password/reset", r.AuthController.ForgotPassword_ResetPage)
 	router.POST("/:userID/forgot-password/reset", r.AuthController.ForgotPassword_ResetPage)
-
+	// Account activation after signup:
+	router.GET("/:userID/activate", r.AuthController.Activate)
+	// Unlock after repeated login failures:
+	router.GET("/:userID/unlock", r.AuthController.UnlockAccount)
+	router.GET("/:userID/confirm-email", r.AuthController.ConfirmEmailChangePage)
+	router.POST("/:userID/confirm-email", r.AuthController.ConfirmEmailChange)
+	router.GET("/:userID/revert-email", r.AuthController.RevertEmailChangePage)
+	router.POST("/:userID/revert-email", r.AuthController.RevertEmailChange)
+	router.POST("/magic-link", r.MagicLinkController.Request)
+	router.GET("/:userID/magic-link", r.MagicLinkController.LoginPage)
+	router.POST("/:userID/magic-link", r.MagicLinkController.Login)
+
+	// routes with the fullAccessTokenMiddleware change the account and are not available for oauth2 tokens with scopes
+	// and personal access tokens, the revocation routes only remove access and are available with the permission:
 	routerV2 := app.SetRouterGroup("auth_v2", "/api/v2/auth")
+	routerV2.POST("/signup", r.AuthController.Signup)
 	routerV2.POST("/forgot-password/process", r.AuthController.ForgotPassword_Process)
-	routerV2.POST("/change-password", r.AuthController.ChangeOwnPasswordApi)
+	routerV2.POST("/change-password", r.AuthController.ChangeOwnPasswordApi, fullAccessTokenMiddleware)
+	routerV2.POST("/change-email", r.AuthController.ChangeEmail, fullAccessTokenMiddleware)
+	routerV2.POST("/magic-link", r.MagicLinkController.Request)
+	// TOTP two-factor authentication:
+	routerV2.GET("/mfa", r.MfaController.GetStatus)
+	routerV2.POST("/mfa/totp/enroll", r.MfaController.TOTPEnroll, fullAccessTokenMiddleware)
+	routerV2.POST("/mfa/totp/confirm", r.MfaController.TOTPConfirm, fullAccessTokenMiddleware)
+	routerV2.POST("/mfa/totp/disable", r.MfaController.TOTPDisable, fullAccessTokenMiddleware)
+	routerV2.POST("/mfa/recovery-codes", r.MfaController.RegenerateRecoveryCodes, fullAccessTokenMiddleware)
+	// FIDO2/WebAuthn credentials, passkey login and second factor:
+	routerV2.GET("/webauthn/credentials", r.WebAuthnController.ListCredentials)
+	routerV2.DELETE("/webauthn/credentials/:id", r.WebAuthnController.DeleteCredential, fullAccessTokenMiddleware)
+	routerV2.POST("/webauthn/register/begin", r.WebAuthnController.RegisterBegin, fullAccessTokenMiddleware)
+	routerV2.POST("/webauthn/register/finish", r.WebAuthnController.RegisterFinish, fullAccessTokenMiddleware)
+	routerV2.POST("/webauthn/login/begin", r.WebAuthnController.LoginBegin)
+	routerV2.POST("/webauthn/login/finish", r.WebAuthnController.LoginFinish)
+	routerV2.POST("/webauthn/mfa/begin", r.WebAuthnController.MfaBegin)
+	routerV2.POST("/webauthn/mfa/finish", r.WebAuthnController.MfaFinish)
+	// active sessions and oauth2 tokens:
+	routerV2.GET("/sessions", r.SessionController.ListSessions)
+	routerV2.DELETE("/sessions/:id", r.SessionController.RevokeSession, fullAccessTokenMiddleware)
+	routerV2.DELETE("/user/:userID/sessions", r.SessionController.RevokeAllUserSessions)
+	routerV2.DELETE("/user/:userID/credentials", r.SessionController.RevokeAllUserCredentials)
+	routerV2.POST("/user/:userID/unlock", r.AuthController.UnlockUser, fullAccessTokenMiddleware)
+	// linked social login accounts:
+	routerV2.GET("/identities", r.SocialAuthController.ListIdentities)
+	routerV2.POST("/identities/:provider", r.SocialAuthController.LinkWithAppCode, fullAccessTokenMiddleware)
+	routerV2.DELETE("/identities/:provider", r.SocialAuthController.Unlink, fullAccessTokenMiddleware)
+	// security audit log:
+	routerV2.GET("/events", r.AuthEventController.Query)
+	routerV2.GET("/activity", r.AuthEventController.MyActivity)
+	// JWT access tokens signing keys:
+	routerV2.GET("/signing-keys", r.SigningKeyController.List)
+	routerV2.POST("/signing-keys/rotate", r.SigningKeyController.Rotate, fullAccessTokenMiddleware)
+
+	routerV2.GET("/tokens", r.TokenController.List)
+	routerV2.POST("/tokens", r.TokenController.Create, fullAccessTokenMiddleware)
+	routerV2.DELETE("/tokens/:id", r.TokenController.Revoke, fullAccessTokenMiddleware)
+	routerV2.GET("/user/:userID/tokens", r.TokenController.ListUserTokens)
+	routerV2.POST("/user/:userID/tokens", r.TokenController.CreateUserToken, fullAccessTokenMiddleware)
+	routerV2.DELETE("/user/:userID/tokens/:id", r.TokenController.RevokeUserToken)
+
+	routerV2.GET("/oauth-clients", r.OAuthClientController.List)
+	routerV2.POST("/oauth-clients", r.OAuthClientController.Create, fullAccessTokenMiddleware)
+	routerV2.DELETE("/oauth-clients/:clientId", r.OAuthClientController.Delete)
 
 	mainRouter := app.GetRouter()
 	mainRouter.GET("/login", r.SessionController.LoginPage) // ok
 	mainRouter.POST("/login", r.SessionController.Login)    // ok
+	mainRouter.POST("/login/mfa", r.SessionController.LoginMfa)
 	mainRouter.GET("/logout", r.SessionController.Logout)
 	mainRouter.POST("/logout", r.SessionController.Logout)
+	mainRouter.GET("/oauth/authorize", r.OAuthAuthorizeController.Authorize)
+	mainRouter.POST("/oauth/authorize", r.OAuthAuthorizeController.Decide)
+	mainRouter.GET("/oauth/userinfo", r.OpenIDController.UserInfo)
+	mainRouter.POST("/oauth/userinfo", r.OpenIDController.UserInfo)
+	mainRouter.GET("/oauth/logout", r.OpenIDController.Logout)
+	mainRouter.POST("/oauth/logout", r.OpenIDController.Logout)
+	mainRouter.GET("/.well-known/openid-configuration", r.OpenIDController.Discovery)
 
 	router.GET("/current", r.AuthController.GetCurrentUser) // ok
 	// Compatibility with we.js:
-	router.POST("/:userID/new-password", r.AuthController.SetPassword)
-	router.POST("/:userID/set-password", r.AuthController.SetPassword)
+	router.POST("/:userID/new-password", r.AuthController.SetPassword, fullAccessTokenMiddleware)
+	router.POST("/:userID/set-password", r.AuthController.SetPassword, fullAccessTokenMiddleware)
 
 	// social auths:
+	r.registerFacebookProviderFromConfig(app)
+
+	socialCtl := r.SocialAuthController
+	router.GET("/:provider/login", socialCtl.Login)
+	router.GET("/:provider/callback", socialCtl.Callback)
+	router.GET("/:provider/link", socialCtl.Link)
+	router.POST("/:provider/app-code", socialCtl.AppCodeLogin)
+
 	fbAuthCtl := r.FacebookAuthController
 	mainRouter.POST("/auth/facebook/app-login-code", fbAuthCtl.LoginWithFacebookAppCode)
 	return nil
 }
 
+// registerFacebookProviderFromConfig keeps the Facebook login configured with the SITE_FACEBOOK_APP_ID configuration
+func (p *AuthPlugin) registerFacebookProviderFromConfig(app bolo.App) {
+	if p.GetSocialProvider("facebook") != nil {
+		return
+	}
+
+	cfgs := app.GetConfiguration()
+	clientID := cfgs.Get("SITE_FACEBOOK_APP_ID")
+	clientSecret := cfgs.Get("FACEBOOK_CLIENT_SECRET")
+
+	if clientID == "" || clientSecret == "" {
+		return
+	}
+
+	p.RegisterSocialProvider(user_social.NewFacebookProvider(clientID, clientSecret, cfgs.Get("FACEBOOK_REDIRECT_URI")))
+}
+
 func (p *AuthPlugin) setTemplateFunctions(app bolo.App) error {
 	app.SetTemplateFunction("renderFlashMessages", renderFlashMessages)
 
@@ -185,13 +387,24 @@ func (p *AuthPlugin) Bootstrap(app bolo.App) error {
 	return nil
 }
 
+// OnClose closes the session store and the login throttle, like the redis connections and the cleanup goroutines
 func (p *AuthPlugin) OnClose(app bolo.App) error {
-	switch p.SessionStore.(type) {
+	var errs []error
+
+	switch store := p.SessionStore.(type) {
 	case *redistore.RediStore:
-		return p.SessionStore.(*redistore.RediStore).Close()
+		errs = append(errs, store.Close())
+	case *redisstore.RedisStore:
+		// the redis connection is shared in SessionDBWriter
+	case interface{ Close() error }:
+		errs = append(errs, store.Close())
 	}
 
-	return nil
+	if l, ok := p.LoginThrottle.(interface{ Close() error }); ok {
+		errs = append(errs, l.Close())
+	}
+
+	return errors.Join(errs...)
 }
 
 func (p *AuthPlugin) GetMigrations() []*bolo.Migration {
@@ -200,12 +413,36 @@ func (p *AuthPlugin) GetMigrations() []*bolo.Migration {
 
 type AuthPluginCfgs struct {
 	ResetPrefixNames map[string]string
+	// Optional login throttle, the default is the redis security.LoginThrottle
+	LoginThrottle security.LoginThrottleInterface
+	// Optional store of the default login throttle, the default is redis.
+	// Other options: user_helpers.MemoryKVStore and user_models.DatabaseKVStore
+	LoginThrottleStore user_helpers.KVStore
+	// Optional password policy, the default is the security.PasswordPolicy with the AUTH_PASSWORD_* configurations
+	PasswordPolicy security.PasswordPolicyInterface
+	// Optional account lockout, the default is the security.AccountLockout with the AUTH_LOCKOUT_* configurations
+	AccountLockout *security.AccountLockout
+	// Optional session store factory, the default is the RedisSessionStoreFactory.
+	// Other options: DatabaseSessionStoreFactory, CookieSessionStoreFactory and MemorySessionStoreFactory
+	SessionStoreFactory SessionStoreFactory
+	// Social login providers, Facebook is also registered if the SITE_FACEBOOK_APP_ID configuration is set
+	SocialProviders []user_social.Provider
 }
 
 func NewAuthPlugin(cfg *AuthPluginCfgs) *AuthPlugin {
 	p := AuthPlugin{
-		Name:             "auth",
-		ResetPrefixNames: cfg.ResetPrefixNames,
+		Name:                "auth",
+		ResetPrefixNames:    cfg.ResetPrefixNames,
+		LoginThrottle:       cfg.LoginThrottle,
+		LoginThrottleStore:  cfg.LoginThrottleStore,
+		PasswordPolicy:      cfg.PasswordPolicy,
+		AccountLockout:      cfg.AccountLockout,
+		SessionStoreFactory: cfg.SessionStoreFactory,
 	}
+
+	for _, provider := range cfg.SocialProviders {
+		p.RegisterSocialProvider(provider)
+	}
+
 	return &p
 }
@@ -2,13 +2,16 @@ package user
 
 import (
 	"bytes"
+	"fmt"
 	"net/http"
 
 	"github.com/go-bolo/bolo"
 	"github.com/go-bolo/bolo/acl"
+	"github.com/go-bolo/bolo/helpers"
 	"github.com/go-bolo/metatags"
 	user_models "github.com/go-bolo/user/models"
 	"github.com/google/uuid"
+	"github.com/gookit/event"
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -121,6 +124,8 @@ func (ctl *Controller) Create(c echo.Context) error {
 		return err
 	}
 
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventUserCreate, record.GetID(), nil)
+
 	err = record.LoadData()
 	if err != nil {
 		return err
@@ -237,7 +242,10 @@ func (ctl *Controller) Update(c echo.Context) error {
 
 	record.LoadData()
 
-	body := FindOneJSONResponse{Record: &record}
+	// the body is bound in one copy and only the editable fields are changed:
+	data := record
+	data.Roles = nil
+	body := FindOneJSONResponse{Record: &data}
 
 	if err := c.Bind(&body); err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -251,10 +259,53 @@ func (ctl *Controller) Update(c echo.Context) error {
 		return c.NoContent(http.StatusNotFound)
 	}
 
+	record.SetProfileFields(&data)
+
+	// roles are changed in the UpdateUserRoles and the users change the own email with the confirmation flow:
+	if ctx.Can("manage_users") {
+		record.Active = data.Active
+		record.Blocked = data.Blocked
+
+		if data.Email != record.Email {
+			record.Email = user_models.NormalizeEmail(data.Email)
+			// the new email was not confirmed by the user:
+			record.EmailVerified = false
+			if !user_models.ValidEmail(record.Email) {
+				return c.JSON(http.StatusBadRequest, bolo.ValidationResponse{
+					Errors: []*bolo.ValidationFieldError{{
+						Field:   "email",
+						Tag:     "email",
+						Value:   record.Email,
+						Message: "auth.change-email.invalid-email",
+					}},
+				})
+			}
+
+			inUse, err := user_models.IsEmailInUse(record.Email, record.ID)
+			if err != nil {
+				return err
+			}
+
+			if inUse {
+				return c.JSON(http.StatusBadRequest, bolo.ValidationResponse{
+					Errors: []*bolo.ValidationFieldError{{
+						Field:   "email",
+						Tag:     "unique",
+						Value:   record.Email,
+						Message: "auth.change-email.email-in-use",
+					}},
+				})
+			}
+		}
+	}
+
 	err = record.Save(ctx)
 	if err != nil {
 		return err
 	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventUserUpdate, record.GetID(), nil)
+
 	resp := FindOneJSONResponse{
 		Record: &record,
 	}
@@ -296,6 +347,11 @@ func (ctl *Controller) Delete(c echo.Context) error {
 		return err
 	}
 
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventUserDelete, record.GetID(), map[string]interface{}{
+		"username": record.Username,
+		"email":    record.Email,
+	})
+
 	return c.NoContent(http.StatusNoContent)
 }
 
@@ -413,23 +469,34 @@ type UserRolesResponse struct {
 
 func (ctl *Controller) GetUserRolesAndPermissions(c echo.Context) error {
 	ctx := c.(*bolo.RequestContext)
-	appStruct := ctx.App.(*bolo.AppStruct)
-	r := UserRolesResponse{Roles: appStruct.RolesList}
+	r := UserRolesResponse{Roles: ctx.App.GetRoles()}
 
 	return c.JSON(http.StatusOK, &r)
 }
-func (ctl *Controller) GetUserRoles(c echo.Context) error {
-	return c.String(http.StatusNotImplemented, "Not implemented")
-}
+
+// UserRolesUpdatedEventName is triggered after one change in the user roles
+const UserRolesUpdatedEventName = "user-roles-updated"
 
 type UserRolesBodyRequest struct {
 	UserRoles []string `json:"userRoles"`
 }
 
+// UserRolesUpdateResponse - the user roles before and after the change
+type UserRolesUpdateResponse struct {
+	OldRoles []string `json:"oldRoles"`
+	NewRoles []string `json:"newRoles"`
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+}
+
 func (ctl *Controller) UpdateUserRoles(c echo.Context) error {
 	userID := c.Param("userID")
 	ctx := c.(*bolo.RequestContext)
 
+	if !ctx.Can("manage_user_roles") {
+		return forbiddenError("Controller.UpdateUserRoles user without the manage_user_roles permission")
+	}
+
 	var user user_models.UserModel
 	err := user_models.UserFindOne(userID, &user)
 	if err != nil {
@@ -456,17 +523,143 @@ func (ctl *Controller) UpdateUserRoles(c echo.Context) error {
 		return c.NoContent(http.StatusNotFound)
 	}
 
-	err = user.SetRoles(body.UserRoles)
-	if err != nil {
-		return err
+	newRoles := []string{}
+	for _, name := range body.UserRoles {
+		if !helpers.SliceContains(newRoles, name) {
+			newRoles = append(newRoles, name)
+		}
+	}
+
+	resp := UserRolesUpdateResponse{
+		OldRoles: append([]string{}, user.GetRoles()...),
+		NewRoles: newRoles,
+	}
+	resp.Added, resp.Removed = diffRoles(resp.OldRoles, resp.NewRoles)
+
+	// only the changed roles are checked, then the user can keep roles added by other features:
+	validationErrors := []*bolo.ValidationFieldError{}
+	for _, name := range resp.Added {
+		role := ctx.App.GetRole(name)
+		if role == nil || !role.CanAddInUsers {
+			validationErrors = append(validationErrors, &bolo.ValidationFieldError{
+				Field:   "userRoles",
+				Tag:     "role",
+				Value:   name,
+				Message: "acl.role.not-assignable",
+			})
+		}
+	}
+
+	for _, name := range resp.Removed {
+		if !canRemoveUserRole(ctx, name) {
+			validationErrors = append(validationErrors, &bolo.ValidationFieldError{
+				Field:   "userRoles",
+				Tag:     "role",
+				Value:   name,
+				Message: "acl.role.not-removable",
+			})
+		}
+	}
+
+	if len(validationErrors) > 0 {
+		return c.JSON(http.StatusBadRequest, bolo.ValidationResponse{Errors: validationErrors})
+	}
+
+	// managers can only grant or revoke roles with permissions they already have:
+	for _, name := range append(append([]string{}, resp.Added...), resp.Removed...) {
+		if !canChangeUserRole(ctx, name) {
+			return forbiddenError("Controller.UpdateUserRoles user can not change the role " + name)
+		}
+	}
+
+	if len(resp.Added) == 0 && len(resp.Removed) == 0 {
+		return c.JSON(http.StatusOK, &resp)
 	}
 
-	err = user.Save(ctx)
+	err = user.SetRoles(newRoles)
 	if err != nil {
 		return errors.Wrap(err, "user.UpdateUserRoles error on save user roles")
 	}
 
-	return c.JSON(http.StatusOK, make(map[string]string))
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventRolesChange, user.GetID(), map[string]interface{}{
+		"oldRoles": resp.OldRoles,
+		"newRoles": resp.NewRoles,
+		"added":    resp.Added,
+		"removed":  resp.Removed,
+	})
+
+	err, _ = ctx.App.GetEvents().Trigger(UserRolesUpdatedEventName, event.M{
+		"ctx":      ctx,
+		"user":     &user,
+		"oldRoles": resp.OldRoles,
+		"newRoles": resp.NewRoles,
+		"added":    resp.Added,
+		"removed":  resp.Removed,
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"userID": user.GetID(),
+			"error":  fmt.Sprintf("%+v\n", err),
+		}).Error("user.UpdateUserRoles error on trigger event")
+	}
+
+	return c.JSON(http.StatusOK, &resp)
+}
+
+// canRemoveUserRole checks if the role can be removed in the user roles update, roles that can not be added
+// in users are managed by other features and only administrators can remove them
+func canRemoveUserRole(ctx *bolo.RequestContext, name string) bool {
+	role := ctx.App.GetRole(name)
+	if role == nil || role.CanAddInUsers {
+		return true
+	}
+
+	return helpers.SliceContains(*ctx.GetAuthenticatedRoles(), "administrator")
+}
+
+// canChangeUserRole checks if the authenticated user has all the role permissions,
+// the administrator role can only be changed by administrators
+func canChangeUserRole(ctx *bolo.RequestContext, name string) bool {
+	if helpers.SliceContains(*ctx.GetAuthenticatedRoles(), "administrator") {
+		return true
+	}
+
+	if name == "administrator" {
+		return false
+	}
+
+	role := ctx.App.GetRole(name)
+	if role == nil {
+		// roles removed from the app have no permissions
+		return true
+	}
+
+	for _, permission := range role.Permissions {
+		if !ctx.Can(permission) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func diffRoles(oldRoles, newRoles []string) (added, removed []string) {
+	added = []string{}
+	removed = []string{}
+
+	for _, r := range newRoles {
+		if !helpers.SliceContains(oldRoles, r) {
+			added = append(added, r)
+		}
+	}
+
+	for _, r := range oldRoles {
+		if !helpers.SliceContains(newRoles, r) {
+			removed = append(removed, r)
+		}
+	}
+
+	return added, removed
 }
 
 type ControllerCfg struct {
@@ -0,0 +1,358 @@
+package user_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/bolo/acl"
+	"github.com/go-bolo/user"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/gookit/event"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestController_UpdateUserRoles(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	app.SetRole("roles_manager", acl.Role{
+		Name:          "roles_manager",
+		Permissions:   []string{"manage_user_roles", "find_user"},
+		CanAddInUsers: true,
+	})
+	app.SetRole("reviewer", acl.Role{
+		Name:          "reviewer",
+		Permissions:   []string{"find_user"},
+		CanAddInUsers: true,
+	})
+	app.SetRole("editor", acl.Role{
+		Name:          "editor",
+		Permissions:   []string{"update_user"},
+		CanAddInUsers: true,
+	})
+	app.SetRole("member", acl.Role{
+		Name:        "member",
+		Permissions: []string{"find_user"},
+	})
+
+	var updatedEvent event.Event
+	app.GetEvents().On(user.UserRolesUpdatedEventName, event.ListenerFunc(func(e event.Event) error {
+		updatedEvent = e
+		return nil
+	}), event.Normal)
+
+	newUser := func(roles ...string) *user_models.UserModel {
+		u := user_models.UserModel{
+			Username: gofakeit.UUID(),
+			Email:    gofakeit.Email(),
+			Active:   true,
+		}
+		u.SetRoles(roles)
+		err := u.Save(ctx)
+		assert.NoError(t, err)
+		t.Cleanup(func() { u.Delete() })
+		return &u
+	}
+
+	admin := newUser("administrator")
+	manager := newUser("roles_manager")
+	target := newUser()
+
+	adminToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, admin)
+	assert.NoError(t, err)
+	managerToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, manager)
+	assert.NoError(t, err)
+	targetToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, target)
+	assert.NoError(t, err)
+
+	requestUser := func(u *user_models.UserModel, accessToken string, roles ...string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(user.UserRolesBodyRequest{UserRoles: roles})
+
+		req := httptest.NewRequest(http.MethodPost, "/acl/user/"+u.GetID()+"/roles", strings.NewReader(string(body)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	request := func(accessToken string, roles ...string) *httptest.ResponseRecorder {
+		return requestUser(target, accessToken, roles...)
+	}
+
+	t.Run("requires the manage_user_roles permission", func(t *testing.T) {
+		rec := request(targetToken.AccessToken, "administrator")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Empty(t, getUserRoles(t, target.GetID()))
+	})
+
+	t.Run("only accepts known roles that can be added in users", func(t *testing.T) {
+		rec := request(adminToken.AccessToken, "reviewer", "unknown_role", "owner")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		var resp bolo.ValidationResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		if assert.Len(t, resp.Errors, 2) {
+			assert.Equal(t, "unknown_role", resp.Errors[0].Value)
+			assert.Equal(t, "owner", resp.Errors[1].Value)
+		}
+		assert.Empty(t, getUserRoles(t, target.GetID()))
+	})
+
+	t.Run("managers can grant roles with permissions they have", func(t *testing.T) {
+		updatedEvent = nil
+
+		rec := request(managerToken.AccessToken, "reviewer", "reviewer")
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var resp user.UserRolesUpdateResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{}, resp.OldRoles)
+		assert.Equal(t, []string{"reviewer"}, resp.NewRoles)
+		assert.Equal(t, []string{"reviewer"}, resp.Added)
+		assert.Equal(t, []string{}, resp.Removed)
+		assert.Equal(t, []string{"reviewer"}, getUserRoles(t, target.GetID()))
+
+		if assert.NotNil(t, updatedEvent) {
+			assert.Equal(t, []string{"reviewer"}, updatedEvent.Get("added"))
+		}
+	})
+
+	t.Run("managers can not grant roles with permissions they do not have", func(t *testing.T) {
+		rec := request(managerToken.AccessToken, "reviewer", "editor")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		rec = request(managerToken.AccessToken, "reviewer", "administrator")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		assert.Equal(t, []string{"reviewer"}, getUserRoles(t, target.GetID()))
+	})
+
+	t.Run("administrators can grant and revoke all roles", func(t *testing.T) {
+		rec := request(adminToken.AccessToken, "editor")
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var resp user.UserRolesUpdateResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"reviewer"}, resp.OldRoles)
+		assert.Equal(t, []string{"editor"}, resp.Added)
+		assert.Equal(t, []string{"reviewer"}, resp.Removed)
+		assert.Equal(t, []string{"editor"}, getUserRoles(t, target.GetID()))
+
+		events, _, err := user_models.QueryAuthEvents(&user_models.AuthEventQueryOpts{
+			UserID: target.GetID(),
+			Type:   user_models.AuthEventRolesChange,
+			Limit:  10,
+		})
+		assert.NoError(t, err)
+		assert.Len(t, events, 2)
+	})
+
+	t.Run("managers can not revoke roles with permissions they do not have", func(t *testing.T) {
+		rec := request(managerToken.AccessToken)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, []string{"editor"}, getUserRoles(t, target.GetID()))
+	})
+
+	t.Run("roles that can not be added in users are kept and only administrators can remove them", func(t *testing.T) {
+		member := newUser("member")
+
+		rec := requestUser(member, managerToken.AccessToken, "member", "reviewer")
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, []string{"member", "reviewer"}, getUserRoles(t, member.GetID()))
+
+		rec = requestUser(member, managerToken.AccessToken, "reviewer")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		var resp bolo.ValidationResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		if assert.Len(t, resp.Errors, 1) {
+			assert.Equal(t, "member", resp.Errors[0].Value)
+			assert.Equal(t, "acl.role.not-removable", resp.Errors[0].Message)
+		}
+		assert.Equal(t, []string{"member", "reviewer"}, getUserRoles(t, member.GetID()))
+
+		rec = requestUser(member, adminToken.AccessToken, "reviewer")
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, []string{"reviewer"}, getUserRoles(t, member.GetID()))
+	})
+}
+
+func getUserRoles(t *testing.T, userID string) []string {
+	var record user_models.UserModel
+	err := user_models.UserFindOne(userID, &record)
+	assert.NoError(t, err)
+	return record.GetRoles()
+}
+
+func TestUserModel_SaveRoles(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Roles:    []string{"editor", "reviewer"},
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	t.Run("records loaded without the roles keep the roles", func(t *testing.T) {
+		var record user_models.UserModel
+		err := user_models.UserFindOne(u.GetID(), &record)
+		assert.NoError(t, err)
+
+		record.DisplayName = "Bob"
+		err = record.Save(ctx)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"editor", "reviewer"}, getUserRoles(t, u.GetID()))
+	})
+
+	t.Run("save does not override the roles changed by other request", func(t *testing.T) {
+		var record user_models.UserModel
+		err := user_models.UserFindOne(u.GetID(), &record)
+		assert.NoError(t, err)
+		record.LoadData()
+
+		var other user_models.UserModel
+		err = user_models.UserFindOne(u.GetID(), &other)
+		assert.NoError(t, err)
+		err = other.SetRoles([]string{"editor"})
+		assert.NoError(t, err)
+
+		record.DisplayName = "Carol"
+		err = record.Save(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"editor"}, getUserRoles(t, u.GetID()))
+	})
+
+	t.Run("empty roles remove all the roles", func(t *testing.T) {
+		err := u.SetRoles([]string{})
+		assert.NoError(t, err)
+		assert.Empty(t, getUserRoles(t, u.GetID()))
+	})
+}
+
+func TestController_Update(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	app.SetRole("owner", acl.Role{
+		Name:        "owner",
+		Permissions: []string{"update_user"},
+	})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	admin := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	admin.SetRole("administrator")
+	err = admin.Save(ctx)
+	assert.NoError(t, err)
+	defer admin.Delete()
+
+	userToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+	assert.NoError(t, err)
+	adminToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &admin)
+	assert.NoError(t, err)
+
+	request := func(accessToken, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/user/"+u.GetID(), strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("owners only change the profile fields", func(t *testing.T) {
+		rec := request(userToken.AccessToken, `{"user": {"displayName": "Alice", "active": false, "blocked": true, "email": "alice@example.com", "roles": ["administrator"]}}`)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var record user_models.UserModel
+		err := user_models.UserFindOne(u.GetID(), &record)
+		assert.NoError(t, err)
+		assert.Equal(t, "Alice", record.DisplayName)
+		assert.True(t, record.Active)
+		assert.False(t, record.Blocked)
+		assert.Equal(t, u.Email, record.Email)
+		assert.Empty(t, record.GetRoles())
+	})
+
+	t.Run("managers can block users", func(t *testing.T) {
+		rec := request(adminToken.AccessToken, `{"user": {"blocked": true}}`)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var record user_models.UserModel
+		err := user_models.UserFindOne(u.GetID(), &record)
+		assert.NoError(t, err)
+		assert.True(t, record.Blocked)
+		assert.Equal(t, "Alice", record.DisplayName)
+	})
+
+	t.Run("managers can not change the email to one email in use", func(t *testing.T) {
+		rec := request(adminToken.AccessToken, `{"user": {"email": "`+strings.ToUpper(admin.Email)+`"}}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), "auth.change-email.email-in-use")
+
+		var record user_models.UserModel
+		err := user_models.UserFindOne(u.GetID(), &record)
+		assert.NoError(t, err)
+		assert.Equal(t, u.Email, record.Email)
+	})
+}
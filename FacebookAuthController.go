@@ -1,31 +1,29 @@
 package user
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/go-bolo/bolo"
 	user_models "github.com/go-bolo/user/models"
-	user_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	user_social "github.com/go-bolo/user/social"
 	"github.com/labstack/echo/v4"
-	"github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/facebook"
-	"gorm.io/gorm"
 )
 
 type FacebookAuthController struct {
 }
 
+// LoginWithFacebookAppCode - compatibility endpoint, same of the POST /auth/facebook/app-code
 func (ctl *FacebookAuthController) LoginWithFacebookAppCode(c echo.Context) error {
 	ctx := c.(*bolo.RequestContext)
+	authPlugin := ctx.App.GetPlugin("auth").(*AuthPlugin)
 
-	var OAuth2Config = GetFacebookOAuthConfig(ctx)
-	if OAuth2Config.ClientID == "" || OAuth2Config.ClientSecret == "" {
+	provider := authPlugin.GetSocialProvider("facebook")
+	if provider == nil {
 		return &bolo.HTTPError{
 			Code:     http.StatusNotFound,
 			Message:  "facebook auth configuration not set",
@@ -33,81 +31,7 @@ func (ctl *FacebookAuthController) LoginWithFacebookAppCode(c echo.Context) erro
 		}
 	}
 
-	ctx.SetResponseContentType("application/json")
-
-	var body LoginWithFacebookAppCodeBodyRequest
-	if err := c.Bind(&body); err != nil {
-		if _, ok := err.(*echo.HTTPError); ok {
-			return err
-		}
-		return c.NoContent(http.StatusNotFound)
-	}
-
-	token, err := OAuth2Config.Exchange(context.Background(), body.Code)
-	if err != nil || token == nil {
-		logrus.WithFields(logrus.Fields{
-			"err": err,
-		}).Error("LoginWithFacebookAppCode: error on exchange token")
-
-		return &bolo.HTTPError{
-			Code:     http.StatusUnauthorized,
-			Message:  "Invalid token",
-			Internal: fmt.Errorf("error on exchange token: %w", err),
-		}
-	}
-
-	fbUserDetails, fbUserDetailsError := GetUserInfoFromFacebook(token.AccessToken, ctx)
-	if fbUserDetailsError != nil {
-		logrus.WithFields(logrus.Fields{
-			"err": err,
-		}).Error("LoginWithFacebookAppCode: error on get user info from facebook")
-
-		return &bolo.HTTPError{
-			Code:     http.StatusUnauthorized,
-			Message:  "Invalid token",
-			Internal: fmt.Errorf("error on get user info from facebook: %w", fbUserDetailsError),
-		}
-	}
-
-	u, authTokenError := FindOrCreateUserFromFacebook(fbUserDetails, ctx)
-	if authTokenError != nil {
-		d, _ := json.Marshal(fbUserDetails)
-
-		logrus.WithFields(logrus.Fields{
-			"err":           authTokenError,
-			"fbUserDetails": string(d),
-			"u":             u,
-		}).Error("LoginWithFacebookAppCode: error on FindOrCreateUserFromFacebook")
-
-		return &bolo.HTTPError{
-			Code:     http.StatusUnauthorized,
-			Message:  "Invalid token",
-			Internal: fmt.Errorf("FindOrCreateUserFromFacebook: %w", authTokenError),
-		}
-	}
-
-	// Authenticate user:
-	data, err := user_oauth2_password.Oauth2GenerateAndSaveToken(ctx, u)
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"err": err,
-		}).Error("LoginWithFacebookAppCode: error on generate and save token")
-
-		return &bolo.HTTPError{
-			Code:     http.StatusBadRequest,
-			Message:  "Invalid token",
-			Internal: fmt.Errorf("error on generate and save token: %w", err),
-		}
-	}
-
-	resp := oauth2PasswordJSONResponse{
-		AccessToken:  &data.AccessToken,
-		RefreshToken: &data.RefreshToken,
-		ExpiresIn:    &data.ExpiresIn,
-		User:         u,
-	}
-
-	return c.JSON(200, &resp)
+	return authPlugin.SocialAuthController.appCodeLogin(c, provider)
 }
 
 type NewFacebookAuthControllerCFG struct {
@@ -131,13 +55,6 @@ type SuccessResponse struct {
 	Response interface{}
 }
 
-// Claims is  a struct that will be encoded to a JWT.
-// jwt.StandardClaims is an embedded type to provide expiry time
-type Claims struct {
-	Email string
-	jwt.StandardClaims
-}
-
 // UserDetails is struct used for user details
 type UserDetails struct {
 	Name     string
@@ -202,38 +119,29 @@ func GetUserInfoFromFacebook(token string, ctx *bolo.RequestContext) (FacebookUs
 	return fbUserDetails, nil
 }
 
-// SignInUser Used for Signing In the Users
+// FindOrCreateUserFromFacebook returns the user linked to the Facebook account or creates one new user
 func FindOrCreateUserFromFacebook(facebookUserDetails FacebookUserDetails, ctx *bolo.RequestContext) (*user_models.UserModel, error) {
 	if facebookUserDetails.Email == "" {
-		return nil, errors.New("FindOrCreateUserFromFacebook last name can't be empty")
+		return nil, errors.New("FindOrCreateUserFromFacebook email can't be empty")
 	}
 
 	if facebookUserDetails.Name == "" {
-		return nil, errors.New("FindOrCreateUserFromFacebook password can't be empty")
+		return nil, errors.New("FindOrCreateUserFromFacebook name can't be empty")
 	}
 
-	u := user_models.UserModel{}
-	err := user_models.UserFindOneByUsername(facebookUserDetails.Email, &u)
-	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-		return nil, errors.New("FindOrCreateUserFromFacebook user not found")
+	profile := user_social.Profile{
+		Provider: "facebook",
+		Subject:  facebookUserDetails.ID,
+		Username: facebookUserDetails.ID,
+		Email:    facebookUserDetails.Email,
+		Name:     facebookUserDetails.Name,
 	}
 
-	if u.ID == 0 {
-		// user not found, create new user
-		u.Username = facebookUserDetails.ID
-		u.Email = facebookUserDetails.Email
-		u.DisplayName = facebookUserDetails.Name
-		u.FullName = facebookUserDetails.Name
-		u.Active = true
-		u.AcceptTerms = false
-
-		err = u.Save(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("FindOrCreateUserFromFacebook error on save user: %w", err)
-		}
+	if facebookUserDetails.Picture != nil {
+		profile.Picture = facebookUserDetails.Picture.Data.URL
 	}
 
-	return &u, nil
+	return FindOrCreateUserFromSocialProfile(ctx, &profile)
 }
 
 type oauth2PasswordJSONResponse struct {
@@ -0,0 +1,301 @@
+package user
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/emails"
+	"github.com/go-bolo/system_settings"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// same message for all valid requests, to not expose the registered emails
+const magicLinkRequestMessage = "Se o email estiver correto, enviamos um link de login para a sua caixa de entrada."
+
+type MagicLinkRequestBody struct {
+	Email string `json:"email" form:"email" validate:"required"`
+}
+
+// MagicLinkLoginJSONResponse - oauth2 tokens returned to API clients, same format of the oauth2 password grant
+type MagicLinkLoginJSONResponse struct {
+	AccessToken  string                 `json:"access_token"`
+	RefreshToken string                 `json:"refresh_token"`
+	ExpiresIn    int64                  `json:"expires_in"`
+	User         *user_models.UserModel `json:"user"`
+}
+
+// MagicLinkMfaRequiredResponse - returned to API clients of users with two-factor authentication,
+// the login continues in the oauth2 mfa_otp grant
+type MagicLinkMfaRequiredResponse struct {
+	Error     string `json:"error"`
+	MfaToken  string `json:"mfa_token"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+// magicLinkPageData - data of the confirmation page, the form posts the token to the Login action
+type magicLinkPageData struct {
+	UserID string `json:"userId"`
+	Token  string `json:"token"`
+}
+
+type NewMagicLinkControllerCFG struct {
+	App bolo.App
+}
+
+func NewMagicLinkController(cfg *NewMagicLinkControllerCFG) *MagicLinkController {
+	return &MagicLinkController{App: cfg.App}
+}
+
+// MagicLinkController - opt-in passwordless login with single use links sent by email,
+// enabled with the AUTH_MAGIC_LINK_ENABLED configuration
+type MagicLinkController struct {
+	App bolo.App
+}
+
+// Request - sends one login link to the user email. The response is the same for unknown, blocked and locked users
+func (ctl *MagicLinkController) Request(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !user_models.IsMagicLinkEnabled(ctl.App) {
+		return echo.NotFoundHandler(c)
+	}
+
+	var body MagicLinkRequestBody
+	if err := c.Bind(&body); err != nil {
+		if _, ok := err.(*echo.HTTPError); ok {
+			return err
+		}
+
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "invalid param or data format",
+			Internal: errors.Wrap(err, "invalid param or data format"),
+		}
+	}
+
+	if err := c.Validate(&body); err != nil {
+		return err
+	}
+
+	email := strings.TrimSpace(body.Email)
+
+	var u user_models.UserModel
+	err := user_models.UserFindOneByUsername(email, &u)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.Wrap(err, "MagicLinkController.Request error on find user")
+	}
+
+	if u.CanLogin() && !u.IsLocked() {
+		authToken, err := user_models.CreateMagicLinkToken(u.GetID())
+		if err != nil {
+			return errors.Wrap(err, "MagicLinkController.Request error on create token")
+		}
+
+		user_models.RecordAuthEvent(ctx, user_models.AuthEventMagicLinkReq, u.GetID(), nil)
+
+		SendMagicLinkEmail(ctx, &u, authToken)
+	} else {
+		logrus.WithFields(logrus.Fields{
+			"email": email,
+		}).Debug("MagicLinkController.Request user not found or can not login")
+	}
+
+	if ctx.GetResponseContentType() == "application/json" {
+		return c.JSON(http.StatusOK, EmptySuccessResponse{
+			Messages: []*bolo.ResponseMessage{
+				{
+					Message: magicLinkRequestMessage,
+					Type:    "success",
+				},
+			},
+		})
+	}
+
+	AddFlashMessage(c, &FlashMessage{
+		Type:    "success",
+		Message: magicLinkRequestMessage,
+	})
+
+	return c.Redirect(http.StatusFound, "/login")
+}
+
+// LoginPage - renders the emailed link confirmation page. The token is only consumed in the Login POST, then
+// email scanners that prefetch the link do not use it
+func (ctl *MagicLinkController) LoginPage(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !user_models.IsMagicLinkEnabled(ctl.App) {
+		return echo.NotFoundHandler(c)
+	}
+
+	data := &magicLinkPageData{
+		UserID: c.Param("userID"),
+		Token:  c.QueryParam("t"),
+	}
+
+	if data.UserID == "" || data.Token == "" {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "auth.magic-link.token.required",
+			Internal: errors.New("auth.magic-link.token.required"),
+		}
+	}
+
+	if ctx.GetResponseContentType() == "application/json" {
+		return c.JSON(http.StatusOK, data)
+	}
+
+	ctx.Title = "Login"
+
+	return bolo.MinifiAndRender(http.StatusOK, "auth/magic-link", &bolo.TemplateCTX{
+		Ctx:    ctx,
+		Record: data,
+	}, ctx)
+}
+
+// Login - consumes the emailed link token sent in the "t" param of the confirmation form, creates one session for
+// web clients or returns the oauth2 tokens to API clients
+func (ctl *MagicLinkController) Login(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !user_models.IsMagicLinkEnabled(ctl.App) {
+		return echo.NotFoundHandler(c)
+	}
+
+	userID := c.Param("userID")
+	token := c.FormValue("t")
+	isJSON := ctx.GetResponseContentType() == "application/json"
+
+	if userID == "" || token == "" {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "auth.magic-link.token.required",
+			Internal: errors.New("auth.magic-link.token.required"),
+		}
+	}
+
+	valid, err := user_models.ConsumeMagicLinkToken(userID, token, user_models.GetMagicLinkTTL(ctl.App))
+	if err != nil {
+		return errors.Wrap(err, "MagicLinkController.Login error on consume token")
+	}
+
+	var u user_models.UserModel
+	if valid {
+		err = user_models.UserFindOne(userID, &u)
+		if err != nil {
+			return errors.Wrap(err, "MagicLinkController.Login error on find user")
+		}
+	}
+
+	if !u.CanLogin() {
+		user_models.RecordLoginFailure(ctx, userID, "", "magic_link", "invalid_token")
+
+		return &bolo.HTTPError{
+			Code:     http.StatusNotFound,
+			Message:  "auth.magic-link.token.invalid",
+			Internal: errors.New("auth.magic-link.token.invalid user id=" + userID),
+		}
+	}
+
+	if u.IsLocked() {
+		user_models.RecordLoginFailure(ctx, u.GetID(), u.Email, "magic_link", "account_locked")
+
+		if isJSON {
+			return &bolo.HTTPError{
+				Code:     http.StatusLocked,
+				Message:  "account_locked",
+				Internal: errors.New("auth.magic-link account locked user id=" + u.GetID()),
+			}
+		}
+
+		authPlugin := ctx.App.GetPlugin("auth").(*AuthPlugin)
+		return authPlugin.SessionController.renderAccountLocked(c)
+	}
+
+	hasMfa, err := user_models.UserHasMfaEnabled(u.GetID())
+	if err != nil {
+		return err
+	}
+
+	if hasMfa {
+		challenge, err := user_models.CreateMfaChallenge(u.GetID())
+		if err != nil {
+			return err
+		}
+
+		if isJSON {
+			return c.JSON(http.StatusForbidden, &MagicLinkMfaRequiredResponse{
+				Error:     "mfa_required",
+				MfaToken:  challenge.Token,
+				ExpiresIn: int64(user_models.MfaChallengeTTL.Seconds()),
+			})
+		}
+
+		authPlugin := ctx.App.GetPlugin("auth").(*AuthPlugin)
+		return authPlugin.SessionController.renderLoginMfaPage(c, http.StatusOK, challenge.Token)
+	}
+
+	user_models.OnUserLoginSuccess(ctx, &u)
+
+	if isJSON {
+		data, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+		if err != nil {
+			return err
+		}
+
+		user_models.RecordAuthEvent(ctx, user_models.AuthEventLogin, u.GetID(), map[string]interface{}{
+			"method": "magic_link",
+			"client": "oauth2",
+		})
+
+		return c.JSON(http.StatusOK, &MagicLinkLoginJSONResponse{
+			AccessToken:  data.AccessToken,
+			RefreshToken: data.RefreshToken,
+			ExpiresIn:    data.ExpiresIn,
+			User:         &u,
+		})
+	}
+
+	_, err = SetUserSession(ctx.App, c, &u)
+	if err != nil {
+		return err
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventLogin, u.GetID(), map[string]interface{}{
+		"method": "magic_link",
+		"client": "session",
+	})
+
+	return c.Redirect(http.StatusFound, "/")
+}
+
+// SendMagicLinkEmail sends the login link to the user email
+func SendMagicLinkEmail(ctx *bolo.RequestContext, u *user_models.UserModel, authToken *user_models.AuthTokenModel) {
+	if ctx.App.GetPlugin("emails") == nil {
+		logrus.WithFields(logrus.Fields{
+			"magicLinkUrl": authToken.GetMagicLinkUrl(ctx),
+			"user_id":      u.GetID(),
+		}).Warn("SendMagicLinkEmail E-mail not sent, then the login url was logged")
+		return
+	}
+
+	emails.SendEmailAsync(&emails.EmailOpts{
+		To:           u.Email,
+		TemplateName: "AuthMagicLinkEmail",
+		Variables: emails.TemplateVariables{
+			"displayName":  u.DisplayName,
+			"siteName":     system_settings.Get("siteName"),
+			"siteUrl":      ctx.AppOrigin,
+			"username":     u.Username,
+			"magicLinkUrl": authToken.GetMagicLinkUrl(ctx),
+			"ttl":          strconv.Itoa(int(user_models.GetMagicLinkTTL(ctx.App).Minutes())),
+		},
+	})
+}
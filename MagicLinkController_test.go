@@ -0,0 +1,168 @@
+package user_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user"
+	user_models "github.com/go-bolo/user/models"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMagicLinkController(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    strings.ToLower(gofakeit.Email()),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	requestLink := func(email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v2/auth/magic-link", strings.NewReader(`{"email":"`+email+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	openLink := func(token string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/"+u.GetID()+"/magic-link", strings.NewReader(url.Values{"t": {token}}.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		req.Header.Set(echo.HeaderAccept, accept)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	findToken := func() *user_models.AuthTokenModel {
+		var token user_models.AuthTokenModel
+		err := app.GetDB().
+			Where("userId = ? AND tokenType = ?", u.GetID(), user_models.MagicLinkTokenType).
+			Order("id DESC").
+			First(&token).Error
+		assert.NoError(t, err)
+		return &token
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		rec := requestLink(u.Email)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Setenv("AUTH_MAGIC_LINK_ENABLED", "true")
+
+	t.Run("same response for known and unknown emails", func(t *testing.T) {
+		unknown := requestLink("unknown-" + u.Email)
+		assert.Equal(t, http.StatusOK, unknown.Code)
+
+		known := requestLink(u.Email)
+		assert.Equal(t, http.StatusOK, known.Code)
+		assert.Equal(t, unknown.Body.String(), known.Body.String())
+	})
+
+	t.Run("api clients receive oauth2 tokens and links are single use", func(t *testing.T) {
+		rec := requestLink(u.Email)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		token := findToken()
+
+		rec = openLink(token.Token, echo.MIMEApplicationJSON)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var resp user.MagicLinkLoginJSONResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, resp.AccessToken)
+		assert.NotEmpty(t, resp.RefreshToken)
+		assert.Equal(t, u.ID, resp.User.ID)
+
+		rec = openLink(token.Token, echo.MIMEApplicationJSON)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("opening the link does not consume the token", func(t *testing.T) {
+		rec := requestLink(u.Email)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		token := findToken()
+
+		req := httptest.NewRequest(http.MethodGet, "/auth/"+u.GetID()+"/magic-link?t="+token.Token, nil)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		rec = httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), token.Token)
+
+		rec = openLink(token.Token, echo.MIMEApplicationJSON)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+
+	t.Run("only the last requested link is valid", func(t *testing.T) {
+		requestLink(u.Email)
+		first := findToken()
+		requestLink(u.Email)
+
+		rec := openLink(first.Token, echo.MIMEApplicationJSON)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("expired links are invalid", func(t *testing.T) {
+		requestLink(u.Email)
+		token := findToken()
+
+		err := app.GetDB().Model(token).
+			Update("createdAt", time.Now().Add(-user_models.MagicLinkTTL-time.Minute)).Error
+		assert.NoError(t, err)
+
+		rec := openLink(token.Token, echo.MIMEApplicationJSON)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("web clients receive one session", func(t *testing.T) {
+		requestLink(u.Email)
+		token := findToken()
+
+		rec := openLink(token.Token, echo.MIMETextHTML)
+		assert.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+		assert.Equal(t, "/", rec.Header().Get(echo.HeaderLocation))
+		assert.NotEmpty(t, rec.Header().Get(echo.HeaderSetCookie))
+
+		sessions, err := user_models.FindUserSessionsByUserID(u.GetID())
+		assert.NoError(t, err)
+
+		webSessions := 0
+		for _, r := range sessions {
+			if r.Type == user_models.UserSessionTypeSession {
+				webSessions++
+			}
+		}
+		assert.Equal(t, 1, webSessions)
+	})
+}
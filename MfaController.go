@@ -0,0 +1,342 @@
+package user
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/system_settings"
+	user_models "github.com/go-bolo/user/models"
+	"github.com/go-bolo/user/security"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+type MfaController struct {
+	App bolo.App
+}
+
+type MfaCodeBody struct {
+	Code string `json:"code" form:"code" validate:"required"`
+}
+
+type TOTPEnrollResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+type MfaRecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type MfaStatusResponse struct {
+	// Enabled is true if the user has one confirmed TOTP
+	Enabled bool `json:"enabled"`
+	// WebAuthn is true if the user has credentials to complete the MFA challenge in /webauthn/mfa/begin
+	WebAuthn bool `json:"webauthn"`
+}
+
+// GetStatus - return if the authenticated user has the two-factor authentication enabled
+func (ctl *MfaController) GetStatus(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	enabled, err := user_models.UserHasTOTPEnabled(ctx.AuthenticatedUser.GetID())
+	if err != nil {
+		return err
+	}
+
+	hasWebAuthn, err := user_models.UserHasWebAuthnCredentials(ctx.AuthenticatedUser.GetID())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &MfaStatusResponse{Enabled: enabled, WebAuthn: hasWebAuthn})
+}
+
+// TOTPEnroll - start the TOTP enrollment, the secret is only active after the TOTPConfirm step
+func (ctl *MfaController) TOTPEnroll(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	user, err := getAuthenticatedUserRecord(ctx)
+	if err != nil {
+		return err
+	}
+
+	var passwordRecord user_models.PasswordModel
+	err = user_models.FindPasswordByUserID(user.GetID(), &passwordRecord)
+	if err != nil {
+		return err
+	}
+
+	if passwordRecord.ID == 0 {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "auth.mfa.password-required",
+			Internal: errors.New("MfaController.TOTPEnroll user without password"),
+		}
+	}
+
+	var totp user_models.UserTOTPModel
+	err = user_models.FindUserTOTPByUserID(user.GetID(), &totp)
+	if err != nil {
+		return err
+	}
+
+	if totp.Confirmed {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "auth.mfa.already-enabled",
+			Internal: errors.New("MfaController.TOTPEnroll mfa already enabled"),
+		}
+	}
+
+	secret, err := security.GenerateTOTPSecret()
+	if err != nil {
+		return err
+	}
+
+	totp.UserID = int64(user.ID)
+	totp.Secret = secret
+	totp.LastUsedStep = 0
+
+	err = totp.Save()
+	if err != nil {
+		return err
+	}
+
+	account := user.Email
+	if account == "" {
+		account = user.Username
+	}
+
+	return c.JSON(http.StatusOK, &TOTPEnrollResponse{
+		Secret:          secret,
+		ProvisioningURI: security.BuildTOTPProvisioningURI(ctl.getIssuer(), account, secret),
+	})
+}
+
+// TOTPConfirm - enable the TOTP after check one code from the authenticator app and return the recovery codes
+func (ctl *MfaController) TOTPConfirm(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	body, err := ctl.bindCodeBody(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := getAuthenticatedUserRecord(ctx)
+	if err != nil {
+		return err
+	}
+
+	var totp user_models.UserTOTPModel
+	err = user_models.FindUserTOTPByUserID(user.GetID(), &totp)
+	if err != nil {
+		return err
+	}
+
+	if totp.ID == 0 || totp.Confirmed {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "auth.mfa.enrollment-not-found",
+			Internal: errors.New("MfaController.TOTPConfirm enrollment not found"),
+		}
+	}
+
+	valid, err := totp.ValidateCode(body.Code, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if !valid {
+		return invalidMfaCodeError()
+	}
+
+	totp.Confirmed = true
+	err = totp.Save()
+	if err != nil {
+		return err
+	}
+
+	codes, err := user_models.GenerateMfaRecoveryCodes(user.GetID())
+	if err != nil {
+		return err
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventMfaEnabled, user.GetID(), nil)
+
+	return c.JSON(http.StatusOK, &MfaRecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// TOTPDisable - disable the two-factor authentication, requires one valid TOTP or recovery code
+func (ctl *MfaController) TOTPDisable(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	body, err := ctl.bindCodeBody(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := getAuthenticatedUserRecord(ctx)
+	if err != nil {
+		return err
+	}
+
+	var totp user_models.UserTOTPModel
+	err = user_models.FindUserTOTPByUserID(user.GetID(), &totp)
+	if err != nil {
+		return err
+	}
+
+	if totp.ID == 0 {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	if totp.Confirmed {
+		valid, err := user_models.VerifyUserMfaCode(user.GetID(), body.Code)
+		if err != nil {
+			return err
+		}
+
+		if !valid {
+			return invalidMfaCodeError()
+		}
+	}
+
+	err = totp.Delete()
+	if err != nil {
+		return err
+	}
+
+	err = user_models.DeleteUserMfaRecoveryCodes(user.GetID())
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error":  err,
+			"userID": user.GetID(),
+		}).Error("MfaController.TOTPDisable error on delete recovery codes")
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventMfaDisabled, user.GetID(), nil)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes - replace all recovery codes, requires one valid TOTP code
+func (ctl *MfaController) RegenerateRecoveryCodes(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	body, err := ctl.bindCodeBody(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := getAuthenticatedUserRecord(ctx)
+	if err != nil {
+		return err
+	}
+
+	var totp user_models.UserTOTPModel
+	err = user_models.FindUserTOTPByUserID(user.GetID(), &totp)
+	if err != nil {
+		return err
+	}
+
+	if totp.ID == 0 || !totp.Confirmed {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "auth.mfa.not-enabled",
+			Internal: errors.New("MfaController.RegenerateRecoveryCodes mfa not enabled"),
+		}
+	}
+
+	valid, err := totp.ValidateCode(body.Code, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if !valid {
+		return invalidMfaCodeError()
+	}
+
+	codes, err := user_models.GenerateMfaRecoveryCodes(user.GetID())
+	if err != nil {
+		return err
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventMfaRecoveryCodes, user.GetID(), nil)
+
+	return c.JSON(http.StatusOK, &MfaRecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (ctl *MfaController) bindCodeBody(c echo.Context) (*MfaCodeBody, error) {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return nil, &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	body := MfaCodeBody{}
+
+	if err := c.Bind(&body); err != nil {
+		if e, ok := err.(*echo.HTTPError); ok {
+			return nil, e
+		}
+
+		return nil, &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "Invalid data sent",
+			Internal: errors.New("Invalid data sent on MfaController"),
+		}
+	}
+
+	if err := c.Validate(&body); err != nil {
+		return nil, err
+	}
+
+	return &body, nil
+}
+
+func (ctl *MfaController) getIssuer() string {
+	if siteName := system_settings.Get("siteName"); siteName != "" {
+		return siteName
+	}
+
+	return ctl.App.GetConfiguration().GetF("SITE_NAME", "App")
+}
+
+func invalidMfaCodeError() error {
+	return &bolo.HTTPError{
+		Code:     http.StatusBadRequest,
+		Message:  "auth.mfa.invalid-code",
+		Internal: errors.New("invalid mfa code"),
+	}
+}
+
+type NewMfaControllerCFG struct {
+	App bolo.App
+}
+
+func NewMfaController(cfg *NewMfaControllerCFG) *MfaController {
+	return &MfaController{App: cfg.App}
+}
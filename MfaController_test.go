@@ -0,0 +1,176 @@
+package user_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/go-bolo/user/security"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMfaController_TOTPEnrollAndConfirm(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	authToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+	assert.NoError(t, err)
+
+	request := func(url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		req.Header.Set(echo.HeaderAccept, "application/json")
+		req.Header.Set(echo.HeaderContentType, "application/json")
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+authToken.AccessToken)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("user without password can not enroll", func(t *testing.T) {
+		rec := request("/api/v2/auth/mfa/totp/enroll", "{}")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	err = u.SetPassword("123456")
+	assert.NoError(t, err)
+
+	var enroll user.TOTPEnrollResponse
+	t.Run("enroll", func(t *testing.T) {
+		rec := request("/api/v2/auth/mfa/totp/enroll", "{}")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		err := json.Unmarshal(rec.Body.Bytes(), &enroll)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, enroll.Secret)
+		assert.True(t, strings.HasPrefix(enroll.ProvisioningURI, "otpauth://totp/"))
+
+		enabled, err := user_models.UserHasMfaEnabled(u.GetID())
+		assert.NoError(t, err)
+		assert.False(t, enabled, "should only be enabled after the confirmation")
+	})
+
+	t.Run("confirm with invalid code", func(t *testing.T) {
+		rec := request("/api/v2/auth/mfa/totp/confirm", `{"code":"000000"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("confirm", func(t *testing.T) {
+		code, err := security.GenerateTOTPCode(enroll.Secret, time.Now())
+		assert.NoError(t, err)
+
+		rec := request("/api/v2/auth/mfa/totp/confirm", `{"code":"`+code+`"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var resp user.MfaRecoveryCodesResponse
+		err = json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		assert.Len(t, resp.RecoveryCodes, user_models.MfaRecoveryCodesCount)
+
+		enabled, err := user_models.UserHasMfaEnabled(u.GetID())
+		assert.NoError(t, err)
+		assert.True(t, enabled)
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		rec := request("/api/v2/auth/mfa/totp/disable", `{"code":"000000"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		// the code used in the confirmation is not accepted again:
+		code, err := security.GenerateTOTPCode(enroll.Secret, time.Now().Add(security.TOTPPeriod*time.Second))
+		assert.NoError(t, err)
+
+		rec = request("/api/v2/auth/mfa/totp/disable", `{"code":"`+code+`"}`)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		enabled, err := user_models.UserHasMfaEnabled(u.GetID())
+		assert.NoError(t, err)
+		assert.False(t, enabled)
+	})
+}
+
+func TestUserTOTPModel_ValidateCode(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	secret, err := security.GenerateTOTPSecret()
+	assert.NoError(t, err)
+
+	totp := user_models.UserTOTPModel{
+		UserID:    int64(u.ID),
+		Secret:    secret,
+		Confirmed: true,
+	}
+	err = totp.Save()
+	assert.NoError(t, err)
+	defer totp.Delete()
+
+	t.Run("one code is only accepted once in parallel requests", func(t *testing.T) {
+		// each request loads one copy of the record before the code check:
+		var first, second user_models.UserTOTPModel
+		err := user_models.FindUserTOTPByUserID(u.GetID(), &first)
+		assert.NoError(t, err)
+		err = user_models.FindUserTOTPByUserID(u.GetID(), &second)
+		assert.NoError(t, err)
+
+		now := time.Now()
+		code, err := security.GenerateTOTPCode(secret, now)
+		assert.NoError(t, err)
+
+		valid, err := first.ValidateCode(code, now)
+		assert.NoError(t, err)
+		assert.True(t, valid)
+
+		valid, err = second.ValidateCode(code, now)
+		assert.NoError(t, err)
+		assert.False(t, valid)
+	})
+}
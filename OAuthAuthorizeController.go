@@ -0,0 +1,192 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/metatags"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/labstack/echo/v4"
+)
+
+// OAuthAuthorizeController - authorization endpoint of the authorization_code grant, the user is identified by the
+// HTML session and approves the client in one consent screen
+type OAuthAuthorizeController struct {
+	App bolo.App
+}
+
+// data available in the auth/oauth-authorize template as .Record
+type oauthAuthorizePageData struct {
+	Client *user_models.OAuthClientModel `json:"client"`
+	// empty for clients with the full user rights
+	Scopes []string `json:"scopes"`
+	// send it in the consent form as consent_token
+	ConsentToken string `json:"consentToken"`
+}
+
+type OAuthAuthorizeDecisionRequestBody struct {
+	ConsentToken string `json:"consent_token" form:"consent_token" validate:"required"`
+	// "allow" approves the client, any other value denies
+	Decision string `json:"decision" form:"decision"`
+}
+
+type OAuthAuthorizeDecisionJSONResponse struct {
+	RedirectTo string `json:"redirectTo"`
+}
+
+// Authorize - GET /oauth/authorize, validates the request, redirects to the login page and renders the consent screen
+func (ctl *OAuthAuthorizeController) Authorize(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+	isJSON := ctx.GetResponseContentType() == "application/json"
+
+	r, client, err := auth_oauth2_password.ParseAuthorizationRequest(ctl.App, c.QueryParams())
+	if err != nil {
+		return ctl.authorizationError(c, err)
+	}
+
+	userID := getSessionUserID(ctx)
+	if userID == "" {
+		if isJSON {
+			return &bolo.HTTPError{
+				Code:     http.StatusUnauthorized,
+				Message:  "auth.oauth.login-required",
+				Internal: errors.New("OAuthAuthorizeController.Authorize request without session"),
+			}
+		}
+
+		return c.Redirect(http.StatusFound, "/login?redirectTo="+url.QueryEscape(c.Request().URL.RequestURI()))
+	}
+
+	r.UserID = userID
+
+	consentToken, err := auth_oauth2_password.SaveAuthorizationRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	data := oauthAuthorizePageData{
+		Client:       client,
+		Scopes:       r.Scopes,
+		ConsentToken: consentToken,
+	}
+
+	// the consent screen should not be framed by other sites:
+	c.Response().Header().Set("X-Frame-Options", "DENY")
+
+	if isJSON {
+		return c.JSON(http.StatusOK, &data)
+	}
+
+	mt := c.Get("metatags").(*metatags.HTMLMetaTags)
+
+	ctx.Title = "Autorizar acesso"
+	mt.Title = "Autorizar acesso"
+
+	return bolo.MinifiAndRender(http.StatusOK, "auth/oauth-authorize", &bolo.TemplateCTX{
+		Ctx:    ctx,
+		Record: &data,
+	}, ctx)
+}
+
+// Decide - POST /oauth/authorize, the consent form answer. Approved requests redirect to the client with one
+// authorization code and denied requests with the access_denied error
+func (ctl *OAuthAuthorizeController) Decide(c echo.Context) error {
+	var body OAuthAuthorizeDecisionRequestBody
+
+	ctx := c.(*bolo.RequestContext)
+	isJSON := ctx.GetResponseContentType() == "application/json"
+
+	userID := getSessionUserID(ctx)
+	if userID == "" {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("OAuthAuthorizeController.Decide request without session"),
+		}
+	}
+
+	if err := c.Bind(&body); err != nil {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "invalid body",
+			Internal: fmt.Errorf("OAuthAuthorizeController.Decide error on bind body: %w", err),
+		}
+	}
+
+	if err := c.Validate(&body); err != nil {
+		return err
+	}
+
+	r, err := auth_oauth2_password.ConsumeAuthorizationRequestConsent(body.ConsentToken, userID)
+	if err != nil {
+		return err
+	}
+
+	if r == nil {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "auth.oauth.consent-expired",
+			Internal: errors.New("OAuthAuthorizeController.Decide consent token not found"),
+		}
+	}
+
+	redirectTo := r.DeniedRedirectURL()
+
+	if body.Decision == "allow" {
+		redirectTo, err = auth_oauth2_password.CreateAuthorizationCode(ctx, r)
+		if err != nil {
+			return err
+		}
+
+		user_models.RecordAuthEvent(ctx, user_models.AuthEventOAuthConsent, userID, map[string]interface{}{
+			"oauthClientId": r.ClientID,
+			"scopes":        strings.Join(r.Scopes, " "),
+		})
+	}
+
+	if isJSON {
+		return c.JSON(http.StatusOK, &OAuthAuthorizeDecisionJSONResponse{RedirectTo: redirectTo})
+	}
+
+	return c.Redirect(http.StatusFound, redirectTo)
+}
+
+// authorizationError redirects the oauth2 errors to the client when the redirect_uri is valid
+func (ctl *OAuthAuthorizeController) authorizationError(c echo.Context, err error) error {
+	var ae *auth_oauth2_password.AuthorizationError
+	if !errors.As(err, &ae) {
+		return err
+	}
+
+	if redirectTo := ae.RedirectURL(); redirectTo != "" {
+		return c.Redirect(http.StatusFound, redirectTo)
+	}
+
+	return &bolo.HTTPError{
+		Code:     http.StatusBadRequest,
+		Message:  ae.Code,
+		Internal: ae,
+	}
+}
+
+// getSessionUserID returns the user authenticated by the HTML session, requests authenticated with tokens are ignored
+func getSessionUserID(ctx *bolo.RequestContext) string {
+	if !ctx.IsAuthenticated {
+		return ""
+	}
+
+	return ctx.Session.UserID
+}
+
+type NewOAuthAuthorizeControllerCFG struct {
+	App bolo.App
+}
+
+func NewOAuthAuthorizeController(cfg *NewOAuthAuthorizeControllerCFG) *OAuthAuthorizeController {
+	return &OAuthAuthorizeController{App: cfg.App}
+}
@@ -0,0 +1,235 @@
+package user_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user"
+	user_models "github.com/go-bolo/user/models"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+type oauthAuthorizeTestPage struct {
+	Client       user_models.OAuthClientModel `json:"client"`
+	Scopes       []string                     `json:"scopes"`
+	ConsentToken string                       `json:"consentToken"`
+}
+
+func TestOAuthAuthorizeController(t *testing.T) {
+	t.Setenv("OAUTH2_SCOPES", `{"read": ["find_user"]}`)
+
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	err = u.SetPassword("123456")
+	assert.NoError(t, err)
+
+	redirectURI := "https://app.example.com/callback"
+
+	client, _, err := user_models.CreateOAuthClient("web", []string{"authorization_code", "refresh_token"}, []string{"read"}, []string{redirectURI}, false)
+	assert.NoError(t, err)
+	defer client.Delete()
+
+	verifier := strings.Repeat("a1b2c3d4", 6)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	authorizeURL := func(params url.Values) string {
+		q := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {client.ClientID},
+			"redirect_uri":          {redirectURI},
+			"state":                 {"xyz"},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		}
+		for k, v := range params {
+			q[k] = v
+		}
+
+		return "/oauth/authorize?" + q.Encode()
+	}
+
+	// the session cookie of one login in the HTML form:
+	login := func() *http.Cookie {
+		form := url.Values{"email": {u.Email}, "password": {"123456"}, "redirectTo": {"/oauth/authorize"}}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+		assert.Equal(t, "/oauth/authorize", rec.Header().Get(echo.HeaderLocation))
+
+		cookies := rec.Result().Cookies()
+		assert.NotEmpty(t, cookies)
+
+		return cookies[0]
+	}
+
+	request := func(method, url string, cookie *http.Cookie, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	redirect := func(rec *httptest.ResponseRecorder) url.Values {
+		var resp user.OAuthAuthorizeDecisionJSONResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(resp.RedirectTo, redirectURI+"?"), resp.RedirectTo)
+
+		u, err := url.Parse(resp.RedirectTo)
+		assert.NoError(t, err)
+		return u.Query()
+	}
+
+	consent := func(cookie *http.Cookie, decision string) url.Values {
+		rec := request(http.MethodGet, authorizeURL(nil), cookie, nil)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var page oauthAuthorizeTestPage
+		err := json.Unmarshal(rec.Body.Bytes(), &page)
+		assert.NoError(t, err)
+		assert.Equal(t, client.ClientID, page.Client.ClientID)
+		assert.Equal(t, []string{"read"}, page.Scopes)
+		assert.NotEmpty(t, page.ConsentToken)
+
+		rec = request(http.MethodPost, "/oauth/authorize", cookie, url.Values{
+			"consent_token": {page.ConsentToken},
+			"decision":      {decision},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		return redirect(rec)
+	}
+
+	exchange := func(code, codeVerifier string) *httptest.ResponseRecorder {
+		return request(http.MethodPost, "/auth/oauth2/token", nil, url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {client.ClientID},
+			"code":          {code},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {codeVerifier},
+		})
+	}
+
+	t.Run("users without session are sent to the login page", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, authorizeURL(nil), nil)
+		req.Header.Set(echo.HeaderAccept, echo.MIMETextHTML)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusFound, rec.Code)
+		location, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+		assert.NoError(t, err)
+		assert.Equal(t, "/login", location.Path)
+		assert.Equal(t, authorizeURL(nil), location.Query().Get("redirectTo"))
+	})
+
+	t.Run("the login only redirects to local paths", func(t *testing.T) {
+		form := url.Values{"email": {u.Email}, "password": {"123456"}, "redirectTo": {"//evil.example.com"}}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+		assert.Equal(t, "/", rec.Header().Get(echo.HeaderLocation))
+	})
+
+	cookie := login()
+
+	t.Run("invalid clients and redirect uris are not redirected", func(t *testing.T) {
+		rec := request(http.MethodGet, authorizeURL(url.Values{"redirect_uri": {"https://evil.example.com/callback"}}), cookie, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Empty(t, rec.Header().Get(echo.HeaderLocation))
+
+		rec = request(http.MethodGet, authorizeURL(url.Values{"client_id": {"unknown"}}), cookie, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("requests without PKCE are redirected with the error", func(t *testing.T) {
+		rec := request(http.MethodGet, authorizeURL(url.Values{"code_challenge_method": {"plain"}}), cookie, nil)
+		assert.Equal(t, http.StatusFound, rec.Code)
+
+		location, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+		assert.NoError(t, err)
+		assert.Equal(t, "invalid_request", location.Query().Get("error"))
+		assert.Equal(t, "xyz", location.Query().Get("state"))
+	})
+
+	t.Run("approved requests return one code exchanged with the code_verifier", func(t *testing.T) {
+		q := consent(cookie, "allow")
+		assert.Equal(t, "xyz", q.Get("state"))
+		assert.NotEmpty(t, q.Get("code"))
+
+		rec := exchange(q.Get("code"), verifier)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), "refresh_token")
+
+		// codes are single use:
+		rec = exchange(q.Get("code"), verifier)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid_grant")
+	})
+
+	t.Run("reject invalid code verifiers", func(t *testing.T) {
+		q := consent(cookie, "allow")
+
+		rec := exchange(q.Get("code"), strings.Repeat("x", 43))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid_grant")
+	})
+
+	t.Run("denied requests are redirected with access_denied", func(t *testing.T) {
+		q := consent(cookie, "deny")
+		assert.Equal(t, "access_denied", q.Get("error"))
+		assert.Empty(t, q.Get("code"))
+	})
+
+	t.Run("consent tokens are single use", func(t *testing.T) {
+		rec := request(http.MethodPost, "/oauth/authorize", cookie, url.Values{
+			"consent_token": {"invalid"},
+			"decision":      {"allow"},
+		})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
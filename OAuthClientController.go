@@ -0,0 +1,211 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-bolo/bolo"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/labstack/echo/v4"
+)
+
+type OAuthClientController struct {
+	App bolo.App
+}
+
+type OAuthClientCreateRequestBody struct {
+	Name         string   `json:"name" validate:"required,max=100"`
+	Grants       []string `json:"grants" validate:"required"`
+	Scopes       []string `json:"scopes"`
+	RedirectURIs []string `json:"redirectUris"`
+	// public clients, like mobile and single page apps, don't have secret
+	Public bool `json:"public"`
+}
+
+type OAuthClientListJSONResponse struct {
+	Records []*user_models.OAuthClientModel `json:"oauthClient"`
+}
+
+type OAuthClientCreateJSONResponse struct {
+	Record *user_models.OAuthClientModel `json:"oauthClient"`
+	// the plain client secret, only returned here
+	ClientSecret string `json:"clientSecret,omitempty"`
+}
+
+// List - admin endpoint with the registered oauth2 clients, without the secrets
+func (ctl *OAuthClientController) List(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.Can("manage_oauth_clients") {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "Forbidden",
+			Internal: errors.New("OAuthClientController.List forbidden"),
+		}
+	}
+
+	records, err := user_models.FindOAuthClients()
+	if err != nil {
+		return fmt.Errorf("OAuthClientController.List error on find clients: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, &OAuthClientListJSONResponse{Records: records})
+}
+
+// Create - admin endpoint to register one oauth2 client, the secret is only returned in this response
+func (ctl *OAuthClientController) Create(c echo.Context) error {
+	var body OAuthClientCreateRequestBody
+
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.Can("manage_oauth_clients") {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "Forbidden",
+			Internal: errors.New("OAuthClientController.Create forbidden"),
+		}
+	}
+
+	if err := c.Bind(&body); err != nil {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "invalid body",
+			Internal: fmt.Errorf("OAuthClientController.Create error on bind body: %w", err),
+		}
+	}
+
+	if err := c.Validate(&body); err != nil {
+		return err
+	}
+
+	for _, g := range body.Grants {
+		if !auth_oauth2_password.IsSupportedGrantType(g) || (body.Public && g == "client_credentials") {
+			return &bolo.HTTPError{
+				Code:     http.StatusBadRequest,
+				Message:  "auth.oauth-clients.invalid-grant",
+				Internal: errors.New("OAuthClientController.Create invalid grant " + g),
+			}
+		}
+	}
+
+	scopes := auth_oauth2_password.ParseScope(strings.Join(body.Scopes, " "))
+	err := auth_oauth2_password.ValidateScopes(ctl.App, scopes)
+	if err != nil {
+		if errors.Is(err, auth_oauth2_password.ErrInvalidScope) {
+			return &bolo.HTTPError{
+				Code:     http.StatusBadRequest,
+				Message:  "auth.oauth-clients.invalid-scope",
+				Internal: err,
+			}
+		}
+
+		return err
+	}
+
+	for _, uri := range body.RedirectURIs {
+		if !isValidRedirectURI(ctl.App, uri) {
+			return &bolo.HTTPError{
+				Code:     http.StatusBadRequest,
+				Message:  "auth.oauth-clients.invalid-redirect-uri",
+				Internal: errors.New("OAuthClientController.Create invalid redirect uri " + uri),
+			}
+		}
+	}
+
+	record, secret, err := user_models.CreateOAuthClient(strings.TrimSpace(body.Name), body.Grants, scopes, body.RedirectURIs, !body.Public)
+	if err != nil {
+		return err
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventClientCreate, "", map[string]interface{}{
+		"oauthClientId": record.ClientID,
+		"name":          record.Name,
+		"grants":        record.Grants,
+	})
+
+	return c.JSON(http.StatusCreated, &OAuthClientCreateJSONResponse{
+		Record:       record,
+		ClientSecret: secret,
+	})
+}
+
+// Delete - admin endpoint to delete one oauth2 client, the client_credentials tokens are rejected in the next request
+func (ctl *OAuthClientController) Delete(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.Can("manage_oauth_clients") {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "Forbidden",
+			Internal: errors.New("OAuthClientController.Delete forbidden"),
+		}
+	}
+
+	record := user_models.OAuthClientModel{}
+	err := user_models.FindOAuthClientByClientID(c.Param("clientId"), &record)
+	if err != nil {
+		return fmt.Errorf("OAuthClientController.Delete error on find client: %w", err)
+	}
+
+	if record.ID == 0 {
+		return echo.NotFoundHandler(c)
+	}
+
+	err = record.Delete()
+	if err != nil {
+		return fmt.Errorf("OAuthClientController.Delete error on delete client: %w", err)
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventClientDelete, "", map[string]interface{}{
+		"oauthClientId": record.ClientID,
+		"name":          record.Name,
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// isValidRedirectURI accepts absolute URIs without fragment (RFC 6749 3.1.2) with the https scheme, http only in
+// loopback hosts (RFC 8252 7.3) and the mobile apps custom schemes of the OAUTH2_REDIRECT_URI_SCHEMES configuration
+func isValidRedirectURI(app bolo.App, uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" || u.User != nil || strings.ContainsAny(uri, " \t\r\n") {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return u.Hostname() != ""
+	case "http":
+		return isLoopbackHost(u.Hostname())
+	default:
+		for _, s := range strings.Split(app.GetConfiguration().Get("OAUTH2_REDIRECT_URI_SCHEMES"), ",") {
+			if s = strings.ToLower(strings.TrimSpace(s)); s != "" && s == u.Scheme {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+type NewOAuthClientControllerCFG struct {
+	App bolo.App
+}
+
+func NewOAuthClientController(cfg *NewOAuthClientControllerCFG) *OAuthClientController {
+	return &OAuthClientController{App: cfg.App}
+}
@@ -0,0 +1,142 @@
+package user_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOAuthClientController(t *testing.T) {
+	t.Setenv("OAUTH2_SCOPES", `{"read": ["find_user"]}`)
+
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	admin := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	admin.SetRole("administrator")
+	err = admin.Save(ctx)
+	assert.NoError(t, err)
+	defer admin.Delete()
+
+	userToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+	assert.NoError(t, err)
+	adminToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &admin)
+	assert.NoError(t, err)
+
+	request := func(method, url, accessToken, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("forbidden without the manage_oauth_clients permission", func(t *testing.T) {
+		rec := request(http.MethodGet, "/api/v2/auth/oauth-clients", userToken.AccessToken, "")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		rec = request(http.MethodPost, "/api/v2/auth/oauth-clients", userToken.AccessToken, `{"name": "service", "grants": ["client_credentials"]}`)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("validate the grants, scopes and redirect uris", func(t *testing.T) {
+		rec := request(http.MethodPost, "/api/v2/auth/oauth-clients", adminToken.AccessToken, `{"name": "service", "grants": ["implicit"]}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "auth.oauth-clients.invalid-grant")
+
+		rec = request(http.MethodPost, "/api/v2/auth/oauth-clients", adminToken.AccessToken, `{"name": "mobile", "grants": ["client_credentials"], "public": true}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "auth.oauth-clients.invalid-grant")
+
+		rec = request(http.MethodPost, "/api/v2/auth/oauth-clients", adminToken.AccessToken, `{"name": "service", "grants": ["client_credentials"], "scopes": ["admin"]}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "auth.oauth-clients.invalid-scope")
+
+		rec = request(http.MethodPost, "/api/v2/auth/oauth-clients", adminToken.AccessToken, `{"name": "web", "grants": ["password"], "redirectUris": ["/callback"]}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "auth.oauth-clients.invalid-redirect-uri")
+	})
+
+	t.Run("redirect uris are https, loopback http or allowed custom schemes", func(t *testing.T) {
+		t.Setenv("OAUTH2_REDIRECT_URI_SCHEMES", "com.example.app")
+
+		for _, uri := range []string{"http://example.com/callback", "javascript://example.com/%0Aalert(1)", "data:text/html,hi", "myapp://callback", "https://user@example.com/callback"} {
+			rec := request(http.MethodPost, "/api/v2/auth/oauth-clients", adminToken.AccessToken, `{"name": "web", "grants": ["authorization_code"], "redirectUris": ["`+uri+`"]}`)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, uri)
+			assert.Contains(t, rec.Body.String(), "auth.oauth-clients.invalid-redirect-uri", uri)
+		}
+
+		for _, uri := range []string{"https://example.com/callback", "http://127.0.0.1:8080/callback", "http://localhost/callback", "com.example.app:/callback"} {
+			rec := request(http.MethodPost, "/api/v2/auth/oauth-clients", adminToken.AccessToken, `{"name": "web", "grants": ["authorization_code"], "redirectUris": ["`+uri+`"]}`)
+			assert.Equal(t, http.StatusCreated, rec.Code, uri)
+
+			var created user.OAuthClientCreateJSONResponse
+			json.Unmarshal(rec.Body.Bytes(), &created)
+			if created.Record != nil {
+				created.Record.Delete()
+			}
+		}
+	})
+
+	t.Run("create, list and delete clients", func(t *testing.T) {
+		rec := request(http.MethodPost, "/api/v2/auth/oauth-clients", adminToken.AccessToken, `{"name": "service", "grants": ["client_credentials"], "scopes": ["read"], "redirectUris": ["https://example.com/callback"]}`)
+		assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+		created := user.OAuthClientCreateJSONResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &created)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, created.Record.ClientID)
+		assert.NotEmpty(t, created.ClientSecret)
+		assert.Equal(t, "read", created.Record.Scopes)
+
+		rec = request(http.MethodGet, "/api/v2/auth/oauth-clients", adminToken.AccessToken, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), created.Record.ClientID)
+		assert.NotContains(t, rec.Body.String(), created.ClientSecret)
+
+		rec = request(http.MethodDelete, "/api/v2/auth/oauth-clients/"+created.Record.ClientID, adminToken.AccessToken, "")
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		record := user_models.OAuthClientModel{}
+		err = user_models.FindOAuthClientByClientID(created.Record.ClientID, &record)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(0), record.ID)
+	})
+}
@@ -0,0 +1,305 @@
+package user
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/go-bolo/bolo"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// OpenIDController - OpenID Connect provider endpoints on top of the oauth2 authorization_code grant
+type OpenIDController struct {
+	App bolo.App
+}
+
+// OpenIDConfiguration - OpenID Connect discovery document
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksURI                           string   `json:"jwks_uri"`
+	EndSessionEndpoint                string   `json:"end_session_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+// openIDLogoutPageData - data of the logout confirmation page, the form posts the fields to the Logout action
+type openIDLogoutPageData struct {
+	LogoutToken           string `json:"logoutToken"`
+	ClientID              string `json:"clientId"`
+	PostLogoutRedirectURI string `json:"postLogoutRedirectUri"`
+	State                 string `json:"state"`
+}
+
+// Discovery - GET /.well-known/openid-configuration
+func (ctl *OpenIDController) Discovery(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !auth_oauth2_password.IsOpenIDEnabled(ctl.App) {
+		return echo.NotFoundHandler(c)
+	}
+
+	configured, err := auth_oauth2_password.GetScopes(ctl.App)
+	if err != nil {
+		return err
+	}
+
+	scopes := append([]string{}, auth_oauth2_password.OpenIDScopes...)
+	for s := range configured {
+		if !auth_oauth2_password.IsOpenIDScope(s) {
+			scopes = append(scopes, s)
+		}
+	}
+	sort.Strings(scopes[len(auth_oauth2_password.OpenIDScopes):])
+
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+
+	return c.JSON(http.StatusOK, &OpenIDConfiguration{
+		Issuer:                            auth_oauth2_password.GetOpenIDIssuer(ctx),
+		AuthorizationEndpoint:             ctx.AppOrigin + "/oauth/authorize",
+		TokenEndpoint:                     ctx.AppOrigin + "/auth/oauth2/token",
+		UserinfoEndpoint:                  ctx.AppOrigin + "/oauth/userinfo",
+		JwksURI:                           ctx.AppOrigin + "/.well-known/jwks.json",
+		EndSessionEndpoint:                ctx.AppOrigin + "/oauth/logout",
+		ScopesSupported:                   scopes,
+		ClaimsSupported:                   auth_oauth2_password.OpenIDClaims,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               auth_oauth2_password.GrantTypes,
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{auth_oauth2_password.GetIDTokenSigningAlgorithm(ctl.App)},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+	})
+}
+
+// UserInfo - GET and POST /oauth/userinfo, the claims of the access token user released by the token scopes.
+// Requires one access token with the openid scope
+func (ctl *OpenIDController) UserInfo(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !auth_oauth2_password.IsOpenIDEnabled(ctl.App) {
+		return echo.NotFoundHandler(c)
+	}
+
+	if !ctx.IsAuthenticated {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+		return &bolo.HTTPError{
+			Code:     http.StatusUnauthorized,
+			Message:  "invalid_token",
+			Internal: errors.New("OpenIDController.UserInfo request without access token"),
+		}
+	}
+
+	scopes, _ := c.Get(auth_oauth2_password.TokenScopesKey).([]string)
+	if !auth_oauth2_password.HasOpenIDScope(scopes) {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="insufficient_scope", scope="openid"`)
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "insufficient_scope",
+			Internal: errors.New("OpenIDController.UserInfo token without the openid scope"),
+		}
+	}
+
+	var userRecord user_models.UserModel
+	err := user_models.UserFindOne(ctx.AuthenticatedUser.GetID(), &userRecord)
+	if err != nil {
+		return fmt.Errorf("OpenIDController.UserInfo error on find user: %w", err)
+	}
+
+	if userRecord.ID == 0 {
+		return echo.NotFoundHandler(c)
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+
+	return c.JSON(http.StatusOK, auth_oauth2_password.GetOpenIDUserClaims(&userRecord, scopes))
+}
+
+// Logout - GET and POST /oauth/logout, RP-initiated logout. Deletes the HTML session and redirects to the
+// post_logout_redirect_uri, that should be one redirect uri of the client from the id_token_hint or client_id.
+// Requests without one id_token_hint of the session user render one confirmation page, then other sites can not
+// logout the user with one link
+func (ctl *OpenIDController) Logout(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !auth_oauth2_password.IsOpenIDEnabled(ctl.App) {
+		return echo.NotFoundHandler(c)
+	}
+
+	clientID := c.FormValue("client_id")
+	hintSubject := ""
+
+	if hint := c.FormValue("id_token_hint"); hint != "" {
+		claims, err := auth_oauth2_password.ParseIDTokenHint(ctx, hint)
+		if err == nil && clientID != "" && clientID != claims.Audience {
+			err = errors.New("the client_id is not the id_token_hint audience")
+		}
+
+		if err != nil {
+			return &bolo.HTTPError{
+				Code:     http.StatusBadRequest,
+				Message:  "auth.oauth.invalid-id-token-hint",
+				Internal: fmt.Errorf("OpenIDController.Logout invalid id_token_hint: %w", err),
+			}
+		}
+
+		clientID = claims.Audience
+		hintSubject = claims.Subject
+	}
+
+	redirectTo := "/"
+	uri := c.FormValue("post_logout_redirect_uri")
+
+	if uri != "" {
+		client := user_models.OAuthClientModel{}
+		if clientID != "" {
+			err := user_models.FindOAuthClientByClientID(clientID, &client)
+			if err != nil {
+				return fmt.Errorf("OpenIDController.Logout error on find client: %w", err)
+			}
+		}
+
+		if client.ID == 0 || !client.AllowsRedirectURI(uri) {
+			return &bolo.HTTPError{
+				Code:     http.StatusBadRequest,
+				Message:  "auth.oauth.invalid-post-logout-redirect-uri",
+				Internal: errors.New("OpenIDController.Logout post_logout_redirect_uri is not registered for the client"),
+			}
+		}
+
+		redirectTo = withState(uri, c.FormValue("state"))
+	}
+
+	userID := getSessionUserID(ctx)
+	if userID != "" {
+		if hintSubject != userID {
+			confirmed, err := ctl.consumeLogoutToken(c)
+			if err != nil {
+				return err
+			}
+
+			if !confirmed {
+				return ctl.renderLogoutPage(c, &openIDLogoutPageData{
+					ClientID:              clientID,
+					PostLogoutRedirectURI: uri,
+					State:                 c.FormValue("state"),
+				})
+			}
+		}
+
+		user_models.RecordAuthEvent(ctx, user_models.AuthEventLogout, userID, map[string]interface{}{
+			"method":        "openid",
+			"oauthClientId": clientID,
+		})
+	}
+
+	err := DeleteUserSession(c)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": fmt.Sprintf("%+v\n", err),
+		}).Error("OpenIDController.Logout error on delete session")
+	}
+
+	return c.Redirect(http.StatusFound, redirectTo)
+}
+
+// renderLogoutPage saves one logout token in the session and renders the confirmation form with it
+func (ctl *OpenIDController) renderLogoutPage(c echo.Context, data *openIDLogoutPageData) error {
+	ctx := c.(*bolo.RequestContext)
+
+	sess, err := session.Get("session", c)
+	if err != nil {
+		return fmt.Errorf("OpenIDController.renderLogoutPage error on get session: %w", err)
+	}
+
+	data.LogoutToken = randomToken()
+	sess.Values["openIDLogout"] = data.LogoutToken
+
+	err = sess.Save(c.Request(), c.Response())
+	if err != nil {
+		return fmt.Errorf("OpenIDController.renderLogoutPage error on save session: %w", err)
+	}
+
+	// the confirmation can not be clicked in one frame of other site:
+	c.Response().Header().Set("X-Frame-Options", "DENY")
+
+	if ctx.GetResponseContentType() == "application/json" {
+		return c.JSON(http.StatusOK, data)
+	}
+
+	ctx.Title = "Logout"
+
+	return bolo.MinifiAndRender(http.StatusOK, "auth/oauth-logout", &bolo.TemplateCTX{
+		Ctx:    ctx,
+		Record: data,
+	}, ctx)
+}
+
+// consumeLogoutToken returns true if the POST has the logout_token of the confirmation page, the token is single use
+func (ctl *OpenIDController) consumeLogoutToken(c echo.Context) (bool, error) {
+	if c.Request().Method != http.MethodPost {
+		return false, nil
+	}
+
+	sess, err := session.Get("session", c)
+	if err != nil {
+		return false, fmt.Errorf("OpenIDController.consumeLogoutToken error on get session: %w", err)
+	}
+
+	expected, _ := sess.Values["openIDLogout"].(string)
+	if expected == "" {
+		return false, nil
+	}
+
+	delete(sess.Values, "openIDLogout")
+	err = sess.Save(c.Request(), c.Response())
+	if err != nil {
+		return false, fmt.Errorf("OpenIDController.consumeLogoutToken error on save session: %w", err)
+	}
+
+	received := c.FormValue("logout_token")
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(received)) == 1, nil
+}
+
+// withState adds the state param to one registered redirect uri
+func withState(uri, state string) string {
+	if state == "" {
+		return uri
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+
+	q := u.Query()
+	q.Set("state", state)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+type NewOpenIDControllerCFG struct {
+	App bolo.App
+}
+
+func NewOpenIDController(cfg *NewOpenIDControllerCFG) *OpenIDController {
+	return &OpenIDController{App: cfg.App}
+}
@@ -0,0 +1,375 @@
+package user_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/brianvoe/gofakeit/v6"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user"
+	user_models "github.com/go-bolo/user/models"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+type openIDTestTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+}
+
+func TestOpenIDController(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	t.Setenv("OAUTH2_OPENID_ENABLED", "true")
+	t.Setenv("OAUTH2_SCOPES", `{"read": ["find_user"]}`)
+	t.Setenv("OAUTH2_JWT_ALGORITHM", "RS256")
+	t.Setenv("OAUTH2_JWT_PRIVATE_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})))
+	t.Setenv("OAUTH2_JWT_ISSUER", "https://id.example.com")
+
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username:    gofakeit.UUID(),
+		Email:       gofakeit.Email(),
+		DisplayName: "Alice",
+		FullName:    "Alice Souza",
+		Language:    "pt-br",
+		Phone:       "+5511999999999",
+		Active:      true,
+		// confirmed in the activation link:
+		EmailVerified: true,
+	}
+	err = u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	err = u.SetPassword("123456")
+	assert.NoError(t, err)
+
+	redirectURI := "https://rp.example.com/callback"
+
+	client, _, err := user_models.CreateOAuthClient("rp", []string{"authorization_code", "refresh_token"}, nil, []string{redirectURI}, false)
+	assert.NoError(t, err)
+	defer client.Delete()
+
+	verifier := strings.Repeat("a1b2c3d4", 6)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	request := func(method, url string, cookie *http.Cookie, accessToken string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if accessToken != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+		}
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	login := func() *http.Cookie {
+		rec := request(http.MethodPost, "/login", nil, "", url.Values{"email": {u.Email}, "password": {"123456"}})
+		assert.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+
+		cookies := rec.Result().Cookies()
+		assert.NotEmpty(t, cookies)
+
+		return cookies[0]
+	}
+
+	// authorization_code flow with the scope and the nonce:
+	authorize := func(cookie *http.Cookie, scope string) openIDTestTokenResponse {
+		rec := request(http.MethodGet, "/oauth/authorize?"+url.Values{
+			"response_type":         {"code"},
+			"client_id":             {client.ClientID},
+			"redirect_uri":          {redirectURI},
+			"scope":                 {scope},
+			"nonce":                 {"n-0S6_WzA2Mj"},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		}.Encode(), cookie, "", nil)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var page struct {
+			ConsentToken string `json:"consentToken"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &page)
+
+		rec = request(http.MethodPost, "/oauth/authorize", cookie, "", url.Values{
+			"consent_token": {page.ConsentToken},
+			"decision":      {"allow"},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var decision user.OAuthAuthorizeDecisionJSONResponse
+		json.Unmarshal(rec.Body.Bytes(), &decision)
+		location, err := url.Parse(decision.RedirectTo)
+		assert.NoError(t, err)
+
+		rec = request(http.MethodPost, "/auth/oauth2/token", nil, "", url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {client.ClientID},
+			"code":          {location.Query().Get("code")},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {verifier},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var token openIDTestTokenResponse
+		err = json.Unmarshal(rec.Body.Bytes(), &token)
+		assert.NoError(t, err)
+
+		return token
+	}
+
+	parseIDToken := func(token string) jwt.MapClaims {
+		claims := jwt.MapClaims{}
+		_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+			return &rsaKey.PublicKey, nil
+		})
+		assert.NoError(t, err)
+
+		return claims
+	}
+
+	cookie := login()
+
+	t.Run("discovery document", func(t *testing.T) {
+		rec := request(http.MethodGet, "/.well-known/openid-configuration", nil, "", nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var cfg user.OpenIDConfiguration
+		err := json.Unmarshal(rec.Body.Bytes(), &cfg)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://id.example.com", cfg.Issuer)
+		assert.True(t, strings.HasSuffix(cfg.AuthorizationEndpoint, "/oauth/authorize"))
+		assert.True(t, strings.HasSuffix(cfg.UserinfoEndpoint, "/oauth/userinfo"))
+		assert.True(t, strings.HasSuffix(cfg.EndSessionEndpoint, "/oauth/logout"))
+		assert.Equal(t, []string{"openid", "profile", "email", "phone", "read"}, cfg.ScopesSupported)
+		assert.Equal(t, []string{"RS256"}, cfg.IDTokenSigningAlgValuesSupported)
+	})
+
+	t.Run("ID tokens and userinfo release the claims of the scopes", func(t *testing.T) {
+		token := authorize(cookie, "openid email")
+		assert.NotEmpty(t, token.IDToken)
+
+		claims := parseIDToken(token.IDToken)
+		assert.Equal(t, "https://id.example.com", claims["iss"])
+		assert.Equal(t, client.ClientID, claims["aud"])
+		assert.Equal(t, u.GetID(), claims["sub"])
+		assert.Equal(t, "n-0S6_WzA2Mj", claims["nonce"])
+		assert.Equal(t, u.Email, claims["email"])
+		assert.Equal(t, true, claims["email_verified"])
+		assert.Nil(t, claims["name"])
+
+		rec := request(http.MethodGet, "/oauth/userinfo", nil, token.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var info map[string]interface{}
+		err := json.Unmarshal(rec.Body.Bytes(), &info)
+		assert.NoError(t, err)
+		assert.Equal(t, u.GetID(), info["sub"])
+		assert.Equal(t, u.Email, info["email"])
+		assert.Nil(t, info["name"])
+		assert.Nil(t, info["phone_number"])
+
+		token = authorize(cookie, "openid profile phone")
+
+		rec = request(http.MethodGet, "/oauth/userinfo", nil, token.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		info = map[string]interface{}{}
+		err = json.Unmarshal(rec.Body.Bytes(), &info)
+		assert.NoError(t, err)
+		assert.Equal(t, "Alice Souza", info["name"])
+		assert.Equal(t, "Alice", info["nickname"])
+		assert.Equal(t, u.Username, info["preferred_username"])
+		assert.Equal(t, "pt-br", info["locale"])
+		assert.Equal(t, "+5511999999999", info["phone_number"])
+		assert.Nil(t, info["email"])
+	})
+
+	t.Run("refreshed tokens have one new ID token", func(t *testing.T) {
+		token := authorize(cookie, "openid")
+
+		rec := request(http.MethodPost, "/auth/oauth2/token", nil, "", url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {client.ClientID},
+			"refresh_token": {token.RefreshToken},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var refreshed openIDTestTokenResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &refreshed)
+		assert.NoError(t, err)
+
+		claims := parseIDToken(refreshed.IDToken)
+		assert.Equal(t, u.GetID(), claims["sub"])
+		assert.Nil(t, claims["nonce"])
+	})
+
+	t.Run("tokens without the openid scope", func(t *testing.T) {
+		token := authorize(cookie, "read")
+		assert.Empty(t, token.IDToken)
+
+		rec := request(http.MethodGet, "/oauth/userinfo", nil, token.AccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		rec = request(http.MethodGet, "/oauth/userinfo", nil, "", nil)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("codes are not consumed if the ID token can not be signed", func(t *testing.T) {
+		rec := request(http.MethodGet, "/oauth/authorize?"+url.Values{
+			"response_type":         {"code"},
+			"client_id":             {client.ClientID},
+			"redirect_uri":          {redirectURI},
+			"scope":                 {"openid"},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		}.Encode(), cookie, "", nil)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var page struct {
+			ConsentToken string `json:"consentToken"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &page)
+
+		rec = request(http.MethodPost, "/oauth/authorize", cookie, "", url.Values{
+			"consent_token": {page.ConsentToken},
+			"decision":      {"allow"},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var decision user.OAuthAuthorizeDecisionJSONResponse
+		json.Unmarshal(rec.Body.Bytes(), &decision)
+		location, err := url.Parse(decision.RedirectTo)
+		assert.NoError(t, err)
+
+		exchange := func() *httptest.ResponseRecorder {
+			return request(http.MethodPost, "/auth/oauth2/token", nil, "", url.Values{
+				"grant_type":    {"authorization_code"},
+				"client_id":     {client.ClientID},
+				"code":          {location.Query().Get("code")},
+				"redirect_uri":  {redirectURI},
+				"code_verifier": {verifier},
+			})
+		}
+
+		t.Setenv("OAUTH2_JWT_ALGORITHM", "HS256")
+		t.Setenv("OAUTH2_JWT_SECRET", "a-jwt-secret-with-more-than-32-chars")
+
+		rec = exchange()
+		assert.Equal(t, http.StatusInternalServerError, rec.Code, rec.Body.String())
+
+		t.Setenv("OAUTH2_JWT_ALGORITHM", "RS256")
+
+		rec = exchange()
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+
+	t.Run("logout without one id_token_hint of the session user requires one confirmation", func(t *testing.T) {
+		cookie := login()
+
+		rec := request(http.MethodGet, "/oauth/logout?"+url.Values{
+			"client_id":                {client.ClientID},
+			"post_logout_redirect_uri": {redirectURI},
+		}.Encode(), cookie, "", nil)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var page struct {
+			LogoutToken           string `json:"logoutToken"`
+			PostLogoutRedirectURI string `json:"postLogoutRedirectUri"`
+		}
+		err := json.Unmarshal(rec.Body.Bytes(), &page)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, page.LogoutToken)
+		assert.Equal(t, redirectURI, page.PostLogoutRedirectURI)
+
+		rec = request(http.MethodPost, "/oauth/logout", cookie, "", url.Values{
+			"client_id":                {client.ClientID},
+			"post_logout_redirect_uri": {redirectURI},
+			"logout_token":             {"invalid"},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		// the session is kept:
+		token := authorize(cookie, "openid")
+		assert.NotEmpty(t, token.IDToken)
+
+		rec = request(http.MethodGet, "/oauth/logout?"+url.Values{"client_id": {client.ClientID}}.Encode(), cookie, "", nil)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		err = json.Unmarshal(rec.Body.Bytes(), &page)
+		assert.NoError(t, err)
+
+		rec = request(http.MethodPost, "/oauth/logout", cookie, "", url.Values{
+			"client_id":                {client.ClientID},
+			"post_logout_redirect_uri": {redirectURI},
+			"logout_token":             {page.LogoutToken},
+		})
+		assert.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+		assert.Equal(t, redirectURI, rec.Header().Get(echo.HeaderLocation))
+	})
+
+	t.Run("RP-initiated logout", func(t *testing.T) {
+		token := authorize(cookie, "openid")
+
+		rec := request(http.MethodGet, "/oauth/logout?"+url.Values{
+			"id_token_hint":            {token.IDToken},
+			"post_logout_redirect_uri": {"https://evil.example.com"},
+		}.Encode(), cookie, "", nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = request(http.MethodGet, "/oauth/logout?"+url.Values{
+			"id_token_hint": {"invalid"},
+		}.Encode(), cookie, "", nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = request(http.MethodGet, "/oauth/logout?"+url.Values{
+			"id_token_hint":            {token.IDToken},
+			"post_logout_redirect_uri": {redirectURI},
+			"state":                    {"af0ifjsldkj"},
+		}.Encode(), cookie, "", nil)
+		assert.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+		assert.Equal(t, redirectURI+"?state=af0ifjsldkj", rec.Header().Get(echo.HeaderLocation))
+
+		// the session is deleted:
+		rec = request(http.MethodGet, "/oauth/authorize?"+url.Values{
+			"response_type":         {"code"},
+			"client_id":             {client.ClientID},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		}.Encode(), cookie, "", nil)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
@@ -0,0 +1,269 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/labstack/echo/v4"
+)
+
+type PersonalAccessTokenController struct {
+	App bolo.App
+}
+
+type PersonalAccessTokenCreateRequestBody struct {
+	Name string `json:"name" form:"name" validate:"required,max=100"`
+	// space separated oauth2 scopes, empty for the full user rights
+	Scopes string `json:"scopes" form:"scopes"`
+	// days until the token expiration, 0 for the AUTH_PERSONAL_ACCESS_TOKEN_MAX_DAYS
+	ExpiresInDays int64 `json:"expiresInDays" form:"expiresInDays"`
+}
+
+type PersonalAccessTokenListJSONResponse struct {
+	Records []*user_models.PersonalAccessTokenModel `json:"personalAccessToken"`
+}
+
+type PersonalAccessTokenCreateJSONResponse struct {
+	Record *user_models.PersonalAccessTokenModel `json:"personalAccessToken"`
+	// the plain token, only returned here
+	Token string `json:"token"`
+}
+
+// List - list the authenticated user personal access tokens
+func (ctl *PersonalAccessTokenController) List(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	return ctl.list(c, ctx.AuthenticatedUser.GetID())
+}
+
+// Create - create one personal access token for the authenticated user
+func (ctl *PersonalAccessTokenController) Create(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	return ctl.create(c, ctx.AuthenticatedUser.GetID())
+}
+
+// Revoke - delete one of the authenticated user personal access tokens, the token is rejected in the next request
+func (ctl *PersonalAccessTokenController) Revoke(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	return ctl.revoke(c, ctx.AuthenticatedUser.GetID())
+}
+
+// ListUserTokens - admin endpoint with the personal access tokens of one user or service account
+func (ctl *PersonalAccessTokenController) ListUserTokens(c echo.Context) error {
+	record, err := ctl.findManagedUser(c, "ListUserTokens")
+	if err != nil {
+		return err
+	}
+
+	return ctl.list(c, record.GetID())
+}
+
+// CreateUserToken - admin endpoint to create one personal access token for one user or service account
+func (ctl *PersonalAccessTokenController) CreateUserToken(c echo.Context) error {
+	record, err := ctl.findManagedUser(c, "CreateUserToken")
+	if err != nil {
+		return err
+	}
+
+	// tokens have the user roles, then only users with all the role permissions can create them:
+	ctx := c.(*bolo.RequestContext)
+	for _, role := range record.GetRoles() {
+		if !canChangeUserRole(ctx, role) {
+			return &bolo.HTTPError{
+				Code:     http.StatusForbidden,
+				Message:  "auth.tokens.user-role-not-allowed",
+				Internal: fmt.Errorf("PersonalAccessTokenController.CreateUserToken forbidden for the role %s", role),
+			}
+		}
+	}
+
+	return ctl.create(c, record.GetID())
+}
+
+// RevokeUserToken - admin endpoint to revoke one personal access token of one user or service account
+func (ctl *PersonalAccessTokenController) RevokeUserToken(c echo.Context) error {
+	record, err := ctl.findManagedUser(c, "RevokeUserToken")
+	if err != nil {
+		return err
+	}
+
+	return ctl.revoke(c, record.GetID())
+}
+
+func (ctl *PersonalAccessTokenController) list(c echo.Context, userID string) error {
+	records, err := user_models.FindPersonalAccessTokensByUserID(userID)
+	if err != nil {
+		return fmt.Errorf("PersonalAccessTokenController.list error on find tokens: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, &PersonalAccessTokenListJSONResponse{Records: records})
+}
+
+func (ctl *PersonalAccessTokenController) create(c echo.Context, userID string) error {
+	var body PersonalAccessTokenCreateRequestBody
+
+	ctx := c.(*bolo.RequestContext)
+
+	if err := c.Bind(&body); err != nil {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "invalid body",
+			Internal: fmt.Errorf("PersonalAccessTokenController.create error on bind body: %w", err),
+		}
+	}
+
+	if err := c.Validate(&body); err != nil {
+		return err
+	}
+
+	scopes := auth_oauth2_password.ParseScope(body.Scopes)
+	err := auth_oauth2_password.ValidateScopes(ctl.App, scopes)
+	if err != nil {
+		if errors.Is(err, auth_oauth2_password.ErrInvalidScope) {
+			return &bolo.HTTPError{
+				Code:     http.StatusBadRequest,
+				Message:  "auth.tokens.invalid-scope",
+				Internal: err,
+			}
+		}
+
+		return err
+	}
+
+	expiresAt, err := ctl.getExpiresAt(body.ExpiresInDays)
+	if err != nil {
+		return err
+	}
+
+	record, token, err := user_models.CreatePersonalAccessToken(userID, strings.TrimSpace(body.Name), scopes, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventPersonalTokenAdd, userID, map[string]interface{}{
+		"tokenId": record.GetID(),
+		"name":    record.Name,
+		"scopes":  record.Scopes,
+	})
+
+	return c.JSON(http.StatusCreated, &PersonalAccessTokenCreateJSONResponse{
+		Record: record,
+		Token:  token,
+	})
+}
+
+func (ctl *PersonalAccessTokenController) revoke(c echo.Context, userID string) error {
+	ctx := c.(*bolo.RequestContext)
+
+	record := user_models.PersonalAccessTokenModel{}
+	err := user_models.FindUserPersonalAccessToken(userID, c.Param("id"), &record)
+	if err != nil {
+		return fmt.Errorf("PersonalAccessTokenController.revoke error on find token: %w", err)
+	}
+
+	if record.ID == 0 {
+		return echo.NotFoundHandler(c)
+	}
+
+	err = record.Delete()
+	if err != nil {
+		return fmt.Errorf("PersonalAccessTokenController.revoke error on delete token: %w", err)
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventPersonalTokenDel, userID, map[string]interface{}{
+		"tokenId": record.GetID(),
+		"name":    record.Name,
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// getExpiresAt returns the token expiration, limited by the AUTH_PERSONAL_ACCESS_TOKEN_MAX_DAYS configuration.
+// With AUTH_PERSONAL_ACCESS_TOKEN_MAX_DAYS=0 tokens without expiresInDays never expire
+func (ctl *PersonalAccessTokenController) getExpiresAt(expiresInDays int64) (*time.Time, error) {
+	maxDays := ctl.App.GetConfiguration().GetInt64F("AUTH_PERSONAL_ACCESS_TOKEN_MAX_DAYS", 365)
+
+	if expiresInDays < 0 || (maxDays > 0 && expiresInDays > maxDays) {
+		return nil, &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "auth.tokens.invalid-expiration",
+			Internal: fmt.Errorf("PersonalAccessTokenController invalid expiresInDays %d, max %d", expiresInDays, maxDays),
+		}
+	}
+
+	if expiresInDays == 0 {
+		if maxDays <= 0 {
+			return nil, nil
+		}
+
+		expiresInDays = maxDays
+	}
+
+	expiresAt := time.Now().Add(time.Duration(expiresInDays) * 24 * time.Hour)
+	return &expiresAt, nil
+}
+
+// findManagedUser checks the manage_users permission and returns the :userID user
+func (ctl *PersonalAccessTokenController) findManagedUser(c echo.Context, action string) (*user_models.UserModel, error) {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.Can("manage_users") {
+		return nil, &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "Forbidden",
+			Internal: errors.New("PersonalAccessTokenController." + action + " forbidden"),
+		}
+	}
+
+	var record user_models.UserModel
+	err := user_models.UserFindOne(c.Param("userID"), &record)
+	if err != nil {
+		return nil, err
+	}
+
+	if record.ID == 0 {
+		return nil, echo.NotFoundHandler(c)
+	}
+
+	return &record, nil
+}
+
+type NewPersonalAccessTokenControllerCFG struct {
+	App bolo.App
+}
+
+func NewPersonalAccessTokenController(cfg *NewPersonalAccessTokenControllerCFG) *PersonalAccessTokenController {
+	return &PersonalAccessTokenController{App: cfg.App}
+}
@@ -0,0 +1,244 @@
+package user_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/bolo/acl"
+	"github.com/go-bolo/user"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersonalAccessTokenController(t *testing.T) {
+	t.Setenv("OAUTH2_SCOPES", `{"read": ["find_user"]}`)
+
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	admin := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	admin.SetRole("administrator")
+	err = admin.Save(ctx)
+	assert.NoError(t, err)
+	defer admin.Delete()
+
+	app.SetRole("users_manager", acl.Role{
+		Name:        "users_manager",
+		Permissions: []string{"manage_users"},
+	})
+
+	manager := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	manager.SetRole("users_manager")
+	err = manager.Save(ctx)
+	assert.NoError(t, err)
+	defer manager.Delete()
+
+	userToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+	assert.NoError(t, err)
+	adminToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &admin)
+	assert.NoError(t, err)
+
+	request := func(method, url, accessToken, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	createToken := func(t *testing.T, url, accessToken, body string) user.PersonalAccessTokenCreateJSONResponse {
+		rec := request(http.MethodPost, url, accessToken, body)
+		assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+		res := user.PersonalAccessTokenCreateJSONResponse{}
+		err := json.Unmarshal(rec.Body.Bytes(), &res)
+		assert.NoError(t, err)
+		return res
+	}
+
+	t.Run("create, use and list tokens", func(t *testing.T) {
+		created := createToken(t, "/api/v2/auth/tokens", userToken.AccessToken, `{"name": "ci", "expiresInDays": 30}`)
+		assert.True(t, strings.HasPrefix(created.Token, user_models.PersonalAccessTokenPrefix))
+		assert.NotNil(t, created.Record.ExpiresAt)
+
+		rec := request(http.MethodGet, "/api/v2/auth/tokens", created.Token, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"name":"ci"`)
+		assert.NotContains(t, rec.Body.String(), created.Token)
+
+		record := user_models.PersonalAccessTokenModel{}
+		err := user_models.FindPersonalAccessTokenByToken(created.Token, &record)
+		assert.NoError(t, err)
+		assert.NotNil(t, record.LastUsedAt)
+		assert.NotEmpty(t, record.TokenHash)
+		assert.NotContains(t, rec.Body.String(), record.TokenHash)
+	})
+
+	t.Run("tokens can not manage tokens", func(t *testing.T) {
+		created := createToken(t, "/api/v2/auth/tokens", userToken.AccessToken, `{"name": "ci"}`)
+
+		rec := request(http.MethodPost, "/api/v2/auth/tokens", created.Token, `{"name": "other"}`)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Contains(t, rec.Body.String(), "auth.personal-access-token-not-allowed")
+	})
+
+	t.Run("tokens can not change the credentials", func(t *testing.T) {
+		created := createToken(t, "/api/v2/auth/tokens", userToken.AccessToken, `{"name": "ci"}`)
+
+		rec := request(http.MethodPost, "/auth/change-password", created.Token, `{"oldPassword": "123456", "newPassword": "1234567", "rNewPassword": "1234567"}`)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Contains(t, rec.Body.String(), "auth.personal-access-token-not-allowed")
+
+		adminCreated := createToken(t, "/api/v2/auth/tokens", adminToken.AccessToken, `{"name": "admin"}`)
+
+		rec = request(http.MethodPost, "/auth/"+u.GetID()+"/set-password", adminCreated.Token, `{"newPassword": "1234567", "rNewPassword": "1234567"}`)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Contains(t, rec.Body.String(), "auth.personal-access-token-not-allowed")
+	})
+
+	t.Run("invalid scopes and expiration", func(t *testing.T) {
+		rec := request(http.MethodPost, "/api/v2/auth/tokens", userToken.AccessToken, `{"name": "ci", "scopes": "unknown"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "auth.tokens.invalid-scope")
+
+		rec = request(http.MethodPost, "/api/v2/auth/tokens", userToken.AccessToken, `{"name": "ci", "expiresInDays": 1000}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "auth.tokens.invalid-expiration")
+	})
+
+	t.Run("scoped tokens only have the scope permissions", func(t *testing.T) {
+		created := createToken(t, "/api/v2/auth/user/"+u.GetID()+"/tokens", adminToken.AccessToken, `{"name": "service", "scopes": "read"}`)
+		assert.Equal(t, "read", created.Record.Scopes)
+
+		rec := request(http.MethodGet, "/api/v2/auth/user/"+u.GetID()+"/tokens", created.Token, "")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("managers can not create tokens of users with other permissions", func(t *testing.T) {
+		managerToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &manager)
+		assert.NoError(t, err)
+
+		rec := request(http.MethodPost, "/api/v2/auth/user/"+admin.GetID()+"/tokens", managerToken.AccessToken, `{"name": "admin"}`)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Contains(t, rec.Body.String(), "auth.tokens.user-role-not-allowed")
+
+		createToken(t, "/api/v2/auth/user/"+u.GetID()+"/tokens", managerToken.AccessToken, `{"name": "service"}`)
+	})
+
+	t.Run("tokens of inactive users are rejected", func(t *testing.T) {
+		created := createToken(t, "/api/v2/auth/tokens", userToken.AccessToken, `{"name": "ci"}`)
+
+		err := bolo.GetDefaultDatabaseConnection().Model(&u).Update("active", false).Error
+		assert.NoError(t, err)
+
+		rec := request(http.MethodGet, "/api/v2/auth/tokens", created.Token, "")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		err = bolo.GetDefaultDatabaseConnection().Model(&u).Update("active", true).Error
+		assert.NoError(t, err)
+
+		rec = request(http.MethodGet, "/api/v2/auth/tokens", created.Token, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("tokens are only revoked with all the user credentials", func(t *testing.T) {
+		other := user_models.UserModel{
+			Username: gofakeit.UUID(),
+			Email:    gofakeit.Email(),
+			Active:   true,
+		}
+		err := other.Save(ctx)
+		assert.NoError(t, err)
+		defer other.Delete()
+
+		otherToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &other)
+		assert.NoError(t, err)
+
+		created := createToken(t, "/api/v2/auth/tokens", otherToken.AccessToken, `{"name": "ci"}`)
+
+		err = user_models.DeleteUserSessions(other.GetID(), "")
+		assert.NoError(t, err)
+
+		rec := request(http.MethodGet, "/api/v2/auth/tokens", created.Token, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		rec = request(http.MethodDelete, "/api/v2/auth/user/"+other.GetID()+"/credentials", created.Token, "")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		rec = request(http.MethodDelete, "/api/v2/auth/user/"+other.GetID()+"/credentials", adminToken.AccessToken, "")
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		rec = request(http.MethodGet, "/api/v2/auth/tokens", created.Token, "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("revoked tokens are rejected and the audit log has the token", func(t *testing.T) {
+		created := createToken(t, "/api/v2/auth/tokens", userToken.AccessToken, `{"name": "ci"}`)
+		adminCreated := createToken(t, "/api/v2/auth/tokens", adminToken.AccessToken, `{"name": "admin"}`)
+
+		rec := request(http.MethodDelete, "/api/v2/auth/user/"+u.GetID()+"/tokens/"+created.Record.GetID(), adminCreated.Token, "")
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		rec = request(http.MethodGet, "/api/v2/auth/tokens", created.Token, "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		event := user_models.AuthEventModel{}
+		err := bolo.GetDefaultDatabaseConnection().
+			Where("type = ? AND userId = ?", user_models.AuthEventPersonalTokenDel, u.GetID()).
+			Order("id DESC").
+			First(&event).Error
+		assert.NoError(t, err)
+		if assert.NotNil(t, event.ActorID) {
+			assert.Equal(t, int64(admin.ID), *event.ActorID)
+		}
+		assert.Contains(t, string(event.Metadata), `"personalAccessTokenId":"`+adminCreated.Record.GetID()+`"`)
+	})
+
+	t.Run("users can only revoke their own tokens", func(t *testing.T) {
+		created := createToken(t, "/api/v2/auth/tokens", adminToken.AccessToken, `{"name": "admin"}`)
+
+		rec := request(http.MethodDelete, "/api/v2/auth/tokens/"+created.Record.GetID(), userToken.AccessToken, "")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec = request(http.MethodDelete, "/api/v2/auth/tokens/"+created.Record.GetID(), adminToken.AccessToken, "")
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	})
+}
@@ -0,0 +1,507 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/bolo/acl"
+	user_models "github.com/go-bolo/user/models"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+var roleNameRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{1,99}$`)
+
+// RoleController - role and permission management, the database roles are merged with the static app roles
+type RoleController struct {
+	App bolo.App
+	// roles from the static configuration, they can be edited but not deleted
+	staticRoles map[string]bool
+	// static roles loaded from the configuration, the base of the ReloadRoles
+	staticRoleList map[string]acl.Role
+	// the app roles map is read without locks in each request, then it is never changed: the role changes build
+	// one new map and replace the app map. The lock only serializes the changes
+	rolesMu sync.Mutex
+	// current roles map, read by this controller
+	roles      atomic.Pointer[map[string]*acl.Role]
+	stopReload chan struct{}
+}
+
+type RoleBody struct {
+	Name          string   `json:"name" form:"name"`
+	CanAddInUsers bool     `json:"canAddInUsers" form:"canAddInUsers"`
+	Permissions   []string `json:"permissions" form:"permissions"`
+}
+
+type RoleJSONResponse struct {
+	Role *acl.Role `json:"role"`
+}
+
+type RoleListJSONResponse struct {
+	Roles []*acl.Role `json:"roles"`
+}
+
+type UserRoleNamesResponse struct {
+	Roles []string `json:"roles"`
+}
+
+// LoadRoles merges the database roles in the app roles, runs in the app bootstrap
+func (ctl *RoleController) LoadRoles() error {
+	ctl.staticRoles = map[string]bool{}
+	ctl.staticRoleList = map[string]acl.Role{}
+	for name, r := range ctl.App.GetRoles() {
+		ctl.staticRoles[name] = true
+		ctl.staticRoleList[name] = *r
+	}
+
+	return ctl.ReloadRoles()
+}
+
+// ReloadRoles replaces the app roles with the static roles and the database roles, then the role changes made in
+// other app instances are applied here. Runs every ACL_ROLES_RELOAD_INTERVAL seconds after the bootstrap
+func (ctl *RoleController) ReloadRoles() error {
+	ctl.rolesMu.Lock()
+	defer ctl.rolesMu.Unlock()
+
+	roles := map[string]*acl.Role{}
+	for name, r := range ctl.staticRoleList {
+		role := r
+		role.Permissions = append([]string{}, r.Permissions...)
+		roles[name] = &role
+	}
+
+	db := ctl.App.GetDB()
+	if db == nil || !db.Migrator().HasTable(&user_models.RoleModel{}) {
+		logrus.Debug("RoleController.ReloadRoles roles table not found, skipping the database roles")
+		ctl.publishRoles(roles)
+		return nil
+	}
+
+	records, err := user_models.FindRoles()
+	if err != nil {
+		return fmt.Errorf("RoleController.ReloadRoles error on find roles: %w", err)
+	}
+
+	for _, r := range records {
+		role := acl.Role{
+			Name:          r.Name,
+			CanAddInUsers: r.CanAddInUsers,
+			Permissions:   r.Permissions,
+		}
+
+		// the database permissions replace the static ones:
+		if current, ok := ctl.staticRoleList[r.Name]; ok {
+			role.IsSystemRole = current.IsSystemRole
+		}
+
+		roles[r.Name] = &role
+	}
+
+	ctl.publishRoles(roles)
+
+	return nil
+}
+
+// StartRolesReload reloads the roles in the background until the StopRolesReload, interval 0 disables the reload
+func (ctl *RoleController) StartRolesReload(interval time.Duration) {
+	if interval <= 0 || ctl.stopReload != nil {
+		return
+	}
+
+	ctl.stopReload = make(chan struct{})
+	stop := ctl.stopReload
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				err := ctl.ReloadRoles()
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error": err,
+					}).Error("RoleController.StartRolesReload error on reload roles")
+				}
+			}
+		}
+	}()
+}
+
+// StopRolesReload stops the background reload, runs in the app close
+func (ctl *RoleController) StopRolesReload() {
+	if ctl.stopReload != nil {
+		close(ctl.stopReload)
+		ctl.stopReload = nil
+	}
+}
+
+func (ctl *RoleController) IsStaticRole(name string) bool {
+	return ctl.staticRoles[name]
+}
+
+// Find - list all app roles with the permissions
+func (ctl *RoleController) Find(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.Can("manage_permissions") {
+		return forbiddenError("RoleController.Find forbidden")
+	}
+
+	roles := ctl.listAppRoles()
+
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Name < roles[j].Name
+	})
+
+	return c.JSON(http.StatusOK, &RoleListJSONResponse{Roles: roles})
+}
+
+// FindOne - get one role with the permissions
+func (ctl *RoleController) FindOne(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.Can("manage_permissions") {
+		return forbiddenError("RoleController.FindOne forbidden")
+	}
+
+	role := ctl.getAppRole(c.Param("roleName"))
+	if role == nil {
+		return echo.NotFoundHandler(c)
+	}
+
+	return c.JSON(http.StatusOK, &RoleJSONResponse{Role: role})
+}
+
+// Create - create one database role
+func (ctl *RoleController) Create(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.Can("manage_permissions") {
+		return forbiddenError("RoleController.Create forbidden")
+	}
+
+	var body RoleBody
+	if err := c.Bind(&body); err != nil {
+		if _, ok := err.(*echo.HTTPError); ok {
+			return err
+		}
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	if !roleNameRegex.MatchString(body.Name) {
+		return c.JSON(http.StatusBadRequest, bolo.ValidationResponse{
+			Errors: []*bolo.ValidationFieldError{{
+				Field:   "name",
+				Tag:     "roleName",
+				Value:   body.Name,
+				Message: "acl.role.invalid-name",
+			}},
+		})
+	}
+
+	if ctl.getAppRole(body.Name) != nil {
+		return c.JSON(http.StatusBadRequest, bolo.ValidationResponse{
+			Errors: []*bolo.ValidationFieldError{{
+				Field:   "name",
+				Tag:     "unique",
+				Value:   body.Name,
+				Message: "acl.role.already-exists",
+			}},
+		})
+	}
+
+	role := acl.Role{
+		Name:          body.Name,
+		CanAddInUsers: body.CanAddInUsers,
+		Permissions:   body.Permissions,
+	}
+
+	err := ctl.saveRole(&role)
+	if err != nil {
+		return err
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventRoleCreate, "", map[string]interface{}{
+		"role":        role.Name,
+		"permissions": role.Permissions,
+	})
+
+	return c.JSON(http.StatusCreated, &RoleJSONResponse{Role: ctl.getAppRole(role.Name)})
+}
+
+// Update - replace the role canAddInUsers and permissions, static roles are saved in the database after the first change
+func (ctl *RoleController) Update(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.Can("manage_permissions") {
+		return forbiddenError("RoleController.Update forbidden")
+	}
+
+	current := ctl.getAppRole(c.Param("roleName"))
+	if current == nil {
+		return echo.NotFoundHandler(c)
+	}
+
+	var body RoleBody
+	if err := c.Bind(&body); err != nil {
+		if _, ok := err.(*echo.HTTPError); ok {
+			return err
+		}
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	role := acl.Role{
+		Name:          current.Name,
+		CanAddInUsers: body.CanAddInUsers,
+		Permissions:   body.Permissions,
+		IsSystemRole:  current.IsSystemRole,
+	}
+
+	err := ctl.saveRole(&role)
+	if err != nil {
+		return err
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventRoleUpdate, "", map[string]interface{}{
+		"role":           role.Name,
+		"oldPermissions": current.Permissions,
+		"permissions":    role.Permissions,
+	})
+
+	return c.JSON(http.StatusOK, &RoleJSONResponse{Role: ctl.getAppRole(role.Name)})
+}
+
+// Delete - delete one database role, the role is also removed from all users
+func (ctl *RoleController) Delete(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.Can("manage_permissions") {
+		return forbiddenError("RoleController.Delete forbidden")
+	}
+
+	name := c.Param("roleName")
+
+	role := ctl.getAppRole(name)
+	if role == nil {
+		return echo.NotFoundHandler(c)
+	}
+
+	if role.IsSystemRole || ctl.IsStaticRole(name) {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "acl.role.static-role",
+			Internal: errors.New("RoleController.Delete static role " + name),
+		}
+	}
+
+	err := user_models.DeleteRole(name)
+	if err != nil {
+		return fmt.Errorf("RoleController.Delete error on delete role: %w", err)
+	}
+
+	ctl.deleteAppRole(name)
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventRoleDelete, "", map[string]interface{}{
+		"role": name,
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// AddPermission - add one permission to the role
+func (ctl *RoleController) AddPermission(c echo.Context) error {
+	return ctl.setPermission(c, true)
+}
+
+// RemovePermission - remove one permission from the role
+func (ctl *RoleController) RemovePermission(c echo.Context) error {
+	return ctl.setPermission(c, false)
+}
+
+func (ctl *RoleController) setPermission(c echo.Context, hasAccess bool) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.Can("manage_permissions") {
+		return forbiddenError("RoleController.setPermission forbidden")
+	}
+
+	current := ctl.getAppRole(c.Param("roleName"))
+	if current == nil {
+		return echo.NotFoundHandler(c)
+	}
+
+	permission := c.Param("permissionName")
+
+	role := acl.Role{
+		Name:          current.Name,
+		CanAddInUsers: current.CanAddInUsers,
+		Permissions:   append([]string{}, current.Permissions...),
+		IsSystemRole:  current.IsSystemRole,
+	}
+
+	if hasAccess {
+		role.AddPermission(permission)
+	} else {
+		role.RemovePermission(permission)
+	}
+
+	err := ctl.saveRole(&role)
+	if err != nil {
+		return err
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventRoleUpdate, "", map[string]interface{}{
+		"role":       role.Name,
+		"permission": permission,
+		"hasAccess":  hasAccess,
+	})
+
+	return c.JSON(http.StatusOK, &RoleJSONResponse{Role: ctl.getAppRole(role.Name)})
+}
+
+// GetUserRoles - list the user role names, available for the user and users with find_user permission
+func (ctl *RoleController) GetUserRoles(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	var record user_models.UserModel
+	err := user_models.UserFindOne(c.Param("userID"), &record)
+	if err != nil {
+		return err
+	}
+
+	if record.ID == 0 {
+		return echo.NotFoundHandler(c)
+	}
+
+	isOwner := ctx.IsAuthenticated && record.GetID() == ctx.AuthenticatedUser.GetID()
+
+	if !isOwner && !ctx.Can("find_user") && !ctx.Can("manage_permissions") {
+		return forbiddenError("RoleController.GetUserRoles forbidden")
+	}
+
+	roles := record.GetRoles()
+	if roles == nil {
+		roles = []string{}
+	}
+
+	return c.JSON(http.StatusOK, &UserRoleNamesResponse{Roles: roles})
+}
+
+// saveRole saves the role in the database then updates the app roles
+func (ctl *RoleController) saveRole(role *acl.Role) error {
+	permissions := role.Permissions
+	role.Permissions = []string{}
+	for _, p := range permissions {
+		if p != "" {
+			role.AddPermission(p)
+		}
+	}
+
+	err := user_models.SaveRole(&user_models.RoleModel{
+		Name:          role.Name,
+		CanAddInUsers: role.CanAddInUsers,
+		Permissions:   role.Permissions,
+	})
+	if err != nil {
+		return fmt.Errorf("RoleController error on save role: %w", err)
+	}
+
+	return ctl.setAppRole(*role)
+}
+
+func (ctl *RoleController) setAppRole(role acl.Role) error {
+	ctl.rolesMu.Lock()
+	defer ctl.rolesMu.Unlock()
+
+	roles := ctl.copyAppRoles()
+	roles[role.Name] = &role
+	ctl.publishRoles(roles)
+
+	return nil
+}
+
+func (ctl *RoleController) deleteAppRole(name string) {
+	ctl.rolesMu.Lock()
+	defer ctl.rolesMu.Unlock()
+
+	roles := ctl.copyAppRoles()
+	delete(roles, name)
+	ctl.publishRoles(roles)
+}
+
+// getAppRole returns one role of the current roles map
+func (ctl *RoleController) getAppRole(name string) *acl.Role {
+	if roles := ctl.roles.Load(); roles != nil {
+		return (*roles)[name]
+	}
+
+	return ctl.getAppRole(name)
+}
+
+func (ctl *RoleController) listAppRoles() []*acl.Role {
+	list := []*acl.Role{}
+	for _, r := range ctl.copyAppRoles() {
+		list = append(list, r)
+	}
+
+	return list
+}
+
+// copyAppRoles returns one shallow copy of the current roles map, the roles are never changed after the publishRoles
+func (ctl *RoleController) copyAppRoles() map[string]*acl.Role {
+	current := ctl.roles.Load()
+	if current == nil {
+		appRoles := ctl.App.GetRoles()
+		current = &appRoles
+	}
+
+	roles := make(map[string]*acl.Role, len(*current))
+	for name, r := range *current {
+		roles[name] = r
+	}
+
+	return roles
+}
+
+// publishRoles replaces the app roles map, requires the rolesMu lock
+func (ctl *RoleController) publishRoles(roles map[string]*acl.Role) {
+	ctl.roles.Store(&roles)
+
+	if app, ok := ctl.App.(*bolo.AppStruct); ok {
+		// one pointer swap, requests never read one map while it is changed:
+		app.RolesList = roles
+		return
+	}
+
+	// other App implementations only have the SetRole:
+	for name, r := range roles {
+		ctl.App.SetRole(name, *r)
+	}
+}
+
+func forbiddenError(internal string) error {
+	return &bolo.HTTPError{
+		Code:     http.StatusForbidden,
+		Message:  "Forbidden",
+		Internal: errors.New(internal),
+	}
+}
+
+type NewRoleControllerCFG struct {
+	App bolo.App
+}
+
+func NewRoleController(cfg *NewRoleControllerCFG) *RoleController {
+	return &RoleController{App: cfg.App, staticRoles: map[string]bool{}}
+}
@@ -0,0 +1,211 @@
+package user_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleController(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	admin := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	admin.SetRole("administrator")
+	err := admin.Save(ctx)
+	assert.NoError(t, err)
+	defer admin.Delete()
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err = u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	adminToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &admin)
+	assert.NoError(t, err)
+	userToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+	assert.NoError(t, err)
+
+	request := func(method, url, accessToken, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	roleName := "editor_" + strings.ReplaceAll(gofakeit.UUID(), "-", "")[:8]
+
+	t.Run("mutations require the manage_permissions permission", func(t *testing.T) {
+		rec := request(http.MethodPost, "/acl/role", userToken.AccessToken, `{"name":"`+roleName+`"}`)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		rec = request(http.MethodDelete, "/acl/role/administrator", userToken.AccessToken, "")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("create role", func(t *testing.T) {
+		rec := request(http.MethodPost, "/acl/role", adminToken.AccessToken, `{"name":"invalid name"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = request(http.MethodPost, "/acl/role", adminToken.AccessToken, `{"name":"administrator"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = request(http.MethodPost, "/acl/role", adminToken.AccessToken, `{"name":"`+roleName+`","canAddInUsers":true,"permissions":["find_user","find_user"]}`)
+		assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+		var resp user.RoleJSONResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		assert.Equal(t, roleName, resp.Role.Name)
+		assert.Equal(t, []string{"find_user"}, resp.Role.Permissions)
+		assert.True(t, app.Can("find_user", []string{roleName}))
+	})
+
+	t.Run("add and remove permissions", func(t *testing.T) {
+		rec := request(http.MethodPost, "/acl/role/"+roleName+"/permissions/update_user", adminToken.AccessToken, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, app.Can("update_user", []string{roleName}))
+
+		rec = request(http.MethodDelete, "/acl/role/"+roleName+"/permissions/find_user", adminToken.AccessToken, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.False(t, app.Can("find_user", []string{roleName}))
+
+		rec = request(http.MethodPost, "/acl/role/unknown_role/permissions/find_user", adminToken.AccessToken, "")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("database roles are loaded in the bootstrap", func(t *testing.T) {
+		otherApp := NewApp(t)
+		role := otherApp.GetRole(roleName)
+		if assert.NotNil(t, role) {
+			assert.Equal(t, []string{"update_user"}, role.Permissions)
+			assert.True(t, role.CanAddInUsers)
+		}
+	})
+
+	t.Run("role changes of other app instances are reloaded", func(t *testing.T) {
+		otherApp := NewApp(t)
+		otherRoles := otherApp.GetPlugin("user").(*user.UserPlugin).RoleController
+
+		name := "reloaded_" + strings.ReplaceAll(gofakeit.UUID(), "-", "")[:8]
+		rec := request(http.MethodPost, "/acl/role", adminToken.AccessToken, `{"name":"`+name+`","permissions":["find_user"]}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Nil(t, otherApp.GetRole(name))
+
+		err := otherRoles.ReloadRoles()
+		assert.NoError(t, err)
+		assert.NotNil(t, otherApp.GetRole(name))
+		assert.True(t, otherApp.Can("find_user", []string{name}))
+
+		rec = request(http.MethodDelete, "/acl/role/"+name, adminToken.AccessToken, "")
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		err = otherRoles.ReloadRoles()
+		assert.NoError(t, err)
+		assert.Nil(t, otherApp.GetRole(name))
+		assert.NotNil(t, otherApp.GetRole("administrator"))
+	})
+
+	t.Run("static roles can be edited but not deleted", func(t *testing.T) {
+		rec := request(http.MethodPut, "/acl/role/authenticated", adminToken.AccessToken, `{"permissions":["find_user"]}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, app.Can("find_user", []string{"authenticated"}))
+
+		rec = request(http.MethodPut, "/acl/role/authenticated", adminToken.AccessToken, `{"permissions":[]}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.False(t, app.Can("find_user", []string{"authenticated"}))
+
+		rec = request(http.MethodDelete, "/acl/role/authenticated", adminToken.AccessToken, "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("get user roles", func(t *testing.T) {
+		u.SetRole(roleName)
+		err := u.Save(ctx)
+		assert.NoError(t, err)
+
+		rec := request(http.MethodGet, "/acl/user/"+u.GetID()+"/roles", userToken.AccessToken, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var resp user.UserRoleNamesResponse
+		err = json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{roleName}, resp.Roles)
+	})
+
+	t.Run("user roles are saved in the user_roles table", func(t *testing.T) {
+		roles, err := user_models.FindUserRoleNames(u.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{roleName}, roles)
+
+		assert.NoError(t, u.AddRole("reviewer"))
+		assert.NoError(t, u.AddRole("reviewer"))
+		assert.NoError(t, u.RemoveRole("reviewer"))
+
+		var record user_models.UserModel
+		err = user_models.UserFindOne(u.GetID(), &record)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{roleName}, record.GetRoles())
+	})
+
+	t.Run("filter users by role", func(t *testing.T) {
+		rec := request(http.MethodGet, "/api/user?role="+roleName, adminToken.AccessToken, "")
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var resp user.ListJSONResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), resp.Meta.Count)
+		if assert.Len(t, *resp.Record, 1) {
+			assert.Equal(t, u.ID, (*resp.Record)[0].ID)
+			assert.Equal(t, []string{roleName}, (*resp.Record)[0].Roles)
+		}
+	})
+
+	t.Run("delete role", func(t *testing.T) {
+		rec := request(http.MethodDelete, "/acl/role/"+roleName, adminToken.AccessToken, "")
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Nil(t, app.GetRole(roleName))
+
+		var record user_models.UserModel
+		err := user_models.UserFindOne(u.GetID(), &record)
+		assert.NoError(t, err)
+		assert.Empty(t, record.GetRoles(), "the deleted role should be removed from users")
+
+		rec = request(http.MethodGet, "/acl/role/"+roleName, adminToken.AccessToken, "")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
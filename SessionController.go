@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/go-bolo/bolo"
 	"github.com/go-bolo/metatags"
+	user_helpers "github.com/go-bolo/user/helpers"
 	user_models "github.com/go-bolo/user/models"
+	"github.com/go-bolo/user/security"
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
@@ -29,6 +32,23 @@ type LoginRequestBody struct {
 	RememberMe bool   `json:"remember_me" form:"remember_me"`
 }
 
+type LoginMfaRequestBody struct {
+	MfaToken string `json:"mfa_token" form:"mfa_token" validate:"required"`
+	Code     string `json:"code" form:"code" validate:"required"`
+}
+
+// data available in the auth/login template as .Record
+type loginPageData struct {
+	// send it in the login form as redirectTo
+	RedirectTo string
+}
+
+// data available in the auth/login-mfa template as .Record
+type loginMfaPageData struct {
+	MfaToken   string
+	RedirectTo string
+}
+
 func NewSessionController(cfg *NewSessionControllerCFG) *SessionController {
 	return &SessionController{App: cfg.App}
 }
@@ -40,7 +60,7 @@ type SessionController struct {
 func (ctl *SessionController) LoginPage(c echo.Context) error {
 	ctx := c.(*bolo.RequestContext)
 	if ctx.IsAuthenticated {
-		return c.Redirect(http.StatusTemporaryRedirect, "/")
+		return c.Redirect(http.StatusTemporaryRedirect, getLoginRedirectTo(c))
 	}
 
 	mt := c.Get("metatags").(*metatags.HTMLMetaTags)
@@ -49,13 +69,16 @@ func (ctl *SessionController) LoginPage(c echo.Context) error {
 	mt.Title = "Login"
 
 	status := http.StatusOK
-	switch c.Get("status").(type) {
+	switch v := c.Get("status").(type) {
 	case string:
-		status, _ = strconv.Atoi(c.Get("status").(string))
+		status, _ = strconv.Atoi(v)
+	case int:
+		status = v
 	}
 
 	return bolo.MinifiAndRender(status, "auth/login", &bolo.TemplateCTX{
-		Ctx: ctx,
+		Ctx:    ctx,
+		Record: &loginPageData{RedirectTo: getLoginRedirectTo(c)},
 	}, ctx)
 }
 
@@ -65,7 +88,7 @@ func (ctl *SessionController) Login(c echo.Context) error {
 	ctx := c.(*bolo.RequestContext)
 
 	if ctx.IsAuthenticated {
-		return c.Redirect(http.StatusTemporaryRedirect, "/")
+		return c.Redirect(http.StatusTemporaryRedirect, getLoginRedirectTo(c))
 	}
 
 	if err := c.Bind(&body); err != nil {
@@ -76,29 +99,56 @@ func (ctl *SessionController) Login(c echo.Context) error {
 		return err
 	}
 
-	valid, err := user_models.ValidUsernamePassword(body.Email, body.Password)
+	authPlugin := ctx.App.GetPlugin("auth").(*AuthPlugin)
+	throttle := authPlugin.GetLoginThrottle()
+	throttleKey := strings.ToLower(strings.TrimSpace(body.Email))
+
+	err := security.CheckLoginThrottle(throttle, throttleKey, c)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		var he *bolo.HTTPError
+		if errors.As(err, &he) && he.Code == http.StatusTooManyRequests {
 			AddFlashMessage(c, &FlashMessage{
 				Type:    "error",
-				Message: "Email ou senha incorretos.",
+				Message: "Muitas tentativas de login, aguarde alguns minutos e tente novamente.",
 			})
-			c.Set("status", http.StatusBadRequest)
+			c.Set("status", http.StatusTooManyRequests)
 			return ctl.LoginPage(c)
 		}
 
-		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
-			err = AddFlashMessage(c, &FlashMessage{
-				Type:    "error",
-				Message: "Usuário não encontrado ou não possuí senha cadastrada.",
-			})
-			if err != nil {
-				logrus.WithFields(logrus.Fields{
-					"error": fmt.Sprintf("%+v\n", err),
-				}).Error("AddFlashMessage Error", err)
+		return err
+	}
+
+	var userRecord user_models.UserModel
+
+	err = user_models.UserFindOneByUsername(body.Email, &userRecord)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if userRecord.IsLocked() {
+		user_models.RecordLoginFailure(ctx, userRecord.GetID(), body.Email, "session", "account_locked")
+		return ctl.renderAccountLocked(c)
+	}
+
+	valid, err := user_models.ValidUsernamePassword(body.Email, body.Password)
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) || errors.Is(err, gorm.ErrRecordNotFound) {
+			ctl.onLoginFail(throttle, throttleKey, c)
+		}
+
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			user_models.RecordLoginFailure(ctx, userRecord.GetID(), body.Email, "session", "invalid_password")
+
+			if user_models.OnUserLoginFail(ctx, &userRecord) {
+				return ctl.renderAccountLocked(c)
 			}
-			c.Set("status", http.StatusBadRequest)
-			return ctl.LoginPage(c)
+
+			return ctl.renderInvalidCredentials(c)
+		}
+
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			user_models.RecordLoginFailure(ctx, userRecord.GetID(), body.Email, "session", user_models.GetPasswordNotFoundReason(&userRecord))
+			return ctl.renderInvalidCredentials(c)
 		}
 
 		logrus.WithFields(logrus.Fields{
@@ -108,30 +158,214 @@ func (ctl *SessionController) Login(c echo.Context) error {
 	}
 
 	if !valid {
+		ctl.onLoginFail(throttle, throttleKey, c)
+
+		user_models.RecordLoginFailure(ctx, userRecord.GetID(), body.Email, "session", "invalid_password")
+
+		if user_models.OnUserLoginFail(ctx, &userRecord) {
+			return ctl.renderAccountLocked(c)
+		}
+
+		return ctl.renderInvalidCredentials(c)
+	}
+
+	if !userRecord.CanLogin() {
+		user_models.RecordLoginFailure(ctx, userRecord.GetID(), body.Email, "session", "inactive_user")
+
 		AddFlashMessage(c, &FlashMessage{
 			Type:    "error",
-			Message: "Erro ao validar a senha.",
+			Message: "Conta inativa ou bloqueada.",
 		})
 		c.Set("status", http.StatusBadRequest)
 		return ctl.LoginPage(c)
 	}
 
-	var userRecord user_models.UserModel
+	hasMfa, err := user_models.UserHasMfaEnabled(userRecord.GetID())
+	if err != nil {
+		return err
+	}
 
-	err = user_models.UserFindOneByUsername(body.Email, &userRecord)
+	if hasMfa {
+		challenge, err := user_models.CreateMfaChallenge(userRecord.GetID())
+		if err != nil {
+			return err
+		}
+
+		return ctl.renderLoginMfaPage(c, http.StatusOK, challenge.Token)
+	}
+
+	// the lockout and throttle counters are only reset after the second factor in logins with MFA:
+	user_models.OnUserLoginSuccess(ctx, &userRecord)
+
+	_, err = SetUserSession(ctx.App, c, &userRecord)
 	if err != nil {
 		return err
 	}
 
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventLogin, userRecord.GetID(), map[string]interface{}{
+		"method": "session",
+	})
+
+	return c.Redirect(http.StatusFound, getLoginRedirectTo(c))
+}
+
+// LoginMfa - second login step for users with two-factor authentication enabled
+func (ctl *SessionController) LoginMfa(c echo.Context) error {
+	var body LoginMfaRequestBody
+
+	ctx := c.(*bolo.RequestContext)
+
+	if ctx.IsAuthenticated {
+		return c.Redirect(http.StatusTemporaryRedirect, getLoginRedirectTo(c))
+	}
+
+	if err := c.Bind(&body); err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	if err := c.Validate(&body); err != nil {
+		return err
+	}
+
+	challenge, err := user_models.FindMfaChallenge(body.MfaToken)
+	if err != nil {
+		return err
+	}
+
+	if challenge == nil {
+		AddFlashMessage(c, &FlashMessage{
+			Type:    "error",
+			Message: "Sessão de login expirada, faça o login novamente.",
+		})
+		c.Set("status", http.StatusBadRequest)
+		return ctl.LoginPage(c)
+	}
+
+	authPlugin := ctx.App.GetPlugin("auth").(*AuthPlugin)
+	throttle := authPlugin.GetLoginThrottle()
+	throttleKey := "mfa_" + *challenge.UserID
+
+	err = security.CheckLoginThrottle(throttle, throttleKey, c)
+	if err != nil {
+		var he *bolo.HTTPError
+		if errors.As(err, &he) && he.Code == http.StatusTooManyRequests {
+			AddFlashMessage(c, &FlashMessage{
+				Type:    "error",
+				Message: "Muitas tentativas de login, aguarde alguns minutos e tente novamente.",
+			})
+			return ctl.renderLoginMfaPage(c, http.StatusTooManyRequests, body.MfaToken)
+		}
+
+		return err
+	}
+
+	valid, err := user_models.CompleteMfaChallenge(challenge, body.Code)
+	if err != nil {
+		return err
+	}
+
+	if !valid {
+		ctl.onLoginFail(throttle, throttleKey, c)
+
+		user_models.RecordLoginFailure(ctx, *challenge.UserID, "", "session", "invalid_mfa_code")
+
+		AddFlashMessage(c, &FlashMessage{
+			Type:    "error",
+			Message: "Código de verificação inválido.",
+		})
+		return ctl.renderLoginMfaPage(c, http.StatusBadRequest, body.MfaToken)
+	}
+
+	var userRecord user_models.UserModel
+	err = user_models.UserFindOne(*challenge.UserID, &userRecord)
+	if err != nil {
+		return err
+	}
+
+	if !userRecord.CanLogin() {
+		c.Set("status", http.StatusBadRequest)
+		return ctl.LoginPage(c)
+	}
+
+	user_models.OnUserLoginSuccess(ctx, &userRecord, throttleKey)
+
 	_, err = SetUserSession(ctx.App, c, &userRecord)
 	if err != nil {
 		return err
 	}
 
-	return c.Redirect(http.StatusFound, "/")
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventLogin, userRecord.GetID(), map[string]interface{}{
+		"method": "session",
+		"mfa":    true,
+	})
+
+	return c.Redirect(http.StatusFound, getLoginRedirectTo(c))
+}
+
+func (ctl *SessionController) renderLoginMfaPage(c echo.Context, status int, mfaToken string) error {
+	ctx := c.(*bolo.RequestContext)
+
+	mt := c.Get("metatags").(*metatags.HTMLMetaTags)
+
+	ctx.Title = "Login"
+	mt.Title = "Login"
+
+	return bolo.MinifiAndRender(status, "auth/login-mfa", &bolo.TemplateCTX{
+		Ctx:    ctx,
+		Record: &loginMfaPageData{MfaToken: mfaToken, RedirectTo: getLoginRedirectTo(c)},
+	}, ctx)
+}
+
+// getLoginRedirectTo returns the redirectTo query or form param, used to continue flows like the oauth2 authorization
+// after the login. Only local paths are accepted to avoid open redirects
+func getLoginRedirectTo(c echo.Context) string {
+	redirectTo := user_helpers.SafeRedirectPath(c.FormValue("redirectTo"))
+	if redirectTo == "" {
+		return "/"
+	}
+
+	return redirectTo
+}
+
+// renderAccountLocked renders the login page with the account temporarily locked message
+func (ctl *SessionController) renderAccountLocked(c echo.Context) error {
+	AddFlashMessage(c, &FlashMessage{
+		Type:    "error",
+		Message: "Conta temporariamente bloqueada por excesso de tentativas de login. Tente novamente mais tarde ou use o link de desbloqueio enviado para o seu email.",
+	})
+	c.Set("status", http.StatusLocked)
+	return ctl.LoginPage(c)
+}
+
+// renderInvalidCredentials renders the login page with the same error for unknown users and wrong passwords
+func (ctl *SessionController) renderInvalidCredentials(c echo.Context) error {
+	AddFlashMessage(c, &FlashMessage{
+		Type:    "error",
+		Message: security.InvalidCredentialsMessage,
+	})
+	c.Set("status", http.StatusBadRequest)
+	return ctl.LoginPage(c)
+}
+
+func (ctl *SessionController) onLoginFail(throttle security.LoginThrottleInterface, throttleKey string, c echo.Context) {
+	if throttle == nil {
+		return
+	}
+
+	err := throttle.OnLoginFail(throttleKey, c)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": fmt.Sprintf("%+v\n", err),
+		}).Error("SessionController.Login error on register login fail")
+	}
 }
 
 func (ctl *SessionController) Logout(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+	if ctx.IsAuthenticated {
+		user_models.RecordAuthEvent(ctx, user_models.AuthEventLogout, ctx.AuthenticatedUser.GetID(), nil)
+	}
+
 	err := DeleteUserSession(c)
 	if err != nil {
 		AddFlashMessage(c, &FlashMessage{
@@ -142,3 +376,123 @@ func (ctl *SessionController) Logout(c echo.Context) error {
 
 	return c.Redirect(http.StatusTemporaryRedirect, "/")
 }
+
+type UserSessionsResponse struct {
+	Records []*user_models.UserSessionModel `json:"records"`
+}
+
+// ListSessions - list the authenticated user active sessions and oauth2 tokens
+func (ctl *SessionController) ListSessions(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	records, err := user_models.FindUserSessionsByUserID(ctx.AuthenticatedUser.GetID())
+	if err != nil {
+		return fmt.Errorf("SessionController.ListSessions error on find sessions: %w", err)
+	}
+
+	currentID := getCurrentUserSessionID(c)
+	for _, r := range records {
+		r.Current = r.GetID() == currentID
+	}
+
+	return c.JSON(http.StatusOK, &UserSessionsResponse{Records: records})
+}
+
+// RevokeSession - revoke one of the authenticated user sessions
+func (ctl *SessionController) RevokeSession(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	registry := user_models.UserSessionModel{}
+	err := user_models.FindUserSession(c.Param("id"), &registry)
+	if err != nil {
+		return fmt.Errorf("SessionController.RevokeSession error on find session: %w", err)
+	}
+
+	if registry.ID == 0 || strconv.FormatInt(registry.UserID, 10) != ctx.AuthenticatedUser.GetID() {
+		return echo.NotFoundHandler(c)
+	}
+
+	err = registry.Delete()
+	if err != nil {
+		return fmt.Errorf("SessionController.RevokeSession error on delete session: %w", err)
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventSessionRevoke, ctx.AuthenticatedUser.GetID(), map[string]interface{}{
+		"sessionId": registry.GetID(),
+		"type":      registry.Type,
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RevokeAllUserSessions - admin endpoint to revoke all sessions and oauth2 tokens of one user,
+// the personal access tokens are kept
+func (ctl *SessionController) RevokeAllUserSessions(c echo.Context) error {
+	return ctl.revokeAllUserCredentials(c, false)
+}
+
+// RevokeAllUserCredentials - admin endpoint to revoke all sessions, oauth2 tokens and personal access tokens of one user
+func (ctl *SessionController) RevokeAllUserCredentials(c echo.Context) error {
+	return ctl.revokeAllUserCredentials(c, true)
+}
+
+func (ctl *SessionController) revokeAllUserCredentials(c echo.Context, withPersonalAccessTokens bool) error {
+	ctx := c.(*bolo.RequestContext)
+	userID := c.Param("userID")
+
+	if !ctx.Can("manage_users") {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "Forbidden",
+			Internal: errors.New("SessionController.revokeAllUserCredentials forbidden"),
+		}
+	}
+
+	var record user_models.UserModel
+	err := user_models.UserFindOne(userID, &record)
+	if err != nil {
+		return err
+	}
+
+	if record.ID == 0 {
+		return echo.NotFoundHandler(c)
+	}
+
+	if withPersonalAccessTokens {
+		err = user_models.RevokeUserCredentials(record.GetID())
+	} else {
+		err = user_models.DeleteUserSessions(record.GetID(), "")
+	}
+	if err != nil {
+		return fmt.Errorf("SessionController.revokeAllUserCredentials error on delete credentials: %w", err)
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventSessionRevoke, record.GetID(), map[string]interface{}{
+		"all":                  true,
+		"personalAccessTokens": withPersonalAccessTokens,
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// getCurrentUserSessionID returns the user_sessions registry id of the current request
+func getCurrentUserSessionID(c echo.Context) string {
+	sid, _ := c.Get(user_models.CurrentUserSessionIDKey).(string)
+	return sid
+}
@@ -0,0 +1,127 @@
+package user_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionController_Sessions(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+	defer user_models.DeleteUserSessions(u.GetID(), "")
+
+	current, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+	assert.NoError(t, err)
+	other, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+	assert.NoError(t, err)
+
+	request := func(method, url, accessToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set(echo.HeaderAccept, "application/json")
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("list sessions", func(t *testing.T) {
+		rec := request(http.MethodGet, "/api/v2/auth/sessions", current.AccessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var resp user.UserSessionsResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		assert.Len(t, resp.Records, 2)
+
+		for _, r := range resp.Records {
+			assert.Equal(t, r.GetID() == current.SessionID, r.Current)
+		}
+	})
+
+	t.Run("can not revoke sessions of other users", func(t *testing.T) {
+		u2 := user_models.UserModel{
+			Username: gofakeit.UUID(),
+			Email:    gofakeit.Email(),
+			Active:   true,
+		}
+		err := u2.Save(ctx)
+		assert.NoError(t, err)
+		defer u2.Delete()
+
+		token, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u2)
+		assert.NoError(t, err)
+		defer user_models.DeleteUserSessions(u2.GetID(), "")
+
+		rec := request(http.MethodDelete, "/api/v2/auth/sessions/"+other.SessionID, token.AccessToken)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("revoke one session", func(t *testing.T) {
+		rec := request(http.MethodDelete, "/api/v2/auth/sessions/"+other.SessionID, current.AccessToken)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		rec = request(http.MethodGet, "/api/v2/auth/sessions", other.AccessToken)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		rec = request(http.MethodGet, "/api/v2/auth/sessions", current.AccessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("revoke all user sessions requires permission", func(t *testing.T) {
+		rec := request(http.MethodDelete, "/api/v2/auth/user/"+u.GetID()+"/sessions", current.AccessToken)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("change password revokes the other sessions", func(t *testing.T) {
+		another, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v2/auth/change-password", strings.NewReader(`{"newPassword":"a-strong-new-password","rNewPassword":"a-strong-new-password"}`))
+		req.Header.Set(echo.HeaderAccept, "application/json")
+		req.Header.Set(echo.HeaderContentType, "application/json")
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+current.AccessToken)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		rec = request(http.MethodGet, "/api/v2/auth/sessions", another.AccessToken)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		rec = request(http.MethodGet, "/api/v2/auth/sessions", current.AccessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
@@ -0,0 +1,93 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-bolo/bolo"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/labstack/echo/v4"
+)
+
+type SigningKeyController struct {
+	App bolo.App
+}
+
+type SigningKeyListJSONResponse struct {
+	Records []*user_models.SigningKeyModel `json:"signingKey"`
+}
+
+type SigningKeyJSONResponse struct {
+	Record *user_models.SigningKeyModel `json:"signingKey"`
+}
+
+// List - admin endpoint with the active and inactive JWT signing keys, without the private keys
+func (ctl *SigningKeyController) List(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.Can("manage_signing_keys") {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "Forbidden",
+			Internal: errors.New("SigningKeyController.List forbidden"),
+		}
+	}
+
+	records, err := user_models.FindSigningKeys()
+	if err != nil {
+		return fmt.Errorf("SigningKeyController.List error on find keys: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, &SigningKeyListJSONResponse{Records: records})
+}
+
+// Rotate - admin endpoint to create one new active signing key, the old key still verifies tokens until the retirement
+func (ctl *SigningKeyController) Rotate(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.Can("manage_signing_keys") {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "Forbidden",
+			Internal: errors.New("SigningKeyController.Rotate forbidden"),
+		}
+	}
+
+	if !auth_oauth2_password.IsJWTDatabaseKeysEnabled(ctl.App) {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "auth.signing-keys.database-keys-disabled",
+			Internal: errors.New("SigningKeyController.Rotate OAUTH2_JWT_KEYS is not database"),
+		}
+	}
+
+	record, err := auth_oauth2_password.DefaultKeyManager.Rotate(ctl.App)
+	if err != nil {
+		if errors.Is(err, auth_oauth2_password.ErrSigningKeyRotated) {
+			return &bolo.HTTPError{
+				Code:     http.StatusConflict,
+				Message:  "auth.signing-keys.already-rotated",
+				Internal: err,
+			}
+		}
+
+		return fmt.Errorf("SigningKeyController.Rotate error on rotate: %w", err)
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventSigningKeyRotate, "", map[string]interface{}{
+		"kid":       record.Kid,
+		"algorithm": record.Algorithm,
+	})
+
+	return c.JSON(http.StatusCreated, &SigningKeyJSONResponse{Record: record})
+}
+
+type NewSigningKeyControllerCFG struct {
+	App bolo.App
+}
+
+func NewSigningKeyController(cfg *NewSigningKeyControllerCFG) *SigningKeyController {
+	return &SigningKeyController{App: cfg.App}
+}
@@ -0,0 +1,116 @@
+package user_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigningKeyController(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	admin := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	admin.SetRole("administrator")
+	err = admin.Save(ctx)
+	assert.NoError(t, err)
+	defer admin.Delete()
+
+	userToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+	assert.NoError(t, err)
+	adminToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &admin)
+	assert.NoError(t, err)
+
+	request := func(method, url, accessToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("forbidden without the manage_signing_keys permission", func(t *testing.T) {
+		rec := request(http.MethodGet, "/api/v2/auth/signing-keys", userToken.AccessToken)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		rec = request(http.MethodPost, "/api/v2/auth/signing-keys/rotate", userToken.AccessToken)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("rotate requires the database keys", func(t *testing.T) {
+		rec := request(http.MethodPost, "/api/v2/auth/signing-keys/rotate", adminToken.AccessToken)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("rotate and list the keys", func(t *testing.T) {
+		t.Setenv("OAUTH2_JWT_KEYS", "database")
+		t.Setenv("OAUTH2_JWT_ALGORITHM", "EdDSA")
+		t.Setenv("OAUTH2_JWT_KEY_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		auth_oauth2_password.DefaultKeyManager.Reset()
+		defer auth_oauth2_password.DefaultKeyManager.Reset()
+
+		rec := request(http.MethodPost, "/api/v2/auth/signing-keys/rotate", adminToken.AccessToken)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "PRIVATE KEY")
+
+		var rotated user.SigningKeyJSONResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &rotated)
+		assert.NoError(t, err)
+		assert.Equal(t, user_models.SigningKeyStatusActive, rotated.Record.Status)
+
+		rec = request(http.MethodGet, "/api/v2/auth/signing-keys", adminToken.AccessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "PRIVATE KEY")
+
+		var list user.SigningKeyListJSONResponse
+		err = json.Unmarshal(rec.Body.Bytes(), &list)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, list.Records)
+		assert.Equal(t, rotated.Record.Kid, list.Records[0].Kid)
+
+		events, _, err := user_models.QueryAuthEvents(&user_models.AuthEventQueryOpts{
+			ActorID: admin.GetID(),
+			Type:    user_models.AuthEventSigningKeyRotate,
+			Limit:   10,
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, events, 1) {
+			assert.Contains(t, string(events[0].Metadata), rotated.Record.Kid)
+		}
+	})
+}
@@ -0,0 +1,729 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	user_helpers "github.com/go-bolo/user/helpers"
+	user_models "github.com/go-bolo/user/models"
+	user_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/go-bolo/user/security"
+	user_social "github.com/go-bolo/user/social"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+// Max time between the social login redirect and the provider callback
+var SocialAuthStateTTL = 10 * time.Minute
+
+var (
+	ErrSocialProfileWithoutEmail   = errors.New("social profile without email")
+	ErrSocialProfileWithoutSubject = errors.New("social profile without subject")
+	ErrSocialEmailNotVerified      = errors.New("social profile email is not verified and is used by one existing account")
+	ErrSocialAccountNotLinked      = errors.New("social profile email is used by one existing account not linked with the provider")
+	ErrSocialIdentityInUse         = errors.New("social identity is linked to other account")
+	ErrSocialLastLoginMethod       = errors.New("social identity is the last account login method")
+	ErrSocialUserBlocked           = errors.New("user is blocked or inactive")
+)
+
+type SocialAuthController struct {
+	App bolo.App
+}
+
+type UserIdentitiesResponse struct {
+	Records []*user_models.UserIdentityModel `json:"identities"`
+}
+
+type SocialAppCodeBody struct {
+	Code         string `json:"code" form:"code" validate:"required"`
+	RedirectUri  string `json:"redirect_uri" form:"redirect_uri"`
+	CodeVerifier string `json:"code_verifier" form:"code_verifier"`
+	Nonce        string `json:"nonce" form:"nonce"`
+}
+
+// data saved in the session between the login redirect and the provider callback
+type socialAuthState struct {
+	Provider   string    `json:"provider"`
+	State      string    `json:"state"`
+	Nonce      string    `json:"nonce"`
+	Verifier   string    `json:"verifier"`
+	RedirectTo string    `json:"redirectTo"`
+	CreatedAt  time.Time `json:"createdAt"`
+	// set in the link flow, id of the authenticated user that started the flow
+	LinkUserID string `json:"linkUserId,omitempty"`
+}
+
+// Login - start the web login flow, redirects to the provider authorization page
+func (ctl *SocialAuthController) Login(c echo.Context) error {
+	provider, err := ctl.getProvider(c)
+	if err != nil {
+		return err
+	}
+
+	return ctl.redirectToProvider(c, provider, "")
+}
+
+// Link - start the web flow to link the provider account to the authenticated user
+func (ctl *SocialAuthController) Link(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	provider, err := ctl.getProvider(c)
+	if err != nil {
+		return err
+	}
+
+	return ctl.redirectToProvider(c, provider, ctx.AuthenticatedUser.GetID())
+}
+
+func (ctl *SocialAuthController) redirectToProvider(c echo.Context, provider user_social.Provider, linkUserID string) error {
+	ctx := c.(*bolo.RequestContext)
+
+	state := socialAuthState{
+		Provider:   provider.GetName(),
+		State:      randomToken(),
+		Nonce:      randomToken(),
+		Verifier:   oauth2.GenerateVerifier(),
+		RedirectTo: user_helpers.SafeRedirectPath(c.QueryParam("redirectTo")),
+		CreatedAt:  time.Now(),
+		LinkUserID: linkUserID,
+	}
+
+	authURL, err := provider.AuthCodeURL(ctl.getRedirectURL(ctx, provider), state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		return fmt.Errorf("SocialAuthController error on build auth url: %w", err)
+	}
+
+	sess, err := session.Get("session", c)
+	if err != nil {
+		return fmt.Errorf("SocialAuthController error on get session: %w", err)
+	}
+
+	stateJSON, _ := json.Marshal(state)
+	sess.Values["socialAuth"] = string(stateJSON)
+
+	err = sess.Save(c.Request(), c.Response())
+	if err != nil {
+		return fmt.Errorf("SocialAuthController error on save session: %w", err)
+	}
+
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback - finish the web login flow and create the user session
+func (ctl *SocialAuthController) Callback(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	provider, err := ctl.getProvider(c)
+	if err != nil {
+		return err
+	}
+
+	sess, err := session.Get("session", c)
+	if err != nil {
+		return fmt.Errorf("SocialAuthController.Callback error on get session: %w", err)
+	}
+
+	var state socialAuthState
+	if v, ok := sess.Values["socialAuth"].(string); ok {
+		_ = json.Unmarshal([]byte(v), &state)
+	}
+
+	// the state is single use:
+	delete(sess.Values, "socialAuth")
+	err = sess.Save(c.Request(), c.Response())
+	if err != nil {
+		return fmt.Errorf("SocialAuthController.Callback error on save session: %w", err)
+	}
+
+	receivedState := c.QueryParam("state")
+	if state.State == "" ||
+		state.Provider != provider.GetName() ||
+		time.Since(state.CreatedAt) > SocialAuthStateTTL ||
+		subtle.ConstantTimeCompare([]byte(state.State), []byte(receivedState)) != 1 {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "auth.social.invalid-state",
+			Internal: errors.New("SocialAuthController.Callback invalid state"),
+		}
+	}
+
+	if providerErr := c.QueryParam("error"); providerErr != "" {
+		return &bolo.HTTPError{
+			Code:     http.StatusUnauthorized,
+			Message:  "auth.social.denied",
+			Internal: errors.New("SocialAuthController.Callback provider error: " + providerErr),
+		}
+	}
+
+	redirectTo := state.RedirectTo
+	if redirectTo == "" {
+		redirectTo = "/"
+	}
+
+	if state.LinkUserID != "" {
+		// the link flow should finish with the same user that started it:
+		if !ctx.IsAuthenticated || ctx.AuthenticatedUser.GetID() != state.LinkUserID {
+			return &bolo.HTTPError{
+				Code:     http.StatusForbidden,
+				Message:  "user should be authenticated",
+				Internal: errors.New("SocialAuthController.Callback link flow with other user"),
+			}
+		}
+
+		profile, err := ctl.getProfile(c, provider, c.QueryParam("code"), ctl.getRedirectURL(ctx, provider), state.Verifier, state.Nonce)
+		if err != nil {
+			return err
+		}
+
+		record, err := getAuthenticatedUserRecord(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = ctl.link(ctx, record, profile)
+		if err != nil {
+			return err
+		}
+
+		return c.Redirect(http.StatusFound, redirectTo)
+	}
+
+	u, err := ctl.authenticate(c, provider, c.QueryParam("code"), ctl.getRedirectURL(ctx, provider), state.Verifier, state.Nonce)
+	if err != nil {
+		return err
+	}
+
+	_, err = SetUserSession(ctx.App, c, u)
+	if err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, redirectTo)
+}
+
+// AppCodeLogin - mobile app login with one authorization code received from the provider SDK, returns oauth2 tokens
+func (ctl *SocialAuthController) AppCodeLogin(c echo.Context) error {
+	provider, err := ctl.getProvider(c)
+	if err != nil {
+		return err
+	}
+
+	return ctl.appCodeLogin(c, provider)
+}
+
+func (ctl *SocialAuthController) appCodeLogin(c echo.Context, provider user_social.Provider) error {
+	ctx := c.(*bolo.RequestContext)
+
+	ctx.SetResponseContentType("application/json")
+
+	var body SocialAppCodeBody
+	if err := c.Bind(&body); err != nil {
+		if _, ok := err.(*echo.HTTPError); ok {
+			return err
+		}
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	if err := c.Validate(&body); err != nil {
+		return err
+	}
+
+	u, err := ctl.authenticate(c, provider, body.Code, body.getRedirectURL(provider), body.CodeVerifier, body.Nonce)
+	if err != nil {
+		return err
+	}
+
+	data, err := user_oauth2_password.Oauth2GenerateAndSaveToken(ctx, u)
+	if err != nil {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "Invalid token",
+			Internal: fmt.Errorf("error on generate and save token: %w", err),
+		}
+	}
+
+	resp := oauth2PasswordJSONResponse{
+		AccessToken:  &data.AccessToken,
+		RefreshToken: &data.RefreshToken,
+		ExpiresIn:    &data.ExpiresIn,
+		User:         u,
+	}
+
+	return c.JSON(http.StatusOK, &resp)
+}
+
+// ListIdentities - list the provider accounts linked to the authenticated user
+func (ctl *SocialAuthController) ListIdentities(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	records, err := user_models.FindUserIdentitiesByUserID(ctx.AuthenticatedUser.GetID())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &UserIdentitiesResponse{Records: records})
+}
+
+// LinkWithAppCode - link the provider account to the authenticated user with one app authorization code
+func (ctl *SocialAuthController) LinkWithAppCode(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	provider, err := ctl.getProvider(c)
+	if err != nil {
+		return err
+	}
+
+	var body SocialAppCodeBody
+	if err := c.Bind(&body); err != nil {
+		if _, ok := err.(*echo.HTTPError); ok {
+			return err
+		}
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	if err := c.Validate(&body); err != nil {
+		return err
+	}
+
+	profile, err := ctl.getProfile(c, provider, body.Code, body.getRedirectURL(provider), body.CodeVerifier, body.Nonce)
+	if err != nil {
+		return err
+	}
+
+	record, err := getAuthenticatedUserRecord(ctx)
+	if err != nil {
+		return err
+	}
+
+	identity, err := ctl.link(ctx, record, profile)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, identity)
+}
+
+// Unlink - remove the provider accounts from the authenticated user
+func (ctl *SocialAuthController) Unlink(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	userID := ctx.AuthenticatedUser.GetID()
+	providerName := c.Param("provider")
+
+	records, err := user_models.FindUserIdentitiesByUserID(userID)
+	if err != nil {
+		return err
+	}
+
+	toDelete := []*user_models.UserIdentityModel{}
+	for _, r := range records {
+		if r.Provider == providerName {
+			toDelete = append(toDelete, r)
+		}
+	}
+
+	if len(toDelete) == 0 {
+		return &bolo.HTTPError{
+			Code:     http.StatusNotFound,
+			Message:  "auth.social.identity-not-found",
+			Internal: errors.New("SocialAuthController.Unlink identity not found"),
+		}
+	}
+
+	// users without password can only login with the linked providers:
+	if len(toDelete) == len(records) {
+		var passwordRecord user_models.PasswordModel
+		err = user_models.FindPasswordByUserID(userID, &passwordRecord)
+		if err != nil {
+			return err
+		}
+
+		if passwordRecord.ID == 0 {
+			return &bolo.HTTPError{
+				Code:     http.StatusBadRequest,
+				Message:  "auth.social.last-login-method",
+				Internal: ErrSocialLastLoginMethod,
+			}
+		}
+	}
+
+	for _, r := range toDelete {
+		err = r.Delete()
+		if err != nil {
+			return fmt.Errorf("SocialAuthController.Unlink error on delete identity: %w", err)
+		}
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventIdentityUnlink, userID, map[string]interface{}{
+		"provider": providerName,
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (ctl *SocialAuthController) link(ctx *bolo.RequestContext, u *user_models.UserModel, profile *user_social.Profile) (*user_models.UserIdentityModel, error) {
+	identity, err := LinkSocialIdentity(u, profile)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrSocialIdentityInUse):
+			return nil, &bolo.HTTPError{
+				Code:     http.StatusConflict,
+				Message:  "auth.social.identity-in-use",
+				Internal: err,
+			}
+		case errors.Is(err, ErrSocialProfileWithoutSubject):
+			return nil, &bolo.HTTPError{
+				Code:     http.StatusUnauthorized,
+				Message:  "Invalid token",
+				Internal: err,
+			}
+		}
+
+		return nil, fmt.Errorf("SocialAuthController error on link identity: %w", err)
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventIdentityLink, u.GetID(), map[string]interface{}{
+		"provider": identity.Provider,
+	})
+
+	return identity, nil
+}
+
+// authenticate exchanges the code, loads the provider profile and returns the related user
+func (ctl *SocialAuthController) authenticate(c echo.Context, provider user_social.Provider, code, redirectURL, codeVerifier, nonce string) (*user_models.UserModel, error) {
+	ctx := c.(*bolo.RequestContext)
+
+	profile, err := ctl.getProfile(c, provider, code, redirectURL, codeVerifier, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := FindOrCreateUserFromSocialProfile(ctx, profile)
+	if err != nil {
+		user_models.RecordLoginFailure(ctx, "", profile.Email, provider.GetName(), err.Error())
+
+		switch {
+		case errors.Is(err, ErrSocialEmailNotVerified):
+			return nil, &bolo.HTTPError{
+				Code:     http.StatusConflict,
+				Message:  "auth.social.email-not-verified",
+				Internal: err,
+			}
+		case errors.Is(err, ErrSocialAccountNotLinked):
+			return nil, &bolo.HTTPError{
+				Code:     http.StatusConflict,
+				Message:  "auth.social.link-required",
+				Internal: err,
+			}
+		case errors.Is(err, ErrSocialProfileWithoutEmail),
+			errors.Is(err, ErrSocialProfileWithoutSubject),
+			errors.Is(err, ErrSocialUserBlocked):
+			return nil, &bolo.HTTPError{
+				Code:     http.StatusUnauthorized,
+				Message:  "Invalid token",
+				Internal: err,
+			}
+		}
+
+		return nil, fmt.Errorf("SocialAuthController error on find or create user: %w", err)
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventLogin, u.GetID(), map[string]interface{}{
+		"method": provider.GetName(),
+	})
+
+	return u, nil
+}
+
+// getProfile exchanges the code and loads the provider profile
+func (ctl *SocialAuthController) getProfile(c echo.Context, provider user_social.Provider, code, redirectURL, codeVerifier, nonce string) (*user_social.Profile, error) {
+	ctx := c.(*bolo.RequestContext)
+
+	// the account is unknown before the code exchange then only the IP is throttled:
+	throttle := security.GetAppLoginThrottle(ctx.App)
+	err := security.CheckLoginThrottle(throttle, "", c)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := provider.Exchange(context.Background(), redirectURL, code, codeVerifier)
+	if err != nil || token == nil {
+		logrus.WithFields(logrus.Fields{
+			"err":      err,
+			"provider": provider.GetName(),
+		}).Error("SocialAuthController: error on exchange token")
+
+		if throttle != nil {
+			throttleErr := throttle.OnLoginFail("", c)
+			if throttleErr != nil {
+				logrus.WithFields(logrus.Fields{
+					"err": throttleErr,
+				}).Error("SocialAuthController: error on register login fail")
+			}
+		}
+
+		return nil, &bolo.HTTPError{
+			Code:     http.StatusUnauthorized,
+			Message:  "Invalid token",
+			Internal: fmt.Errorf("error on exchange token: %w", err),
+		}
+	}
+
+	profile, err := provider.GetProfile(context.Background(), token, nonce)
+	if err != nil {
+		return nil, &bolo.HTTPError{
+			Code:     http.StatusUnauthorized,
+			Message:  "Invalid token",
+			Internal: fmt.Errorf("error on get user profile from %s: %w", provider.GetName(), err),
+		}
+	}
+
+	return profile, nil
+}
+
+func (ctl *SocialAuthController) getProvider(c echo.Context) (user_social.Provider, error) {
+	ctx := c.(*bolo.RequestContext)
+	authPlugin := ctx.App.GetPlugin("auth").(*AuthPlugin)
+
+	provider := authPlugin.GetSocialProvider(c.Param("provider"))
+	if provider == nil {
+		return nil, &bolo.HTTPError{
+			Code:     http.StatusNotFound,
+			Message:  "auth.social.provider-not-found",
+			Internal: errors.New("social provider not found: " + c.Param("provider")),
+		}
+	}
+
+	return provider, nil
+}
+
+func (ctl *SocialAuthController) getRedirectURL(ctx *bolo.RequestContext, provider user_social.Provider) string {
+	if url := provider.GetRedirectURL(); url != "" {
+		return url
+	}
+
+	return ctx.AppOrigin + "/auth/" + provider.GetName() + "/callback"
+}
+
+// FindOrCreateUserFromSocialProfile returns the user linked to the provider account or creates one new user.
+// Existing accounts are only linked by email if the provider returned one verified email claim and the
+// AUTH_SOCIAL_AUTO_LINK_BY_EMAIL configuration is enabled, it is disabled by default
+func FindOrCreateUserFromSocialProfile(ctx *bolo.RequestContext, profile *user_social.Profile) (*user_models.UserModel, error) {
+	if profile.Subject == "" {
+		return nil, ErrSocialProfileWithoutSubject
+	}
+
+	var identity user_models.UserIdentityModel
+	err := user_models.FindUserIdentity(profile.Provider, profile.Subject, &identity)
+	if err != nil {
+		return nil, err
+	}
+
+	u := user_models.UserModel{}
+
+	if identity.ID != 0 {
+		err = user_models.UserFindOne(identity.GetUserID(), &u)
+		if err != nil {
+			return nil, err
+		}
+
+		if u.ID != 0 {
+			if !u.CanLogin() {
+				return nil, ErrSocialUserBlocked
+			}
+
+			err = updateUserIdentityFromProfile(&identity, profile)
+			if err != nil {
+				return nil, err
+			}
+
+			return &u, nil
+		}
+
+		// the linked user was deleted:
+		err = identity.Delete()
+		if err != nil {
+			return nil, fmt.Errorf("FindOrCreateUserFromSocialProfile error on delete orphan identity: %w", err)
+		}
+	}
+
+	if profile.Email == "" {
+		return nil, ErrSocialProfileWithoutEmail
+	}
+
+	err = user_models.UserFindOneByEmail(profile.Email, &u)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.ID != 0 {
+		if !profile.EmailVerified {
+			return nil, ErrSocialEmailNotVerified
+		}
+
+		if !ctx.App.GetConfiguration().GetBoolF("AUTH_SOCIAL_AUTO_LINK_BY_EMAIL", false) {
+			return nil, ErrSocialAccountNotLinked
+		}
+
+		if !u.CanLogin() {
+			return nil, ErrSocialUserBlocked
+		}
+
+		_, err = LinkSocialIdentity(&u, profile)
+		if err != nil {
+			return nil, err
+		}
+
+		return &u, nil
+	}
+
+	// provider usernames can have chars not allowed here and be used by other users:
+	username := user_helpers.SanitizeUsername(profile.Username)
+	if username == "" {
+		username = user_helpers.SanitizeUsername(profile.Provider + "_" + profile.Subject)
+	}
+
+	username, err = user_models.FindAvailableUsername(username)
+	if err != nil {
+		return nil, fmt.Errorf("FindOrCreateUserFromSocialProfile error on find username: %w", err)
+	}
+
+	name := profile.Name
+	if name == "" {
+		name = strings.TrimSpace(profile.GivenName + " " + profile.FamilyName)
+	}
+
+	u.Username = username
+	u.Email = profile.Email
+	u.EmailVerified = profile.EmailVerified
+	u.DisplayName = name
+	u.FullName = name
+	u.Active = true
+	u.AcceptTerms = false
+
+	err = u.Save(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("FindOrCreateUserFromSocialProfile error on save user: %w", err)
+	}
+
+	_, err = LinkSocialIdentity(&u, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// LinkSocialIdentity links the provider account to the user, returns ErrSocialIdentityInUse if it is linked to other user
+func LinkSocialIdentity(u *user_models.UserModel, profile *user_social.Profile) (*user_models.UserIdentityModel, error) {
+	if profile.Subject == "" {
+		return nil, ErrSocialProfileWithoutSubject
+	}
+
+	var identity user_models.UserIdentityModel
+	err := user_models.FindUserIdentity(profile.Provider, profile.Subject, &identity)
+	if err != nil {
+		return nil, err
+	}
+
+	if identity.ID != 0 && identity.GetUserID() != u.GetID() {
+		return nil, ErrSocialIdentityInUse
+	}
+
+	identity.UserID = int64(u.ID)
+	identity.Provider = profile.Provider
+	identity.ProviderUserID = profile.Subject
+
+	err = updateUserIdentityFromProfile(&identity, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+func updateUserIdentityFromProfile(identity *user_models.UserIdentityModel, profile *user_social.Profile) error {
+	identity.Email = profile.Email
+	identity.EmailVerified = profile.EmailVerified
+
+	err := identity.SetProfile(profile.Raw)
+	if err != nil {
+		return err
+	}
+
+	err = identity.Save()
+	if err != nil {
+		return fmt.Errorf("error on save user identity: %w", err)
+	}
+
+	return nil
+}
+
+func (b *SocialAppCodeBody) getRedirectURL(provider user_social.Provider) string {
+	if b.RedirectUri != "" {
+		return b.RedirectUri
+	}
+
+	return provider.GetRedirectURL()
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+type NewSocialAuthControllerCFG struct {
+	App bolo.App
+}
+
+func NewSocialAuthController(cfg *NewSocialAuthControllerCFG) *SocialAuthController {
+	return &SocialAuthController{App: cfg.App}
+}
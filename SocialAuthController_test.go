@@ -0,0 +1,374 @@
+package user_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	user_social "github.com/go-bolo/user/social"
+	"github.com/go-bolo/user/social/socialtest"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSocialTestApp(t *testing.T) (bolo.App, *socialtest.StubIdP) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+
+	idp := socialtest.NewStubIdP("client-id", "client-secret")
+	t.Cleanup(idp.Close)
+
+	authPlugin := app.GetPlugin("auth").(*user.AuthPlugin)
+	authPlugin.RegisterSocialProvider(user_social.NewOIDCProvider(user_social.OIDCProviderConfig{
+		Name:         "stub",
+		Issuer:       idp.Issuer(),
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+	}))
+
+	return app, idp
+}
+
+func TestSocialAuthController_WebFlow(t *testing.T) {
+	app, idp := newSocialTestApp(t)
+
+	email := strings.ToLower(gofakeit.Email())
+	idp.Claims = map[string]interface{}{
+		"sub":            gofakeit.UUID(),
+		"email":          email,
+		"email_verified": true,
+		"name":           "Stub User",
+	}
+
+	// 1- login redirect:
+	req := httptest.NewRequest(http.MethodGet, "/auth/stub/login?redirectTo=/profile", nil)
+	rec := httptest.NewRecorder()
+	app.GetRouter().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusFound, rec.Code)
+
+	authURL := rec.Header().Get("Location")
+	assert.True(t, strings.HasPrefix(authURL, idp.Issuer()+"/authorize"))
+	assert.Contains(t, authURL, "code_challenge_method=S256")
+	cookies := rec.Result().Cookies()
+
+	// 2- provider authorization page:
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(authURL)
+	assert.NoError(t, err)
+	callbackURL, err := url.Parse(resp.Header.Get("Location"))
+	assert.NoError(t, err)
+
+	t.Run("error with invalid state", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, callbackURL.Path+"?code=abc&state=invalid", nil)
+		req.Header.Set(echo.HeaderAccept, "application/json")
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	// 3- callback:
+	req = httptest.NewRequest(http.MethodGet, callbackURL.RequestURI(), nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	app.GetRouter().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "/profile", rec.Header().Get("Location"))
+
+	u := user_models.UserModel{}
+	err = user_models.UserFindOneByEmail(email, &u)
+	assert.NoError(t, err)
+	assert.NotZero(t, u.ID)
+	defer u.Delete()
+	defer user_models.DeleteUserSessions(u.GetID(), "")
+	defer user_models.DeleteUserIdentities(u.GetID())
+
+	identities, err := user_models.FindUserIdentitiesByUserID(u.GetID())
+	assert.NoError(t, err)
+	assert.Len(t, identities, 1)
+
+	sessions, err := user_models.FindUserSessionsByUserID(u.GetID())
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+}
+
+func TestSocialAuthController_AppCodeLogin(t *testing.T) {
+	app, idp := newSocialTestApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	existing := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    strings.ToLower(gofakeit.Email()),
+		Active:   true,
+	}
+	err := existing.Save(ctx)
+	assert.NoError(t, err)
+	defer existing.Delete()
+	defer user_models.DeleteUserSessions(existing.GetID(), "")
+
+	redirectURI := "com.example.app:/oauth2redirect"
+
+	request := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/stub/app-code", strings.NewReader(body))
+		req.Header.Set(echo.HeaderAccept, "application/json")
+		req.Header.Set(echo.HeaderContentType, "application/json")
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name           string
+		email          string
+		emailVerified  bool
+		autoLink       string
+		code           string
+		expectedStatus int
+	}{
+		{
+			name:           "error with invalid code",
+			emailVerified:  true,
+			code:           "invalid",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "error with unverified email of one existing account",
+			emailVerified:  false,
+			autoLink:       "true",
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "error with auto link by email disabled by default",
+			emailVerified:  true,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "success with verified email",
+			emailVerified:  true,
+			autoLink:       "true",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "success with the linked identity after the provider email change",
+			email:          strings.ToLower(gofakeit.Email()),
+			emailVerified:  false,
+			autoLink:       "false",
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.autoLink != "" {
+				t.Setenv("AUTH_SOCIAL_AUTO_LINK_BY_EMAIL", tt.autoLink)
+			}
+
+			email := tt.email
+			if email == "" {
+				email = existing.Email
+			}
+
+			idp.Claims = map[string]interface{}{
+				"sub":            "app-user",
+				"email":          email,
+				"email_verified": tt.emailVerified,
+			}
+
+			code := tt.code
+			if code == "" {
+				code = idp.IssueCode(redirectURI, "nonce-1", "")
+			}
+
+			rec := request(`{"code":"` + code + `","redirect_uri":"` + redirectURI + `","nonce":"nonce-1"}`)
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			if tt.expectedStatus == http.StatusOK {
+				var resp struct {
+					AccessToken string                `json:"access_token"`
+					User        user_models.UserModel `json:"user"`
+				}
+				err := json.Unmarshal(rec.Body.Bytes(), &resp)
+				assert.NoError(t, err)
+				assert.NotEmpty(t, resp.AccessToken)
+				assert.Equal(t, existing.ID, resp.User.ID)
+
+				var identity user_models.UserIdentityModel
+				err = user_models.FindUserIdentity("stub", "app-user", &identity)
+				assert.NoError(t, err)
+				assert.Equal(t, existing.GetID(), identity.GetUserID())
+				assert.Equal(t, email, identity.Email)
+			}
+		})
+	}
+	defer user_models.DeleteUserIdentities(existing.GetID())
+
+	t.Run("provider not found", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/auth/unknown/app-code", strings.NewReader(`{"code":"a"}`))
+		req.Header.Set(echo.HeaderAccept, "application/json")
+		req.Header.Set(echo.HeaderContentType, "application/json")
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestSocialAuthController_Identities(t *testing.T) {
+	app, idp := newSocialTestApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    strings.ToLower(gofakeit.Email()),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+	defer user_models.DeleteUserSessions(u.GetID(), "")
+	defer user_models.DeleteUserIdentities(u.GetID())
+
+	other := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    strings.ToLower(gofakeit.Email()),
+		Active:   true,
+	}
+	err = other.Save(ctx)
+	assert.NoError(t, err)
+	defer other.Delete()
+	defer user_models.DeleteUserIdentities(other.GetID())
+
+	_, err = user.LinkSocialIdentity(&other, &user_social.Profile{Provider: "stub", Subject: "other-account"})
+	assert.NoError(t, err)
+
+	token, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+	assert.NoError(t, err)
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderAccept, "application/json")
+		req.Header.Set(echo.HeaderContentType, "application/json")
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token.AccessToken)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	redirectURI := "com.example.app:/oauth2redirect"
+	linkBody := func(subject string) string {
+		idp.Claims = map[string]interface{}{
+			"sub":            subject,
+			"email":          "any-" + u.Email,
+			"email_verified": false,
+		}
+
+		return `{"code":"` + idp.IssueCode(redirectURI, "", "") + `","redirect_uri":"` + redirectURI + `"}`
+	}
+
+	t.Run("error linking one account of other user", func(t *testing.T) {
+		rec := request(http.MethodPost, "/api/v2/auth/identities/stub", linkBody("other-account"))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("link the provider account", func(t *testing.T) {
+		rec := request(http.MethodPost, "/api/v2/auth/identities/stub", linkBody("my-account"))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		rec = request(http.MethodGet, "/api/v2/auth/identities", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var resp struct {
+			Identities []user_models.UserIdentityModel `json:"identities"`
+		}
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		assert.Len(t, resp.Identities, 1)
+		assert.Equal(t, "my-account", resp.Identities[0].ProviderUserID)
+	})
+
+	t.Run("error unlinking the last login method", func(t *testing.T) {
+		rec := request(http.MethodDelete, "/api/v2/auth/identities/stub", "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("unlink the provider account of one user with password", func(t *testing.T) {
+		err := u.SetPassword("a-strong-password")
+		assert.NoError(t, err)
+
+		rec := request(http.MethodDelete, "/api/v2/auth/identities/stub", "")
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		records, err := user_models.FindUserIdentitiesByUserID(u.GetID())
+		assert.NoError(t, err)
+		assert.Len(t, records, 0)
+
+		rec = request(http.MethodDelete, "/api/v2/auth/identities/stub", "")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("error without authentication", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v2/auth/identities", nil)
+		req.Header.Set(echo.HeaderAccept, "application/json")
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+}
+
+func TestFindOrCreateUserFromSocialProfile(t *testing.T) {
+	app, _ := newSocialTestApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	existing := user_models.UserModel{
+		Username: "social_" + strings.ReplaceAll(gofakeit.UUID(), "-", "")[:10],
+		Email:    strings.ToLower(gofakeit.Email()),
+		Active:   true,
+	}
+	err := existing.Save(ctx)
+	assert.NoError(t, err)
+	defer existing.Delete()
+
+	t.Run("sanitize the provider username and add one suffix if it is used", func(t *testing.T) {
+		u, err := user.FindOrCreateUserFromSocialProfile(ctx, &user_social.Profile{
+			Provider:      "stub",
+			Subject:       gofakeit.UUID(),
+			Username:      " " + existing.Username + " ",
+			Email:         strings.ToLower(gofakeit.Email()),
+			EmailVerified: true,
+		})
+		assert.NoError(t, err)
+		if assert.NotNil(t, u) {
+			defer user_models.DeleteUserIdentities(u.GetID())
+			defer u.Delete()
+
+			assert.NotEqual(t, existing.Username, u.Username)
+			assert.True(t, strings.HasPrefix(u.Username, existing.Username+"_"), u.Username)
+			assert.Regexp(t, `^[A-Za-z0-9_-]{2,30}$`, u.Username)
+		}
+	})
+}
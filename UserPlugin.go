@@ -1,6 +1,8 @@
 package user
 
 import (
+	"time"
+
 	"github.com/go-bolo/bolo"
 	migrations_user "github.com/go-bolo/user/migrations/user"
 	"github.com/gookit/event"
@@ -9,7 +11,8 @@ import (
 
 type UserPlugin struct {
 	bolo.Pluginer
-	Controller *Controller
+	Controller     *Controller
+	RoleController *RoleController
 
 	Name string
 }
@@ -22,6 +25,7 @@ func (r *UserPlugin) Init(app bolo.App) error {
 	logrus.Debug(r.GetName() + " Init")
 
 	r.Controller = NewController(&ControllerCfg{App: app})
+	r.RoleController = NewRoleController(&NewRoleControllerCFG{App: app})
 
 	app.GetEvents().On("bindRoutes", event.ListenerFunc(func(e event.Event) error {
 		return r.BindRoutes(app)
@@ -31,6 +35,25 @@ func (r *UserPlugin) Init(app bolo.App) error {
 		return r.setTemplateFunctions(app)
 	}), event.Normal)
 
+	// merge the database roles with the static roles:
+	app.GetEvents().On("bootstrap", event.ListenerFunc(func(e event.Event) error {
+		err := r.RoleController.LoadRoles()
+		if err != nil {
+			return err
+		}
+
+		// role changes of other app instances:
+		interval := app.GetConfiguration().GetInt64F("ACL_ROLES_RELOAD_INTERVAL", 60)
+		r.RoleController.StartRolesReload(time.Duration(interval) * time.Second)
+
+		return nil
+	}), event.Normal)
+
+	app.GetEvents().On("close", event.ListenerFunc(func(e event.Event) error {
+		r.RoleController.StopRolesReload()
+		return nil
+	}), event.Normal)
+
 	return nil
 }
 
@@ -44,51 +67,43 @@ func (r *UserPlugin) BindRoutes(app bolo.App) error {
 
 	aclRouter := app.SetRouterGroup("acl", "/acl")
 	aclRouter.GET("/permission", ctl.GetUserRolesAndPermissions)
-	aclRouter.POST("/user/:userID/roles", ctl.UpdateUserRoles)
+	aclRouter.GET("/user/:userID/roles", r.RoleController.GetUserRoles)
+	aclRouter.POST("/user/:userID/roles", ctl.UpdateUserRoles, fullAccessTokenMiddleware)
+	aclRouter.GET("/role", r.RoleController.Find)
+	aclRouter.POST("/role", r.RoleController.Create)
+	aclRouter.GET("/role/:roleName", r.RoleController.FindOne)
+	aclRouter.PUT("/role/:roleName", r.RoleController.Update)
+	aclRouter.DELETE("/role/:roleName", r.RoleController.Delete)
+	aclRouter.POST("/role/:roleName/permissions/:permissionName", r.RoleController.AddPermission)
+	aclRouter.DELETE("/role/:roleName/permissions/:permissionName", r.RoleController.RemovePermission)
 
 	app.SetRouterGroup("user", "/api/user")
 	routerUser := app.GetRouterGroup("user")
 	app.SetResource("user", r.Controller, routerUser)
 
-	// 'get /acl/user/:userId([0-9]+)/roles': {
-	// 	'titleHandler'  : 'i18n',
-	// 	'titleI18n'     : 'admin.user.roles',
-	// 	'controller'    : 'role',
-	// 	'action'        : 'updateUserRoles',
-	// 	'model'         : 'user',
-	// 	'permission'    : 'manage_role',
-	// 	'responseType'  : 'json'
-	// },
-	// 'post /acl/role/:roleName/permissions/:permissionName': {
-	// 	'controller'    : 'role',
-	// 	'action'        : 'addPermissionToRole',
-	// 	'permission'    : 'manage_permissions'
-	// },
-	// 'delete /acl/role/:roleName/permissions/:permissionName': {
-	// 	'controller'    : 'role',
-	// 	'action'        : 'removePermissionFromRole',
-	// 	'permission'    : 'manage_permissions'
-	// },
-	// 'get /acl/role': {
-	// 	'name'          : 'admin.role.find',
-	// 	'controller'    : 'role',
-	// 	'action'        : 'find',
-	// 	'permission'    : 'manage_permissions',
-	// 	'responseType'  : 'json'
-	// },
-	// 'post /acl/role': {
-	// 	'controller'    : 'role',
-	// 	'action'        : 'find',
-	// 	'permission'    : 'manage_permissions',
-	// 	'responseType'  : 'json'
-	// }
-
 	return nil
 }
 
 func (p *UserPlugin) GetMigrations() []*bolo.Migration {
 	return []*bolo.Migration{
 		migrations_user.GetInitMigration(),
+		migrations_user.GetMfaMigration(),
+		migrations_user.GetUserSessionsMigration(),
+		migrations_user.GetSessionsMigration(),
+		migrations_user.GetUserIdentitiesMigration(),
+		migrations_user.GetPasswordHistoriesMigration(),
+		migrations_user.GetUserLockoutMigration(),
+		migrations_user.GetAuthEventsMigration(),
+		migrations_user.GetRolesMigration(),
+		migrations_user.GetUserRolesMigration(),
+		migrations_user.GetAuthTokensEmailMigration(),
+		migrations_user.GetWebAuthnMigration(),
+		migrations_user.GetSigningKeysMigration(),
+		migrations_user.GetPersonalAccessTokensMigration(),
+		migrations_user.GetOAuthClientsMigration(),
+		migrations_user.GetUserEmailVerifiedMigration(),
+		migrations_user.GetSigningKeysActiveSlotMigration(),
+		migrations_user.GetKVStoreMigration(),
 	}
 }
 
@@ -0,0 +1,651 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-bolo/bolo"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/go-bolo/user/security"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/labstack/echo/v4"
+)
+
+var ErrWebAuthnLastLoginMethod = errors.New("webauthn credential is the last account login method")
+
+// WebAuthnBeginResponse - options for navigator.credentials.create() or navigator.credentials.get(),
+// the challenge_id should be sent back in the finish step
+type WebAuthnBeginResponse struct {
+	ChallengeID string      `json:"challenge_id"`
+	PublicKey   interface{} `json:"publicKey"`
+}
+
+type WebAuthnRegisterFinishBody struct {
+	ChallengeID string `json:"challenge_id" validate:"required"`
+	// Credential name displayed in the user credentials list
+	Name string `json:"name"`
+	// PublicKeyCredential returned by navigator.credentials.create()
+	Credential json.RawMessage `json:"credential" validate:"required"`
+}
+
+type WebAuthnLoginFinishBody struct {
+	ChallengeID string `json:"challenge_id" validate:"required"`
+	// PublicKeyCredential returned by navigator.credentials.get()
+	Credential json.RawMessage `json:"credential" validate:"required"`
+	// Creates one cookie session instead of the oauth2 tokens
+	Session bool `json:"session"`
+}
+
+type WebAuthnMfaBeginBody struct {
+	MfaToken string `json:"mfa_token" validate:"required"`
+}
+
+type WebAuthnMfaFinishBody struct {
+	MfaToken string `json:"mfa_token" validate:"required"`
+	WebAuthnLoginFinishBody
+}
+
+// WebAuthnLoginResponse - oauth2 tokens in the same format of the oauth2 password grant,
+// only the user is returned in session logins
+type WebAuthnLoginResponse struct {
+	AccessToken  string                 `json:"access_token,omitempty"`
+	RefreshToken string                 `json:"refresh_token,omitempty"`
+	ExpiresIn    int64                  `json:"expires_in,omitempty"`
+	User         *user_models.UserModel `json:"user"`
+}
+
+type WebAuthnCredentialsResponse struct {
+	Records []*user_models.WebAuthnCredentialModel `json:"credentials"`
+}
+
+type NewWebAuthnControllerCFG struct {
+	App bolo.App
+}
+
+func NewWebAuthnController(cfg *NewWebAuthnControllerCFG) *WebAuthnController {
+	return &WebAuthnController{App: cfg.App}
+}
+
+// WebAuthnController - FIDO2/WebAuthn credentials (passkeys and security keys) registration,
+// passwordless login and second factor in the login MFA challenge
+type WebAuthnController struct {
+	App bolo.App
+}
+
+// ListCredentials - list the authenticated user credentials
+func (ctl *WebAuthnController) ListCredentials(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	records, err := user_models.FindWebAuthnCredentialsByUserID(ctx.AuthenticatedUser.GetID())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &WebAuthnCredentialsResponse{Records: records})
+}
+
+// DeleteCredential - remove one authenticated user credential. The last login method of users
+// without password and social identities can not be removed
+func (ctl *WebAuthnController) DeleteCredential(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	userID := ctx.AuthenticatedUser.GetID()
+
+	var record user_models.WebAuthnCredentialModel
+	err := user_models.FindUserWebAuthnCredential(userID, c.Param("id"), &record)
+	if err != nil {
+		return err
+	}
+
+	if record.ID == 0 {
+		return &bolo.HTTPError{
+			Code:     http.StatusNotFound,
+			Message:  "auth.webauthn.credential-not-found",
+			Internal: errors.New("WebAuthnController.DeleteCredential credential not found"),
+		}
+	}
+
+	canDelete, err := ctl.hasOtherLoginMethod(userID)
+	if err != nil {
+		return err
+	}
+
+	if !canDelete {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "auth.webauthn.last-login-method",
+			Internal: ErrWebAuthnLastLoginMethod,
+		}
+	}
+
+	err = record.Delete()
+	if err != nil {
+		return fmt.Errorf("WebAuthnController.DeleteCredential error on delete credential: %w", err)
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventWebAuthnRemoved, userID, map[string]interface{}{
+		"credentialId": record.ID,
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RegisterBegin - start the registration of one credential for the authenticated user
+func (ctl *WebAuthnController) RegisterBegin(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	w, err := user_models.NewWebAuthn(ctx)
+	if err != nil {
+		return err
+	}
+
+	record, err := getAuthenticatedUserRecord(ctx)
+	if err != nil {
+		return err
+	}
+
+	user, err := user_models.NewWebAuthnUser(record)
+	if err != nil {
+		return err
+	}
+
+	exclusions := []protocol.CredentialDescriptor{}
+	for _, credential := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	creation, session, err := w.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return fmt.Errorf("WebAuthnController.RegisterBegin error on begin registration: %w", err)
+	}
+
+	challenge, err := user_models.CreateWebAuthnChallenge(user_models.WebAuthnCeremonyRegistration, user.User.ID, "", session)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &WebAuthnBeginResponse{
+		ChallengeID: challenge.Token,
+		PublicKey:   creation.Response,
+	})
+}
+
+// RegisterFinish - verify the authenticator attestation and save the new credential
+func (ctl *WebAuthnController) RegisterFinish(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if !ctx.IsAuthenticated {
+		return &bolo.HTTPError{
+			Code:     http.StatusForbidden,
+			Message:  "user should be authenticated",
+			Internal: errors.New("user should be authenticated"),
+		}
+	}
+
+	var body WebAuthnRegisterFinishBody
+	err := bindWebAuthnBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	u, err := getAuthenticatedUserRecord(ctx)
+	if err != nil {
+		return err
+	}
+
+	challenge, err := user_models.ConsumeWebAuthnChallenge(body.ChallengeID, user_models.WebAuthnCeremonyRegistration)
+	if err != nil {
+		return err
+	}
+
+	if challenge == nil || challenge.UserID != u.ID {
+		return invalidWebAuthnChallengeError()
+	}
+
+	session, err := challenge.GetSessionData()
+	if err != nil {
+		return err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(body.Credential))
+	if err != nil {
+		return invalidWebAuthnCredentialError(err)
+	}
+
+	w, err := user_models.NewWebAuthn(ctx)
+	if err != nil {
+		return err
+	}
+
+	user, err := user_models.NewWebAuthnUser(u)
+	if err != nil {
+		return err
+	}
+
+	credential, err := w.CreateCredential(user, *session, parsed)
+	if err != nil {
+		return invalidWebAuthnCredentialError(err)
+	}
+
+	var existing user_models.WebAuthnCredentialModel
+	err = user_models.FindWebAuthnCredentialByCredentialID(credential.ID, &existing)
+	if err != nil {
+		return err
+	}
+
+	if existing.ID != 0 {
+		return &bolo.HTTPError{
+			Code:     http.StatusConflict,
+			Message:  "auth.webauthn.credential-already-registered",
+			Internal: errors.New("WebAuthnController.RegisterFinish credential already registered"),
+		}
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+
+	record := user_models.NewWebAuthnCredentialModel(u.ID, name, credential)
+	err = record.Save()
+	if err != nil {
+		return fmt.Errorf("WebAuthnController.RegisterFinish error on save credential: %w", err)
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventWebAuthnAdded, u.GetID(), map[string]interface{}{
+		"credentialId": record.ID,
+	})
+
+	return c.JSON(http.StatusCreated, record)
+}
+
+// LoginBegin - start one passwordless login with discoverable credentials (passkeys). The options are the same for
+// all users, then the response doesn't show registered emails. The user verification (PIN or biometric) is required,
+// then passkey logins always have two factors
+func (ctl *WebAuthnController) LoginBegin(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	w, err := user_models.NewWebAuthn(ctx)
+	if err != nil {
+		return err
+	}
+
+	assertion, session, err := w.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return fmt.Errorf("WebAuthnController.LoginBegin error on begin login: %w", err)
+	}
+
+	challenge, err := user_models.CreateWebAuthnChallenge(user_models.WebAuthnCeremonyLogin, 0, "", session)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &WebAuthnBeginResponse{
+		ChallengeID: challenge.Token,
+		PublicKey:   assertion.Response,
+	})
+}
+
+// LoginFinish - verify the assertion and return the oauth2 tokens or create one session like the password login
+func (ctl *WebAuthnController) LoginFinish(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	var body WebAuthnLoginFinishBody
+	err := bindWebAuthnBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	challenge, err := user_models.ConsumeWebAuthnChallenge(body.ChallengeID, user_models.WebAuthnCeremonyLogin)
+	if err != nil {
+		return err
+	}
+
+	if challenge == nil {
+		return invalidWebAuthnChallengeError()
+	}
+
+	u, _, err := ctl.validateAssertion(ctx, challenge, body.Credential)
+	if err != nil {
+		return err
+	}
+
+	if u.IsLocked() {
+		user_models.RecordLoginFailure(ctx, u.GetID(), u.Email, "webauthn", "account_locked")
+
+		return &bolo.HTTPError{
+			Code:     http.StatusLocked,
+			Message:  "account_locked",
+			Internal: errors.New("WebAuthnController.LoginFinish account locked user id=" + u.GetID()),
+		}
+	}
+
+	user_models.OnUserLoginSuccess(ctx, u)
+
+	// the user verification is required in the login ceremony, then the assertion already has two factors,
+	// the authenticator and the PIN or biometric:
+	return ctl.completeLogin(c, u, body.Session, true)
+}
+
+// MfaBegin - start one assertion to complete the login MFA challenge of password, magic link or passkey logins
+func (ctl *WebAuthnController) MfaBegin(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	var body WebAuthnMfaBeginBody
+	err := bindWebAuthnBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	mfaChallenge, err := user_models.FindMfaChallenge(body.MfaToken)
+	if err != nil {
+		return err
+	}
+
+	if mfaChallenge == nil {
+		return invalidWebAuthnChallengeError()
+	}
+
+	var u user_models.UserModel
+	err = user_models.UserFindOne(*mfaChallenge.UserID, &u)
+	if err != nil {
+		return err
+	}
+
+	user, err := user_models.NewWebAuthnUser(&u)
+	if err != nil {
+		return err
+	}
+
+	if u.ID == 0 || len(user.Credentials) == 0 {
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "auth.webauthn.no-credentials",
+			Internal: errors.New("WebAuthnController.MfaBegin user without credentials"),
+		}
+	}
+
+	w, err := user_models.NewWebAuthn(ctx)
+	if err != nil {
+		return err
+	}
+
+	assertion, session, err := w.BeginLogin(user)
+	if err != nil {
+		return fmt.Errorf("WebAuthnController.MfaBegin error on begin login: %w", err)
+	}
+
+	challenge, err := user_models.CreateWebAuthnChallenge(user_models.WebAuthnCeremonyMfa, u.ID, body.MfaToken, session)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &WebAuthnBeginResponse{
+		ChallengeID: challenge.Token,
+		PublicKey:   assertion.Response,
+	})
+}
+
+// MfaFinish - verify the assertion as the second factor and complete the login
+func (ctl *WebAuthnController) MfaFinish(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	var body WebAuthnMfaFinishBody
+	err := bindWebAuthnBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	mfaChallenge, err := user_models.FindMfaChallenge(body.MfaToken)
+	if err != nil {
+		return err
+	}
+
+	challenge, err := user_models.ConsumeWebAuthnChallenge(body.ChallengeID, user_models.WebAuthnCeremonyMfa)
+	if err != nil {
+		return err
+	}
+
+	if mfaChallenge == nil || challenge == nil || challenge.MfaToken != mfaChallenge.Token {
+		return invalidWebAuthnChallengeError()
+	}
+
+	authPlugin := ctx.App.GetPlugin("auth").(*AuthPlugin)
+	throttle := authPlugin.GetLoginThrottle()
+	throttleKey := "mfa_" + *mfaChallenge.UserID
+
+	err = security.CheckLoginThrottle(throttle, throttleKey, c)
+	if err != nil {
+		return err
+	}
+
+	u, _, err := ctl.validateAssertion(ctx, challenge, body.Credential)
+	if err != nil {
+		authPlugin.SessionController.onLoginFail(throttle, throttleKey, c)
+		return err
+	}
+
+	if u.GetID() != *mfaChallenge.UserID {
+		return invalidWebAuthnChallengeError()
+	}
+
+	err = mfaChallenge.Delete()
+	if err != nil {
+		return fmt.Errorf("WebAuthnController.MfaFinish error on delete mfa challenge: %w", err)
+	}
+
+	user_models.OnUserLoginSuccess(ctx, u, throttleKey)
+
+	return ctl.completeLogin(c, u, body.Session, true)
+}
+
+// validateAssertion checks the assertion signature with the credential public key and saves the new counter.
+// The user is loaded from the credential, then it works for both discoverable and email logins
+func (ctl *WebAuthnController) validateAssertion(ctx *bolo.RequestContext, challenge *user_models.WebAuthnChallengeModel, rawCredential json.RawMessage) (*user_models.UserModel, *webauthn.Credential, error) {
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(rawCredential))
+	if err != nil {
+		return nil, nil, invalidWebAuthnCredentialError(err)
+	}
+
+	session, err := challenge.GetSessionData()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var record user_models.WebAuthnCredentialModel
+	err = user_models.FindWebAuthnCredentialByCredentialID(parsed.RawID, &record)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var u user_models.UserModel
+	if record.ID != 0 {
+		err = user_models.UserFindOne(strconv.FormatUint(record.UserID, 10), &u)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if u.ID == 0 || (challenge.UserID != 0 && challenge.UserID != u.ID) {
+		user_models.RecordLoginFailure(ctx, "", "", "webauthn", "unknown_credential")
+		return nil, nil, invalidWebAuthnCredentialError(errors.New("credential not found"))
+	}
+
+	user, err := user_models.NewWebAuthnUser(&u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w, err := user_models.NewWebAuthn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var credential *webauthn.Credential
+	if len(session.UserID) > 0 {
+		credential, err = w.ValidateLogin(user, *session, parsed)
+	} else {
+		credential, err = w.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			return user, nil
+		}, *session, parsed)
+	}
+
+	if err == nil && credential.Authenticator.CloneWarning {
+		err = errors.New("signature counter is not greater than the saved counter, the authenticator may be cloned")
+	}
+
+	if err != nil {
+		user_models.RecordLoginFailure(ctx, u.GetID(), "", "webauthn", "invalid_assertion")
+		return nil, nil, invalidWebAuthnCredentialError(err)
+	}
+
+	err = user.FindCredential(credential.ID).OnAssertion(credential)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !u.CanLogin() {
+		user_models.RecordLoginFailure(ctx, u.GetID(), "", "webauthn", "inactive_user")
+		return nil, nil, invalidWebAuthnCredentialError(errors.New("inactive or blocked user"))
+	}
+
+	return &u, credential, nil
+}
+
+// completeLogin creates one session or returns the oauth2 tokens
+func (ctl *WebAuthnController) completeLogin(c echo.Context, u *user_models.UserModel, useSession, mfa bool) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if useSession {
+		_, err := SetUserSession(ctx.App, c, u)
+		if err != nil {
+			return err
+		}
+
+		user_models.RecordAuthEvent(ctx, user_models.AuthEventLogin, u.GetID(), map[string]interface{}{
+			"method": "webauthn",
+			"client": "session",
+			"mfa":    mfa,
+		})
+
+		return c.JSON(http.StatusOK, &WebAuthnLoginResponse{User: u})
+	}
+
+	data, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, u)
+	if err != nil {
+		return err
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventLogin, u.GetID(), map[string]interface{}{
+		"method": "webauthn",
+		"client": "oauth2",
+		"mfa":    mfa,
+	})
+
+	return c.JSON(http.StatusOK, &WebAuthnLoginResponse{
+		AccessToken:  data.AccessToken,
+		RefreshToken: data.RefreshToken,
+		ExpiresIn:    data.ExpiresIn,
+		User:         u,
+	})
+}
+
+// hasOtherLoginMethod checks if the user can login after remove one credential
+func (ctl *WebAuthnController) hasOtherLoginMethod(userID string) (bool, error) {
+	credentials, err := user_models.FindWebAuthnCredentialsByUserID(userID)
+	if err != nil {
+		return false, err
+	}
+
+	if len(credentials) > 1 {
+		return true, nil
+	}
+
+	var passwordRecord user_models.PasswordModel
+	err = user_models.FindPasswordByUserID(userID, &passwordRecord)
+	if err != nil {
+		return false, err
+	}
+
+	if passwordRecord.ID != 0 {
+		return true, nil
+	}
+
+	identities, err := user_models.FindUserIdentitiesByUserID(userID)
+	if err != nil {
+		return false, err
+	}
+
+	return len(identities) > 0, nil
+}
+
+func bindWebAuthnBody(c echo.Context, body interface{}) error {
+	if err := c.Bind(body); err != nil {
+		if e, ok := err.(*echo.HTTPError); ok {
+			return e
+		}
+
+		return &bolo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "Invalid data sent",
+			Internal: fmt.Errorf("invalid data sent on WebAuthnController: %w", err),
+		}
+	}
+
+	return c.Validate(body)
+}
+
+func invalidWebAuthnChallengeError() error {
+	return &bolo.HTTPError{
+		Code:     http.StatusBadRequest,
+		Message:  "auth.webauthn.invalid-challenge",
+		Internal: errors.New("invalid or expired webauthn challenge"),
+	}
+}
+
+func invalidWebAuthnCredentialError(err error) error {
+	var pe *protocol.Error
+	if errors.As(err, &pe) {
+		err = fmt.Errorf("%s: %s", pe.Type, pe.DevInfo)
+	}
+
+	return &bolo.HTTPError{
+		Code:     http.StatusBadRequest,
+		Message:  "auth.webauthn.invalid-credential",
+		Internal: fmt.Errorf("invalid webauthn credential: %w", err),
+	}
+}
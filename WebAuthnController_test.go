@@ -0,0 +1,384 @@
+package user_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+const webAuthnTestOrigin = "http://localhost:8080"
+
+// softAuthenticator - software FIDO2 authenticator with one ES256 credential and "none" attestation
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	// sets the user verified flag, like one authenticator with PIN or biometric
+	userVerified bool
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	id := make([]byte, 32)
+	_, err = rand.Read(id)
+	assert.NoError(t, err)
+
+	return &softAuthenticator{key: key, credentialID: id, userVerified: true}
+}
+
+func (a *softAuthenticator) authData(rpID string, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+
+	flags := byte(0x01) // user present
+	if a.userVerified {
+		flags |= 0x04
+	}
+	if attested {
+		flags |= 0x40
+	}
+
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	if attested {
+		publicKey, _ := webauthncbor.Marshal(&webauthncose.EC2PublicKeyData{
+			PublicKeyData: webauthncose.PublicKeyData{
+				KeyType:   int64(webauthncose.EllipticKey),
+				Algorithm: int64(webauthncose.AlgES256),
+			},
+			Curve:  1, // P-256
+			XCoord: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+			YCoord: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+		})
+
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, publicKey...)
+	}
+
+	return data
+}
+
+func (a *softAuthenticator) clientData(ceremony, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    webAuthnTestOrigin,
+	})
+	return data
+}
+
+// create returns the PublicKeyCredential of navigator.credentials.create()
+func (a *softAuthenticator) create(t *testing.T, options map[string]interface{}) json.RawMessage {
+	rp := options["rp"].(map[string]interface{})
+	u := options["user"].(map[string]interface{})
+
+	userHandle, err := base64.RawURLEncoding.DecodeString(u["id"].(string))
+	assert.NoError(t, err)
+	a.userHandle = userHandle
+
+	attestationObject, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(rp["id"].(string), true),
+	})
+	assert.NoError(t, err)
+
+	return a.credentialJSON(map[string]string{
+		"clientDataJSON":    b64(a.clientData("webauthn.create", options["challenge"].(string))),
+		"attestationObject": b64(attestationObject),
+	})
+}
+
+// get returns the PublicKeyCredential of navigator.credentials.get()
+func (a *softAuthenticator) get(t *testing.T, options map[string]interface{}) json.RawMessage {
+	a.signCount++
+
+	authData := a.authData(options["rpId"].(string), false)
+	clientData := a.clientData("webauthn.get", options["challenge"].(string))
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	assert.NoError(t, err)
+
+	return a.credentialJSON(map[string]string{
+		"clientDataJSON":    b64(clientData),
+		"authenticatorData": b64(authData),
+		"signature":         b64(signature),
+		"userHandle":        b64(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) credentialJSON(response map[string]string) json.RawMessage {
+	data, _ := json.Marshal(map[string]interface{}{
+		"id":       b64(a.credentialID),
+		"rawId":    b64(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	return data
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestWebAuthnController(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	t.Setenv("APP_ORIGIN", webAuthnTestOrigin)
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    strings.ToLower(gofakeit.Email()),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	authToken, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+	assert.NoError(t, err)
+
+	request := func(method, url string, body interface{}, accessToken string) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+
+		req := httptest.NewRequest(method, url, strings.NewReader(string(data)))
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if accessToken != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+		}
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	begin := func(url string, body interface{}, accessToken string) (string, map[string]interface{}) {
+		rec := request(http.MethodPost, url, body, accessToken)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var resp struct {
+			ChallengeID string                 `json:"challenge_id"`
+			PublicKey   map[string]interface{} `json:"publicKey"`
+		}
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		return resp.ChallengeID, resp.PublicKey
+	}
+
+	register := func(a *softAuthenticator, name string) *httptest.ResponseRecorder {
+		challengeID, options := begin("/api/v2/auth/webauthn/register/begin", map[string]string{}, authToken.AccessToken)
+
+		return request(http.MethodPost, "/api/v2/auth/webauthn/register/finish", map[string]interface{}{
+			"challenge_id": challengeID,
+			"name":         name,
+			"credential":   a.create(t, options),
+		}, authToken.AccessToken)
+	}
+
+	login := func(a *softAuthenticator, session bool) *httptest.ResponseRecorder {
+		challengeID, options := begin("/api/v2/auth/webauthn/login/begin", map[string]string{}, "")
+
+		return request(http.MethodPost, "/api/v2/auth/webauthn/login/finish", map[string]interface{}{
+			"challenge_id": challengeID,
+			"credential":   a.get(t, options),
+			"session":      session,
+		}, "")
+	}
+
+	authenticator := newSoftAuthenticator(t)
+
+	t.Run("registration requires authentication", func(t *testing.T) {
+		rec := request(http.MethodPost, "/api/v2/auth/webauthn/register/begin", map[string]string{}, "")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	var credential user_models.WebAuthnCredentialModel
+	t.Run("register one credential", func(t *testing.T) {
+		rec := register(authenticator, "Laptop")
+		assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+		err := json.Unmarshal(rec.Body.Bytes(), &credential)
+		assert.NoError(t, err)
+		assert.Equal(t, "Laptop", credential.Name)
+		assert.Equal(t, u.ID, credential.UserID)
+
+		rec = request(http.MethodGet, "/api/v2/auth/webauthn/credentials", nil, authToken.AccessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var list user.WebAuthnCredentialsResponse
+		err = json.Unmarshal(rec.Body.Bytes(), &list)
+		assert.NoError(t, err)
+		assert.Len(t, list.Records, 1)
+	})
+
+	t.Run("same credential can not be registered twice", func(t *testing.T) {
+		rec := register(authenticator, "Laptop")
+		assert.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+	})
+
+	t.Run("discoverable login returns oauth2 tokens", func(t *testing.T) {
+		rec := login(authenticator, false)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var resp user.WebAuthnLoginResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, resp.AccessToken)
+		assert.NotEmpty(t, resp.RefreshToken)
+		assert.Equal(t, u.ID, resp.User.ID)
+
+		var record user_models.WebAuthnCredentialModel
+		err = user_models.FindUserWebAuthnCredential(u.GetID(), credential.GetID(), &record)
+		assert.NoError(t, err)
+		assert.Equal(t, authenticator.signCount, record.SignCount)
+		assert.NotNil(t, record.LastUsedAt)
+	})
+
+	t.Run("session login", func(t *testing.T) {
+		rec := login(authenticator, true)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.NotEmpty(t, rec.Header().Get(echo.HeaderSetCookie))
+
+		var resp user.WebAuthnLoginResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		assert.Empty(t, resp.AccessToken)
+		assert.Equal(t, u.ID, resp.User.ID)
+	})
+
+	t.Run("logins without user verification are rejected", func(t *testing.T) {
+		_, options := begin("/api/v2/auth/webauthn/login/begin", map[string]string{}, "")
+		assert.Equal(t, "required", options["userVerification"])
+		assert.Nil(t, options["allowCredentials"])
+
+		authenticator.userVerified = false
+		defer func() { authenticator.userVerified = true }()
+
+		rec := login(authenticator, false)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("challenges are single use", func(t *testing.T) {
+		challengeID, options := begin("/api/v2/auth/webauthn/login/begin", map[string]string{}, "")
+		body := map[string]interface{}{
+			"challenge_id": challengeID,
+			"credential":   authenticator.get(t, options),
+		}
+
+		rec := request(http.MethodPost, "/api/v2/auth/webauthn/login/finish", body, "")
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		rec = request(http.MethodPost, "/api/v2/auth/webauthn/login/finish", body, "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("invalid signatures and cloned authenticators are rejected", func(t *testing.T) {
+		other := newSoftAuthenticator(t)
+		other.credentialID = authenticator.credentialID
+		other.userHandle = authenticator.userHandle
+		other.signCount = authenticator.signCount
+
+		rec := login(other, false)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		cloned := *authenticator
+		cloned.signCount = 0
+
+		rec = login(&cloned, false)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	phone := newSoftAuthenticator(t)
+	t.Run("the last login method can not be removed", func(t *testing.T) {
+		rec := request(http.MethodDelete, "/api/v2/auth/webauthn/credentials/"+credential.GetID(), nil, authToken.AccessToken)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = register(phone, "Phone")
+		assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+		rec = request(http.MethodDelete, "/api/v2/auth/webauthn/credentials/"+credential.GetID(), nil, authToken.AccessToken)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		rec = login(authenticator, false)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("passkeys complete the password login MFA challenge", func(t *testing.T) {
+		err := u.SetPassword("123456")
+		assert.NoError(t, err)
+
+		// users with credentials and without TOTP also have two-factor authentication:
+		rec := request(http.MethodPost, "/auth/oauth2/token", map[string]string{
+			"grant_type": "password",
+			"email":      u.Email,
+			"password":   "123456",
+		}, "")
+		assert.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+
+		var mfaResp struct {
+			Error    string `json:"error"`
+			MfaToken string `json:"mfa_token"`
+		}
+		err = json.Unmarshal(rec.Body.Bytes(), &mfaResp)
+		assert.NoError(t, err)
+		assert.Equal(t, "mfa_required", mfaResp.Error)
+		assert.NotEmpty(t, mfaResp.MfaToken)
+
+		challengeID, options := begin("/api/v2/auth/webauthn/mfa/begin", map[string]string{"mfa_token": mfaResp.MfaToken}, "")
+
+		rec = request(http.MethodPost, "/api/v2/auth/webauthn/mfa/finish", map[string]interface{}{
+			"mfa_token":    mfaResp.MfaToken,
+			"challenge_id": challengeID,
+			"credential":   phone.get(t, options),
+		}, "")
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var resp user.WebAuthnLoginResponse
+		err = json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, resp.AccessToken)
+
+		challenge, err := user_models.FindMfaChallenge(mfaResp.MfaToken)
+		assert.NoError(t, err)
+		assert.Nil(t, challenge)
+	})
+}
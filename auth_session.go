@@ -10,6 +10,7 @@ import (
 	"github.com/go-bolo/bolo"
 	user_helpers "github.com/go-bolo/user/helpers"
 	user_models "github.com/go-bolo/user/models"
+	"github.com/gorilla/sessions"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
@@ -85,6 +86,18 @@ func sessionAuthenticationHandler(c echo.Context) error {
 	}
 
 	if savedUser.ID != 0 {
+		sid, err := validateSessionRegistry(c, sess, &savedUser)
+		if err != nil {
+			return err
+		}
+
+		if sid == "" {
+			// the session was revoked or expired:
+			return DeleteUserSession(c)
+		}
+
+		c.Set(user_models.CurrentUserSessionIDKey, sid)
+
 		logrus.WithFields(logrus.Fields{
 			"userId": savedUser.ID,
 		}).Debug("sessionAuthenticationHandler user authenticated")
@@ -112,3 +125,55 @@ func sessionAuthenticationHandler(c echo.Context) error {
 	}
 	return nil
 }
+
+// validateSessionRegistry checks the session in the user sessions registry and returns the registry id,
+// an empty id means that the session was revoked
+func validateSessionRegistry(c echo.Context, sess *sessions.Session, user *user_models.UserModel) (string, error) {
+	ctx := c.(*bolo.RequestContext)
+
+	maxAge := user_helpers.GetSessionOptions(ctx.App).MaxAge
+	expiresAt := time.Now().Add(time.Duration(maxAge) * time.Second)
+
+	sid := getSessionRegistryID(sess)
+	if sid == "" {
+		// sessions created before the registry are registered in the first request:
+		registry, err := user_models.CreateUserSession(
+			user.GetID(),
+			user_models.UserSessionTypeSession,
+			c.RealIP(),
+			c.Request().UserAgent(),
+			expiresAt,
+		)
+		if err != nil {
+			return "", fmt.Errorf("validateSessionRegistry error on register session: %w", err)
+		}
+
+		sess.Values["sid"] = registry.GetID()
+		err = sess.Save(c.Request(), c.Response())
+		if err != nil {
+			return "", fmt.Errorf("validateSessionRegistry error on save session: %w", err)
+		}
+
+		return registry.GetID(), nil
+	}
+
+	registry := user_models.UserSessionModel{}
+	err := user_models.FindUserSession(sid, &registry)
+	if err != nil {
+		return "", fmt.Errorf("validateSessionRegistry error on find session registry: %w", err)
+	}
+
+	if !registry.IsValidFor(user.GetID()) {
+		return "", nil
+	}
+
+	err = registry.Touch(c.RealIP(), c.Request().UserAgent(), expiresAt)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"sid":   sid,
+		}).Error("validateSessionRegistry error on update last seen")
+	}
+
+	return sid, nil
+}
@@ -151,5 +151,199 @@ Atenciosamente,
 				},
 			},
 		})
+
+		emailPlugin.AddEmailTemplate("AuthAccountLockedEmail", &emails.EmailType{
+			Label:          "Email de aviso de bloqueio de conta por tentativas de login",
+			DefaultSubject: `Conta bloqueada temporariamente no site {{siteName}}`,
+			DefaultHTML: `<p>Oi {{displayName}},</p>
+<p>A sua conta no site {{siteName}} foi bloqueada temporariamente ap&oacute;s v&aacute;rias tentativas de login com a senha incorreta. A &uacute;ltima tentativa foi feita do IP {{ip}}.</p>
+<p><a href="{{unlockUrl}}">Clique aqui</a> ou copie e cole o link abaixo para desbloquear a sua conta:</p>
+<p>Link para desbloquear a conta: {{unlockUrl}}</p>
+<p>Se n&atilde;o foi voc&ecirc;, recomendamos trocar a sua senha.</p>
+<p><br />Atenciosamente,<br />{{siteName}}<br />{{siteUrl}}</p>`,
+			DefaultText: `Oi {{displayName}},
+
+A sua conta no site {{siteName}} foi bloqueada temporariamente após várias tentativas de login com a senha incorreta. A última tentativa foi feita do IP {{ip}}.
+
+Link para desbloquear a conta: {{unlockUrl}}
+
+Se não foi você, recomendamos trocar a sua senha.
+
+
+Atenciosamente,
+{{siteName}}
+{{siteUrl}}`,
+			TemplateVariables: map[string]*emails.TemplateVariable{
+				"unlockUrl": {
+					Example:     "http://linkysystems.com/example",
+					Description: "URL para desbloquear a conta do usuário",
+				},
+				"ip": {
+					Example:     "127.0.0.1",
+					Description: "IP da última tentativa de login",
+				},
+				"username": {
+					Example:     "alberto",
+					Description: "Nome único do usuário",
+				},
+				"displayName": {
+					Example:     "Alberto",
+					Description: "Nome de exibição do usuário",
+				},
+				"siteName": {
+					Example:     "Site Name",
+					Description: "Nome desse site",
+				},
+				"siteUrl": {
+					Example:     "/#example",
+					Description: "URL desse site",
+				},
+			},
+		})
+
+		emailPlugin.AddEmailTemplate("AuthEmailChangeConfirmationEmail", &emails.EmailType{
+			Label:          "Email de confirmação do novo endereço de email do usuário",
+			DefaultSubject: `Confirme o seu novo email no site {{siteName}}`,
+			DefaultHTML: `<p>Oi {{displayName}},</p>
+<p>Recebemos um pedido para trocar o email da sua conta no site {{siteName}} para {{newEmail}}.</p>
+<p><a href="{{confirmUrl}}">Clique aqui</a> ou copie e cole o link abaixo para confirmar o novo email:</p>
+<p>Link de confirma&ccedil;&atilde;o: {{confirmUrl}}</p>
+<p>Se n&atilde;o foi voc&ecirc;, ignore esse email.</p>
+<p><br />Atenciosamente,<br />{{siteName}}<br />{{siteUrl}}</p>`,
+			DefaultText: `Oi {{displayName}},
+
+Recebemos um pedido para trocar o email da sua conta no site {{siteName}} para {{newEmail}}.
+
+Link de confirmação: {{confirmUrl}}
+
+Se não foi você, ignore esse email.
+
+
+Atenciosamente,
+{{siteName}}
+{{siteUrl}}`,
+			TemplateVariables: map[string]*emails.TemplateVariable{
+				"confirmUrl": {
+					Example:     "http://linkysystems.com/example",
+					Description: "URL de confirmação do novo email",
+				},
+				"newEmail": {
+					Example:     "alberto@linkysystems.com",
+					Description: "Novo email do usuário",
+				},
+				"username": {
+					Example:     "alberto",
+					Description: "Nome único do usuário",
+				},
+				"displayName": {
+					Example:     "Alberto",
+					Description: "Nome de exibição do usuário",
+				},
+				"siteName": {
+					Example:     "Site Name",
+					Description: "Nome desse site",
+				},
+				"siteUrl": {
+					Example:     "/#example",
+					Description: "URL desse site",
+				},
+			},
+		})
+
+		emailPlugin.AddEmailTemplate("AuthEmailChangedEmail", &emails.EmailType{
+			Label:          "Email de aviso de troca de email enviado para o email antigo",
+			DefaultSubject: `O email da sua conta no site {{siteName}} foi alterado`,
+			DefaultHTML: `<p>Oi {{displayName}},</p>
+<p>O email da sua conta no site {{siteName}} foi alterado para {{newEmail}}.</p>
+<p>Se n&atilde;o foi voc&ecirc;, <a href="{{revertUrl}}">clique aqui</a> ou copie e cole o link abaixo para restaurar esse email e trocar a sua senha:</p>
+<p>Link para desfazer a troca: {{revertUrl}}</p>
+<p><br />Atenciosamente,<br />{{siteName}}<br />{{siteUrl}}</p>`,
+			DefaultText: `Oi {{displayName}},
+
+O email da sua conta no site {{siteName}} foi alterado para {{newEmail}}.
+
+Se não foi você, use o link abaixo para restaurar esse email e trocar a sua senha:
+
+Link para desfazer a troca: {{revertUrl}}
+
+
+Atenciosamente,
+{{siteName}}
+{{siteUrl}}`,
+			TemplateVariables: map[string]*emails.TemplateVariable{
+				"revertUrl": {
+					Example:     "http://linkysystems.com/example",
+					Description: "URL para desfazer a troca de email",
+				},
+				"newEmail": {
+					Example:     "alberto@linkysystems.com",
+					Description: "Novo email do usuário",
+				},
+				"username": {
+					Example:     "alberto",
+					Description: "Nome único do usuário",
+				},
+				"displayName": {
+					Example:     "Alberto",
+					Description: "Nome de exibição do usuário",
+				},
+				"siteName": {
+					Example:     "Site Name",
+					Description: "Nome desse site",
+				},
+				"siteUrl": {
+					Example:     "/#example",
+					Description: "URL desse site",
+				},
+			},
+		})
+
+		emailPlugin.AddEmailTemplate("AuthMagicLinkEmail", &emails.EmailType{
+			Label:          "Email com o link de login sem senha",
+			DefaultSubject: `Seu link de login no site {{siteName}}`,
+			DefaultHTML: `<p>Oi {{displayName}},</p>
+<p><a href="{{magicLinkUrl}}">Clique aqui</a> ou copie e cole o link abaixo para entrar no site {{siteName}}. O link pode ser usado uma vez e expira em {{ttl}} minutos.</p>
+<p>Link de login: {{magicLinkUrl}}</p>
+<p>Se n&atilde;o foi voc&ecirc; que pediu esse link, ignore esse email.</p>
+<p><br />Atenciosamente,<br />{{siteName}}<br />{{siteUrl}}</p>`,
+			DefaultText: `Oi {{displayName}},
+
+Use o link abaixo para entrar no site {{siteName}}. O link pode ser usado uma vez e expira em {{ttl}} minutos.
+
+Link de login: {{magicLinkUrl}}
+
+Se não foi você que pediu esse link, ignore esse email.
+
+
+Atenciosamente,
+{{siteName}}
+{{siteUrl}}`,
+			TemplateVariables: map[string]*emails.TemplateVariable{
+				"magicLinkUrl": {
+					Example:     "http://linkysystems.com/example",
+					Description: "URL de login sem senha",
+				},
+				"ttl": {
+					Example:     "15",
+					Description: "Minutos até o link expirar",
+				},
+				"username": {
+					Example:     "alberto",
+					Description: "Nome único do usuário",
+				},
+				"displayName": {
+					Example:     "Alberto",
+					Description: "Nome de exibição do usuário",
+				},
+				"siteName": {
+					Example:     "Site Name",
+					Description: "Nome desse site",
+				},
+				"siteUrl": {
+					Example:     "/#example",
+					Description: "URL desse site",
+				},
+			},
+		})
 	}
 }
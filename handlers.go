@@ -1,10 +1,13 @@
 package user
 
 import (
+	"errors"
+	"net/http"
 	"strconv"
 
 	"github.com/go-bolo/bolo"
 	"github.com/go-bolo/system_settings"
+	user_models "github.com/go-bolo/user/models"
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
 )
@@ -83,3 +86,30 @@ func UserSettingsHandler(c echo.Context) error {
 
 	return c.JSON(200, &data)
 }
+
+// getAuthenticatedUserRecord returns the full authenticated user record, users authenticated with
+// signed access tokens only have the token claims data and are loaded from the database here
+func getAuthenticatedUserRecord(ctx *bolo.RequestContext) (*user_models.UserModel, error) {
+	u := ctx.AuthenticatedUser.(*user_models.UserModel)
+	if !u.IsFromTokenClaims() {
+		return u, nil
+	}
+
+	var record user_models.UserModel
+	err := user_models.UserFindOne(u.GetID(), &record)
+	if err != nil {
+		return nil, err
+	}
+
+	if !record.CanLogin() {
+		return nil, &bolo.HTTPError{
+			Code:     http.StatusUnauthorized,
+			Message:  "invalid token",
+			Internal: errors.New("getAuthenticatedUserRecord user not found, blocked or inactive"),
+		}
+	}
+
+	ctx.SetAuthenticatedUserAndFillRoles(&record)
+
+	return &record, nil
+}
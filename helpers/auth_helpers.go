@@ -2,9 +2,42 @@ package user_helpers
 
 import (
 	"regexp"
+	"strings"
 )
 
+// max sanitized username length, leaves space to one collision suffix in the 30 chars limit
+const sanitizedUsernameMaxLength = 23
+
+var invalidUsernameChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
 func ValidateUsername(username string) bool {
 	matched, _ := regexp.MatchString(`^[A-Za-z0-9_-]{2,30}$`, username)
 	return matched
 }
+
+// SanitizeUsername replaces the chars not allowed in usernames with "_" and trims the length, used with usernames
+// from external sources like the social providers. Returns an empty string if the result is too short
+func SanitizeUsername(username string) string {
+	username = strings.Trim(invalidUsernameChars.ReplaceAllString(username, "_"), "_")
+
+	if len(username) > sanitizedUsernameMaxLength {
+		username = strings.TrimRight(username[:sanitizedUsernameMaxLength], "_")
+	}
+
+	if len(username) < 2 {
+		return ""
+	}
+
+	return username
+}
+
+// SafeRedirectPath only allows local paths to avoid open redirects, returns an empty string for other values
+func SafeRedirectPath(redirectTo string) string {
+	if !strings.HasPrefix(redirectTo, "/") ||
+		strings.HasPrefix(redirectTo, "//") ||
+		strings.ContainsAny(redirectTo, "\\\t\r\n") {
+		return ""
+	}
+
+	return redirectTo
+}
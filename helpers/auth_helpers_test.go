@@ -41,3 +41,51 @@ func TestValidateUsername(t *testing.T) {
 		})
 	}
 }
+
+func TestSanitizeUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		want     string
+	}{
+		{name: "valid", username: "alberto", want: "alberto"},
+		{name: "spaces and accents", username: " João Silva ", want: "Jo_o_Silva"},
+		{name: "long", username: "a.very.long.username.from.one.provider", want: "a_very_long_username_fr"},
+		{name: "too short", username: "ã", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SanitizeUsername(tt.username)
+			if got != tt.want {
+				t.Errorf("SanitizeUsername() = %v, want %v", got, tt.want)
+			}
+
+			if got != "" && !ValidateUsername(got) {
+				t.Errorf("SanitizeUsername() = %v is not one valid username", got)
+			}
+		})
+	}
+}
+
+func TestSafeRedirectPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		redirectTo string
+		want       string
+	}{
+		{name: "local path", redirectTo: "/oauth2/authorize?client_id=1", want: "/oauth2/authorize?client_id=1"},
+		{name: "empty", redirectTo: "", want: ""},
+		{name: "absolute url", redirectTo: "https://example.com", want: ""},
+		{name: "protocol relative", redirectTo: "//example.com", want: ""},
+		{name: "backslash", redirectTo: "/\\example.com", want: ""},
+		{name: "tab", redirectTo: "/\t/example.com", want: ""},
+		{name: "new line", redirectTo: "/\r\nLocation: https://example.com", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SafeRedirectPath(tt.redirectTo); got != tt.want {
+				t.Errorf("SafeRedirectPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package user_helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrKVNotFound is returned by the KVStore reads of missing or expired keys
+var ErrKVNotFound = errors.New("kv store key not found")
+
+// Interval to delete expired keys in the memory and database stores
+var KVStoreCleanupInterval = 10 * time.Minute
+
+// KVStore is the key value storage with expiration used by the login throttle and the oauth2 tokens and codes.
+// Backends: RedisKVStore, MemoryKVStore and the database user_models.DatabaseKVStore, like the session stores
+type KVStore interface {
+	// Get returns ErrKVNotFound if the key does not exist or is expired
+	Get(key string) (string, error)
+	Set(key, value string, ttl time.Duration) error
+	// GetDel gets and deletes the key in one operation, only one of the parallel calls receives the value
+	GetDel(key string) (string, error)
+	Del(keys ...string) error
+	// Exists returns the number of keys that exist
+	Exists(keys ...string) (int64, error)
+	// TTL returns the time left to expire the key, zero if the key does not exist
+	TTL(key string) (time.Duration, error)
+	// Incr increments the key counter in one atomic operation, the ttl is only set when the key is created
+	Incr(key string, ttl time.Duration) (int64, error)
+	Expire(key string, ttl time.Duration) error
+	Close() error
+}
+
+// NewRedisKVStore creates one KVStore with the redis connections, the connections are not closed in the store Close
+func NewRedisKVStore(writer, reader *redis.Client) *RedisKVStore {
+	return &RedisKVStore{Writer: writer, Reader: reader}
+}
+
+// RedisKVStore - KVStore with one redis writer and one reader connection
+type RedisKVStore struct {
+	Writer *redis.Client
+	Reader *redis.Client
+}
+
+// redisKVIncrScript increments the KEYS[1] and sets the ARGV[1] ttl, in milliseconds, only in new keys
+var redisKVIncrScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+func (s *RedisKVStore) Get(key string) (string, error) {
+	v, err := s.Reader.Get(context.Background(), key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrKVNotFound
+	}
+
+	return v, err
+}
+
+func (s *RedisKVStore) Set(key, value string, ttl time.Duration) error {
+	return s.Writer.Set(context.Background(), key, value, ttl).Err()
+}
+
+func (s *RedisKVStore) GetDel(key string) (string, error) {
+	v, err := s.Writer.GetDel(context.Background(), key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrKVNotFound
+	}
+
+	return v, err
+}
+
+func (s *RedisKVStore) Del(keys ...string) error {
+	return s.Writer.Del(context.Background(), keys...).Err()
+}
+
+func (s *RedisKVStore) Exists(keys ...string) (int64, error) {
+	return s.Reader.Exists(context.Background(), keys...).Result()
+}
+
+func (s *RedisKVStore) TTL(key string) (time.Duration, error) {
+	ttl, err := s.Reader.PTTL(context.Background(), key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// redis returns negative values for missing keys and keys without expiration:
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+func (s *RedisKVStore) Incr(key string, ttl time.Duration) (int64, error) {
+	return redisKVIncrScript.Run(context.Background(), s.Writer, []string{key}, ttl.Milliseconds()).Int64()
+}
+
+func (s *RedisKVStore) Expire(key string, ttl time.Duration) error {
+	return s.Writer.PExpire(context.Background(), key, ttl).Err()
+}
+
+// Close does nothing, the redis connections are shared and closed by the owner
+func (s *RedisKVStore) Close() error {
+	return nil
+}
+
+type memoryKVRecord struct {
+	value     string
+	expiresAt time.Time
+}
+
+func (r *memoryKVRecord) isExpired(now time.Time) bool {
+	return !r.expiresAt.IsZero() && !now.Before(r.expiresAt)
+}
+
+// NewMemoryKVStore creates one KVStore in the process memory, only for tests, development and single instance apps.
+// One goroutine deletes the expired keys until the store Close
+func NewMemoryKVStore() *MemoryKVStore {
+	s := MemoryKVStore{
+		records:     make(map[string]*memoryKVRecord),
+		stopCleanup: make(chan struct{}),
+	}
+
+	go RunKVStoreCleanup(s.stopCleanup, s.deleteExpired)
+
+	return &s
+}
+
+// MemoryKVStore - KVStore in the process memory
+type MemoryKVStore struct {
+	mu      sync.Mutex
+	records map[string]*memoryKVRecord
+
+	stopCleanup chan struct{}
+	closeOnce   sync.Once
+}
+
+// getRecord returns the not expired record, the lock should be held by the caller
+func (s *MemoryKVStore) getRecord(key string) *memoryKVRecord {
+	r, ok := s.records[key]
+	if !ok {
+		return nil
+	}
+
+	if r.isExpired(time.Now()) {
+		delete(s.records, key)
+		return nil
+	}
+
+	return r
+}
+
+func getExpiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(ttl)
+}
+
+func (s *MemoryKVStore) Get(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.getRecord(key)
+	if r == nil {
+		return "", ErrKVNotFound
+	}
+
+	return r.value, nil
+}
+
+func (s *MemoryKVStore) Set(key, value string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[key] = &memoryKVRecord{value: value, expiresAt: getExpiresAt(ttl)}
+
+	return nil
+}
+
+func (s *MemoryKVStore) GetDel(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.getRecord(key)
+	if r == nil {
+		return "", ErrKVNotFound
+	}
+
+	delete(s.records, key)
+
+	return r.value, nil
+}
+
+func (s *MemoryKVStore) Del(keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.records, key)
+	}
+
+	return nil
+}
+
+func (s *MemoryKVStore) Exists(keys ...string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int64
+	for _, key := range keys {
+		if s.getRecord(key) != nil {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (s *MemoryKVStore) TTL(key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.getRecord(key)
+	if r == nil || r.expiresAt.IsZero() {
+		return 0, nil
+	}
+
+	return time.Until(r.expiresAt), nil
+}
+
+func (s *MemoryKVStore) Incr(key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.getRecord(key)
+	if r == nil {
+		r = &memoryKVRecord{value: "0", expiresAt: getExpiresAt(ttl)}
+		s.records[key] = r
+	}
+
+	count, err := strconv.ParseInt(r.value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("MemoryKVStore.Incr the key %s is not one number: %w", key, err)
+	}
+
+	count++
+	r.value = strconv.FormatInt(count, 10)
+
+	return count, nil
+}
+
+func (s *MemoryKVStore) Expire(key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.getRecord(key)
+	if r != nil {
+		r.expiresAt = getExpiresAt(ttl)
+	}
+
+	return nil
+}
+
+// Close stops the expired keys cleanup
+func (s *MemoryKVStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stopCleanup)
+	})
+
+	return nil
+}
+
+func (s *MemoryKVStore) deleteExpired() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, r := range s.records {
+		if r.isExpired(now) {
+			delete(s.records, key)
+		}
+	}
+
+	return nil
+}
+
+// RunKVStoreCleanup calls the deleteExpired in each KVStoreCleanupInterval until the stop channel is closed,
+// used by the stores that need to delete the expired keys
+func RunKVStoreCleanup(stop chan struct{}, deleteExpired func() error) {
+	ticker := time.NewTicker(KVStoreCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := deleteExpired()
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+				}).Error("RunKVStoreCleanup error on delete expired keys")
+			}
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package user
 
 import (
+	"errors"
+	"net/http"
+
+	"github.com/go-bolo/bolo"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
 	"github.com/labstack/echo/v4"
 )
 
@@ -20,3 +25,27 @@ func sessionAuthenticationMiddleware() echo.MiddlewareFunc {
 		}
 	}
 }
+
+// fullAccessTokenMiddleware blocks oauth2 tokens with scopes and personal access tokens in the account management routes,
+// these routes only check the authenticated user and the scopes only restrict permissions
+func fullAccessTokenMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if auth_oauth2_password.IsPersonalAccessTokenRequest(c) {
+			return &bolo.HTTPError{
+				Code:     http.StatusForbidden,
+				Message:  "auth.personal-access-token-not-allowed",
+				Internal: errors.New("fullAccessTokenMiddleware personal access token in " + c.Path()),
+			}
+		}
+
+		if auth_oauth2_password.HasTokenScopes(c) {
+			return &bolo.HTTPError{
+				Code:     http.StatusForbidden,
+				Message:  "auth.scoped-token-not-allowed",
+				Internal: errors.New("fullAccessTokenMiddleware token with scopes in " + c.Path()),
+			}
+		}
+
+		return next(c)
+	}
+}
@@ -0,0 +1,76 @@
+package user_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFullAccessTokenMiddleware(t *testing.T) {
+	t.Setenv("OAUTH2_SCOPES", `{"read": ["find_user"]}`)
+
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	request := func(method, url, accessToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(`{}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+
+		rec := httptest.NewRecorder()
+		app.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	scoped, err := auth_oauth2_password.Oauth2GenerateAndSaveScopedToken(ctx, &u, []string{"read"})
+	assert.NoError(t, err)
+	full, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+	assert.NoError(t, err)
+
+	t.Run("block account management with scoped tokens", func(t *testing.T) {
+		rec := request(http.MethodPost, "/api/v2/auth/mfa/totp/enroll", scoped.AccessToken)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Contains(t, rec.Body.String(), "auth.scoped-token-not-allowed")
+	})
+
+	t.Run("allow reads with scoped tokens", func(t *testing.T) {
+		rec := request(http.MethodGet, "/api/v2/auth/mfa", scoped.AccessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("allow account management with full access tokens", func(t *testing.T) {
+		rec := request(http.MethodPost, "/api/v2/auth/mfa/totp/enroll", full.AccessToken)
+		assert.NotEqual(t, http.StatusForbidden, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "auth.scoped-token-not-allowed")
+	})
+}
@@ -0,0 +1,76 @@
+package migrations_user
+
+import (
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+	"gorm.io/gorm"
+)
+
+// GetMfaMigration creates the tables used in the TOTP two-factor authentication
+func GetMfaMigration() *bolo.Migration {
+	queries := []struct {
+		table string
+		up    string
+		down  string
+	}{
+		{
+			table: "user_totps",
+			up: `CREATE TABLE IF NOT EXISTS user_totps (
+				id int NOT NULL AUTO_INCREMENT,
+				userId bigint NOT NULL,
+				secret varchar(255) NOT NULL,
+				confirmed tinyint(1) DEFAULT '0',
+				lastUsedStep bigint DEFAULT '0',
+				createdAt datetime NOT NULL,
+				updatedAt datetime NOT NULL,
+				PRIMARY KEY (id),
+				UNIQUE KEY user_totps_userId (userId)
+			)`,
+			down: `DROP TABLE IF EXISTS user_totps`,
+		},
+		{
+			table: "user_mfa_recovery_codes",
+			up: `CREATE TABLE IF NOT EXISTS user_mfa_recovery_codes (
+				id int NOT NULL AUTO_INCREMENT,
+				userId bigint NOT NULL,
+				codeHash varchar(64) NOT NULL,
+				usedAt datetime DEFAULT NULL,
+				createdAt datetime NOT NULL,
+				PRIMARY KEY (id),
+				KEY user_mfa_recovery_codes_userId (userId)
+			)`,
+			down: `DROP TABLE IF EXISTS user_mfa_recovery_codes`,
+		},
+	}
+
+	return &bolo.Migration{
+		Name: "mfa",
+		Up: func(app bolo.App) error {
+			db := app.GetDB()
+			return db.Transaction(func(tx *gorm.DB) error {
+				for _, q := range queries {
+					err := tx.Exec(q.up).Error
+					if err != nil {
+						return fmt.Errorf("failed to create "+q.table+" table: %w", err)
+					}
+				}
+
+				return nil
+			})
+		},
+		Down: func(app bolo.App) error {
+			db := app.GetDB()
+			return db.Transaction(func(tx *gorm.DB) error {
+				for _, q := range queries {
+					err := tx.Exec(q.down).Error
+					if err != nil {
+						return fmt.Errorf("failed to drop "+q.table+" table: %w", err)
+					}
+				}
+
+				return nil
+			})
+		},
+	}
+}
@@ -0,0 +1,37 @@
+package migrations_user
+
+import (
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+)
+
+// GetUserSessionsMigration creates the registry of user sessions and oauth2 tokens
+func GetUserSessionsMigration() *bolo.Migration {
+	return &bolo.Migration{
+		Name: "user-sessions",
+		Up: func(app bolo.App) error {
+			err := app.GetDB().Exec(`CREATE TABLE IF NOT EXISTS user_sessions (
+				id bigint NOT NULL AUTO_INCREMENT,
+				userId bigint NOT NULL,
+				type varchar(20) NOT NULL,
+				ip varchar(45) DEFAULT NULL,
+				userAgent text,
+				lastSeenAt datetime DEFAULT NULL,
+				expiresAt datetime DEFAULT NULL,
+				createdAt datetime NOT NULL,
+				updatedAt datetime NOT NULL,
+				PRIMARY KEY (id),
+				KEY user_sessions_userId (userId)
+			)`).Error
+			if err != nil {
+				return fmt.Errorf("failed to create user_sessions table: %w", err)
+			}
+
+			return nil
+		},
+		Down: func(app bolo.App) error {
+			return app.GetDB().Exec(`DROP TABLE IF EXISTS user_sessions`).Error
+		},
+	}
+}
@@ -0,0 +1,33 @@
+package migrations_user
+
+import (
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+)
+
+// GetSessionsMigration creates the table used in the database session store
+func GetSessionsMigration() *bolo.Migration {
+	return &bolo.Migration{
+		Name: "sessions",
+		Up: func(app bolo.App) error {
+			err := app.GetDB().Exec(`CREATE TABLE IF NOT EXISTS sessions (
+				id varchar(128) NOT NULL,
+				data longtext,
+				expiresAt datetime DEFAULT NULL,
+				createdAt datetime NOT NULL,
+				updatedAt datetime NOT NULL,
+				PRIMARY KEY (id),
+				KEY sessions_expiresAt (expiresAt)
+			)`).Error
+			if err != nil {
+				return fmt.Errorf("failed to create sessions table: %w", err)
+			}
+
+			return nil
+		},
+		Down: func(app bolo.App) error {
+			return app.GetDB().Exec(`DROP TABLE IF EXISTS sessions`).Error
+		},
+	}
+}
@@ -0,0 +1,38 @@
+package migrations_user
+
+import (
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+)
+
+// GetUserIdentitiesMigration creates the table of external provider accounts linked to users
+func GetUserIdentitiesMigration() *bolo.Migration {
+	return &bolo.Migration{
+		Name: "user-identities",
+		Up: func(app bolo.App) error {
+			err := app.GetDB().Exec(`CREATE TABLE IF NOT EXISTS user_identities (
+				id bigint NOT NULL AUTO_INCREMENT,
+				userId bigint NOT NULL,
+				provider varchar(50) NOT NULL,
+				providerUserId varchar(255) NOT NULL,
+				email varchar(255) DEFAULT NULL,
+				emailVerified tinyint(1) NOT NULL DEFAULT 0,
+				profile text,
+				createdAt datetime NOT NULL,
+				updatedAt datetime NOT NULL,
+				PRIMARY KEY (id),
+				UNIQUE KEY user_identities_provider_user (provider, providerUserId),
+				KEY user_identities_userId (userId)
+			)`).Error
+			if err != nil {
+				return fmt.Errorf("failed to create user_identities table: %w", err)
+			}
+
+			return nil
+		},
+		Down: func(app bolo.App) error {
+			return app.GetDB().Exec(`DROP TABLE IF EXISTS user_identities`).Error
+		},
+	}
+}
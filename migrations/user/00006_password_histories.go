@@ -0,0 +1,32 @@
+package migrations_user
+
+import (
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+)
+
+// GetPasswordHistoriesMigration creates the table with the old user password hashes
+func GetPasswordHistoriesMigration() *bolo.Migration {
+	return &bolo.Migration{
+		Name: "password-histories",
+		Up: func(app bolo.App) error {
+			err := app.GetDB().Exec(`CREATE TABLE IF NOT EXISTS password_histories (
+				id bigint NOT NULL AUTO_INCREMENT,
+				userId bigint NOT NULL,
+				password text NOT NULL,
+				createdAt datetime NOT NULL,
+				PRIMARY KEY (id),
+				KEY password_histories_userId (userId)
+			)`).Error
+			if err != nil {
+				return fmt.Errorf("failed to create password_histories table: %w", err)
+			}
+
+			return nil
+		},
+		Down: func(app bolo.App) error {
+			return app.GetDB().Exec(`DROP TABLE IF EXISTS password_histories`).Error
+		},
+	}
+}
@@ -0,0 +1,35 @@
+package migrations_user
+
+import (
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+)
+
+// GetUserLockoutMigration adds the login lockout state columns in the users table
+func GetUserLockoutMigration() *bolo.Migration {
+	return &bolo.Migration{
+		Name: "user-lockout",
+		Up: func(app bolo.App) error {
+			err := app.GetDB().Exec(`ALTER TABLE users
+				ADD COLUMN failedLoginCount int NOT NULL DEFAULT 0,
+				ADD COLUMN lastFailedLoginAt datetime DEFAULT NULL,
+				ADD COLUMN lastFailedLoginIP varchar(45) DEFAULT NULL,
+				ADD COLUMN lockedUntil datetime DEFAULT NULL,
+				ADD COLUMN lockoutCount int NOT NULL DEFAULT 0`).Error
+			if err != nil {
+				return fmt.Errorf("failed to add the lockout columns in users table: %w", err)
+			}
+
+			return nil
+		},
+		Down: func(app bolo.App) error {
+			return app.GetDB().Exec(`ALTER TABLE users
+				DROP COLUMN failedLoginCount,
+				DROP COLUMN lastFailedLoginAt,
+				DROP COLUMN lastFailedLoginIP,
+				DROP COLUMN lockedUntil,
+				DROP COLUMN lockoutCount`).Error
+		},
+	}
+}
@@ -0,0 +1,39 @@
+package migrations_user
+
+import (
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+)
+
+// GetAuthEventsMigration creates the security audit log table
+func GetAuthEventsMigration() *bolo.Migration {
+	return &bolo.Migration{
+		Name: "auth-events",
+		Up: func(app bolo.App) error {
+			err := app.GetDB().Exec(`CREATE TABLE IF NOT EXISTS auth_events (
+				id bigint NOT NULL AUTO_INCREMENT,
+				userId bigint DEFAULT NULL,
+				actorId bigint DEFAULT NULL,
+				type varchar(60) NOT NULL,
+				ip varchar(45) DEFAULT NULL,
+				userAgent text,
+				metadata text,
+				createdAt datetime NOT NULL,
+				PRIMARY KEY (id),
+				KEY auth_events_userId (userId),
+				KEY auth_events_actorId (actorId),
+				KEY auth_events_type (type),
+				KEY auth_events_createdAt (createdAt)
+			)`).Error
+			if err != nil {
+				return fmt.Errorf("failed to create auth_events table: %w", err)
+			}
+
+			return nil
+		},
+		Down: func(app bolo.App) error {
+			return app.GetDB().Exec(`DROP TABLE IF EXISTS auth_events`).Error
+		},
+	}
+}
@@ -0,0 +1,58 @@
+package migrations_user
+
+import (
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+	"gorm.io/gorm"
+)
+
+// GetRolesMigration creates the database roles and role permissions tables
+func GetRolesMigration() *bolo.Migration {
+	return &bolo.Migration{
+		Name: "roles",
+		Up: func(app bolo.App) error {
+			db := app.GetDB()
+
+			return db.Transaction(func(tx *gorm.DB) error {
+				err := tx.Exec(`CREATE TABLE IF NOT EXISTS roles (
+					id bigint NOT NULL AUTO_INCREMENT,
+					name varchar(100) NOT NULL,
+					canAddInUsers tinyint(1) NOT NULL DEFAULT 0,
+					createdAt datetime NOT NULL,
+					updatedAt datetime NOT NULL,
+					PRIMARY KEY (id),
+					UNIQUE KEY roles_name (name)
+				)`).Error
+				if err != nil {
+					return fmt.Errorf("failed to create roles table: %w", err)
+				}
+
+				err = tx.Exec(`CREATE TABLE IF NOT EXISTS role_permissions (
+					id bigint NOT NULL AUTO_INCREMENT,
+					roleId bigint NOT NULL,
+					permission varchar(100) NOT NULL,
+					PRIMARY KEY (id),
+					UNIQUE KEY role_permissions_roleId_permission (roleId, permission)
+				)`).Error
+				if err != nil {
+					return fmt.Errorf("failed to create role_permissions table: %w", err)
+				}
+
+				return nil
+			})
+		},
+		Down: func(app bolo.App) error {
+			db := app.GetDB()
+
+			return db.Transaction(func(tx *gorm.DB) error {
+				err := tx.Exec(`DROP TABLE IF EXISTS role_permissions`).Error
+				if err != nil {
+					return err
+				}
+
+				return tx.Exec(`DROP TABLE IF EXISTS roles`).Error
+			})
+		},
+	}
+}
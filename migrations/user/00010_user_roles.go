@@ -0,0 +1,95 @@
+package migrations_user
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+	"gorm.io/gorm"
+)
+
+type legacyUserRoles struct {
+	ID    uint64
+	Roles string
+}
+
+// GetUserRolesMigration creates the user_roles table and moves the roles saved as JSON in users.roles to it
+func GetUserRolesMigration() *bolo.Migration {
+	return &bolo.Migration{
+		Name: "user-roles",
+		Up: func(app bolo.App) error {
+			db := app.GetDB()
+
+			return db.Transaction(func(tx *gorm.DB) error {
+				err := tx.Exec(`CREATE TABLE IF NOT EXISTS user_roles (
+					id bigint NOT NULL AUTO_INCREMENT,
+					userId bigint unsigned NOT NULL,
+					role varchar(100) NOT NULL,
+					PRIMARY KEY (id),
+					UNIQUE KEY user_roles_userId_role (userId, role),
+					KEY user_roles_role (role)
+				)`).Error
+				if err != nil {
+					return fmt.Errorf("failed to create user_roles table: %w", err)
+				}
+
+				users := []legacyUserRoles{}
+				err = tx.Raw(`SELECT id, roles FROM users WHERE roles IS NOT NULL AND roles != ''`).
+					Scan(&users).Error
+				if err != nil {
+					return fmt.Errorf("failed to load the users roles: %w", err)
+				}
+
+				for _, u := range users {
+					roles := []string{}
+					if err := json.Unmarshal([]byte(u.Roles), &roles); err != nil {
+						// invalid values were ignored by the old user model too
+						continue
+					}
+
+					for _, role := range roles {
+						if role == "" {
+							continue
+						}
+
+						err = tx.Exec(`INSERT IGNORE INTO user_roles (userId, role) VALUES (?, ?)`, u.ID, role).Error
+						if err != nil {
+							return fmt.Errorf("failed to convert the user %d roles: %w", u.ID, err)
+						}
+					}
+				}
+
+				return nil
+			})
+		},
+		Down: func(app bolo.App) error {
+			db := app.GetDB()
+
+			return db.Transaction(func(tx *gorm.DB) error {
+				rows := []struct {
+					UserID uint64 `gorm:"column:userId"`
+					Role   string
+				}{}
+				err := tx.Raw(`SELECT userId, role FROM user_roles ORDER BY id ASC`).Scan(&rows).Error
+				if err != nil {
+					return fmt.Errorf("failed to load the user_roles: %w", err)
+				}
+
+				rolesByUser := map[uint64][]string{}
+				for _, r := range rows {
+					rolesByUser[r.UserID] = append(rolesByUser[r.UserID], r.Role)
+				}
+
+				for userID, roles := range rolesByUser {
+					data, _ := json.Marshal(roles)
+					err = tx.Exec(`UPDATE users SET roles = ? WHERE id = ?`, string(data), userID).Error
+					if err != nil {
+						return fmt.Errorf("failed to restore the user %d roles: %w", userID, err)
+					}
+				}
+
+				return tx.Exec(`DROP TABLE IF EXISTS user_roles`).Error
+			})
+		},
+	}
+}
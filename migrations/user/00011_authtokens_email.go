@@ -0,0 +1,26 @@
+package migrations_user
+
+import (
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+)
+
+// GetAuthTokensEmailMigration adds the email column used by the email change tokens
+func GetAuthTokensEmailMigration() *bolo.Migration {
+	return &bolo.Migration{
+		Name: "authtokens-email",
+		Up: func(app bolo.App) error {
+			err := app.GetDB().Exec(`ALTER TABLE authtokens
+				ADD COLUMN email varchar(255) DEFAULT NULL`).Error
+			if err != nil {
+				return fmt.Errorf("failed to add the email column in authtokens table: %w", err)
+			}
+
+			return nil
+		},
+		Down: func(app bolo.App) error {
+			return app.GetDB().Exec(`ALTER TABLE authtokens DROP COLUMN email`).Error
+		},
+	}
+}
@@ -0,0 +1,65 @@
+package migrations_user
+
+import (
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+)
+
+// GetWebAuthnMigration creates the FIDO2/WebAuthn credentials and ceremony challenges tables
+func GetWebAuthnMigration() *bolo.Migration {
+	return &bolo.Migration{
+		Name: "webauthn",
+		Up: func(app bolo.App) error {
+			err := app.GetDB().Exec(`CREATE TABLE IF NOT EXISTS webauthn_credentials (
+				id bigint NOT NULL AUTO_INCREMENT,
+				userId bigint NOT NULL,
+				credentialId varchar(255) NOT NULL,
+				publicKey blob NOT NULL,
+				attestationType varchar(50) DEFAULT NULL,
+				transports varchar(255) DEFAULT NULL,
+				aaguid blob,
+				signCount int unsigned NOT NULL DEFAULT 0,
+				backupEligible tinyint(1) NOT NULL DEFAULT 0,
+				backupState tinyint(1) NOT NULL DEFAULT 0,
+				name varchar(100) DEFAULT NULL,
+				lastUsedAt datetime DEFAULT NULL,
+				createdAt datetime NOT NULL,
+				updatedAt datetime NOT NULL,
+				PRIMARY KEY (id),
+				UNIQUE KEY webauthn_credentials_credentialId (credentialId),
+				KEY webauthn_credentials_userId (userId)
+			)`).Error
+			if err != nil {
+				return fmt.Errorf("failed to create webauthn_credentials table: %w", err)
+			}
+
+			err = app.GetDB().Exec(`CREATE TABLE IF NOT EXISTS webauthn_challenges (
+				id bigint NOT NULL AUTO_INCREMENT,
+				token varchar(64) NOT NULL,
+				ceremony varchar(20) NOT NULL,
+				userId bigint NOT NULL DEFAULT 0,
+				mfaToken varchar(255) DEFAULT NULL,
+				sessionData text NOT NULL,
+				createdAt datetime NOT NULL,
+				PRIMARY KEY (id),
+				UNIQUE KEY webauthn_challenges_token (token),
+				KEY webauthn_challenges_userId (userId),
+				KEY webauthn_challenges_createdAt (createdAt)
+			)`).Error
+			if err != nil {
+				return fmt.Errorf("failed to create webauthn_challenges table: %w", err)
+			}
+
+			return nil
+		},
+		Down: func(app bolo.App) error {
+			err := app.GetDB().Exec(`DROP TABLE IF EXISTS webauthn_challenges`).Error
+			if err != nil {
+				return err
+			}
+
+			return app.GetDB().Exec(`DROP TABLE IF EXISTS webauthn_credentials`).Error
+		},
+	}
+}
@@ -0,0 +1,38 @@
+package migrations_user
+
+import (
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+)
+
+// GetSigningKeysMigration creates the JWT access tokens signing keys table
+func GetSigningKeysMigration() *bolo.Migration {
+	return &bolo.Migration{
+		Name: "signing-keys",
+		Up: func(app bolo.App) error {
+			err := app.GetDB().Exec(`CREATE TABLE IF NOT EXISTS signing_keys (
+				id bigint NOT NULL AUTO_INCREMENT,
+				kid varchar(100) NOT NULL,
+				algorithm varchar(20) NOT NULL,
+				status varchar(20) NOT NULL,
+				privateKey text NOT NULL,
+				publicKey text NOT NULL,
+				notAfter datetime NOT NULL,
+				createdAt datetime NOT NULL,
+				updatedAt datetime NOT NULL,
+				PRIMARY KEY (id),
+				UNIQUE KEY signing_keys_kid (kid),
+				KEY signing_keys_status (status)
+			)`).Error
+			if err != nil {
+				return fmt.Errorf("failed to create signing_keys table: %w", err)
+			}
+
+			return nil
+		},
+		Down: func(app bolo.App) error {
+			return app.GetDB().Exec(`DROP TABLE IF EXISTS signing_keys`).Error
+		},
+	}
+}
@@ -0,0 +1,40 @@
+package migrations_user
+
+import (
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+)
+
+// GetPersonalAccessTokensMigration creates the personal access tokens table, only the token hashes are saved
+func GetPersonalAccessTokensMigration() *bolo.Migration {
+	return &bolo.Migration{
+		Name: "personal-access-tokens",
+		Up: func(app bolo.App) error {
+			err := app.GetDB().Exec(`CREATE TABLE IF NOT EXISTS personal_access_tokens (
+				id bigint NOT NULL AUTO_INCREMENT,
+				userId bigint NOT NULL,
+				name varchar(100) NOT NULL,
+				tokenPrefix varchar(20) NOT NULL,
+				tokenHash varchar(64) NOT NULL,
+				scopes varchar(255) DEFAULT NULL,
+				expiresAt datetime DEFAULT NULL,
+				lastUsedAt datetime DEFAULT NULL,
+				lastUsedIP varchar(45) DEFAULT NULL,
+				createdAt datetime NOT NULL,
+				updatedAt datetime NOT NULL,
+				PRIMARY KEY (id),
+				UNIQUE KEY personal_access_tokens_tokenHash (tokenHash),
+				KEY personal_access_tokens_userId (userId)
+			)`).Error
+			if err != nil {
+				return fmt.Errorf("failed to create personal_access_tokens table: %w", err)
+			}
+
+			return nil
+		},
+		Down: func(app bolo.App) error {
+			return app.GetDB().Exec(`DROP TABLE IF EXISTS personal_access_tokens`).Error
+		},
+	}
+}
@@ -0,0 +1,37 @@
+package migrations_user
+
+import (
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+)
+
+// GetOAuthClientsMigration creates the oauth2 clients registry table, only the client secret hashes are saved
+func GetOAuthClientsMigration() *bolo.Migration {
+	return &bolo.Migration{
+		Name: "oauth-clients",
+		Up: func(app bolo.App) error {
+			err := app.GetDB().Exec(`CREATE TABLE IF NOT EXISTS oauth_clients (
+				id bigint NOT NULL AUTO_INCREMENT,
+				clientId varchar(100) NOT NULL,
+				name varchar(100) NOT NULL,
+				secretHash varchar(64) DEFAULT NULL,
+				grants varchar(255) DEFAULT NULL,
+				scopes varchar(255) DEFAULT NULL,
+				redirectUris text,
+				createdAt datetime NOT NULL,
+				updatedAt datetime NOT NULL,
+				PRIMARY KEY (id),
+				UNIQUE KEY oauth_clients_clientId (clientId)
+			)`).Error
+			if err != nil {
+				return fmt.Errorf("failed to create oauth_clients table: %w", err)
+			}
+
+			return nil
+		},
+		Down: func(app bolo.App) error {
+			return app.GetDB().Exec(`DROP TABLE IF EXISTS oauth_clients`).Error
+		},
+	}
+}
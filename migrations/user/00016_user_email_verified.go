@@ -0,0 +1,32 @@
+package migrations_user
+
+import (
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+)
+
+// GetUserEmailVerifiedMigration adds the email verification state in the users table. Users activated before
+// this migration confirmed the email in the activation link, then they start verified
+func GetUserEmailVerifiedMigration() *bolo.Migration {
+	return &bolo.Migration{
+		Name: "user-email-verified",
+		Up: func(app bolo.App) error {
+			err := app.GetDB().Exec(`ALTER TABLE users
+				ADD COLUMN emailVerified tinyint(1) NOT NULL DEFAULT 0`).Error
+			if err != nil {
+				return fmt.Errorf("failed to add the emailVerified column in users table: %w", err)
+			}
+
+			err = app.GetDB().Exec(`UPDATE users SET emailVerified = 1 WHERE active = 1`).Error
+			if err != nil {
+				return fmt.Errorf("failed to set the emailVerified of active users: %w", err)
+			}
+
+			return nil
+		},
+		Down: func(app bolo.App) error {
+			return app.GetDB().Exec(`ALTER TABLE users DROP COLUMN emailVerified`).Error
+		},
+	}
+}
@@ -0,0 +1,48 @@
+package migrations_user
+
+import (
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+)
+
+// GetSigningKeysActiveSlotMigration adds the activeSlot unique column in the signing_keys table, only the active
+// key has one value then concurrent app instances can not create two active keys
+func GetSigningKeysActiveSlotMigration() *bolo.Migration {
+	return &bolo.Migration{
+		Name: "signing-keys-active-slot",
+		Up: func(app bolo.App) error {
+			db := app.GetDB()
+
+			err := db.Exec(`ALTER TABLE signing_keys ADD COLUMN activeSlot tinyint(1) DEFAULT NULL`).Error
+			if err != nil {
+				return fmt.Errorf("failed to add the activeSlot column in signing_keys table: %w", err)
+			}
+
+			// keep only the newest active key:
+			err = db.Exec(`UPDATE signing_keys SET activeSlot = 1 WHERE id = (
+				SELECT id FROM (SELECT MAX(id) AS id FROM signing_keys WHERE status = 'active') AS newest
+			)`).Error
+			if err != nil {
+				return fmt.Errorf("failed to set the signing_keys activeSlot: %w", err)
+			}
+
+			err = db.Exec(`UPDATE signing_keys SET status = 'inactive' WHERE status = 'active' AND activeSlot IS NULL`).Error
+			if err != nil {
+				return fmt.Errorf("failed to deactivate the old signing_keys: %w", err)
+			}
+
+			err = db.Exec(`ALTER TABLE signing_keys ADD UNIQUE KEY signing_keys_activeSlot (activeSlot)`).Error
+			if err != nil {
+				return fmt.Errorf("failed to add the signing_keys activeSlot unique key: %w", err)
+			}
+
+			return nil
+		},
+		Down: func(app bolo.App) error {
+			return app.GetDB().Exec(`ALTER TABLE signing_keys
+				DROP INDEX signing_keys_activeSlot,
+				DROP COLUMN activeSlot`).Error
+		},
+	}
+}
@@ -0,0 +1,33 @@
+package migrations_user
+
+import (
+	"fmt"
+
+	"github.com/go-bolo/bolo"
+)
+
+// GetKVStoreMigration creates the table used in the database KVStore of the login throttle and oauth2 storage
+func GetKVStoreMigration() *bolo.Migration {
+	return &bolo.Migration{
+		Name: "kv-store",
+		Up: func(app bolo.App) error {
+			err := app.GetDB().Exec(`CREATE TABLE IF NOT EXISTS kv_store (
+				id varchar(191) NOT NULL,
+				value longtext,
+				expiresAt datetime DEFAULT NULL,
+				createdAt datetime NOT NULL,
+				updatedAt datetime NOT NULL,
+				PRIMARY KEY (id),
+				KEY kv_store_expiresAt (expiresAt)
+			)`).Error
+			if err != nil {
+				return fmt.Errorf("failed to create kv_store table: %w", err)
+			}
+
+			return nil
+		},
+		Down: func(app bolo.App) error {
+			return app.GetDB().Exec(`DROP TABLE IF EXISTS kv_store`).Error
+		},
+	}
+}
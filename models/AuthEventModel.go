@@ -0,0 +1,278 @@
+package user_models
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/gookit/event"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Auth event types saved in the auth_events table
+const (
+	AuthEventLogin             = "login"
+	AuthEventLoginFailed       = "login_failed"
+	AuthEventLogout            = "logout"
+	AuthEventSignup            = "signup"
+	AuthEventSignupFailed      = "signup_failed"
+	AuthEventAccountActivated  = "account_activated"
+	AuthEventAccountLocked     = "account_locked"
+	AuthEventAccountUnlocked   = "account_unlocked"
+	AuthEventPasswordChange    = "password_change"
+	AuthEventPasswordSet       = "password_set"
+	AuthEventPasswordResetReq  = "password_reset_request"
+	AuthEventPasswordReset     = "password_reset"
+	AuthEventEmailChangeReq    = "email_change_request"
+	AuthEventEmailChange       = "email_change"
+	AuthEventEmailChangeRevert = "email_change_revert"
+	AuthEventMagicLinkReq      = "magic_link_request"
+	AuthEventTokenRefresh      = "token_refresh"
+	AuthEventSessionRevoke     = "session_revoke"
+	AuthEventMfaEnabled        = "mfa_enabled"
+	AuthEventMfaDisabled       = "mfa_disabled"
+	AuthEventMfaRecoveryCodes  = "mfa_recovery_codes"
+	AuthEventWebAuthnAdded     = "webauthn_added"
+	AuthEventWebAuthnRemoved   = "webauthn_removed"
+	AuthEventIdentityLink      = "identity_link"
+	AuthEventIdentityUnlink    = "identity_unlink"
+	AuthEventUserCreate        = "user_create"
+	AuthEventUserUpdate        = "user_update"
+	AuthEventUserDelete        = "user_delete"
+	AuthEventRolesChange       = "roles_change"
+	AuthEventRoleCreate        = "role_create"
+	AuthEventRoleUpdate        = "role_update"
+	AuthEventRoleDelete        = "role_delete"
+	AuthEventPersonalTokenAdd  = "personal_token_create"
+	AuthEventPersonalTokenDel  = "personal_token_revoke"
+	AuthEventClientToken       = "client_token"
+	AuthEventClientCreate      = "oauth_client_create"
+	AuthEventClientDelete      = "oauth_client_delete"
+	AuthEventOAuthConsent      = "oauth_consent"
+	AuthEventSigningKeyRotate  = "signing_key_rotate"
+)
+
+// App event triggered after each saved auth event, with the "ctx" and "event" data
+const AuthEventTriggerName = "auth-event"
+
+// AuthEventModel - security audit log record, like logins, password changes and role changes
+type AuthEventModel struct {
+	ID uint64 `gorm:"primary_key;column:id;" json:"id"`
+	// user affected by the event, nil in failed logins with unknown users
+	UserID *int64 `gorm:"column:userId;index" json:"userId"`
+	// authenticated user that made the request, nil in anonymous requests like logins
+	ActorID   *int64          `gorm:"column:actorId;index" json:"actorId"`
+	Type      string          `gorm:"column:type;type:VARCHAR(60);index" json:"type"`
+	IP        string          `gorm:"column:ip;type:VARCHAR(45)" json:"ip"`
+	UserAgent string          `gorm:"column:userAgent;type:TEXT" json:"userAgent"`
+	Metadata  json.RawMessage `gorm:"column:metadata;type:TEXT" json:"metadata"`
+	CreatedAt time.Time       `gorm:"column:createdAt;type:datetime;not null;index" json:"createdAt"`
+}
+
+func (r *AuthEventModel) TableName() string {
+	return "auth_events"
+}
+
+func (r *AuthEventModel) GetID() string {
+	return strconv.FormatUint(r.ID, 10)
+}
+
+func (r *AuthEventModel) Save() error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	if r.ID == 0 {
+		return db.Create(&r).Error
+	}
+
+	return db.Save(&r).Error
+}
+
+// SetMetadata saves the metadata map as JSON
+func (r *AuthEventModel) SetMetadata(metadata map[string]interface{}) error {
+	if len(metadata) == 0 {
+		r.Metadata = nil
+		return nil
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	r.Metadata = data
+	return nil
+}
+
+// RecordAuthEvent saves one auth event with the request IP, user agent and authenticated user as actor,
+// requests made with personal access tokens or oauth2 client tokens also save the token id or client_id in the metadata,
+// then triggers the AuthEventTriggerName app event.
+// Errors are only logged, the audit log should not break the related request.
+// Events of jobs and CLIs, without one request, can be saved with one nil ctx and are not triggered
+func RecordAuthEvent(ctx *bolo.RequestContext, eventType, userID string, metadata map[string]interface{}) *AuthEventModel {
+	r := AuthEventModel{
+		Type:   eventType,
+		UserID: parseAuthEventUserID(userID),
+	}
+
+	if ctx != nil {
+		if req := ctx.Request(); req != nil {
+			r.IP = ctx.RealIP()
+			r.UserAgent = req.UserAgent()
+		}
+
+		if ctx.IsAuthenticated && ctx.AuthenticatedUser != nil {
+			r.ActorID = parseAuthEventUserID(ctx.AuthenticatedUser.GetID())
+		}
+
+		metadata = withRequestTokenMetadata(ctx, metadata)
+	}
+
+	err := r.SetMetadata(metadata)
+	if err == nil {
+		err = r.Save()
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error":  err,
+			"type":   eventType,
+			"userId": userID,
+		}).Error("RecordAuthEvent error on save auth event")
+		return nil
+	}
+
+	if ctx == nil || ctx.App == nil {
+		return &r
+	}
+
+	err, _ = ctx.App.GetEvents().Trigger(AuthEventTriggerName, event.M{
+		"ctx":   ctx,
+		"event": &r,
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"type":  eventType,
+		}).Error("RecordAuthEvent error on trigger auth event")
+	}
+
+	return &r
+}
+
+// RecordLoginFailure saves one login_failed event, userID is empty if the username was not found
+func RecordLoginFailure(ctx *bolo.RequestContext, userID, username, method, reason string) {
+	RecordAuthEvent(ctx, AuthEventLoginFailed, userID, map[string]interface{}{
+		"method":   method,
+		"reason":   reason,
+		"username": username,
+	})
+}
+
+// GetPasswordNotFoundReason returns the login_failed reason of logins without password record,
+// the user exists without password, like social signups, or the user was not found
+func GetPasswordNotFoundReason(u *UserModel) string {
+	if u != nil && u.ID != 0 {
+		return "password_not_set"
+	}
+
+	return "user_not_found"
+}
+
+func parseAuthEventUserID(userID string) *int64 {
+	if userID == "" {
+		return nil
+	}
+
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil || id == 0 {
+		return nil
+	}
+
+	return &id
+}
+
+type AuthEventQueryOpts struct {
+	UserID  string
+	ActorID string
+	Type    string
+	Since   *time.Time
+	Limit   int
+	Offset  int
+}
+
+// QueryAuthEvents returns the auth events, newest first, and the total count for the filters
+func QueryAuthEvents(opts *AuthEventQueryOpts) ([]*AuthEventModel, int64, error) {
+	var records []*AuthEventModel
+	var count int64
+
+	db := bolo.GetDefaultDatabaseConnection()
+
+	query := db.Model(&AuthEventModel{})
+
+	if opts.UserID != "" {
+		query = query.Where("userId = ?", opts.UserID)
+	}
+
+	if opts.ActorID != "" {
+		query = query.Where("actorId = ?", opts.ActorID)
+	}
+
+	if opts.Type != "" {
+		query = query.Where("type = ?", opts.Type)
+	}
+
+	if opts.Since != nil {
+		query = query.Where("createdAt >= ?", opts.Since)
+	}
+
+	err := query.Count(&count).Error
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "QueryAuthEvents error on count")
+	}
+
+	err = query.
+		Order("createdAt DESC").
+		Order("id DESC").
+		Limit(opts.Limit).
+		Offset(opts.Offset).
+		Find(&records).Error
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "QueryAuthEvents error on find")
+	}
+
+	return records, count, nil
+}
+
+// withRequestTokenMetadata returns one copy of the metadata with the "personalAccessTokenId" and "clientId"
+// of the request token, if any
+func withRequestTokenMetadata(ctx *bolo.RequestContext, metadata map[string]interface{}) map[string]interface{} {
+	tokenID, _ := ctx.Get(PersonalAccessTokenIDKey).(string)
+	clientID, _ := ctx.Get(OAuthClientIDKey).(string)
+
+	if tokenID == "" && clientID == "" {
+		return metadata
+	}
+
+	m := map[string]interface{}{}
+	for k, v := range metadata {
+		m[k] = v
+	}
+
+	if tokenID != "" {
+		m["personalAccessTokenId"] = tokenID
+	}
+
+	if clientID != "" {
+		m["clientId"] = clientID
+	}
+
+	return m
+}
+
+// DeleteUserAuthEvents deletes the events of one user, used in the user delete
+func DeleteUserAuthEvents(userID string) error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	return db.Where("userId = ?", userID).Delete(&AuthEventModel{}).Error
+}
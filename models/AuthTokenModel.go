@@ -7,6 +7,19 @@ import (
 	"github.com/go-bolo/bolo/helpers"
 )
 
+const (
+	// ResetPasswordTokenType - forgot password tokens, only valid in the reset password endpoints
+	ResetPasswordTokenType = "resetPassword"
+	// AccountActivationTokenType - signup email confirmation tokens
+	AccountActivationTokenType = "accountActivation"
+)
+
+// ResetPasswordTokenLifetime - time to use one forgot password link
+var ResetPasswordTokenLifetime = 2 * time.Hour
+
+// AccountActivationTokenLifetime - time to use one signup activation link
+var AccountActivationTokenLifetime = 7 * 24 * time.Hour
+
 type AuthTokenModel struct {
 	ID              uint64  `gorm:"primary_key;column:id;" json:"id" filter:"param:id;type:number"`
 	UserID          *string `gorm:"index:userId;column:userId;type:int(11)" json:"userId" filter:"param:userId;type:number"`
@@ -17,6 +30,8 @@ type AuthTokenModel struct {
 	Token       string `gorm:"column:token;type:VARCHAR(255)" json:"token" filter:"param:token;type:string"`
 	IsValid     bool   `gorm:"column:isValid" json:"isValid" filter:"param:isValid;type:bool"`
 	RedirectURL string `gorm:"column:redirectUrl;type:TEXT" json:"redirectUrl" filter:"param:redirectUrl;type:string"`
+	// Email address bound to the token, used in the email change tokens
+	Email string `gorm:"column:email;type:VARCHAR(255)" json:"-"`
 
 	CreatedAt time.Time `gorm:"column:createdAt;" json:"createdAt" filter:"param:createdAt"`
 	UpdatedAt time.Time `gorm:"column:updatedAt;" json:"updatedAt" filter:"param:updatedAt"`
@@ -66,6 +81,31 @@ func (r *AuthTokenModel) GetResetUrl(ctx *bolo.RequestContext, resetPrefixName s
 	return baseUrl + "/auth/" + *r.UserID + "/forgot-password/reset?t=" + r.Token + "&u=" + *r.UserID
 }
 
+func (r *AuthTokenModel) GetActivationUrl(ctx *bolo.RequestContext) string {
+	return ctx.AppOrigin + "/auth/" + *r.UserID + "/activate?t=" + r.Token
+}
+
+func (r *AuthTokenModel) GetUnlockUrl(ctx *bolo.RequestContext) string {
+	return ctx.AppOrigin + "/auth/" + *r.UserID + "/unlock?t=" + r.Token
+}
+
+func (r *AuthTokenModel) GetConfirmEmailUrl(ctx *bolo.RequestContext) string {
+	return ctx.AppOrigin + "/auth/" + *r.UserID + "/confirm-email?t=" + r.Token
+}
+
+func (r *AuthTokenModel) GetRevertEmailUrl(ctx *bolo.RequestContext) string {
+	return ctx.AppOrigin + "/auth/" + *r.UserID + "/revert-email?t=" + r.Token
+}
+
+func (r *AuthTokenModel) GetMagicLinkUrl(ctx *bolo.RequestContext) string {
+	return ctx.AppOrigin + "/auth/" + *r.UserID + "/magic-link?t=" + r.Token
+}
+
+// IsExpired checks if the token is older than the lifetime
+func (r *AuthTokenModel) IsExpired(lifetime time.Duration) bool {
+	return time.Since(r.CreatedAt) > lifetime
+}
+
 func FindInvalidOldUserTokens(uid string) ([]*AuthTokenModel, error) {
 	var tokens []*AuthTokenModel
 
@@ -100,6 +140,29 @@ func ValidAuthToken(userID string, token string) (bool, *AuthTokenModel, error)
 	return true, &authToken, nil
 }
 
+// ValidAuthTokenOfType returns the valid user token only if it has the token type and is not expired, tokens of other
+// flows like the mfa challenge or magic link can not be used. Lifetime 0 disables the expiration check
+func ValidAuthTokenOfType(userID, token, tokenType string, lifetime time.Duration) (bool, *AuthTokenModel, error) {
+	var authToken AuthTokenModel
+
+	db := bolo.GetDefaultDatabaseConnection()
+
+	err := db.Model(&AuthTokenModel{}).
+		Where("token = ? AND userId = ? AND tokenType = ?", token, userID, tokenType).
+		First(&authToken).
+		Error
+
+	if err != nil {
+		return false, nil, err
+	}
+
+	if !authToken.IsValid || (lifetime > 0 && authToken.IsExpired(lifetime)) {
+		return false, nil, nil
+	}
+
+	return true, &authToken, nil
+}
+
 func FindOneAuthToken(id string) (*AuthTokenModel, error) {
 	db := bolo.GetDefaultDatabaseConnection()
 
@@ -113,6 +176,15 @@ func FindOneAuthToken(id string) (*AuthTokenModel, error) {
 	return &token, err
 }
 
+// DeleteUserAuthTokens deletes all the user tokens with the token types
+func DeleteUserAuthTokens(userID string, tokenTypes ...string) error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	return db.Where("userId = ? AND tokenType IN ?", userID, tokenTypes).
+		Delete(&AuthTokenModel{}).
+		Error
+}
+
 func CreateAuthToken(userID, tokenType string) (*AuthTokenModel, error) {
 	t := AuthTokenModel{
 		UserID:    &userID,
@@ -0,0 +1,219 @@
+package user_models
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	user_helpers "github.com/go-bolo/user/helpers"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// KVStoreModel - one key of the DatabaseKVStore
+type KVStoreModel struct {
+	ID        string     `gorm:"primary_key;column:id;type:VARCHAR(191)" json:"id"`
+	Value     string     `gorm:"column:value;type:LONGTEXT" json:"-"`
+	ExpiresAt *time.Time `gorm:"column:expiresAt;type:datetime;index" json:"expiresAt"`
+	CreatedAt time.Time  `gorm:"column:createdAt;type:datetime;not null" json:"createdAt"`
+	UpdatedAt time.Time  `gorm:"column:updatedAt;type:datetime;not null" json:"updatedAt"`
+}
+
+func (r *KVStoreModel) TableName() string {
+	return "kv_store"
+}
+
+// NewDatabaseKVStore creates one KVStore in the kv_store table of the default database connection,
+// for apps without redis. One goroutine deletes the expired keys until the store Close
+func NewDatabaseKVStore() *DatabaseKVStore {
+	s := DatabaseKVStore{
+		stopCleanup: make(chan struct{}),
+	}
+
+	go user_helpers.RunKVStoreCleanup(s.stopCleanup, s.deleteExpired)
+
+	return &s
+}
+
+// DatabaseKVStore - user_helpers.KVStore in the kv_store table
+type DatabaseKVStore struct {
+	stopCleanup chan struct{}
+	closeOnce   sync.Once
+}
+
+func getKVExpiresAt(ttl time.Duration) *time.Time {
+	if ttl <= 0 {
+		return nil
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	return &expiresAt
+}
+
+// notExpired filters the keys without expiration or not expired
+func notExpired(db *gorm.DB) *gorm.DB {
+	return db.Where("expiresAt IS NULL OR expiresAt > ?", time.Now())
+}
+
+func (s *DatabaseKVStore) find(db *gorm.DB, key string) (*KVStoreModel, error) {
+	var record KVStoreModel
+
+	err := db.Scopes(notExpired).
+		Where("id = ?", key).
+		First(&record).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, user_helpers.ErrKVNotFound
+		}
+
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+func (s *DatabaseKVStore) Get(key string) (string, error) {
+	record, err := s.find(bolo.GetDefaultDatabaseConnection(), key)
+	if err != nil {
+		return "", err
+	}
+
+	return record.Value, nil
+}
+
+func (s *DatabaseKVStore) Set(key, value string, ttl time.Duration) error {
+	record := KVStoreModel{
+		ID:        key,
+		Value:     value,
+		ExpiresAt: getKVExpiresAt(ttl),
+	}
+
+	// upsert:
+	return bolo.GetDefaultDatabaseConnection().Save(&record).Error
+}
+
+func (s *DatabaseKVStore) GetDel(key string) (string, error) {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	record, err := s.find(db, key)
+	if err != nil {
+		return "", err
+	}
+
+	// only the request that deletes the record receives the value:
+	result := db.Where("id = ? AND value = ?", key, record.Value).Delete(&KVStoreModel{})
+	if result.Error != nil {
+		return "", result.Error
+	}
+
+	if result.RowsAffected != 1 {
+		return "", user_helpers.ErrKVNotFound
+	}
+
+	return record.Value, nil
+}
+
+func (s *DatabaseKVStore) Del(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return bolo.GetDefaultDatabaseConnection().Where("id IN ?", keys).Delete(&KVStoreModel{}).Error
+}
+
+func (s *DatabaseKVStore) Exists(keys ...string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	var count int64
+	err := bolo.GetDefaultDatabaseConnection().
+		Model(&KVStoreModel{}).
+		Scopes(notExpired).
+		Where("id IN ?", keys).
+		Count(&count).Error
+
+	return count, err
+}
+
+func (s *DatabaseKVStore) TTL(key string) (time.Duration, error) {
+	record, err := s.find(bolo.GetDefaultDatabaseConnection(), key)
+	if err != nil {
+		if errors.Is(err, user_helpers.ErrKVNotFound) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	if record.ExpiresAt == nil {
+		return 0, nil
+	}
+
+	return time.Until(*record.ExpiresAt), nil
+}
+
+// Incr increments the counter with one upsert, then parallel requests are all counted
+func (s *DatabaseKVStore) Incr(key string, ttl time.Duration) (int64, error) {
+	var count int64
+
+	err := bolo.GetDefaultDatabaseConnection().Transaction(func(tx *gorm.DB) error {
+		// expired counters start again:
+		err := tx.Where("id = ? AND expiresAt <= ?", key, time.Now()).Delete(&KVStoreModel{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"value":     gorm.Expr("value + 1"),
+				"updatedAt": time.Now(),
+			}),
+		}).Create(&KVStoreModel{
+			ID:        key,
+			Value:     "1",
+			ExpiresAt: getKVExpiresAt(ttl),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		record, err := s.find(tx, key)
+		if err != nil {
+			return err
+		}
+
+		count, err = strconv.ParseInt(record.Value, 10, 64)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (s *DatabaseKVStore) Expire(key string, ttl time.Duration) error {
+	return bolo.GetDefaultDatabaseConnection().
+		Model(&KVStoreModel{}).
+		Where("id = ?", key).
+		Update("expiresAt", getKVExpiresAt(ttl)).Error
+}
+
+// Close stops the expired keys cleanup
+func (s *DatabaseKVStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stopCleanup)
+	})
+
+	return nil
+}
+
+func (s *DatabaseKVStore) deleteExpired() error {
+	return bolo.GetDefaultDatabaseConnection().
+		Where("expiresAt <= ?", time.Now()).
+		Delete(&KVStoreModel{}).Error
+}
@@ -0,0 +1,96 @@
+package user_models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user/security"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+type MfaRecoveryCodeModel struct {
+	ID        uint64     `gorm:"primary_key;column:id;" json:"id"`
+	UserID    int64      `gorm:"column:userId;type:bigint(20);index" json:"userId"`
+	CodeHash  string     `gorm:"column:codeHash;type:VARCHAR(64)" json:"-"`
+	UsedAt    *time.Time `gorm:"column:usedAt;type:datetime" json:"usedAt"`
+	CreatedAt time.Time  `gorm:"column:createdAt;type:datetime;not null" json:"createdAt"`
+}
+
+func (r *MfaRecoveryCodeModel) TableName() string {
+	return "user_mfa_recovery_codes"
+}
+
+func HashMfaRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateMfaRecoveryCodes replaces all user recovery codes and returns the new ones in plain text.
+// Only the hashes are stored so the codes must be displayed to the user only once
+func GenerateMfaRecoveryCodes(userID string) ([]string, error) {
+	uid, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := []string{}
+	records := []*MfaRecoveryCodeModel{}
+
+	for i := 0; i < MfaRecoveryCodesCount; i++ {
+		code, err := security.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+		records = append(records, &MfaRecoveryCodeModel{
+			UserID:   uid,
+			CodeHash: HashMfaRecoveryCode(code),
+		})
+	}
+
+	db := bolo.GetDefaultDatabaseConnection()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("userId = ?", uid).Delete(&MfaRecoveryCodeModel{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&records).Error
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "GenerateMfaRecoveryCodes error on save codes")
+	}
+
+	return codes, nil
+}
+
+// UseMfaRecoveryCode marks one unused recovery code as used, returns false if the code is invalid
+func UseMfaRecoveryCode(userID string, code string) (bool, error) {
+	if strings.TrimSpace(code) == "" {
+		return false, nil
+	}
+
+	db := bolo.GetDefaultDatabaseConnection()
+
+	now := time.Now()
+	result := db.Model(&MfaRecoveryCodeModel{}).
+		Where("userId = ? AND codeHash = ? AND usedAt IS NULL", userID, HashMfaRecoveryCode(code)).
+		Update("usedAt", &now)
+	if result.Error != nil {
+		return false, errors.Wrap(result.Error, "UseMfaRecoveryCode error on update")
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// DeleteUserMfaRecoveryCodes removes all user recovery codes
+func DeleteUserMfaRecoveryCodes(userID string) error {
+	db := bolo.GetDefaultDatabaseConnection()
+	return db.Where("userId = ?", userID).Delete(&MfaRecoveryCodeModel{}).Error
+}
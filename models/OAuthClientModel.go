@@ -0,0 +1,168 @@
+package user_models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Request context key with the client_id of the oauth2 token used in the request, or of the token endpoint client
+const OAuthClientIDKey = "oauth2ClientID"
+
+// OAuthClientModel - registered oauth2 client, only the client secret hash is saved.
+// Clients without secret are public clients, like mobile and single page apps
+type OAuthClientModel struct {
+	ID       uint64 `gorm:"primary_key;column:id;" json:"id"`
+	ClientID string `gorm:"column:clientId;type:VARCHAR(100);uniqueIndex" json:"clientId"`
+	Name     string `gorm:"column:name;type:VARCHAR(100)" json:"name"`
+	// sha256 of the client secret, in hex, empty in public clients
+	SecretHash string `gorm:"column:secretHash;type:VARCHAR(64)" json:"-"`
+	// space separated grant types allowed in the token endpoint
+	Grants string `gorm:"column:grants;type:VARCHAR(255)" json:"grants"`
+	// space separated max scopes of the client tokens, empty for no limit in the user tokens
+	Scopes string `gorm:"column:scopes;type:VARCHAR(255)" json:"scopes"`
+	// space separated redirect URIs, compared with exact match
+	RedirectURIs string    `gorm:"column:redirectUris;type:TEXT" json:"redirectUris"`
+	CreatedAt    time.Time `gorm:"column:createdAt;type:datetime;not null" json:"createdAt"`
+	UpdatedAt    time.Time `gorm:"column:updatedAt;type:datetime;not null" json:"updatedAt"`
+}
+
+func (r *OAuthClientModel) TableName() string {
+	return "oauth_clients"
+}
+
+func (r *OAuthClientModel) GetID() string {
+	return strconv.FormatUint(r.ID, 10)
+}
+
+func (r *OAuthClientModel) Save() error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	if r.ID == 0 {
+		return db.Create(&r).Error
+	}
+
+	return db.Save(&r).Error
+}
+
+func (r *OAuthClientModel) Delete() error {
+	db := bolo.GetDefaultDatabaseConnection()
+	return db.Unscoped().Delete(&r).Error
+}
+
+// IsConfidential returns true for clients with secret
+func (r *OAuthClientModel) IsConfidential() bool {
+	return r.SecretHash != ""
+}
+
+// ValidSecret checks the client secret in constant time
+func (r *OAuthClientModel) ValidSecret(secret string) bool {
+	if !r.IsConfidential() || secret == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.SecretHash), []byte(hashHighEntropySecret(secret))) == 1
+}
+
+func (r *OAuthClientModel) GetGrants() []string {
+	return strings.Fields(r.Grants)
+}
+
+func (r *OAuthClientModel) GetScopes() []string {
+	return strings.Fields(r.Scopes)
+}
+
+func (r *OAuthClientModel) GetRedirectURIs() []string {
+	return strings.Fields(r.RedirectURIs)
+}
+
+// AllowsGrant returns true if the grant type is allowed for the client
+func (r *OAuthClientModel) AllowsGrant(grantType string) bool {
+	for _, g := range r.GetGrants() {
+		if g == grantType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsRedirectURI returns true if the uri is one of the client redirect URIs, without prefix or pattern matches
+func (r *OAuthClientModel) AllowsRedirectURI(uri string) bool {
+	for _, u := range r.GetRedirectURIs() {
+		if u == uri {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CreateOAuthClient creates one new client with random client_id, the returned secret is not saved and is only available here.
+// Public clients don't have secret
+func CreateOAuthClient(name string, grants, scopes, redirectURIs []string, confidential bool) (*OAuthClientModel, string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "CreateOAuthClient error on generate client id")
+	}
+
+	r := OAuthClientModel{
+		ClientID:     hex.EncodeToString(id),
+		Name:         name,
+		Grants:       strings.Join(grants, " "),
+		Scopes:       strings.Join(scopes, " "),
+		RedirectURIs: strings.Join(redirectURIs, " "),
+	}
+
+	secret := ""
+	if confidential {
+		k := make([]byte, 32)
+		_, err = rand.Read(k)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "CreateOAuthClient error on generate secret")
+		}
+
+		secret = base64.RawURLEncoding.EncodeToString(k)
+		r.SecretHash = hashHighEntropySecret(secret)
+	}
+
+	err = r.Save()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "CreateOAuthClient error on save")
+	}
+
+	return &r, secret, nil
+}
+
+// FindOAuthClientByClientID loads one client by the public client_id, r.ID will be 0 if not found
+func FindOAuthClientByClientID(clientID string, r *OAuthClientModel) error {
+	db := bolo.GetDefaultDatabaseConnection()
+	err := db.Where("clientId = ?", clientID).
+		First(r).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+// FindOAuthClients returns all the registered clients, the newest first
+func FindOAuthClients() ([]*OAuthClientModel, error) {
+	records := []*OAuthClientModel{}
+
+	db := bolo.GetDefaultDatabaseConnection()
+	err := db.Order("id DESC").Find(&records).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "FindOAuthClients error on find clients")
+	}
+
+	return records, nil
+}
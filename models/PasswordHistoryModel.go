@@ -0,0 +1,118 @@
+package user_models
+
+import (
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user/security"
+	"github.com/pkg/errors"
+)
+
+// Max old password hashes saved for each user, the password policy defines how many of them are checked
+var PasswordHistoryMaxRecords = 24
+
+// PasswordHistoryModel - one old user password hash
+type PasswordHistoryModel struct {
+	ID        uint64    `gorm:"primary_key;column:id;" json:"id"`
+	UserID    int64     `gorm:"column:userId;type:bigint(20);index" json:"userId"`
+	Password  string    `gorm:"column:password;type:text" json:"-"`
+	CreatedAt time.Time `gorm:"column:createdAt;type:datetime;not null" json:"createdAt"`
+}
+
+func (r *PasswordHistoryModel) TableName() string {
+	return "password_histories"
+}
+
+// AddPasswordHistory saves the old password hash and deletes the records over the PasswordHistoryMaxRecords
+func AddPasswordHistory(userID int64, hash string) error {
+	if hash == "" {
+		return nil
+	}
+
+	db := bolo.GetDefaultDatabaseConnection()
+
+	r := PasswordHistoryModel{
+		UserID:   userID,
+		Password: hash,
+	}
+
+	err := db.Create(&r).Error
+	if err != nil {
+		return errors.Wrap(err, "AddPasswordHistory error on create")
+	}
+
+	var ids []uint64
+	err = db.Model(&PasswordHistoryModel{}).
+		Where("userId = ?", userID).
+		Order("id DESC").
+		Offset(PasswordHistoryMaxRecords).
+		Limit(1000).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return errors.Wrap(err, "AddPasswordHistory error on find old records")
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return db.Where("id IN ?", ids).Delete(&PasswordHistoryModel{}).Error
+}
+
+// FindPasswordHistoryByUserID returns the last user password hashes, newest first
+func FindPasswordHistoryByUserID(userID string, limit int) ([]*PasswordHistoryModel, error) {
+	records := []*PasswordHistoryModel{}
+
+	db := bolo.GetDefaultDatabaseConnection()
+	err := db.Where("userId = ?", userID).
+		Order("id DESC").
+		Limit(limit).
+		Find(&records).Error
+
+	return records, err
+}
+
+// IsPasswordInHistory checks the password against the current password and the old ones, size is the number of passwords checked
+func IsPasswordInHistory(userID string, password string, size int) (bool, error) {
+	if size <= 0 {
+		return false, nil
+	}
+
+	hashes := []string{}
+
+	var current PasswordModel
+	err := FindPasswordByUserID(userID, &current)
+	if err != nil {
+		return false, err
+	}
+
+	if current.ID != 0 && current.Password != "" {
+		hashes = append(hashes, current.Password)
+	}
+
+	if size > len(hashes) {
+		records, err := FindPasswordHistoryByUserID(userID, size-len(hashes))
+		if err != nil {
+			return false, err
+		}
+
+		for _, r := range records {
+			hashes = append(hashes, r.Password)
+		}
+	}
+
+	for _, hash := range hashes {
+		if security.VerifyPassword(password, hash) == nil {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// DeleteUserPasswordHistory deletes all old password hashes of the user
+func DeleteUserPasswordHistory(userID string) error {
+	db := bolo.GetDefaultDatabaseConnection()
+	return db.Where("userId = ?", userID).
+		Delete(&PasswordHistoryModel{}).Error
+}
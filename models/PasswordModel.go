@@ -4,12 +4,11 @@ import (
 	"strconv"
 
 	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user/security"
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
 
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 type PasswordModel struct {
@@ -40,34 +39,54 @@ func (r *PasswordModel) SetPassword(password string) error {
 
 	return nil
 }
+
+// Compare checks the password with the hasher of the saved hash, returns bcrypt.ErrMismatchedHashAndPassword if it does not match
 func (r *PasswordModel) Compare(password string) (bool, error) {
 	if r.Password == "" {
 		// 404 or empty
 		return false, errors.New("password should not be empty")
 	}
 
-	hashedPassword := []byte(r.Password)
-
-	err := bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	err := security.VerifyPassword(password, r.Password)
 	if err != nil {
-		return false, errors.Wrap(err, "Error on bcrypt.CompareHashAndPassword")
+		return false, errors.Wrap(err, "Error on PasswordModel.Compare")
 	}
 
 	return true, nil
 }
 
+// Generate creates the password hash with the default password hasher
 func (r *PasswordModel) Generate(password string) ([]byte, error) {
 	if password == "" {
 		// 404 or empty
 		return nil, errors.New("password should not be empty")
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := security.HashPassword(password)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error on security.HashPassword")
+	}
+
+	return []byte(hashedPassword), nil
+}
+
+// NeedsRehash checks if the saved hash uses one outdated algorithm or cost
+func (r *PasswordModel) NeedsRehash() bool {
+	return r.Password != "" && security.PasswordNeedsRehash(r.Password)
+}
+
+// Rehash saves the password with the default hasher, the password should be valid.
+// The old hash is not added to the password history because the password is the same
+func (r *PasswordModel) Rehash(password string) error {
+	err := r.SetPassword(password)
 	if err != nil {
-		return nil, errors.Wrap(err, "Error on bcrypt.GenerateFromPassword")
+		return err
 	}
 
-	return hashedPassword, nil
+	db := bolo.GetDefaultDatabaseConnection()
+	return db.Model(&PasswordModel{}).
+		Where("id = ?", r.ID).
+		Update("password", r.Password).Error
 }
 
 func (r *PasswordModel) Save() error {
@@ -138,10 +157,20 @@ func UpdateUserPasswordByUserID(userID string, password string) error {
 		record.UserID = &v
 	}
 
+	oldHash := record.Password
+
 	err = record.SetPassword(password)
 	if err != nil {
 		return err
 	}
 
+	// keep the old hash to block password reuse:
+	if oldHash != "" && record.UserID != nil {
+		err = AddPasswordHistory(*record.UserID, oldHash)
+		if err != nil {
+			return err
+		}
+	}
+
 	return record.Save()
 }
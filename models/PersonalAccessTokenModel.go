@@ -0,0 +1,175 @@
+package user_models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// PersonalAccessTokenPrefix - prefix of all personal access tokens, used to select the token authentication
+const PersonalAccessTokenPrefix = "pat_"
+
+// Request context key with the id of the personal access token used in the request
+const PersonalAccessTokenIDKey = "personalAccessTokenID"
+
+// Min interval between lastUsedAt updates, avoids one database write for every request
+var PersonalAccessTokenTouchInterval = time.Minute
+
+// PersonalAccessTokenModel - long-lived API token of one user or service account, only the token hash is saved
+type PersonalAccessTokenModel struct {
+	ID     uint64 `gorm:"primary_key;column:id;" json:"id"`
+	UserID uint64 `gorm:"column:userId;index" json:"userId"`
+	Name   string `gorm:"column:name;type:VARCHAR(100)" json:"name"`
+	// first token chars, to identify the token in the list
+	TokenPrefix string `gorm:"column:tokenPrefix;type:VARCHAR(20)" json:"tokenPrefix"`
+	// sha256 of the token, in hex
+	TokenHash string `gorm:"column:tokenHash;type:VARCHAR(64);uniqueIndex" json:"-"`
+	// space separated oauth2 scopes, empty for the full user rights
+	Scopes     string     `gorm:"column:scopes;type:VARCHAR(255)" json:"scopes"`
+	ExpiresAt  *time.Time `gorm:"column:expiresAt;type:datetime" json:"expiresAt"`
+	LastUsedAt *time.Time `gorm:"column:lastUsedAt;type:datetime" json:"lastUsedAt"`
+	LastUsedIP string     `gorm:"column:lastUsedIP;type:VARCHAR(45)" json:"lastUsedIP"`
+	CreatedAt  time.Time  `gorm:"column:createdAt;type:datetime;not null" json:"createdAt"`
+	UpdatedAt  time.Time  `gorm:"column:updatedAt;type:datetime;not null" json:"updatedAt"`
+}
+
+func (r *PersonalAccessTokenModel) TableName() string {
+	return "personal_access_tokens"
+}
+
+func (r *PersonalAccessTokenModel) GetID() string {
+	return strconv.FormatUint(r.ID, 10)
+}
+
+func (r *PersonalAccessTokenModel) Save() error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	if r.ID == 0 {
+		return db.Create(&r).Error
+	}
+
+	return db.Save(&r).Error
+}
+
+func (r *PersonalAccessTokenModel) Delete() error {
+	db := bolo.GetDefaultDatabaseConnection()
+	return db.Unscoped().Delete(&r).Error
+}
+
+// GetScopes returns the token scopes list
+func (r *PersonalAccessTokenModel) GetScopes() []string {
+	return strings.Fields(r.Scopes)
+}
+
+// IsExpired returns true after the expiresAt, tokens without expiresAt never expire
+func (r *PersonalAccessTokenModel) IsExpired() bool {
+	return r.ExpiresAt != nil && time.Now().After(*r.ExpiresAt)
+}
+
+// Touch updates the last used data, the update is skipped if the token was used recently
+func (r *PersonalAccessTokenModel) Touch(ip string) error {
+	now := time.Now()
+
+	if r.LastUsedAt != nil && now.Sub(*r.LastUsedAt) < PersonalAccessTokenTouchInterval && r.LastUsedIP == ip {
+		return nil
+	}
+
+	r.LastUsedAt = &now
+	r.LastUsedIP = ip
+
+	db := bolo.GetDefaultDatabaseConnection()
+	return db.Model(r).Updates(map[string]interface{}{
+		"lastUsedAt": now,
+		"lastUsedIP": ip,
+	}).Error
+}
+
+// CreatePersonalAccessToken creates one new token, the returned plain token is not saved and is only available here
+func CreatePersonalAccessToken(userID, name string, scopes []string, expiresAt *time.Time) (*PersonalAccessTokenModel, string, error) {
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "CreatePersonalAccessToken invalid user id")
+	}
+
+	k := make([]byte, 32)
+	_, err = rand.Read(k)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "CreatePersonalAccessToken error on generate token")
+	}
+
+	token := PersonalAccessTokenPrefix + base64.RawURLEncoding.EncodeToString(k)
+
+	r := PersonalAccessTokenModel{
+		UserID:      uid,
+		Name:        name,
+		TokenPrefix: token[:len(PersonalAccessTokenPrefix)+8],
+		TokenHash:   hashHighEntropySecret(token),
+		Scopes:      strings.Join(scopes, " "),
+		ExpiresAt:   expiresAt,
+	}
+
+	err = r.Save()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "CreatePersonalAccessToken error on save")
+	}
+
+	return &r, token, nil
+}
+
+// hashHighEntropySecret returns the hex sha256 saved in place of the generated tokens and client secrets.
+// They have 256 random bits, then one fast hash is enough and allows the lookup by hash
+func hashHighEntropySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// FindPersonalAccessTokenByToken loads one token by the plain token, r.ID will be 0 if not found
+func FindPersonalAccessTokenByToken(token string, r *PersonalAccessTokenModel) error {
+	db := bolo.GetDefaultDatabaseConnection()
+	err := db.Where("tokenHash = ?", hashHighEntropySecret(token)).
+		First(r).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+// FindUserPersonalAccessToken loads one token of the user, r.ID will be 0 if not found
+func FindUserPersonalAccessToken(userID, id string, r *PersonalAccessTokenModel) error {
+	db := bolo.GetDefaultDatabaseConnection()
+	err := db.Where("id = ? AND userId = ?", id, userID).
+		First(r).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+// FindPersonalAccessTokensByUserID returns the user tokens, the newest first
+func FindPersonalAccessTokensByUserID(userID string) ([]*PersonalAccessTokenModel, error) {
+	records := []*PersonalAccessTokenModel{}
+
+	db := bolo.GetDefaultDatabaseConnection()
+	err := db.Where("userId = ?", userID).
+		Order("id DESC").
+		Find(&records).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "FindPersonalAccessTokensByUserID error on find tokens")
+	}
+
+	return records, nil
+}
+
+// DeleteUserPersonalAccessTokens revokes all the user tokens, used on user delete and with the RevokeUserCredentials
+func DeleteUserPersonalAccessTokens(userID string) error {
+	db := bolo.GetDefaultDatabaseConnection()
+	return db.Where("userId = ?", userID).Delete(&PersonalAccessTokenModel{}).Error
+}
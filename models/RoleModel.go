@@ -0,0 +1,162 @@
+package user_models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// RoleModel - role saved in the database, merged with the static app roles in the bootstrap.
+// Roles from the static configuration are also saved here after one permission change
+type RoleModel struct {
+	ID            uint64    `gorm:"primary_key;column:id;" json:"id"`
+	Name          string    `gorm:"column:name;type:VARCHAR(100);uniqueIndex" json:"name"`
+	CanAddInUsers bool      `gorm:"column:canAddInUsers;default:0" json:"canAddInUsers"`
+	CreatedAt     time.Time `gorm:"column:createdAt;type:datetime;not null" json:"createdAt"`
+	UpdatedAt     time.Time `gorm:"column:updatedAt;type:datetime;not null" json:"updatedAt"`
+
+	Permissions []string `gorm:"-" json:"permissions"`
+}
+
+func (r *RoleModel) TableName() string {
+	return "roles"
+}
+
+func (r *RoleModel) GetID() string {
+	return strconv.FormatUint(r.ID, 10)
+}
+
+// RolePermissionModel - one permission of one database role
+type RolePermissionModel struct {
+	ID         uint64 `gorm:"primary_key;column:id;" json:"id"`
+	RoleID     uint64 `gorm:"column:roleId;uniqueIndex:role_permissions_roleId_permission" json:"roleId"`
+	Permission string `gorm:"column:permission;type:VARCHAR(100);uniqueIndex:role_permissions_roleId_permission" json:"permission"`
+}
+
+func (r *RolePermissionModel) TableName() string {
+	return "role_permissions"
+}
+
+// SaveRole creates or updates the role and replaces all the role permissions
+func SaveRole(r *RoleModel) error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var current RoleModel
+		err := tx.Where("name = ?", r.Name).Limit(1).Find(&current).Error
+		if err != nil {
+			return errors.Wrap(err, "SaveRole error on find role")
+		}
+
+		r.ID = current.ID
+		if r.ID == 0 {
+			err = tx.Create(r).Error
+		} else {
+			r.CreatedAt = current.CreatedAt
+			err = tx.Save(r).Error
+		}
+		if err != nil {
+			return errors.Wrap(err, "SaveRole error on save role")
+		}
+
+		err = tx.Where("roleId = ?", r.ID).Delete(&RolePermissionModel{}).Error
+		if err != nil {
+			return errors.Wrap(err, "SaveRole error on delete old permissions")
+		}
+
+		for _, p := range uniqueStrings(r.Permissions) {
+			err = tx.Create(&RolePermissionModel{RoleID: r.ID, Permission: p}).Error
+			if err != nil {
+				return errors.Wrap(err, "SaveRole error on create permission")
+			}
+		}
+
+		return nil
+	})
+}
+
+// FindRoles returns all database roles with the permissions
+func FindRoles() ([]*RoleModel, error) {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	records := []*RoleModel{}
+	err := db.Order("name ASC").Find(&records).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "FindRoles error on find roles")
+	}
+
+	if len(records) == 0 {
+		return records, nil
+	}
+
+	permissions := []*RolePermissionModel{}
+	err = db.Order("id ASC").Find(&permissions).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "FindRoles error on find permissions")
+	}
+
+	byID := map[uint64]*RoleModel{}
+	for _, r := range records {
+		r.Permissions = []string{}
+		byID[r.ID] = r
+	}
+
+	for _, p := range permissions {
+		if r, ok := byID[p.RoleID]; ok {
+			r.Permissions = append(r.Permissions, p.Permission)
+		}
+	}
+
+	return records, nil
+}
+
+// DeleteRole deletes the database role, the role permissions and removes the role from all users
+func DeleteRole(name string) error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var r RoleModel
+		err := tx.Where("name = ?", name).Limit(1).Find(&r).Error
+		if err != nil {
+			return errors.Wrap(err, "DeleteRole error on find role")
+		}
+
+		if r.ID != 0 {
+			err = tx.Where("roleId = ?", r.ID).Delete(&RolePermissionModel{}).Error
+			if err != nil {
+				return errors.Wrap(err, "DeleteRole error on delete permissions")
+			}
+
+			err = tx.Delete(&r).Error
+			if err != nil {
+				return errors.Wrap(err, "DeleteRole error on delete role")
+			}
+		}
+
+		// users with one unknown role would break the permission checks:
+		err = tx.Where("role = ?", name).Delete(&UserRoleModel{}).Error
+		if err != nil {
+			return errors.Wrap(err, "DeleteRole error on delete user roles")
+		}
+
+		return nil
+	})
+}
+
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+
+	for _, v := range values {
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		result = append(result, v)
+	}
+
+	return result
+}
@@ -0,0 +1,16 @@
+package user_models
+
+import "time"
+
+// SessionModel - web session data used in the database session store
+type SessionModel struct {
+	ID        string    `gorm:"primary_key;column:id;type:VARCHAR(128)" json:"id"`
+	Data      string    `gorm:"column:data;type:LONGTEXT" json:"-"`
+	ExpiresAt time.Time `gorm:"column:expiresAt;type:datetime;index" json:"expiresAt"`
+	CreatedAt time.Time `gorm:"column:createdAt;type:datetime;not null" json:"createdAt"`
+	UpdatedAt time.Time `gorm:"column:updatedAt;type:datetime;not null" json:"updatedAt"`
+}
+
+func (r *SessionModel) TableName() string {
+	return "sessions"
+}
@@ -0,0 +1,123 @@
+package user_models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SigningKeyStatusActive - the key that signs new tokens, only one key is active
+	SigningKeyStatusActive = "active"
+	// SigningKeyStatusInactive - rotated keys, only used to verify tokens signed before the rotation
+	SigningKeyStatusInactive = "inactive"
+	// SigningKeyStatusRetired - keys that are not published or accepted anymore
+	SigningKeyStatusRetired = "retired"
+)
+
+// SigningKeyModel - one asymmetric key used to sign the JWT access tokens, see the oauth2_password KeyManager
+type SigningKeyModel struct {
+	ID        uint64 `gorm:"primary_key;column:id;" json:"id"`
+	Kid       string `gorm:"column:kid;type:VARCHAR(100);uniqueIndex" json:"kid"`
+	Algorithm string `gorm:"column:algorithm;type:VARCHAR(20)" json:"algorithm"`
+	Status    string `gorm:"column:status;type:VARCHAR(20);index" json:"status"`
+	// true in the active key and null in the others, the unique index allows only one active key
+	ActiveSlot *bool `gorm:"column:activeSlot;uniqueIndex" json:"-"`
+	// PKCS8 private key encrypted with the OAUTH2_JWT_KEY_ENCRYPTION_KEY, see the oauth2_password KeyManager
+	PrivateKey string `gorm:"column:privateKey;type:TEXT" json:"-"`
+	// PKIX PEM public key
+	PublicKey string `gorm:"column:publicKey;type:TEXT" json:"publicKey"`
+	// rotation time of the active key and retirement time of the inactive keys
+	NotAfter  time.Time `gorm:"column:notAfter;type:datetime;not null" json:"notAfter"`
+	CreatedAt time.Time `gorm:"column:createdAt;type:datetime;not null" json:"createdAt"`
+	UpdatedAt time.Time `gorm:"column:updatedAt;type:datetime;not null" json:"updatedAt"`
+}
+
+func (r *SigningKeyModel) TableName() string {
+	return "signing_keys"
+}
+
+func (r *SigningKeyModel) GetID() string {
+	return strconv.FormatUint(r.ID, 10)
+}
+
+func (r *SigningKeyModel) Save() error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	if r.ID == 0 {
+		return db.Create(&r).Error
+	}
+
+	return db.Save(&r).Error
+}
+
+// FindSigningKeys returns the active and inactive keys, the newest first
+func FindSigningKeys() ([]*SigningKeyModel, error) {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	records := []*SigningKeyModel{}
+	err := db.Where("status IN ?", []string{SigningKeyStatusActive, SigningKeyStatusInactive}).
+		Order("id DESC").
+		Find(&records).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "FindSigningKeys error on find keys")
+	}
+
+	return records, nil
+}
+
+// DeactivateSigningKey moves one active key to inactive until the notAfter, returns false if the key
+// was already rotated, like in concurrent rotations from other app instances
+func DeactivateSigningKey(id uint64, notAfter time.Time) (bool, error) {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	result := db.Model(&SigningKeyModel{}).
+		Where("id = ? AND status = ?", id, SigningKeyStatusActive).
+		Updates(map[string]interface{}{
+			"status":     SigningKeyStatusInactive,
+			"activeSlot": nil,
+			"notAfter":   notAfter,
+			"updatedAt":  time.Now(),
+		})
+	if result.Error != nil {
+		return false, errors.Wrap(result.Error, "DeactivateSigningKey error on update key")
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// RetireExpiredSigningKeys retires the inactive keys after the notAfter
+func RetireExpiredSigningKeys() error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	err := db.Model(&SigningKeyModel{}).
+		Where("status = ? AND notAfter < ?", SigningKeyStatusInactive, time.Now()).
+		Updates(map[string]interface{}{
+			"status":    SigningKeyStatusRetired,
+			"updatedAt": time.Now(),
+		}).Error
+	if err != nil {
+		return errors.Wrap(err, "RetireExpiredSigningKeys error on update keys")
+	}
+
+	return nil
+}
+
+// UpdateSigningKeyPrivateKey only updates the saved private key, used to encrypt keys saved before the encryption
+func UpdateSigningKeyPrivateKey(id uint64, privateKey string) error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	err := db.Model(&SigningKeyModel{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"privateKey": privateKey,
+			"updatedAt":  time.Now(),
+		}).Error
+	if err != nil {
+		return errors.Wrap(err, "UpdateSigningKeyPrivateKey error on update key")
+	}
+
+	return nil
+}
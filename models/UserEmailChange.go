@@ -0,0 +1,149 @@
+package user_models
+
+import (
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/pkg/errors"
+)
+
+// Auth token types used in the email change links
+const (
+	// Sent to the new address, swaps the user email after the click
+	EmailChangeTokenType = "emailChange"
+	// Sent to the old address, restores the old user email
+	EmailChangeRevertTokenType = "emailChangeRevert"
+)
+
+var (
+	// EmailChangeTokenLifetime - time to confirm the new address
+	EmailChangeTokenLifetime = 24 * time.Hour
+	// EmailChangeRevertTokenLifetime - time to revert one email change from the old address
+	EmailChangeRevertTokenLifetime = 72 * time.Hour
+)
+
+// ValidEmail checks if the value is one plain email address, without the display name
+func ValidEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return false
+	}
+
+	return addr.Address == email
+}
+
+// NormalizeEmail trims and lowercases the email address
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// IsEmailInUse checks if the email is used by other user than the exceptUserID
+func IsEmailInUse(email string, exceptUserID uint64) (bool, error) {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	var count int64
+	err := db.Model(&UserModel{}).
+		Where("email = ? AND id != ?", email, exceptUserID).
+		Count(&count).Error
+	if err != nil {
+		return false, errors.Wrap(err, "IsEmailInUse error on count users")
+	}
+
+	return count > 0, nil
+}
+
+// RequestEmailChange saves the new address as the pending ConfirmEmail and creates the confirmation token.
+// Old confirmation tokens are deleted, then only the last requested address can be confirmed
+func (r *UserModel) RequestEmailChange(newEmail string) (*AuthTokenModel, error) {
+	err := DeleteUserAuthTokens(r.GetID(), EmailChangeTokenType)
+	if err != nil {
+		return nil, errors.Wrap(err, "RequestEmailChange error on delete old tokens")
+	}
+
+	r.ConfirmEmail = newEmail
+	err = r.saveEmailState()
+	if err != nil {
+		return nil, errors.Wrap(err, "RequestEmailChange error on save pending email")
+	}
+
+	userID := r.GetID()
+	token := AuthTokenModel{
+		UserID:    &userID,
+		TokenType: EmailChangeTokenType,
+		IsValid:   true,
+		Email:     newEmail,
+	}
+
+	err = token.Save()
+	if err != nil {
+		return nil, errors.Wrap(err, "RequestEmailChange error on create token")
+	}
+
+	return &token, nil
+}
+
+// ConfirmEmailChange swaps the user email with the confirmed token address
+// and returns the revert token for the old address
+func (r *UserModel) ConfirmEmailChange(token *AuthTokenModel) (*AuthTokenModel, error) {
+	oldEmail := r.Email
+
+	r.Email = token.Email
+	r.ConfirmEmail = ""
+	r.EmailVerified = true
+	err := r.saveEmailState()
+	if err != nil {
+		return nil, errors.Wrap(err, "ConfirmEmailChange error on save email")
+	}
+
+	err = DeleteUserAuthTokens(r.GetID(), EmailChangeTokenType)
+	if err != nil {
+		return nil, errors.Wrap(err, "ConfirmEmailChange error on delete tokens")
+	}
+
+	userID := r.GetID()
+	revertToken := AuthTokenModel{
+		UserID:    &userID,
+		TokenType: EmailChangeRevertTokenType,
+		IsValid:   true,
+		Email:     oldEmail,
+	}
+
+	err = revertToken.Save()
+	if err != nil {
+		return nil, errors.Wrap(err, "ConfirmEmailChange error on create revert token")
+	}
+
+	return &revertToken, nil
+}
+
+// RevertEmailChange restores the old user email from the revert token and deletes all the email change tokens
+func (r *UserModel) RevertEmailChange(token *AuthTokenModel) error {
+	r.Email = token.Email
+	r.ConfirmEmail = ""
+	r.EmailVerified = true
+	err := r.saveEmailState()
+	if err != nil {
+		return errors.Wrap(err, "RevertEmailChange error on save email")
+	}
+
+	err = DeleteUserAuthTokens(r.GetID(), EmailChangeTokenType, EmailChangeRevertTokenType)
+	if err != nil {
+		return errors.Wrap(err, "RevertEmailChange error on delete tokens")
+	}
+
+	return nil
+}
+
+func (r *UserModel) saveEmailState() error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	return db.Model(&UserModel{}).
+		Where("id = ?", r.ID).
+		Updates(map[string]interface{}{
+			"email":         r.Email,
+			"confirmEmail":  r.ConfirmEmail,
+			"emailVerified": r.EmailVerified,
+		}).Error
+}
@@ -0,0 +1,98 @@
+package user_models
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// UserIdentityModel - one external provider account linked to one local user
+type UserIdentityModel struct {
+	ID             uint64 `gorm:"primary_key;column:id;" json:"id"`
+	UserID         int64  `gorm:"column:userId;type:bigint(20);index" json:"userId"`
+	Provider       string `gorm:"column:provider;type:VARCHAR(50);uniqueIndex:user_identities_provider_user" json:"provider"`
+	ProviderUserID string `gorm:"column:providerUserId;type:VARCHAR(255);uniqueIndex:user_identities_provider_user" json:"providerUserId"`
+	Email          string `gorm:"column:email;type:VARCHAR(255)" json:"email"`
+	EmailVerified  bool   `gorm:"column:emailVerified" json:"emailVerified"`
+	// Raw provider profile claims in JSON
+	Profile   string    `gorm:"column:profile;type:TEXT" json:"-"`
+	CreatedAt time.Time `gorm:"column:createdAt;type:datetime;not null" json:"createdAt"`
+	UpdatedAt time.Time `gorm:"column:updatedAt;type:datetime;not null" json:"updatedAt"`
+}
+
+func (r *UserIdentityModel) TableName() string {
+	return "user_identities"
+}
+
+func (r *UserIdentityModel) GetID() string {
+	return strconv.FormatUint(r.ID, 10)
+}
+
+func (r *UserIdentityModel) GetUserID() string {
+	return strconv.FormatInt(r.UserID, 10)
+}
+
+// SetProfile saves the raw provider claims
+func (r *UserIdentityModel) SetProfile(claims map[string]interface{}) error {
+	if claims == nil {
+		r.Profile = ""
+		return nil
+	}
+
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return errors.Wrap(err, "UserIdentityModel.SetProfile error on marshal claims")
+	}
+
+	r.Profile = string(data)
+	return nil
+}
+
+func (r *UserIdentityModel) Save() error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	if r.ID == 0 {
+		return db.Create(&r).Error
+	}
+
+	return db.Save(&r).Error
+}
+
+func (r *UserIdentityModel) Delete() error {
+	db := bolo.GetDefaultDatabaseConnection()
+	return db.Unscoped().Delete(&r).Error
+}
+
+// FindUserIdentity loads the identity by provider and provider user id, r.ID will be 0 if not found
+func FindUserIdentity(provider, providerUserID string, r *UserIdentityModel) error {
+	db := bolo.GetDefaultDatabaseConnection()
+	err := db.Where("provider = ? AND providerUserId = ?", provider, providerUserID).
+		First(r).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+// FindUserIdentitiesByUserID returns all identities linked to the user
+func FindUserIdentitiesByUserID(userID string) ([]*UserIdentityModel, error) {
+	records := []*UserIdentityModel{}
+
+	db := bolo.GetDefaultDatabaseConnection()
+	err := db.Where("userId = ?", userID).
+		Order("createdAt ASC").
+		Find(&records).Error
+
+	return records, err
+}
+
+// DeleteUserIdentities unlinks all user identities
+func DeleteUserIdentities(userID string) error {
+	db := bolo.GetDefaultDatabaseConnection()
+	return db.Where("userId = ?", userID).
+		Delete(&UserIdentityModel{}).Error
+}
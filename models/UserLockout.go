@@ -0,0 +1,198 @@
+package user_models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user/security"
+	"github.com/gookit/event"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Event triggered after one account lock, with the "user" and "ctx" data
+const UserLockedEventName = "user-locked"
+
+// Auth token type used in the emailed unlock link
+const AccountUnlockTokenType = "accountUnlock"
+
+// AccountUnlockTokenLifetime - time to use one emailed unlock link
+var AccountUnlockTokenLifetime = 24 * time.Hour
+
+// IsLocked checks if the account is temporarily locked after repeated login failures
+func (r *UserModel) IsLocked() bool {
+	return r.LockedUntil != nil && time.Now().Before(*r.LockedUntil)
+}
+
+// RegisterLoginFailure counts one failed login and locks the account if the lockout max failures is reached.
+// The count is incremented in the database and the lock is decided from the saved value, then concurrent
+// failures are all counted and only one of them locks the account. Returns true if the account was locked by this failure
+func (r *UserModel) RegisterLoginFailure(ip string, lockout *security.AccountLockout) (bool, error) {
+	if r.ID == 0 || !lockout.IsEnabled() {
+		return false, nil
+	}
+
+	db := bolo.GetDefaultDatabaseConnection()
+	now := time.Now()
+
+	err := db.Model(&UserModel{}).
+		Where("id = ? AND lastFailedLoginAt < ?", r.ID, now.Add(-lockout.ResetTime)).
+		Update("failedLoginCount", 0).Error
+	if err != nil {
+		return false, errors.Wrap(err, "RegisterLoginFailure error on reset old failures")
+	}
+
+	err = db.Model(&UserModel{}).
+		Where("id = ?", r.ID).
+		Updates(map[string]interface{}{
+			"failedLoginCount":  gorm.Expr("failedLoginCount + 1"),
+			"lastFailedLoginAt": now,
+			"lastFailedLoginIP": ip,
+		}).Error
+	if err != nil {
+		return false, errors.Wrap(err, "RegisterLoginFailure error on count failure")
+	}
+
+	err = r.loadLockoutState()
+	if err != nil {
+		return false, err
+	}
+
+	if r.FailedLoginCount < lockout.MaxFailures {
+		return false, nil
+	}
+
+	// only the request that still sees the read count locks the account:
+	lockedUntil := now.Add(lockout.GetLockDuration(r.LockoutCount))
+	result := db.Model(&UserModel{}).
+		Where("id = ? AND failedLoginCount = ?", r.ID, r.FailedLoginCount).
+		Updates(map[string]interface{}{
+			"failedLoginCount": 0,
+			"lockedUntil":      lockedUntil,
+			"lockoutCount":     gorm.Expr("lockoutCount + 1"),
+		})
+	if result.Error != nil {
+		return false, errors.Wrap(result.Error, "RegisterLoginFailure error on lock")
+	}
+
+	err = r.loadLockoutState()
+	if err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// loadLockoutState reloads the lockout columns saved in the database
+func (r *UserModel) loadLockoutState() error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	state := UserModel{}
+	err := db.Select("failedLoginCount", "lastFailedLoginAt", "lastFailedLoginIP", "lockedUntil", "lockoutCount").
+		Where("id = ?", r.ID).
+		First(&state).Error
+	if err != nil {
+		return errors.Wrap(err, "loadLockoutState error on find user")
+	}
+
+	r.FailedLoginCount = state.FailedLoginCount
+	r.LastFailedLoginAt = state.LastFailedLoginAt
+	r.LastFailedLoginIP = state.LastFailedLoginIP
+	r.LockedUntil = state.LockedUntil
+	r.LockoutCount = state.LockoutCount
+
+	return nil
+}
+
+// ResetLoginFailures clears the failures count and the lock, used after valid logins and in the unlock
+func (r *UserModel) ResetLoginFailures() error {
+	if r.FailedLoginCount == 0 && r.LockoutCount == 0 && r.LockedUntil == nil {
+		return nil
+	}
+
+	r.FailedLoginCount = 0
+	r.LockoutCount = 0
+	r.LockedUntil = nil
+
+	return r.saveLockoutState()
+}
+
+// saveLockoutState only updates the lockout columns to not override other user changes
+func (r *UserModel) saveLockoutState() error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	return db.Model(&UserModel{}).
+		Where("id = ?", r.ID).
+		Updates(map[string]interface{}{
+			"failedLoginCount":  r.FailedLoginCount,
+			"lastFailedLoginAt": r.LastFailedLoginAt,
+			"lastFailedLoginIP": r.LastFailedLoginIP,
+			"lockedUntil":       r.LockedUntil,
+			"lockoutCount":      r.LockoutCount,
+		}).Error
+}
+
+// OnUserLoginFail registers one failed login in the user record and triggers the UserLockedEventName if the account was locked.
+// Errors are only logged to not change the login response, returns true if the account was locked
+func OnUserLoginFail(ctx *bolo.RequestContext, u *UserModel) bool {
+	locked, err := u.RegisterLoginFailure(ctx.RealIP(), security.GetAppAccountLockout(ctx.App))
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error":  err,
+			"userId": u.ID,
+		}).Error("OnUserLoginFail error on register login failure")
+		return false
+	}
+
+	if locked {
+		RecordAuthEvent(ctx, AuthEventAccountLocked, u.GetID(), map[string]interface{}{
+			"lockedUntil":  u.LockedUntil,
+			"lockoutCount": u.LockoutCount,
+		})
+
+		err, _ = ctx.App.GetEvents().Trigger(UserLockedEventName, event.M{
+			"ctx":  ctx,
+			"user": u,
+		})
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":  err,
+				"userId": u.ID,
+			}).Error("OnUserLoginFail error on trigger user locked event")
+		}
+	}
+
+	return locked
+}
+
+// OnUserLoginSuccess clears the user lockout state and the login throttle of the user password logins,
+// with the optional throttleKeys of the current login step. Call it only after the last login step, then
+// one valid password of users with two-factor authentication does not reset the counters before the second factor
+func OnUserLoginSuccess(ctx *bolo.RequestContext, u *UserModel, throttleKeys ...string) {
+	err := u.ResetLoginFailures()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error":  err,
+			"userId": u.ID,
+		}).Error("OnUserLoginSuccess error on reset login failures")
+	}
+
+	throttle := security.GetAppLoginThrottle(ctx.App)
+	if throttle == nil {
+		return
+	}
+
+	// the password logins use the email or username as the throttle key:
+	keys := append([]string{strings.ToLower(u.Email), strings.ToLower(u.Username)}, throttleKeys...)
+	for _, key := range keys {
+		err = throttle.OnLoginSuccess(key, ctx)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":  err,
+				"userId": u.ID,
+			}).Error("OnUserLoginSuccess error on reset login throttle")
+		}
+	}
+}
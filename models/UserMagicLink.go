@@ -0,0 +1,50 @@
+package user_models
+
+import (
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/pkg/errors"
+)
+
+// Auth token type used in the passwordless login links
+const MagicLinkTokenType = "magicLink"
+
+// MagicLinkTTL - default magic link lifetime, used if the AUTH_MAGIC_LINK_TTL configuration is not set
+var MagicLinkTTL = 15 * time.Minute
+
+// GetMagicLinkTTL returns the magic link lifetime from the app configuration
+func GetMagicLinkTTL(app bolo.App) time.Duration {
+	minutes := app.GetConfiguration().GetInt64F("AUTH_MAGIC_LINK_TTL", int64(MagicLinkTTL/time.Minute))
+	return time.Duration(minutes) * time.Minute
+}
+
+// IsMagicLinkEnabled checks if the passwordless login is enabled with the AUTH_MAGIC_LINK_ENABLED configuration
+func IsMagicLinkEnabled(app bolo.App) bool {
+	return app.GetConfiguration().GetBoolF("AUTH_MAGIC_LINK_ENABLED", false)
+}
+
+// CreateMagicLinkToken creates one login token, old user login tokens are deleted then only the last link is valid
+func CreateMagicLinkToken(userID string) (*AuthTokenModel, error) {
+	err := DeleteUserAuthTokens(userID, MagicLinkTokenType)
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateMagicLinkToken error on delete old tokens")
+	}
+
+	return CreateAuthToken(userID, MagicLinkTokenType)
+}
+
+// ConsumeMagicLinkToken deletes the user login token and returns true if it was valid and not expired.
+// Tokens are single use, the delete is the check then only one of the concurrent requests with the same token is valid
+func ConsumeMagicLinkToken(userID, token string, ttl time.Duration) (bool, error) {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	result := db.
+		Where("userId = ? AND token = ? AND tokenType = ? AND isValid = ? AND createdAt > ?", userID, token, MagicLinkTokenType, true, time.Now().Add(-ttl)).
+		Delete(&AuthTokenModel{})
+	if result.Error != nil {
+		return false, errors.Wrap(result.Error, "ConsumeMagicLinkToken error on delete token")
+	}
+
+	return result.RowsAffected == 1, nil
+}
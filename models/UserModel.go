@@ -1,14 +1,15 @@
 package user_models
 
 import (
-	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-bolo/bolo"
 	"github.com/go-bolo/bolo/helpers"
 	"github.com/go-bolo/clock"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -33,13 +34,25 @@ type UserModel struct {
 
 	Language     string `gorm:"column:language;" json:"language" filter:"param:language;type:string"`
 	ConfirmEmail string `gorm:"column:confirmEmail;" json:"confirmEmail"`
+	// EmailVerified is true after one confirmation link sent to the current email or one verified provider email
+	EmailVerified bool `gorm:"column:emailVerified;default:false;" json:"emailVerified"`
 
 	AcceptTerms bool   `gorm:"column:acceptTerms;" json:"acceptTerms"`
 	Birthdate   string `gorm:"column:birthdate;" json:"birthdate" filter:"param:birthdate;type:date"`
 	Phone       string `gorm:"column:phone;" json:"phone" filter:"param:phone;type:string"`
 
-	Roles     []string `gorm:"-" json:"roles"`
-	RolesText string   `gorm:"column:roles;" json:"-"`
+	// Roles are saved in the user_roles table, see the UserRoleModel:
+	Roles       []string `gorm:"-" json:"roles"`
+	rolesLoaded bool
+	// users created from signed access token claims only have the claims data, see the NewUserModelFromTokenClaims:
+	fromTokenClaims bool
+
+	// Login lockout state, see the RegisterLoginFailure:
+	FailedLoginCount  int        `gorm:"column:failedLoginCount;default:0;" json:"-"`
+	LastFailedLoginAt *time.Time `gorm:"column:lastFailedLoginAt;" json:"-"`
+	LastFailedLoginIP string     `gorm:"column:lastFailedLoginIP;type:VARCHAR(45);" json:"-"`
+	LockedUntil       *time.Time `gorm:"column:lockedUntil;" json:"-"`
+	LockoutCount      int        `gorm:"column:lockoutCount;default:0;" json:"-"`
 
 	CreatedAt time.Time `gorm:"column:createdAt;autoCreateTime:false;" json:"createdAt" filter:"param:createdAt;type:date"`
 	UpdatedAt time.Time `gorm:"column:updatedAt;autoupdatetime:false;default:null;" json:"updatedAt" filter:"param:updatedAt;type:date"`
@@ -59,31 +72,44 @@ func (r *UserModel) SetID(id string) error {
 	return nil
 }
 
+// SetRoles replaces all the user roles, new users will have the roles saved in the Save
 func (r *UserModel) SetRoles(v []string) error {
-	r.Roles = v
+	r.Roles = uniqueStrings(v)
+	r.rolesLoaded = true
 
-	jsonString, _ := json.Marshal(r.Roles)
-	r.RolesText = string(jsonString)
+	if r.ID == 0 {
+		return nil
+	}
 
-	return nil
+	return ReplaceUserRoles(r.ID, r.Roles)
 }
 
 func (r *UserModel) AddRole(role string) error {
-	for i := range r.Roles {
-		if r.Roles[i] == role {
+	roles := r.GetRoles()
+
+	for i := range roles {
+		if roles[i] == role {
 			return nil
 		}
 	}
 
-	r.Roles = append(r.Roles, role)
+	r.Roles = append(roles, role)
 
-	return nil
+	if r.ID == 0 {
+		return nil
+	}
+
+	return AddUserRole(r.ID, role)
 }
 
 func (r *UserModel) RemoveRole(role string) error {
-	// r.Roles.
-	r.Roles, _ = helpers.SliceRemove(r.Roles, role)
-	return nil
+	r.Roles, _ = helpers.SliceRemove(r.GetRoles(), role)
+
+	if r.ID == 0 {
+		return nil
+	}
+
+	return RemoveUserRole(r.ID, role)
 }
 
 func (r *UserModel) GetEmail() string {
@@ -91,7 +117,10 @@ func (r *UserModel) GetEmail() string {
 }
 
 func (r *UserModel) SetEmail(v string) error {
-	// TODO! Validate email format!
+	if !ValidEmail(v) {
+		return errors.New("invalid email format")
+	}
+
 	r.Email = v
 	return nil
 }
@@ -139,6 +168,33 @@ func (r *UserModel) SetBlocked(blocked bool) error {
 	return nil
 }
 
+// NewUserModelFromTokenClaims creates one partial user with the data of one signed access token, without database queries
+func NewUserModelFromTokenClaims(id, username, email, displayName string, roles []string) (*UserModel, error) {
+	r := UserModel{
+		Username:    username,
+		Email:       email,
+		DisplayName: displayName,
+		Active:      true,
+		Roles:       uniqueStrings(roles),
+	}
+
+	err := r.SetID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.rolesLoaded = true
+	r.fromTokenClaims = true
+
+	return &r, nil
+}
+
+// IsFromTokenClaims returns true for partial users created from signed access token claims,
+// features that change or show the full user record should reload it with UserFindOne
+func (r *UserModel) IsFromTokenClaims() bool {
+	return r.fromTokenClaims
+}
+
 func (UserModel) TableName() string {
 	return "users"
 }
@@ -147,22 +203,34 @@ func (r *UserModel) FillById(id string) error {
 	return UserFindOne(id, r)
 }
 
+// GetRoles returns the user roles, loaded from the user_roles table in the first call
 func (r *UserModel) GetRoles() []string {
-	if r.RolesText != "" {
-		_ = json.Unmarshal([]byte(r.RolesText), &r.Roles)
+	if !r.rolesLoaded && r.ID != 0 {
+		roles, err := FindUserRoleNames(r.ID)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"userID": r.ID,
+				"error":  fmt.Sprintf("%+v\n", err),
+			}).Error("UserModel.GetRoles error on load roles")
+			return r.Roles
+		}
+
+		r.Roles = roles
+		r.rolesLoaded = true
 	}
 
 	return r.Roles
 }
 
 func (r *UserModel) SetRole(roleName string) []string {
-	roles := r.GetRoles()
-	roles = append(roles, roleName)
-
-	jsonString, _ := json.Marshal(roles)
-	r.RolesText = string(jsonString)
-
-	r.Roles = roles
+	err := r.AddRole(roleName)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"userID": r.ID,
+			"role":   roleName,
+			"error":  fmt.Sprintf("%+v\n", err),
+		}).Error("UserModel.SetRole error on add role")
+	}
 
 	return r.Roles
 }
@@ -179,6 +247,26 @@ func (r *UserModel) IsBlocked() bool {
 	return r.Blocked
 }
 
+// CanLogin returns true for saved, active and not blocked users, inactive users didn't confirm the email yet.
+// Used by all the login methods, oauth2 grants and token authentications
+func (r *UserModel) CanLogin() bool {
+	return r.ID != 0 && r.Active && !r.Blocked
+}
+
+// SetProfileFields copies the fields that users can change in the own profile,
+// the email, active, blocked and roles fields have their own flows and permissions
+func (r *UserModel) SetProfileFields(data *UserModel) {
+	r.Username = data.Username
+	r.DisplayName = data.DisplayName
+	r.FullName = data.FullName
+	r.Biography = data.Biography
+	r.Gender = data.Gender
+	r.Language = data.Language
+	r.AcceptTerms = data.AcceptTerms
+	r.Birthdate = data.Birthdate
+	r.Phone = data.Phone
+}
+
 func (r *UserModel) GetBiography() string {
 	return r.Biography
 }
@@ -221,11 +309,6 @@ func (m *UserModel) Save(ctx *bolo.RequestContext) error {
 	app := ctx.App
 	m.UpdatedAt = app.GetClock().Now()
 
-	if len(m.Roles) > 0 {
-		jsonString, _ := json.Marshal(m.Roles)
-		m.RolesText = string(jsonString)
-	}
-
 	if m.ID == 0 {
 		m.CreatedAt = clock.New().Now()
 		// create ....
@@ -233,6 +316,16 @@ func (m *UserModel) Save(ctx *bolo.RequestContext) error {
 		if err != nil {
 			return err
 		}
+
+		// roles of saved users are only changed with the SetRoles, AddRole and RemoveRole,
+		// then one outdated copy of the roles never overrides other role change:
+		if len(m.Roles) > 0 {
+			err = ReplaceUserRoles(m.ID, m.Roles)
+			if err != nil {
+				return err
+			}
+			m.rolesLoaded = true
+		}
 	} else {
 		// update ...
 		err = db.Save(&m).Error
@@ -246,6 +339,51 @@ func (m *UserModel) Save(ctx *bolo.RequestContext) error {
 	return nil
 }
 
+// CreateWithPassword creates the new user, the roles and the password in one transaction, then one error on save
+// the password does not leave one user without password that blocks the email and username
+func (m *UserModel) CreateWithPassword(ctx *bolo.RequestContext, password string) error {
+	if m.ID != 0 {
+		return errors.New("UserModel.CreateWithPassword user already created")
+	}
+
+	// the hash is generated before the transaction because it is slow:
+	var passwordRecord PasswordModel
+	err := passwordRecord.SetPassword(password)
+	if err != nil {
+		return err
+	}
+
+	m.CreatedAt = ctx.App.GetClock().Now()
+	m.UpdatedAt = m.CreatedAt
+
+	err = ctx.App.GetDB().Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&m).Error
+		if err != nil {
+			return err
+		}
+
+		if len(m.Roles) > 0 {
+			err = replaceUserRoles(tx, m.ID, m.Roles)
+			if err != nil {
+				return err
+			}
+		}
+
+		userID := int64(m.ID)
+		passwordRecord.UserID = &userID
+
+		return tx.Create(&passwordRecord).Error
+	})
+	if err != nil {
+		m.ID = 0
+		return err
+	}
+
+	m.rolesLoaded = len(m.Roles) > 0
+
+	return nil
+}
+
 func (m *UserModel) LoadTeaserData() error {
 	m.GetRoles()
 	return nil
@@ -261,6 +399,27 @@ func (r *UserModel) Delete() error {
 		return nil
 	}
 	db := bolo.GetDefaultDatabaseConnection()
+
+	err := DeleteAllUserRoles(r.ID)
+	if err != nil {
+		return err
+	}
+
+	err = DeleteUserWebAuthnCredentials(r.GetID())
+	if err != nil {
+		return err
+	}
+
+	err = DeleteUserPersonalAccessTokens(r.GetID())
+	if err != nil {
+		return err
+	}
+
+	err = DeleteUserAuthEvents(r.GetID())
+	if err != nil {
+		return err
+	}
+
 	return db.Unscoped().Delete(&r).Error
 }
 
@@ -329,6 +488,42 @@ func UserFindOneByUsername(username string, record *UserModel) error {
 		First(record).Error
 }
 
+// FindAvailableUsername returns the username if it is not used or the username with one random suffix,
+// used to create users with usernames from external sources without conflicts in the username unique index
+func FindAvailableUsername(username string) (string, error) {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	candidate := username
+	for i := 0; i < 5; i++ {
+		var count int64
+		err := db.Model(&UserModel{}).Where("username = ?", candidate).Count(&count).Error
+		if err != nil {
+			return "", errors.Wrap(err, "FindAvailableUsername error on count users")
+		}
+
+		if count == 0 {
+			return candidate, nil
+		}
+
+		candidate = username + "_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:6]
+	}
+
+	return "", errors.New("FindAvailableUsername no available username for " + username)
+}
+
+// UserFindOneByEmail - Find one user by email, record.ID will be 0 if not found
+func UserFindOneByEmail(email string, record *UserModel) error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	err := db.
+		Where("email = ?", email).
+		First(record).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
 func LoadAllUsers(userList *[]UserModel) error {
 	db := bolo.GetDefaultDatabaseConnection()
 
@@ -383,6 +578,11 @@ func QueryAndCountFromRequest(opts *QueryAndCountFromRequestCfg) error {
 		)
 	}
 
+	role := c.QueryParam("role")
+	if role != "" {
+		query = WhereUserHasRole(query, role)
+	}
+
 	orderColumn, orderIsDesc, orderValid := helpers.ParseUrlQueryOrder(c.QueryParam("order"), c.QueryParam("sort"), c.QueryParam("sortDirection"))
 
 	if orderValid {
@@ -403,6 +603,11 @@ func QueryAndCountFromRequest(opts *QueryAndCountFromRequestCfg) error {
 		return errors.Wrap(r.Error, "user.QueryAndCountFromRequest error on find records")
 	}
 
+	err = LoadUsersRoles(*opts.Records)
+	if err != nil {
+		return errors.Wrap(err, "user.QueryAndCountFromRequest error on load roles")
+	}
+
 	return CountQueryFromRequest(opts)
 }
 
@@ -436,6 +641,12 @@ func CountQueryFromRequest(opts *QueryAndCountFromRequestCfg) error {
 	}
 	queryCount = queryICount.(*gorm.DB)
 
+	// filter after the query parser, it sets the pager in non root queries:
+	role := c.QueryParam("role")
+	if role != "" {
+		queryCount = WhereUserHasRole(queryCount, role)
+	}
+
 	return queryCount.
 		Table("users").
 		Count(opts.Count).Error
@@ -0,0 +1,162 @@
+package user_models
+
+import (
+	"github.com/go-bolo/bolo"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UserRoleModel - one role of one user
+type UserRoleModel struct {
+	ID     uint64 `gorm:"primary_key;column:id;" json:"id"`
+	UserID uint64 `gorm:"column:userId;uniqueIndex:user_roles_userId_role" json:"userId"`
+	Role   string `gorm:"column:role;type:VARCHAR(100);uniqueIndex:user_roles_userId_role;index" json:"role"`
+}
+
+func (r *UserRoleModel) TableName() string {
+	return "user_roles"
+}
+
+// FindUserRoleNames returns the user role names in the insertion order
+func FindUserRoleNames(userID uint64) ([]string, error) {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	roles := []string{}
+	err := db.Model(&UserRoleModel{}).
+		Where("userId = ?", userID).
+		Order("id ASC").
+		Pluck("role", &roles).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "FindUserRoleNames error on find roles")
+	}
+
+	return roles, nil
+}
+
+// FindRoleNamesByUserIDs returns the role names of many users, indexed by user id
+func FindRoleNamesByUserIDs(userIDs []uint64) (map[uint64][]string, error) {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	result := map[uint64][]string{}
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+
+	records := []*UserRoleModel{}
+	err := db.Where("userId IN ?", userIDs).
+		Order("id ASC").
+		Find(&records).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "FindRoleNamesByUserIDs error on find roles")
+	}
+
+	for _, r := range records {
+		result[r.UserID] = append(result[r.UserID], r.Role)
+	}
+
+	return result, nil
+}
+
+// ReplaceUserRoles replaces all the user roles in one transaction
+func ReplaceUserRoles(userID uint64, roles []string) error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		return replaceUserRoles(tx, userID, roles)
+	})
+}
+
+// replaceUserRoles replaces the user roles with the transaction of the caller
+func replaceUserRoles(tx *gorm.DB, userID uint64, roles []string) error {
+	err := tx.Where("userId = ?", userID).Delete(&UserRoleModel{}).Error
+	if err != nil {
+		return errors.Wrap(err, "ReplaceUserRoles error on delete roles")
+	}
+
+	records := []*UserRoleModel{}
+	for _, role := range uniqueStrings(roles) {
+		records = append(records, &UserRoleModel{UserID: userID, Role: role})
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	err = tx.Create(&records).Error
+	if err != nil {
+		return errors.Wrap(err, "ReplaceUserRoles error on create roles")
+	}
+
+	return nil
+}
+
+// AddUserRole adds one role to the user, does nothing if the user already have it
+func AddUserRole(userID uint64, role string) error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	err := db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&UserRoleModel{UserID: userID, Role: role}).Error
+	if err != nil {
+		return errors.Wrap(err, "AddUserRole error on create role")
+	}
+
+	return nil
+}
+
+// RemoveUserRole removes one role from the user
+func RemoveUserRole(userID uint64, role string) error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	err := db.Where("userId = ? AND role = ?", userID, role).
+		Delete(&UserRoleModel{}).Error
+	if err != nil {
+		return errors.Wrap(err, "RemoveUserRole error on delete role")
+	}
+
+	return nil
+}
+
+// DeleteAllUserRoles removes all the roles from the user, used on user delete
+func DeleteAllUserRoles(userID uint64) error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	err := db.Where("userId = ?", userID).Delete(&UserRoleModel{}).Error
+	if err != nil {
+		return errors.Wrap(err, "DeleteAllUserRoles error on delete roles")
+	}
+
+	return nil
+}
+
+// LoadUsersRoles fills the roles of one user list with one query
+func LoadUsersRoles(users []*UserModel) error {
+	ids := []uint64{}
+	for _, u := range users {
+		if u.ID != 0 {
+			ids = append(ids, u.ID)
+		}
+	}
+
+	rolesByUser, err := FindRoleNamesByUserIDs(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		roles := rolesByUser[u.ID]
+		if roles == nil {
+			roles = []string{}
+		}
+
+		u.Roles = roles
+		u.rolesLoaded = true
+	}
+
+	return nil
+}
+
+// WhereUserHasRole filters the users query by one role name
+func WhereUserHasRole(query *gorm.DB, role string) *gorm.DB {
+	return query.Where("id IN (SELECT userId FROM user_roles WHERE role = ?)", role)
+}
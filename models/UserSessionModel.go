@@ -0,0 +1,165 @@
+package user_models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// User session types
+const (
+	UserSessionTypeSession = "session"
+	UserSessionTypeOauth2  = "oauth2"
+)
+
+// Request context key with the current user session registry id
+const CurrentUserSessionIDKey = "userSessionID"
+
+// Min interval between lastSeenAt updates, avoids one database write for every request
+var UserSessionTouchInterval = time.Minute
+
+// UserSessionModel - registry of one user web session or oauth2 token pair
+type UserSessionModel struct {
+	ID         uint64    `gorm:"primary_key;column:id;" json:"id"`
+	UserID     int64     `gorm:"column:userId;type:bigint(20);index" json:"userId"`
+	Type       string    `gorm:"column:type;type:VARCHAR(20)" json:"type"`
+	IP         string    `gorm:"column:ip;type:VARCHAR(45)" json:"ip"`
+	UserAgent  string    `gorm:"column:userAgent;type:TEXT" json:"userAgent"`
+	LastSeenAt time.Time `gorm:"column:lastSeenAt;type:datetime" json:"lastSeenAt"`
+	ExpiresAt  time.Time `gorm:"column:expiresAt;type:datetime" json:"expiresAt"`
+	CreatedAt  time.Time `gorm:"column:createdAt;type:datetime;not null" json:"createdAt"`
+	UpdatedAt  time.Time `gorm:"column:updatedAt;type:datetime;not null" json:"updatedAt"`
+
+	// true if is the session used in the current request
+	Current bool `gorm:"-" json:"current"`
+}
+
+func (r *UserSessionModel) TableName() string {
+	return "user_sessions"
+}
+
+func (r *UserSessionModel) GetID() string {
+	return strconv.FormatUint(r.ID, 10)
+}
+
+func (r *UserSessionModel) Save() error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	if r.ID == 0 {
+		return db.Create(&r).Error
+	}
+
+	return db.Save(&r).Error
+}
+
+func (r *UserSessionModel) Delete() error {
+	db := bolo.GetDefaultDatabaseConnection()
+	return db.Unscoped().Delete(&r).Error
+}
+
+// IsValidFor checks if the session exists, is not expired and belongs to the user
+func (r *UserSessionModel) IsValidFor(userID string) bool {
+	return r.ID != 0 &&
+		strconv.FormatInt(r.UserID, 10) == userID &&
+		time.Now().Before(r.ExpiresAt)
+}
+
+// Touch updates the last seen data, the update is skipped if the session was seen recently
+func (r *UserSessionModel) Touch(ip, userAgent string, expiresAt time.Time) error {
+	now := time.Now()
+
+	if now.Sub(r.LastSeenAt) < UserSessionTouchInterval && r.IP == ip {
+		return nil
+	}
+
+	r.LastSeenAt = now
+	r.IP = ip
+	if userAgent != "" {
+		r.UserAgent = userAgent
+	}
+	if expiresAt.After(r.ExpiresAt) {
+		r.ExpiresAt = expiresAt
+	}
+
+	return r.Save()
+}
+
+// CreateUserSession registers one new user session and cleans the expired ones
+func CreateUserSession(userID, sessionType, ip, userAgent string, expiresAt time.Time) (*UserSessionModel, error) {
+	uid, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateUserSession invalid user id")
+	}
+
+	db := bolo.GetDefaultDatabaseConnection()
+	err = db.Where("userId = ? AND expiresAt < ?", uid, time.Now()).
+		Delete(&UserSessionModel{}).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateUserSession error on delete expired sessions")
+	}
+
+	r := UserSessionModel{
+		UserID:     uid,
+		Type:       sessionType,
+		IP:         ip,
+		UserAgent:  userAgent,
+		LastSeenAt: time.Now(),
+		ExpiresAt:  expiresAt,
+	}
+
+	err = r.Save()
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateUserSession error on save")
+	}
+
+	return &r, nil
+}
+
+// FindUserSession loads one user session, r.ID will be 0 if not found
+func FindUserSession(id string, r *UserSessionModel) error {
+	db := bolo.GetDefaultDatabaseConnection()
+	err := db.Where("id = ?", id).
+		First(r).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+// FindUserSessionsByUserID returns the user active sessions, last seen first
+func FindUserSessionsByUserID(userID string) ([]*UserSessionModel, error) {
+	records := []*UserSessionModel{}
+
+	db := bolo.GetDefaultDatabaseConnection()
+	err := db.Where("userId = ? AND expiresAt > ?", userID, time.Now()).
+		Order("lastSeenAt DESC").
+		Find(&records).Error
+
+	return records, err
+}
+
+// DeleteUserSessions revokes all user sessions, except the one with the exceptID if it is not empty.
+// The personal access tokens are not changed, see RevokeUserCredentials
+func DeleteUserSessions(userID string, exceptID string) error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	q := db.Where("userId = ?", userID)
+	if exceptID != "" {
+		q = q.Where("id <> ?", exceptID)
+	}
+
+	return q.Delete(&UserSessionModel{}).Error
+}
+
+// RevokeUserCredentials revokes all the user sessions and personal access tokens
+func RevokeUserCredentials(userID string) error {
+	err := DeleteUserSessions(userID, "")
+	if err != nil {
+		return err
+	}
+
+	return DeleteUserPersonalAccessTokens(userID)
+}
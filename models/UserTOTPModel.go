@@ -0,0 +1,182 @@
+package user_models
+
+import (
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user/security"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Auth token type used in the login MFA challenge step
+const MfaChallengeTokenType = "mfaChallenge"
+
+// Time to complete the MFA challenge after the password check
+var MfaChallengeTTL = 5 * time.Minute
+
+// Number of recovery codes generated in the TOTP confirmation
+var MfaRecoveryCodesCount = 10
+
+type UserTOTPModel struct {
+	ID           uint64    `gorm:"primary_key;column:id;" json:"id"`
+	UserID       int64     `gorm:"column:userId;type:bigint(20);uniqueIndex" json:"userId"`
+	Secret       string    `gorm:"column:secret;type:VARCHAR(255)" json:"-"`
+	Confirmed    bool      `gorm:"column:confirmed" json:"confirmed"`
+	LastUsedStep int64     `gorm:"column:lastUsedStep;type:bigint(20)" json:"-"`
+	CreatedAt    time.Time `gorm:"column:createdAt;type:datetime;not null" json:"createdAt"`
+	UpdatedAt    time.Time `gorm:"column:updatedAt;type:datetime;not null" json:"updatedAt"`
+}
+
+func (r *UserTOTPModel) TableName() string {
+	return "user_totps"
+}
+
+func (r *UserTOTPModel) Save() error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	if r.ID == 0 {
+		return db.Create(&r).Error
+	}
+
+	return db.Save(&r).Error
+}
+
+func (r *UserTOTPModel) Delete() error {
+	db := bolo.GetDefaultDatabaseConnection()
+	return db.Unscoped().Delete(&r).Error
+}
+
+// ValidateCode checks one TOTP code and blocks reuse of already used time steps. The step is saved with one
+// conditional update, then only one of the parallel requests with the same code is accepted
+func (r *UserTOTPModel) ValidateCode(code string, now time.Time) (bool, error) {
+	valid, step := security.ValidateTOTPCode(r.Secret, code, now)
+	if !valid || step <= r.LastUsedStep {
+		return false, nil
+	}
+
+	db := bolo.GetDefaultDatabaseConnection()
+
+	result := db.Model(&UserTOTPModel{}).
+		Where("id = ? AND lastUsedStep < ?", r.ID, step).
+		Updates(map[string]interface{}{
+			"lastUsedStep": step,
+			"updatedAt":    now,
+		})
+	if result.Error != nil {
+		return false, errors.Wrap(result.Error, "UserTOTPModel.ValidateCode error on save last used step")
+	}
+
+	if result.RowsAffected != 1 {
+		return false, nil
+	}
+
+	r.LastUsedStep = step
+
+	return true, nil
+}
+
+// FindUserTOTPByUserID loads the user TOTP record, r.ID will be 0 if not found
+func FindUserTOTPByUserID(userID string, r *UserTOTPModel) error {
+	db := bolo.GetDefaultDatabaseConnection()
+	err := db.Where("userId = ?", userID).
+		First(r).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+// UserHasTOTPEnabled returns true if the user has one confirmed TOTP
+func UserHasTOTPEnabled(userID string) (bool, error) {
+	var r UserTOTPModel
+	err := FindUserTOTPByUserID(userID, &r)
+	if err != nil {
+		return false, err
+	}
+
+	return r.ID != 0 && r.Confirmed, nil
+}
+
+// UserHasMfaEnabled returns true if the user has one second factor, one confirmed TOTP or one WebAuthn credential.
+// Logins with one factor (password and magic link) continue in the MFA step with any of them
+func UserHasMfaEnabled(userID string) (bool, error) {
+	enabled, err := UserHasTOTPEnabled(userID)
+	if err != nil || enabled {
+		return enabled, err
+	}
+
+	return UserHasWebAuthnCredentials(userID)
+}
+
+// VerifyUserMfaCode checks one TOTP or recovery code for the user
+func VerifyUserMfaCode(userID string, code string) (bool, error) {
+	var r UserTOTPModel
+	err := FindUserTOTPByUserID(userID, &r)
+	if err != nil {
+		return false, err
+	}
+
+	if r.ID == 0 || !r.Confirmed {
+		return false, nil
+	}
+
+	valid, err := r.ValidateCode(code, time.Now())
+	if err != nil || valid {
+		return valid, err
+	}
+
+	return UseMfaRecoveryCode(userID, code)
+}
+
+// CreateMfaChallenge creates the short-lived token returned in logins that need the second factor
+func CreateMfaChallenge(userID string) (*AuthTokenModel, error) {
+	return CreateAuthToken(userID, MfaChallengeTokenType)
+}
+
+// FindMfaChallenge returns the valid and not expired MFA challenge or nil
+func FindMfaChallenge(token string) (*AuthTokenModel, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	var authToken AuthTokenModel
+
+	db := bolo.GetDefaultDatabaseConnection()
+
+	err := db.Model(&AuthTokenModel{}).
+		Where("token = ? AND tokenType = ?", token, MfaChallengeTokenType).
+		First(&authToken).
+		Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if !authToken.IsValid || time.Since(authToken.CreatedAt) > MfaChallengeTTL {
+		return nil, nil
+	}
+
+	return &authToken, nil
+}
+
+// CompleteMfaChallenge checks the second factor code and invalidates the challenge on success
+func CompleteMfaChallenge(challenge *AuthTokenModel, code string) (bool, error) {
+	if challenge == nil || challenge.UserID == nil {
+		return false, nil
+	}
+
+	valid, err := VerifyUserMfaCode(*challenge.UserID, code)
+	if err != nil || !valid {
+		return false, err
+	}
+
+	err = challenge.Delete()
+	if err != nil {
+		return false, errors.Wrap(err, "CompleteMfaChallenge error on delete challenge")
+	}
+
+	return true, nil
+}
@@ -0,0 +1,110 @@
+package user_models
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/system_settings"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/pkg/errors"
+)
+
+// NewWebAuthn creates the WebAuthn relying party from the app configuration:
+// AUTH_WEBAUTHN_RP_ID (default is the APP_ORIGIN host), AUTH_WEBAUTHN_RP_ORIGINS (comma separated,
+// default is the APP_ORIGIN) and AUTH_WEBAUTHN_RP_NAME (default is the siteName setting)
+func NewWebAuthn(ctx *bolo.RequestContext) (*webauthn.WebAuthn, error) {
+	cfgs := ctx.App.GetConfiguration()
+
+	rpID := cfgs.Get("AUTH_WEBAUTHN_RP_ID")
+	if rpID == "" {
+		origin, err := url.Parse(ctx.AppOrigin)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewWebAuthn error on parse the app origin")
+		}
+
+		rpID = origin.Hostname()
+	}
+
+	origins := []string{}
+	for _, o := range strings.Split(cfgs.GetF("AUTH_WEBAUTHN_RP_ORIGINS", ctx.AppOrigin), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+
+	rpName := cfgs.Get("AUTH_WEBAUTHN_RP_NAME")
+	if rpName == "" {
+		rpName = system_settings.Get("siteName")
+	}
+	if rpName == "" {
+		rpName = cfgs.GetF("SITE_NAME", "App")
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+	})
+}
+
+// WebAuthnUser - adapter of one user and the user credentials to the webauthn.User interface
+type WebAuthnUser struct {
+	User        *UserModel
+	Credentials []*WebAuthnCredentialModel
+}
+
+// NewWebAuthnUser loads the user credentials
+func NewWebAuthnUser(u *UserModel) (*WebAuthnUser, error) {
+	credentials, err := FindWebAuthnCredentialsByUserID(u.GetID())
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebAuthnUser{User: u, Credentials: credentials}, nil
+}
+
+// WebAuthnID is the user handle saved in the authenticator, the user id
+func (r *WebAuthnUser) WebAuthnID() []byte {
+	return []byte(r.User.GetID())
+}
+
+func (r *WebAuthnUser) WebAuthnName() string {
+	if r.User.Email != "" {
+		return r.User.Email
+	}
+
+	return r.User.Username
+}
+
+func (r *WebAuthnUser) WebAuthnDisplayName() string {
+	if r.User.DisplayName != "" {
+		return r.User.DisplayName
+	}
+
+	return r.WebAuthnName()
+}
+
+func (r *WebAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (r *WebAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := []webauthn.Credential{}
+	for _, c := range r.Credentials {
+		credentials = append(credentials, c.ToCredential())
+	}
+
+	return credentials
+}
+
+// FindCredential returns the user credential record with the raw credential id or nil
+func (r *WebAuthnUser) FindCredential(credentialID []byte) *WebAuthnCredentialModel {
+	for _, c := range r.Credentials {
+		if c.CredentialID == webAuthnCredentialIDString(credentialID) {
+			return c
+		}
+	}
+
+	return nil
+}
@@ -2,9 +2,11 @@ package user_models
 
 import (
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ValidUsernamePassword checks the user password, valid hashes with one outdated algorithm or cost are upgraded
 func ValidUsernamePassword(username, password string) (bool, error) {
 	var passwordRecord PasswordModel
 
@@ -22,5 +24,16 @@ func ValidUsernamePassword(username, password string) (bool, error) {
 		return false, nil
 	}
 
+	if passwordRecord.NeedsRehash() {
+		err = passwordRecord.Rehash(password)
+		if err != nil {
+			// the login is valid, the rehash will run again in the next login:
+			logrus.WithFields(logrus.Fields{
+				"error":  err,
+				"userId": passwordRecord.UserID,
+			}).Error("ValidUsernamePassword error on rehash password")
+		}
+	}
+
 	return true, nil
 }
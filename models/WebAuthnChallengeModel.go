@@ -0,0 +1,121 @@
+package user_models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/bolo/helpers"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// WebAuthn ceremonies saved in the challenge records
+const (
+	WebAuthnCeremonyRegistration = "registration"
+	WebAuthnCeremonyLogin        = "login"
+	WebAuthnCeremonyMfa          = "mfa"
+)
+
+// WebAuthnChallengeTTL - time to finish one registration or assertion ceremony after the begin step
+var WebAuthnChallengeTTL = 5 * time.Minute
+
+// WebAuthnChallengeModel - server side state of one WebAuthn ceremony, identified by the challenge_id sent to the client
+type WebAuthnChallengeModel struct {
+	ID       uint64 `gorm:"primary_key;column:id;" json:"id"`
+	Token    string `gorm:"column:token;type:VARCHAR(64);uniqueIndex" json:"-"`
+	Ceremony string `gorm:"column:ceremony;type:VARCHAR(20)" json:"ceremony"`
+	// user of the ceremony, 0 in discoverable logins
+	UserID uint64 `gorm:"column:userId;index" json:"userId"`
+	// login MFA challenge completed by the "mfa" ceremony
+	MfaToken string `gorm:"column:mfaToken;type:VARCHAR(255)" json:"-"`
+	// webauthn.SessionData in JSON
+	SessionData string    `gorm:"column:sessionData;type:TEXT" json:"-"`
+	CreatedAt   time.Time `gorm:"column:createdAt;type:datetime;not null" json:"createdAt"`
+}
+
+func (r *WebAuthnChallengeModel) TableName() string {
+	return "webauthn_challenges"
+}
+
+func (r *WebAuthnChallengeModel) Delete() error {
+	db := bolo.GetDefaultDatabaseConnection()
+	return db.Unscoped().Delete(&r).Error
+}
+
+// GetSessionData decodes the webauthn session data saved in the begin step
+func (r *WebAuthnChallengeModel) GetSessionData() (*webauthn.SessionData, error) {
+	var session webauthn.SessionData
+
+	err := json.Unmarshal([]byte(r.SessionData), &session)
+	if err != nil {
+		return nil, errors.Wrap(err, "WebAuthnChallengeModel.GetSessionData error on unmarshal")
+	}
+
+	return &session, nil
+}
+
+// CreateWebAuthnChallenge saves the session data of one ceremony begin step.
+// Challenges of abandoned ceremonies are deleted here
+func CreateWebAuthnChallenge(ceremony string, userID uint64, mfaToken string, session *webauthn.SessionData) (*WebAuthnChallengeModel, error) {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	err := db.Where("createdAt < ?", time.Now().Add(-WebAuthnChallengeTTL)).
+		Delete(&WebAuthnChallengeModel{}).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateWebAuthnChallenge error on delete expired challenges")
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateWebAuthnChallenge error on marshal session")
+	}
+
+	r := WebAuthnChallengeModel{
+		Token:       helpers.RandStringBytes(40),
+		Ceremony:    ceremony,
+		UserID:      userID,
+		MfaToken:    mfaToken,
+		SessionData: string(data),
+	}
+
+	err = db.Create(&r).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateWebAuthnChallenge error on create")
+	}
+
+	return &r, nil
+}
+
+// ConsumeWebAuthnChallenge deletes and returns the challenge of the ceremony, or nil if it is not found or expired.
+// Challenges are single use, then one failed finish step needs a new begin step
+func ConsumeWebAuthnChallenge(token, ceremony string) (*WebAuthnChallengeModel, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	db := bolo.GetDefaultDatabaseConnection()
+
+	var r WebAuthnChallengeModel
+	err := db.Where("token = ? AND ceremony = ?", token, ceremony).
+		First(&r).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "ConsumeWebAuthnChallenge error on find challenge")
+	}
+
+	err = r.Delete()
+	if err != nil {
+		return nil, errors.Wrap(err, "ConsumeWebAuthnChallenge error on delete challenge")
+	}
+
+	if time.Since(r.CreatedAt) > WebAuthnChallengeTTL {
+		return nil, nil
+	}
+
+	return &r, nil
+}
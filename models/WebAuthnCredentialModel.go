@@ -0,0 +1,184 @@
+package user_models
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// WebAuthnCredentialModel - one FIDO2/WebAuthn public key credential (passkey or security key) of one user
+type WebAuthnCredentialModel struct {
+	ID     uint64 `gorm:"primary_key;column:id;" json:"id"`
+	UserID uint64 `gorm:"column:userId;index" json:"userId"`
+	// Credential id in base64 url encoding, without padding
+	CredentialID string `gorm:"column:credentialId;type:VARCHAR(255);uniqueIndex" json:"credentialId"`
+	// COSE encoded credential public key
+	PublicKey       []byte `gorm:"column:publicKey;type:BLOB" json:"-"`
+	AttestationType string `gorm:"column:attestationType;type:VARCHAR(50)" json:"attestationType"`
+	// Comma separated authenticator transports, like usb,nfc,internal
+	Transports     string     `gorm:"column:transports;type:VARCHAR(255)" json:"transports"`
+	AAGUID         []byte     `gorm:"column:aaguid;type:BLOB" json:"-"`
+	SignCount      uint32     `gorm:"column:signCount" json:"-"`
+	BackupEligible bool       `gorm:"column:backupEligible" json:"backupEligible"`
+	BackupState    bool       `gorm:"column:backupState" json:"backupState"`
+	Name           string     `gorm:"column:name;type:VARCHAR(100)" json:"name"`
+	LastUsedAt     *time.Time `gorm:"column:lastUsedAt;type:datetime" json:"lastUsedAt"`
+	CreatedAt      time.Time  `gorm:"column:createdAt;type:datetime;not null" json:"createdAt"`
+	UpdatedAt      time.Time  `gorm:"column:updatedAt;type:datetime;not null" json:"updatedAt"`
+}
+
+func (r *WebAuthnCredentialModel) TableName() string {
+	return "webauthn_credentials"
+}
+
+func (r *WebAuthnCredentialModel) GetID() string {
+	return strconv.FormatUint(r.ID, 10)
+}
+
+func (r *WebAuthnCredentialModel) Save() error {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	if r.ID == 0 {
+		return db.Create(&r).Error
+	}
+
+	return db.Save(&r).Error
+}
+
+func (r *WebAuthnCredentialModel) Delete() error {
+	db := bolo.GetDefaultDatabaseConnection()
+	return db.Unscoped().Delete(&r).Error
+}
+
+// ToCredential converts the record to the webauthn library credential
+func (r *WebAuthnCredentialModel) ToCredential() webauthn.Credential {
+	id, _ := base64.RawURLEncoding.DecodeString(r.CredentialID)
+
+	transports := []protocol.AuthenticatorTransport{}
+	for _, t := range strings.Split(r.Transports, ",") {
+		if t != "" {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+	}
+
+	return webauthn.Credential{
+		ID:              id,
+		PublicKey:       r.PublicKey,
+		AttestationType: r.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			BackupEligible: r.BackupEligible,
+			BackupState:    r.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    r.AAGUID,
+			SignCount: r.SignCount,
+		},
+	}
+}
+
+// NewWebAuthnCredentialModel creates the record of one credential verified in the registration ceremony
+func NewWebAuthnCredentialModel(userID uint64, name string, c *webauthn.Credential) *WebAuthnCredentialModel {
+	transports := []string{}
+	for _, t := range c.Transport {
+		transports = append(transports, string(t))
+	}
+
+	return &WebAuthnCredentialModel{
+		UserID:          userID,
+		CredentialID:    webAuthnCredentialIDString(c.ID),
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transports:      strings.Join(transports, ","),
+		AAGUID:          c.Authenticator.AAGUID,
+		SignCount:       c.Authenticator.SignCount,
+		BackupEligible:  c.Flags.BackupEligible,
+		BackupState:     c.Flags.BackupState,
+		Name:            name,
+	}
+}
+
+// OnAssertion saves the counter and flags of one credential validated in the login ceremony
+func (r *WebAuthnCredentialModel) OnAssertion(c *webauthn.Credential) error {
+	now := time.Now()
+
+	r.SignCount = c.Authenticator.SignCount
+	r.BackupState = c.Flags.BackupState
+	r.LastUsedAt = &now
+
+	err := r.Save()
+	if err != nil {
+		return errors.Wrap(err, "WebAuthnCredentialModel.OnAssertion error on save")
+	}
+
+	return nil
+}
+
+// FindWebAuthnCredentialsByUserID returns all the user credentials in the registration order
+func FindWebAuthnCredentialsByUserID(userID string) ([]*WebAuthnCredentialModel, error) {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	records := []*WebAuthnCredentialModel{}
+	err := db.Where("userId = ?", userID).
+		Order("id ASC").
+		Find(&records).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "FindWebAuthnCredentialsByUserID error on find credentials")
+	}
+
+	return records, nil
+}
+
+// FindWebAuthnCredentialByCredentialID loads one credential by the raw authenticator credential id, r.ID will be 0 if not found
+func FindWebAuthnCredentialByCredentialID(credentialID []byte, r *WebAuthnCredentialModel) error {
+	db := bolo.GetDefaultDatabaseConnection()
+	err := db.Where("credentialId = ?", webAuthnCredentialIDString(credentialID)).
+		First(r).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+// FindUserWebAuthnCredential loads one credential of the user, r.ID will be 0 if not found
+func FindUserWebAuthnCredential(userID, id string, r *WebAuthnCredentialModel) error {
+	db := bolo.GetDefaultDatabaseConnection()
+	err := db.Where("id = ? AND userId = ?", id, userID).
+		First(r).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+// UserHasWebAuthnCredentials returns true if the user has at least one registered credential
+func UserHasWebAuthnCredentials(userID string) (bool, error) {
+	db := bolo.GetDefaultDatabaseConnection()
+
+	var count int64
+	err := db.Model(&WebAuthnCredentialModel{}).
+		Where("userId = ?", userID).
+		Count(&count).Error
+	if err != nil {
+		return false, errors.Wrap(err, "UserHasWebAuthnCredentials error on count credentials")
+	}
+
+	return count > 0, nil
+}
+
+// DeleteUserWebAuthnCredentials removes all the user credentials, used on user delete
+func DeleteUserWebAuthnCredentials(userID string) error {
+	db := bolo.GetDefaultDatabaseConnection()
+	return db.Where("userId = ?", userID).Delete(&WebAuthnCredentialModel{}).Error
+}
+
+func webAuthnCredentialIDString(credentialID []byte) string {
+	return base64.RawURLEncoding.EncodeToString(credentialID)
+}
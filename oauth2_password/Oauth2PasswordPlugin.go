@@ -1,7 +1,10 @@
 package user_oauth2_password
 
 import (
+	"fmt"
+
 	"github.com/go-bolo/bolo"
+	user_helpers "github.com/go-bolo/user/helpers"
 	"github.com/gookit/event"
 	"github.com/sirupsen/logrus"
 )
@@ -19,11 +22,27 @@ func (p *Oauth2PasswordPlugin) GetName() string {
 func (p *Oauth2PasswordPlugin) Init(app bolo.App) error {
 	logrus.Debug(p.GetName() + ".Init Running")
 
+	err := CheckSigningKeyEncryptionConfiguration(app)
+	if err != nil {
+		return fmt.Errorf("Oauth2PasswordPlugin.Init invalid signing keys configuration: %w", err)
+	}
+
+	if IsOpenIDEnabled(app) {
+		err = CheckIDTokenSigningConfiguration(app)
+		if err != nil {
+			return fmt.Errorf("Oauth2PasswordPlugin.Init invalid OpenID Connect configuration: %w", err)
+		}
+	}
+
 	app.GetEvents().On("configuration", event.ListenerFunc(func(e event.Event) error {
 		initStorage(app)
 		return nil
 	}), event.Normal)
 
+	app.GetEvents().On("close", event.ListenerFunc(func(e event.Event) error {
+		return closeStorage()
+	}), event.Normal)
+
 	app.GetEvents().On("bindMiddlewares", event.ListenerFunc(func(e event.Event) error {
 		return p.bindMiddlewares(app)
 	}), event.Normal)
@@ -49,6 +68,9 @@ func (r *Oauth2PasswordPlugin) BindRoutes(app bolo.App) error {
 
 	router := app.SetRouterGroup("auth", "/auth")
 	router.POST("/grant-password/authenticate", AuthenticationOauth2PasswordHandler)
+	router.POST("/oauth2/token", Oauth2TokenHandler)
+
+	app.GetRouter().GET("/.well-known/jwks.json", JWKSHandler)
 	// router.POST("/auth/logout", HealthCheck)
 	// router.POST("/auth/forgot-password", HealthCheck)
 	// router.GET("/auth/forgot-password", HealthCheck)
@@ -60,9 +82,18 @@ func (r *Oauth2PasswordPlugin) GetMigrations() []*bolo.Migration {
 	return []*bolo.Migration{}
 }
 
-type PluginCfgs struct{}
+type PluginCfgs struct {
+	// Optional storage of the tokens, codes and authorization requests, the default is redis.
+	// Other options: user_helpers.MemoryKVStore and user_models.DatabaseKVStore
+	Storage user_helpers.KVStore
+}
 
 func NewPlugin(cfg *PluginCfgs) *Oauth2PasswordPlugin {
 	p := Oauth2PasswordPlugin{Name: "AuthOauth2Password"}
+
+	if cfg != nil && cfg.Storage != nil {
+		Storage = cfg.Storage
+	}
+
 	return &p
 }
@@ -4,17 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-bolo/bolo"
 	"github.com/go-bolo/bolo/helpers"
+	user_helpers "github.com/go-bolo/user/helpers"
 	user_models "github.com/go-bolo/user/models"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -50,9 +50,17 @@ func oauth2TokenAuthentication(c echo.Context) error {
 		return nil
 	}
 
+	if strings.HasPrefix(token, user_models.PersonalAccessTokenPrefix) {
+		return personalAccessTokenAuthentication(ctx, token)
+	}
+
+	if isJWTAccessToken(token) && IsJWTAccessTokenEnabled(ctx.App) {
+		return jwtTokenAuthentication(ctx, token)
+	}
+
 	strData, err := GetAccessToken(token)
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
+		if errors.Is(err, user_helpers.ErrKVNotFound) {
 			return nil
 		}
 
@@ -91,6 +99,10 @@ func oauth2TokenAuthentication(c echo.Context) error {
 		}
 	}
 
+	if data.IsClientToken() {
+		return clientTokenAuthentication(ctx, data.ClientID, data.Scopes)
+	}
+
 	// get user from DB:
 	var userRecord user_models.UserModel
 	err = user_models.UserFindOne(data.OwnerId.String(), &userRecord)
@@ -108,18 +120,269 @@ func oauth2TokenAuthentication(c echo.Context) error {
 		}
 	}
 
-	if userRecord.Blocked {
+	if userRecord.ID == 0 {
+		// deleted user:
+		return &ForbiddenHTTPError{
+			Code:         401,
+			Message:      errors.New("invalid token"),
+			ErrorMessage: "invalid_grant",
+			ErrorContext: "authentication",
+		}
+	}
+
+	if !userRecord.CanLogin() {
+		return &echo.HTTPError{
+			Code:    403,
+			Message: errors.New("user is blocked or inactive"),
+		}
+	}
+
+	// tokens created before the sessions registry don't have the session id:
+	if data.SessionID != "" {
+		registry := user_models.UserSessionModel{}
+		err = user_models.FindUserSession(data.SessionID, &registry)
+		if err != nil {
+			return &echo.HTTPError{
+				Code:    500,
+				Message: errors.New("internal server error"),
+			}
+		}
+
+		if !registry.IsValidFor(userRecord.GetID()) {
+			return &ForbiddenHTTPError{
+				Code:         401,
+				Message:      errors.New("revoked token"),
+				ErrorMessage: "invalid_grant",
+				ErrorContext: "authentication",
+			}
+		}
+
+		err = registry.Touch(c.RealIP(), c.Request().UserAgent(), registry.ExpiresAt)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":     err,
+				"sessionId": data.SessionID,
+			}).Error("oauth2TokenAuthentication error on update last seen")
+		}
+
+		c.Set(user_models.CurrentUserSessionIDKey, data.SessionID)
+	}
+
+	if data.ClientID != "" {
+		c.Set(user_models.OAuthClientIDKey, data.ClientID)
+	}
+
+	ctx.SetAuthenticatedUserAndFillRoles(&userRecord)
+	narrowToTokenScopes(ctx, data.Scopes)
+
+	return nil
+}
+
+// jwtTokenAuthentication validates one signed access token without the storage and database, with the signature,
+// exp, iss and the optional jti denylist. The authenticated user only has the token claims data, see the
+// UserModel.IsFromTokenClaims. With OAUTH2_JWT_CHECK_USER the user and the token session are checked in the database
+// and the request has the database user and roles
+func jwtTokenAuthentication(ctx *bolo.RequestContext, token string) error {
+	claims, err := ParseJWTAccessToken(ctx, token)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Debug("jwtTokenAuthentication invalid access token")
+
+		return &ForbiddenHTTPError{
+			Code:         401,
+			Message:      errors.New("invalid token"),
+			ErrorMessage: "invalid_grant",
+			ErrorContext: "authentication",
+		}
+	}
+
+	if isJWTAccessTokenDenylistEnabled(ctx.App) {
+		denied, err := IsAccessTokenIDDenied(claims.Id)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"jti":   claims.Id,
+				"error": err,
+			}).Error("jwtTokenAuthentication error on check the denylist")
+
+			return &echo.HTTPError{
+				Code:    500,
+				Message: errors.New("internal server error"),
+			}
+		}
+
+		if denied {
+			return &ForbiddenHTTPError{
+				Code:         401,
+				Message:      errors.New("revoked token"),
+				ErrorMessage: "invalid_grant",
+				ErrorContext: "authentication",
+			}
+		}
+	}
+
+	if claims.IsClientToken() {
+		return clientTokenAuthentication(ctx, claims.ClientID, claims.Scopes)
+	}
+
+	userRecord, err := user_models.NewUserModelFromTokenClaims(claims.Subject, claims.Username, claims.Email, claims.Name, claims.Roles)
+	if err != nil {
+		return &ForbiddenHTTPError{
+			Code:         401,
+			Message:      errors.New("invalid token"),
+			ErrorMessage: "invalid_grant",
+			ErrorContext: "authentication",
+		}
+	}
+
+	if isJWTUserCheckEnabled(ctx.App) {
+		userRecord, err = findJWTTokenUser(userRecord.GetID(), claims.SessionID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if claims.SessionID != "" {
+		ctx.Set(user_models.CurrentUserSessionIDKey, claims.SessionID)
+	}
+
+	if claims.ClientID != "" {
+		ctx.Set(user_models.OAuthClientIDKey, claims.ClientID)
+	}
+
+	ctx.SetAuthenticatedUserAndFillRoles(userRecord)
+	narrowToTokenScopes(ctx, claims.Scopes)
+
+	return nil
+}
+
+// findJWTTokenUser returns the database user of one signed access token with the OAUTH2_JWT_CHECK_USER,
+// deleted, blocked or inactive users and revoked sessions are rejected
+func findJWTTokenUser(userID, sessionID string) (*user_models.UserModel, error) {
+	var record user_models.UserModel
+	err := user_models.UserFindOne(userID, &record)
+	if err != nil {
+		return nil, &echo.HTTPError{
+			Code:    500,
+			Message: errors.New("internal server error"),
+		}
+	}
+
+	if record.ID == 0 {
+		// deleted user:
+		return nil, &ForbiddenHTTPError{
+			Code:         401,
+			Message:      errors.New("invalid token"),
+			ErrorMessage: "invalid_grant",
+			ErrorContext: "authentication",
+		}
+	}
+
+	if !record.CanLogin() {
+		return nil, &echo.HTTPError{
+			Code:    403,
+			Message: errors.New("user is blocked or inactive"),
+		}
+	}
+
+	if sessionID != "" {
+		registry := user_models.UserSessionModel{}
+		err = user_models.FindUserSession(sessionID, &registry)
+		if err != nil {
+			return nil, &echo.HTTPError{
+				Code:    500,
+				Message: errors.New("internal server error"),
+			}
+		}
+
+		if !registry.IsValidFor(record.GetID()) {
+			return nil, &ForbiddenHTTPError{
+				Code:         401,
+				Message:      errors.New("revoked token"),
+				ErrorMessage: "invalid_grant",
+				ErrorContext: "authentication",
+			}
+		}
+	}
+
+	// the database roles replace the token roles:
+	record.LoadData()
+
+	return &record, nil
+}
+
+// personalAccessTokenAuthentication validates one personal access token with the database, then revoked tokens
+// are rejected in the next request
+func personalAccessTokenAuthentication(ctx *bolo.RequestContext, token string) error {
+	record := user_models.PersonalAccessTokenModel{}
+	err := user_models.FindPersonalAccessTokenByToken(token, &record)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("personalAccessTokenAuthentication error on find token")
+
+		return &echo.HTTPError{
+			Code:    500,
+			Message: errors.New("internal server error"),
+		}
+	}
+
+	if record.ID == 0 || record.IsExpired() {
+		return &ForbiddenHTTPError{
+			Code:         401,
+			Message:      errors.New("invalid token"),
+			ErrorMessage: "invalid_grant",
+			ErrorContext: "authentication",
+		}
+	}
+
+	var userRecord user_models.UserModel
+	err = user_models.UserFindOne(strconv.FormatUint(record.UserID, 10), &userRecord)
+	if err != nil {
+		return &echo.HTTPError{
+			Code:    500,
+			Message: errors.New("internal server error"),
+		}
+	}
+
+	if userRecord.ID == 0 {
+		return &ForbiddenHTTPError{
+			Code:         401,
+			Message:      errors.New("invalid token"),
+			ErrorMessage: "invalid_grant",
+			ErrorContext: "authentication",
+		}
+	}
+
+	if !userRecord.CanLogin() {
 		return &echo.HTTPError{
 			Code:    403,
-			Message: errors.New("user is blocked"),
+			Message: errors.New("user is blocked or inactive"),
 		}
 	}
 
+	ip, _ := getRequestClientInfo(ctx)
+	err = record.Touch(ip)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error":   err,
+			"tokenId": record.GetID(),
+		}).Error("personalAccessTokenAuthentication error on update last used")
+	}
+
+	ctx.Set(user_models.PersonalAccessTokenIDKey, record.GetID())
 	ctx.SetAuthenticatedUserAndFillRoles(&userRecord)
+	narrowToTokenScopes(ctx, record.GetScopes())
 
 	return nil
 }
 
+// IsPersonalAccessTokenRequest returns true if the request is authenticated with one personal access token
+func IsPersonalAccessTokenRequest(c echo.Context) bool {
+	id, _ := c.Get(user_models.PersonalAccessTokenIDKey).(string)
+	return id != ""
+}
+
 // Get Oauth2 token from authorization with support to use Bearer and Basic token prefix
 func GetOauth2TokenFromAuthorization(authorization string) string {
 	var tokenData []string
@@ -140,24 +403,9 @@ func GetOauth2TokenFromAuthorization(authorization string) string {
 	return ""
 }
 
+// ValidUsernamePassword - same of the user_models.ValidUsernamePassword, with the transparent password rehash
 func ValidUsernamePassword(username, password string) (bool, error) {
-	var passwordRecord user_models.PasswordModel
-
-	err := user_models.FindPasswordByUsername(username, &passwordRecord)
-	if err != nil {
-		return false, err
-	}
-
-	isValid, err := passwordRecord.Compare(password)
-	if err != nil && !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-		return false, err
-	}
-
-	if !isValid {
-		return false, nil
-	}
-
-	return true, nil
+	return user_models.ValidUsernamePassword(username, password)
 }
 
 func Oauth2FindUserWithToken(accessToken string) (bolo.UserInterface, error) {
@@ -173,6 +421,15 @@ type Oauth2TokenData struct {
 	Scopes       []string    `json:"scopes"`
 	ExpireDate   time.Time   `json:"expireDate"`
 	ExpiresIn    int64       `json:"expiresIn"`
+	// user_sessions registry id
+	SessionID string `json:"sessionId,omitempty"`
+	// oauth2 client that requested the token, client_credentials tokens don't have the OwnerId
+	ClientID string `json:"clientId,omitempty"`
+}
+
+// IsClientToken returns true for client_credentials tokens, the empty OwnerId is saved as 0
+func (r *Oauth2TokenData) IsClientToken() bool {
+	return r.ClientID != "" && (r.OwnerId == "" || r.OwnerId == "0")
 }
 
 func (r *Oauth2TokenData) IsValid() bool {
@@ -209,17 +466,69 @@ func Oauth2GenerateToken(ctx *bolo.RequestContext, u bolo.UserInterface) (Oauth2
 	return data, nil
 }
 
+// Oauth2GenerateAndSaveToken creates and saves one new token pair registered as one new user session
 func Oauth2GenerateAndSaveToken(ctx *bolo.RequestContext, user bolo.UserInterface) (Oauth2TokenData, error) {
+	return oauth2GenerateAndSaveSessionToken(ctx, user, nil, nil, "")
+}
+
+// Oauth2GenerateAndSaveScopedToken creates and saves one new token pair restricted to the scopes permissions,
+// the scopes should be validated with ValidateScopes
+func Oauth2GenerateAndSaveScopedToken(ctx *bolo.RequestContext, user bolo.UserInterface, scopes []string) (Oauth2TokenData, error) {
+	return oauth2GenerateAndSaveSessionToken(ctx, user, nil, scopes, "")
+}
+
+// oauth2GenerateAndSaveSessionToken creates and saves one new token pair, the registry is reused in token rotations.
+// The clientID is empty in tokens requested without client authentication
+func oauth2GenerateAndSaveSessionToken(ctx *bolo.RequestContext, user bolo.UserInterface, registry *user_models.UserSessionModel, scopes []string, clientID string) (Oauth2TokenData, error) {
 	data, err := Oauth2GenerateToken(ctx, user)
 	if err != nil {
 		return data, err
 	}
 
+	data.ClientID = clientID
+
+	if len(scopes) > 0 {
+		data.Scopes = scopes
+	}
+
+	cfgs := ctx.App.GetConfiguration()
+	refreshExpiration := cfgs.GetInt64F("OAUTH2_REFRESH_TOKEN_EXPIRATION", 3*1440)
+	expiresAt := time.Now().Add(time.Duration(refreshExpiration) * time.Minute)
+
+	ip, userAgent := getRequestClientInfo(ctx)
+
+	if registry == nil {
+		registry, err = user_models.CreateUserSession(user.GetID(), user_models.UserSessionTypeOauth2, ip, userAgent, expiresAt)
+		if err != nil {
+			return data, err
+		}
+	} else {
+		// force the expiration update:
+		registry.LastSeenAt = time.Time{}
+		err = registry.Touch(ip, userAgent, expiresAt)
+		if err != nil {
+			return data, err
+		}
+	}
+
+	data.SessionID = registry.GetID()
+
+	if IsJWTAccessTokenEnabled(ctx.App) {
+		// signed access tokens are validated without the storage, the ID is the token jti:
+		data.ID = uuid.New().String()
+		data.AccessToken, err = SignJWTAccessToken(ctx, user, &data)
+		if err != nil {
+			return data, err
+		}
+	}
+
 	dataJSON, _ := json.MarshalIndent(data, "", "  ")
 
-	err = SetAccessToken(ctx, data.AccessToken, string(dataJSON))
-	if err != nil {
-		return data, err
+	if !IsJWTAccessTokenEnabled(ctx.App) {
+		err = SetAccessToken(ctx, data.AccessToken, string(dataJSON))
+		if err != nil {
+			return data, err
+		}
 	}
 
 	err = SetRefreshToken(ctx, data.RefreshToken, string(dataJSON))
@@ -229,3 +538,18 @@ func Oauth2GenerateAndSaveToken(ctx *bolo.RequestContext, user bolo.UserInterfac
 
 	return data, nil
 }
+
+// getRequestClientInfo returns the client IP and user agent,
+// request contexts created outside one HTTP request (CLIs and tests) don't have them
+func getRequestClientInfo(ctx *bolo.RequestContext) (ip, userAgent string) {
+	if ctx == nil {
+		return "", ""
+	}
+
+	req := ctx.Request()
+	if req == nil {
+		return "", ""
+	}
+
+	return ctx.RealIP(), req.UserAgent()
+}
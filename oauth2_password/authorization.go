@@ -0,0 +1,258 @@
+package user_oauth2_password
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	user_helpers "github.com/go-bolo/user/helpers"
+	user_models "github.com/go-bolo/user/models"
+)
+
+// AuthorizationRequestTTL - time to answer the consent screen
+var AuthorizationRequestTTL = 10 * time.Minute
+
+// AuthorizationError - oauth2 error of one authorization request (RFC 6749 4.1.2.1).
+// Errors with RedirectURI are sent to the client, the others are shown to the user
+type AuthorizationError struct {
+	Code        string
+	Description string
+	RedirectURI string
+	State       string
+}
+
+func (e *AuthorizationError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// RedirectURL returns the client redirect_uri with the error params, empty for errors that can not be redirected
+func (e *AuthorizationError) RedirectURL() string {
+	if e.RedirectURI == "" {
+		return ""
+	}
+
+	return buildRedirectURL(e.RedirectURI, url.Values{
+		"error":             {e.Code},
+		"error_description": {e.Description},
+	}, e.State)
+}
+
+// AuthorizationRequest - validated authorization_code request, also saved as the authorization code data
+type AuthorizationRequest struct {
+	ClientID string `json:"clientId"`
+	// user that answered the consent, empty before the login
+	UserID string `json:"userId,omitempty"`
+	// the redirect_uri param, required in the code exchange if present in the authorization request
+	RedirectURIParam string   `json:"redirectUriParam,omitempty"`
+	RedirectURI      string   `json:"redirectUri"`
+	Scopes           []string `json:"scopes"`
+	State            string   `json:"state,omitempty"`
+	// PKCE S256 code challenge
+	CodeChallenge string `json:"codeChallenge"`
+	// OpenID Connect nonce, returned in the ID token
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// ParseAuthorizationRequest validates the /oauth/authorize params and returns the request and the client.
+// OAuth2 errors are returned as *AuthorizationError
+func ParseAuthorizationRequest(app bolo.App, params url.Values) (*AuthorizationRequest, *user_models.OAuthClientModel, error) {
+	clientID := params.Get("client_id")
+	if clientID == "" {
+		return nil, nil, &AuthorizationError{Code: "invalid_request", Description: "client_id is required"}
+	}
+
+	client := user_models.OAuthClientModel{}
+	err := user_models.FindOAuthClientByClientID(clientID, &client)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ParseAuthorizationRequest error on find client: %w", err)
+	}
+
+	if client.ID == 0 {
+		return nil, nil, &AuthorizationError{Code: "invalid_client", Description: "unknown client_id"}
+	}
+
+	// the redirect_uri is validated before any redirect, then one invalid uri never receives the user:
+	r := AuthorizationRequest{
+		ClientID:         client.ClientID,
+		RedirectURIParam: params.Get("redirect_uri"),
+		State:            params.Get("state"),
+		Nonce:            params.Get("nonce"),
+	}
+
+	if r.RedirectURIParam != "" {
+		if !client.AllowsRedirectURI(r.RedirectURIParam) {
+			return nil, nil, &AuthorizationError{Code: "invalid_request", Description: "redirect_uri is not registered for the client"}
+		}
+
+		r.RedirectURI = r.RedirectURIParam
+	} else {
+		uris := client.GetRedirectURIs()
+		if len(uris) != 1 {
+			return nil, nil, &AuthorizationError{Code: "invalid_request", Description: "redirect_uri is required"}
+		}
+
+		r.RedirectURI = uris[0]
+	}
+
+	redirectError := func(code, description string) error {
+		return &AuthorizationError{
+			Code:        code,
+			Description: description,
+			RedirectURI: r.RedirectURI,
+			State:       r.State,
+		}
+	}
+
+	if params.Get("response_type") != "code" {
+		return nil, nil, redirectError("unsupported_response_type", "only the code response_type is supported")
+	}
+
+	if !client.AllowsGrant("authorization_code") {
+		return nil, nil, redirectError("unauthorized_client", "the client can not use the authorization_code grant")
+	}
+
+	r.CodeChallenge = params.Get("code_challenge")
+	if r.CodeChallenge == "" || params.Get("code_challenge_method") != "S256" {
+		return nil, nil, redirectError("invalid_request", "PKCE with the S256 code_challenge_method is required")
+	}
+
+	scopes := ParseScope(params.Get("scope"))
+	err = ValidateScopes(app, scopes)
+	if err == nil {
+		scopes, err = getClientScopes(&client, scopes)
+	}
+	if err != nil {
+		if errors.Is(err, ErrInvalidScope) {
+			return nil, nil, redirectError("invalid_scope", "the requested scope is invalid")
+		}
+
+		return nil, nil, err
+	}
+
+	r.Scopes = scopes
+
+	return &r, &client, nil
+}
+
+// SaveAuthorizationRequest saves the request of one user until the consent answer and returns the consent token,
+// the token is also the consent form CSRF token
+func SaveAuthorizationRequest(ctx *bolo.RequestContext, r *AuthorizationRequest) (string, error) {
+	consentToken, err := randomURLToken()
+	if err != nil {
+		return "", err
+	}
+
+	data, _ := json.Marshal(r)
+
+	err = SetAuthorizationRequest(ctx, consentToken, string(data), AuthorizationRequestTTL)
+	if err != nil {
+		return "", fmt.Errorf("SaveAuthorizationRequest error on save: %w", err)
+	}
+
+	return consentToken, nil
+}
+
+// ConsumeAuthorizationRequestConsent returns the saved request of the user, nil if not found, expired or from other user
+func ConsumeAuthorizationRequestConsent(consentToken, userID string) (*AuthorizationRequest, error) {
+	if consentToken == "" {
+		return nil, nil
+	}
+
+	strData, err := ConsumeAuthorizationRequest(consentToken)
+	if err != nil {
+		if errors.Is(err, user_helpers.ErrKVNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("ConsumeAuthorizationRequestConsent error on get request: %w", err)
+	}
+
+	r := AuthorizationRequest{}
+	err = json.Unmarshal([]byte(strData), &r)
+	if err != nil {
+		return nil, fmt.Errorf("ConsumeAuthorizationRequestConsent error on parse request: %w", err)
+	}
+
+	if r.UserID != userID {
+		return nil, nil
+	}
+
+	return &r, nil
+}
+
+// CreateAuthorizationCode saves one authorization code of the approved request and returns the client redirect with the code
+func CreateAuthorizationCode(ctx *bolo.RequestContext, r *AuthorizationRequest) (string, error) {
+	code, err := randomURLToken()
+	if err != nil {
+		return "", err
+	}
+
+	data, _ := json.Marshal(r)
+
+	err = SetAuthorizationCode(ctx, code, string(data))
+	if err != nil {
+		return "", fmt.Errorf("CreateAuthorizationCode error on save: %w", err)
+	}
+
+	return buildRedirectURL(r.RedirectURI, url.Values{"code": {code}}, r.State), nil
+}
+
+// DeniedRedirectURL returns the client redirect of one request denied by the user
+func (r *AuthorizationRequest) DeniedRedirectURL() string {
+	return (&AuthorizationError{
+		Code:        "access_denied",
+		Description: "the user denied the request",
+		RedirectURI: r.RedirectURI,
+		State:       r.State,
+	}).RedirectURL()
+}
+
+// VerifyPKCE checks one code_verifier with the S256 code_challenge (RFC 7636 4.6)
+func VerifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 || challenge == "" {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// buildRedirectURL adds the params to the redirect uri, keeping the registered uri query
+func buildRedirectURL(redirectURI string, params url.Values, state string) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return ""
+	}
+
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+
+	if state != "" {
+		q.Set("state", state)
+	}
+
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+func randomURLToken() (string, error) {
+	k := make([]byte, 32)
+	_, err := rand.Read(k)
+	if err != nil {
+		return "", fmt.Errorf("randomURLToken error on generate token: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(k), nil
+}
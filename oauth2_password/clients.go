@@ -0,0 +1,244 @@
+package user_oauth2_password
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/bolo/helpers"
+	user_models "github.com/go-bolo/user/models"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrInvalidClient - unknown client_id or invalid client secret
+var ErrInvalidClient = errors.New("invalid_client")
+
+// GrantTypes - grant types supported in the token endpoint and allowed in the oauth_clients
+var GrantTypes = []string{"password", "refresh_token", "mfa_otp", "client_credentials", "authorization_code"}
+
+// IsSupportedGrantType returns true if the grant type is in the GrantTypes
+func IsSupportedGrantType(grantType string) bool {
+	for _, g := range GrantTypes {
+		if g == grantType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isClientRequired returns true if the token endpoint should reject requests without client authentication
+func isClientRequired(app bolo.App) bool {
+	return app.GetConfiguration().GetBoolF("OAUTH2_REQUIRE_CLIENT", false)
+}
+
+// getRequestClientCredentials returns the client credentials from the Basic authorization (RFC 6749 2.3.1)
+// or from the client_id and client_secret body params
+func getRequestClientCredentials(c echo.Context, clientID, clientSecret string) (id, secret string, basic bool) {
+	username, password, ok := c.Request().BasicAuth()
+	if ok {
+		id, err := url.QueryUnescape(username)
+		if err != nil {
+			id = username
+		}
+
+		secret, err := url.QueryUnescape(password)
+		if err != nil {
+			secret = password
+		}
+
+		return id, secret, true
+	}
+
+	return clientID, clientSecret, false
+}
+
+// AuthenticateOAuth2Client loads and validates the request client, the client is nil if the request doesn't have the client_id.
+// Confidential clients require the secret and public clients only the client_id
+func AuthenticateOAuth2Client(clientID, clientSecret string) (*user_models.OAuthClientModel, error) {
+	if clientID == "" {
+		return nil, nil
+	}
+
+	client := user_models.OAuthClientModel{}
+	err := user_models.FindOAuthClientByClientID(clientID, &client)
+	if err != nil {
+		return nil, fmt.Errorf("AuthenticateOAuth2Client error on find client: %w", err)
+	}
+
+	if client.ID == 0 {
+		return nil, ErrInvalidClient
+	}
+
+	if client.IsConfidential() && !client.ValidSecret(clientSecret) {
+		return nil, ErrInvalidClient
+	}
+
+	if !client.IsConfidential() && clientSecret != "" {
+		return nil, ErrInvalidClient
+	}
+
+	return &client, nil
+}
+
+// oauth2InvalidClientResponse - failed client authentication, with the Basic challenge for Basic requests
+func oauth2InvalidClientResponse(c echo.Context, basic bool) error {
+	if basic {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="oauth2"`)
+	}
+
+	return oauth2ErrorResponse(c, http.StatusUnauthorized, ErrInvalidClient.Error())
+}
+
+// getClientScopes returns the requested scopes limited by the client scopes, clients without scopes don't limit the
+// user tokens. The default are all the client scopes
+func getClientScopes(client *user_models.OAuthClientModel, scopes []string) ([]string, error) {
+	if client == nil {
+		return scopes, nil
+	}
+
+	clientScopes := client.GetScopes()
+	if len(clientScopes) == 0 {
+		return scopes, nil
+	}
+
+	if len(scopes) == 0 {
+		return ParseScope(client.Scopes), nil
+	}
+
+	if !isScopeSubset(scopes, clientScopes) {
+		return nil, ErrInvalidScope
+	}
+
+	return scopes, nil
+}
+
+type oauth2ClientCredentialsJSONResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// oauth2ClientCredentialsGrant - machine to machine tokens, without user and refresh token.
+// The token only has the permissions of the scopes
+func oauth2ClientCredentialsGrant(c echo.Context, client *user_models.OAuthClientModel, scopes []string) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if client == nil || !client.IsConfidential() {
+		return oauth2InvalidClientResponse(c, false)
+	}
+
+	data, err := Oauth2GenerateAndSaveClientToken(ctx, client, scopes)
+	if err != nil {
+		return err
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventClientToken, "", map[string]interface{}{
+		"scopes": strings.Join(data.Scopes, " "),
+	})
+
+	return c.JSON(http.StatusOK, &oauth2ClientCredentialsJSONResponse{
+		AccessToken: data.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   data.ExpiresIn,
+		Scope:       strings.Join(data.Scopes, " "),
+	})
+}
+
+// Oauth2GenerateAndSaveClientToken creates and saves one access token of the client, without owner and refresh token
+func Oauth2GenerateAndSaveClientToken(ctx *bolo.RequestContext, client *user_models.OAuthClientModel, scopes []string) (Oauth2TokenData, error) {
+	cfgs := ctx.App.GetConfiguration()
+
+	accessToken := uuid.New().String() + helpers.RandStringBytes(35)
+	expireD := time.Duration(cfgs.GetInt64F("OAUTH2_ACCESS_TOKEN_EXPIRATION", 30)) * time.Minute
+
+	data := Oauth2TokenData{
+		ID:          accessToken,
+		ClientID:    client.ClientID,
+		AccessToken: accessToken,
+		Scopes:      scopes,
+		ExpireDate:  time.Now().Add(expireD),
+		ExpiresIn:   int64(expireD / time.Second),
+	}
+
+	if data.Scopes == nil {
+		data.Scopes = []string{}
+	}
+
+	if IsJWTAccessTokenEnabled(ctx.App) {
+		var err error
+		data.ID = uuid.New().String()
+		data.AccessToken, err = SignJWTAccessToken(ctx, nil, &data)
+		if err != nil {
+			return data, err
+		}
+
+		return data, nil
+	}
+
+	dataJSON, _ := json.MarshalIndent(data, "", "  ")
+
+	err := SetAccessToken(ctx, data.AccessToken, string(dataJSON))
+	if err != nil {
+		return data, err
+	}
+
+	return data, nil
+}
+
+// clientTokenAuthentication authenticates one client_credentials token, the request is not authenticated with one user
+// and only has the scopes permissions. Deleted clients and clients without the grant are rejected in the next request
+func clientTokenAuthentication(ctx *bolo.RequestContext, clientID string, scopes []string) error {
+	client := user_models.OAuthClientModel{}
+	err := user_models.FindOAuthClientByClientID(clientID, &client)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"clientId": clientID,
+			"error":    err,
+		}).Error("clientTokenAuthentication error on find client")
+
+		return &echo.HTTPError{
+			Code:    500,
+			Message: errors.New("internal server error"),
+		}
+	}
+
+	if client.ID == 0 || !client.AllowsGrant("client_credentials") {
+		return &ForbiddenHTTPError{
+			Code:         401,
+			Message:      errors.New("revoked token"),
+			ErrorMessage: "invalid_grant",
+			ErrorContext: "authentication",
+		}
+	}
+
+	ctx.Set(user_models.OAuthClientIDKey, clientID)
+	ctx.Set(TokenScopesKey, scopes)
+	ctx.App = &clientApp{App: ctx.App, permissions: getScopesPermissions(ctx.App, scopes)}
+
+	return nil
+}
+
+// GetRequestClientID returns the client_id of the oauth2 token used in the request, empty for tokens without client
+func GetRequestClientID(c echo.Context) string {
+	clientID, _ := c.Get(user_models.OAuthClientIDKey).(string)
+	return clientID
+}
+
+// clientApp - request app of the client_credentials tokens, only the scopes permissions are allowed
+type clientApp struct {
+	bolo.App
+	permissions map[string]bool
+}
+
+func (a *clientApp) Can(permission string, userRoles []string) bool {
+	return a.permissions[permission]
+}
@@ -0,0 +1,259 @@
+package user_oauth2_password_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	user_models "github.com/go-bolo/user/models"
+	user_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type clientsTestWhoamiResponse struct {
+	IsAuthenticated bool   `json:"isAuthenticated"`
+	ClientID        string `json:"clientId"`
+	FindUser        bool   `json:"find_user"`
+	UpdateUser      bool   `json:"update_user"`
+}
+
+func requestTokenWithBasic(app bolo.App, clientID, clientSecret string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/auth/oauth2/token", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+	req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+
+	rec := httptest.NewRecorder()
+	app.GetRouter().ServeHTTP(rec, req)
+
+	return rec
+}
+
+func requestClientsTestWhoami(app bolo.App, accessToken string) (int, clientsTestWhoamiResponse) {
+	req := httptest.NewRequest(http.MethodGet, "/clients-test/whoami", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+	req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+
+	rec := httptest.NewRecorder()
+	app.GetRouter().ServeHTTP(rec, req)
+
+	var resp clientsTestWhoamiResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+
+	return rec.Code, resp
+}
+
+func TestOauth2TokenHandler_Clients(t *testing.T) {
+	t.Setenv("OAUTH2_SCOPES", `{"read": ["find_user"], "write": ["update_user"]}`)
+
+	app := GetAppInstance()
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	app.GetRouter().GET("/clients-test/whoami", func(c echo.Context) error {
+		ctx := c.(*bolo.RequestContext)
+
+		return c.JSON(http.StatusOK, &clientsTestWhoamiResponse{
+			IsAuthenticated: ctx.IsAuthenticated,
+			ClientID:        user_oauth2_password.GetRequestClientID(c),
+			FindUser:        ctx.Can("find_user"),
+			UpdateUser:      ctx.Can("update_user"),
+		})
+	})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+		Roles:    []string{"administrator"},
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	err = u.SetPassword("123456")
+	assert.NoError(t, err)
+
+	service, serviceSecret, err := user_models.CreateOAuthClient("service", []string{"client_credentials"}, []string{"read"}, nil, true)
+	assert.NoError(t, err)
+	defer service.Delete()
+
+	webApp, webAppSecret, err := user_models.CreateOAuthClient("web", []string{"password", "refresh_token"}, nil, nil, true)
+	assert.NoError(t, err)
+	defer webApp.Delete()
+
+	mobile, mobileSecret, err := user_models.CreateOAuthClient("mobile", []string{"password", "client_credentials"}, nil, nil, false)
+	assert.NoError(t, err)
+	defer mobile.Delete()
+	assert.Empty(t, mobileSecret)
+
+	t.Run("client_credentials with Basic authentication", func(t *testing.T) {
+		rec := requestTokenWithBasic(app, service.ClientID, serviceSecret, url.Values{
+			"grant_type": {"client_credentials"},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), `"scope":"read"`)
+		assert.NotContains(t, rec.Body.String(), "refresh_token")
+
+		var token tokenResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &token)
+		assert.NoError(t, err)
+
+		code, whoami := requestClientsTestWhoami(app, token.AccessToken)
+		assert.Equal(t, http.StatusOK, code)
+		assert.False(t, whoami.IsAuthenticated)
+		assert.Equal(t, service.ClientID, whoami.ClientID)
+		assert.True(t, whoami.FindUser)
+		assert.False(t, whoami.UpdateUser)
+	})
+
+	t.Run("client_credentials with body authentication", func(t *testing.T) {
+		rec := requestToken(app, url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {service.ClientID},
+			"client_secret": {serviceSecret},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+
+	t.Run("reject invalid client secrets", func(t *testing.T) {
+		rec := requestTokenWithBasic(app, service.ClientID, "invalid", url.Values{
+			"grant_type": {"client_credentials"},
+		})
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid_client")
+		assert.NotEmpty(t, rec.Header().Get(echo.HeaderWWWAuthenticate))
+
+		rec = requestToken(app, url.Values{
+			"grant_type": {"client_credentials"},
+			"client_id":  {"unknown"},
+		})
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("reject grants and scopes not allowed for the client", func(t *testing.T) {
+		rec := requestTokenWithBasic(app, webApp.ClientID, webAppSecret, url.Values{
+			"grant_type": {"client_credentials"},
+		})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "unauthorized_client")
+
+		rec = requestTokenWithBasic(app, service.ClientID, serviceSecret, url.Values{
+			"grant_type": {"client_credentials"},
+			"scope":      {"read write"},
+		})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid_scope")
+	})
+
+	t.Run("public clients can not use client_credentials", func(t *testing.T) {
+		rec := requestToken(app, url.Values{
+			"grant_type": {"client_credentials"},
+			"client_id":  {mobile.ClientID},
+		})
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid_client")
+	})
+
+	t.Run("user tokens have the client and only the same client can refresh them", func(t *testing.T) {
+		rec := requestTokenWithBasic(app, webApp.ClientID, webAppSecret, url.Values{
+			"grant_type": {"password"},
+			"email":      {u.Email},
+			"password":   {"123456"},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var token tokenResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &token)
+		assert.NoError(t, err)
+
+		code, whoami := requestClientsTestWhoami(app, token.AccessToken)
+		assert.Equal(t, http.StatusOK, code)
+		assert.True(t, whoami.IsAuthenticated)
+		assert.Equal(t, webApp.ClientID, whoami.ClientID)
+
+		rec = requestTokenWithBasic(app, webApp.ClientID, webAppSecret, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {token.RefreshToken},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var refreshed tokenResponse
+		err = json.Unmarshal(rec.Body.Bytes(), &refreshed)
+		assert.NoError(t, err)
+
+		rec = requestToken(app, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {refreshed.RefreshToken},
+		})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid_grant")
+	})
+
+	t.Run("OAUTH2_REQUIRE_CLIENT rejects anonymous clients", func(t *testing.T) {
+		t.Setenv("OAUTH2_REQUIRE_CLIENT", "true")
+
+		rec := requestToken(app, url.Values{
+			"grant_type": {"password"},
+			"email":      {u.Email},
+			"password":   {"123456"},
+		})
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid_client")
+
+		rec = requestToken(app, url.Values{
+			"grant_type": {"password"},
+			"email":      {u.Email},
+			"password":   {"123456"},
+			"client_id":  {mobile.ClientID},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+
+	t.Run("signed client_credentials tokens", func(t *testing.T) {
+		t.Setenv("OAUTH2_ACCESS_TOKEN_FORMAT", "jwt")
+		t.Setenv("OAUTH2_JWT_ALGORITHM", "HS256")
+		t.Setenv("OAUTH2_JWT_SECRET", "a-jwt-secret-with-more-than-32-chars")
+
+		rec := requestTokenWithBasic(app, service.ClientID, serviceSecret, url.Values{
+			"grant_type": {"client_credentials"},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var token tokenResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &token)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, strings.Count(token.AccessToken, "."))
+
+		code, whoami := requestClientsTestWhoami(app, token.AccessToken)
+		assert.Equal(t, http.StatusOK, code)
+		assert.False(t, whoami.IsAuthenticated)
+		assert.Equal(t, service.ClientID, whoami.ClientID)
+		assert.True(t, whoami.FindUser)
+	})
+
+	t.Run("deleted clients tokens are rejected", func(t *testing.T) {
+		other, otherSecret, err := user_models.CreateOAuthClient("other", []string{"client_credentials"}, []string{"read"}, nil, true)
+		assert.NoError(t, err)
+
+		rec := requestTokenWithBasic(app, other.ClientID, otherSecret, url.Values{
+			"grant_type": {"client_credentials"},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var token tokenResponse
+		err = json.Unmarshal(rec.Body.Bytes(), &token)
+		assert.NoError(t, err)
+
+		err = other.Delete()
+		assert.NoError(t, err)
+
+		code, _ := requestClientsTestWhoami(app, token.AccessToken)
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+}
@@ -1,12 +1,17 @@
 package user_oauth2_password
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/go-bolo/bolo"
+	user_helpers "github.com/go-bolo/user/helpers"
 	user_models "github.com/go-bolo/user/models"
+	"github.com/go-bolo/user/security"
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
@@ -22,6 +27,8 @@ type oauth2PasswordJSONResponse struct {
 	AccessToken  *string                `json:"access_token"`
 	RefreshToken *string                `json:"refresh_token"`
 	ExpiresIn    *int64                 `json:"expires_in"`
+	Scope        string                 `json:"scope,omitempty"`
+	IDToken      string                 `json:"id_token,omitempty"` // OpenID Connect, tokens with the openid scope
 	User         *user_models.UserModel `json:"user"`
 }
 
@@ -29,8 +36,65 @@ type oauth2PasswordJSONResponseError struct {
 	bolo.BaseErrorResponse
 }
 
+type oauth2TokenRequestBody struct {
+	GrantType    string `json:"grant_type" form:"grant_type" validate:"required"`
+	Email        string `json:"email" form:"email"`
+	Password     string `json:"password" form:"password"`
+	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+	MfaToken     string `json:"mfa_token" form:"mfa_token"`
+	Otp          string `json:"otp" form:"otp"`
+	// space separated scopes, see the OAUTH2_SCOPES configuration
+	Scope string `json:"scope" form:"scope"`
+	// client authentication in the body, the Basic authorization is also accepted
+	ClientID     string `json:"client_id" form:"client_id"`
+	ClientSecret string `json:"client_secret" form:"client_secret"`
+	// authorization_code grant:
+	Code         string `json:"code" form:"code"`
+	RedirectURI  string `json:"redirect_uri" form:"redirect_uri"`
+	CodeVerifier string `json:"code_verifier" form:"code_verifier"`
+}
+
+// returned in password logins of users with two-factor authentication enabled
+type oauth2MfaRequiredResponse struct {
+	Error     string `json:"error"`
+	MfaToken  string `json:"mfa_token"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+// returned in password logins of temporarily locked accounts
+type oauth2AccountLockedJSONResponse struct {
+	Error            string     `json:"error"`
+	ErrorDescription string     `json:"error_description"`
+	LockedUntil      *time.Time `json:"locked_until"`
+}
+
+func oauth2AccountLockedResponse(c echo.Context, u *user_models.UserModel) error {
+	return c.JSON(http.StatusLocked, &oauth2AccountLockedJSONResponse{
+		Error:            "account_locked",
+		ErrorDescription: "Conta temporariamente bloqueada por excesso de tentativas de login.",
+		LockedUntil:      u.LockedUntil,
+	})
+}
+
 func AuthenticationOauth2PasswordHandler(c echo.Context) error {
 	var body oauth2PasswordRequestBody
+
+	if err := c.Bind(&body); err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	if err := c.Validate(&body); err != nil {
+		return err
+	}
+
+	return oauth2PasswordGrant(c, body.Email, body.Password, nil, "")
+}
+
+// Oauth2TokenHandler - Oauth2 token endpoint, select the grant flow with the grant_type param.
+// Requests without client_id are anonymous clients, unless OAUTH2_REQUIRE_CLIENT is enabled
+func Oauth2TokenHandler(c echo.Context) error {
+	var body oauth2TokenRequestBody
+
 	ctx := c.(*bolo.RequestContext)
 
 	if err := c.Bind(&body); err != nil {
@@ -41,15 +105,111 @@ func AuthenticationOauth2PasswordHandler(c echo.Context) error {
 		return err
 	}
 
-	valid, err := ValidUsernamePassword(body.Email, body.Password)
+	if !IsSupportedGrantType(body.GrantType) {
+		return oauth2ErrorResponse(c, http.StatusBadRequest, "unsupported_grant_type")
+	}
+
+	clientID, clientSecret, basic := getRequestClientCredentials(c, body.ClientID, body.ClientSecret)
+
+	client, err := AuthenticateOAuth2Client(clientID, clientSecret)
+	if err != nil {
+		if errors.Is(err, ErrInvalidClient) {
+			return oauth2InvalidClientResponse(c, basic)
+		}
+
+		return err
+	}
+
+	if client == nil && isClientRequired(ctx.App) {
+		return oauth2InvalidClientResponse(c, basic)
+	}
+
+	if client != nil {
+		if !client.AllowsGrant(body.GrantType) {
+			return oauth2ErrorResponse(c, http.StatusBadRequest, "unauthorized_client")
+		}
+
+		clientID = client.ClientID
+		// saved in the auth events of this request:
+		ctx.Set(user_models.OAuthClientIDKey, clientID)
+	}
+
+	scopes := ParseScope(body.Scope)
+
+	err = ValidateScopes(ctx.App, scopes)
+	if err == nil && body.GrantType != "refresh_token" && body.GrantType != "authorization_code" {
+		// refresh tokens and authorization codes keep the scopes limited by the client in the first request:
+		scopes, err = getClientScopes(client, scopes)
+	}
+	if err != nil {
+		if errors.Is(err, ErrInvalidScope) {
+			return oauth2ErrorResponse(c, http.StatusBadRequest, "invalid_scope")
+		}
+
+		return err
+	}
+
+	switch body.GrantType {
+	case "password":
+		if body.Email == "" || body.Password == "" {
+			return oauth2ErrorResponse(c, http.StatusBadRequest, "Email e senha são obrigatórios.")
+		}
+
+		return oauth2PasswordGrant(c, body.Email, body.Password, scopes, clientID)
+	case "refresh_token":
+		if body.RefreshToken == "" {
+			return oauth2ErrorResponse(c, http.StatusBadRequest, "O refresh_token é obrigatório.")
+		}
+
+		return oauth2RefreshTokenGrant(c, body.RefreshToken, scopes, clientID)
+	case "mfa_otp":
+		if body.MfaToken == "" || body.Otp == "" {
+			return oauth2ErrorResponse(c, http.StatusBadRequest, "O mfa_token e o otp são obrigatórios.")
+		}
+
+		return oauth2MfaOtpGrant(c, body.MfaToken, body.Otp, scopes, clientID)
+	case "client_credentials":
+		return oauth2ClientCredentialsGrant(c, client, scopes)
+	case "authorization_code":
+		if body.Code == "" || body.CodeVerifier == "" {
+			return oauth2ErrorResponse(c, http.StatusBadRequest, "invalid_request")
+		}
+
+		return oauth2AuthorizationCodeGrant(c, client, body.Code, body.RedirectURI, body.CodeVerifier)
+	}
+
+	return oauth2ErrorResponse(c, http.StatusBadRequest, "unsupported_grant_type")
+}
+
+func oauth2PasswordGrant(c echo.Context, email, password string, scopes []string, clientID string) error {
+	ctx := c.(*bolo.RequestContext)
+
+	throttle := security.GetAppLoginThrottle(ctx.App)
+	throttleKey := strings.ToLower(strings.TrimSpace(email))
+
+	err := security.CheckLoginThrottle(throttle, throttleKey, c)
+	if err != nil {
+		return err
+	}
+
+	var userRecord user_models.UserModel
+
+	err = user_models.UserFindOneByUsername(email, &userRecord)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if userRecord.IsLocked() {
+		user_models.RecordLoginFailure(ctx, userRecord.GetID(), email, "oauth2", "account_locked")
+		return oauth2AccountLockedResponse(c, &userRecord)
+	}
+
+	valid, err := ValidUsernamePassword(email, password)
 	if err != nil {
 		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
-			result := oauth2PasswordJSONResponseError{}
-			result.Messages = append(result.Messages, bolo.BaseErrorResponseMessage{
-				Status:  "danger",
-				Message: "Usuário não encontrado ou não possuí senha cadastrada.",
-			})
-			return c.JSON(400, &result)
+			onLoginFail(throttle, throttleKey, c)
+			user_models.RecordLoginFailure(ctx, userRecord.GetID(), email, "oauth2", user_models.GetPasswordNotFoundReason(&userRecord))
+			return oauth2ErrorResponse(c, http.StatusBadRequest, security.InvalidCredentialsMessage)
 		}
 
 		logrus.WithFields(logrus.Fields{
@@ -59,34 +219,338 @@ func AuthenticationOauth2PasswordHandler(c echo.Context) error {
 	}
 
 	if !valid {
-		result := oauth2PasswordJSONResponseError{}
-		result.Messages = append(result.Messages, bolo.BaseErrorResponseMessage{
-			Status:  "danger",
-			Message: "Email ou senha incorretos.",
-		})
-		return c.JSON(400, &result)
+		onLoginFail(throttle, throttleKey, c)
+
+		user_models.RecordLoginFailure(ctx, userRecord.GetID(), email, "oauth2", "invalid_password")
+
+		if user_models.OnUserLoginFail(ctx, &userRecord) {
+			return oauth2AccountLockedResponse(c, &userRecord)
+		}
+
+		return oauth2ErrorResponse(c, http.StatusBadRequest, security.InvalidCredentialsMessage)
+	}
+
+	if !userRecord.CanLogin() {
+		user_models.RecordLoginFailure(ctx, userRecord.GetID(), email, "oauth2", "inactive_user")
+		return oauth2ErrorResponse(c, http.StatusBadRequest, "Conta inativa ou bloqueada.")
 	}
 
 	// create oauth2Tokens
 
+	hasMfa, err := user_models.UserHasMfaEnabled(userRecord.GetID())
+	if err != nil {
+		return err
+	}
+
+	if hasMfa {
+		// the tokens are only created after the mfa_otp grant:
+		challenge, err := user_models.CreateMfaChallenge(userRecord.GetID())
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusForbidden, &oauth2MfaRequiredResponse{
+			Error:     "mfa_required",
+			MfaToken:  challenge.Token,
+			ExpiresIn: int64(user_models.MfaChallengeTTL.Seconds()),
+		})
+	}
+
+	// the lockout and throttle counters are only reset after the second factor in logins with MFA:
+	user_models.OnUserLoginSuccess(ctx, &userRecord)
+
+	data, err := oauth2GenerateAndSaveSessionToken(ctx, &userRecord, nil, scopes, clientID)
+	if err != nil {
+		return err
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventLogin, userRecord.GetID(), map[string]interface{}{
+		"method": "oauth2",
+	})
+
+	resp := oauth2PasswordJSONResponse{
+		AccessToken:  &data.AccessToken,
+		RefreshToken: &data.RefreshToken,
+		ExpiresIn:    &data.ExpiresIn,
+		Scope:        strings.Join(data.Scopes, " "),
+		User:         &userRecord,
+	}
+
+	return c.JSON(200, &resp)
+}
+
+// oauth2RefreshTokenGrant - rotate the access and refresh tokens, the used refresh token is invalidated.
+// The new tokens keep the scopes, or one subset of them if requested, and only the token client can refresh it
+func oauth2RefreshTokenGrant(c echo.Context, refreshToken string, scopes []string, clientID string) error {
+	ctx := c.(*bolo.RequestContext)
+
+	idTokenKeys, err := getGrantIDTokenSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	// consume the refresh token then a replayed token will not be found:
+	strData, err := ConsumeRefreshToken(refreshToken)
+	if err != nil {
+		if errors.Is(err, user_helpers.ErrKVNotFound) {
+			return oauth2ErrorResponse(c, http.StatusBadRequest, "Refresh token inválido ou expirado.")
+		}
+
+		return fmt.Errorf("oauth2RefreshTokenGrant error on get refresh token: %w", err)
+	}
+
+	var data Oauth2TokenData
+	err = json.Unmarshal([]byte(strData), &data)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("oauth2RefreshTokenGrant error on parse refresh token data")
+
+		return oauth2ErrorResponse(c, http.StatusBadRequest, "Refresh token inválido ou expirado.")
+	}
+
+	if data.ClientID != clientID {
+		// the refresh token was consumed, one token used by other client should not be used again:
+		return oauth2ErrorResponse(c, http.StatusBadRequest, "invalid_grant")
+	}
+
+	if len(scopes) == 0 {
+		scopes = data.Scopes
+	} else if !isScopeSubset(scopes, data.Scopes) {
+		// the refresh token was consumed, then the client should login again:
+		return oauth2ErrorResponse(c, http.StatusBadRequest, "invalid_scope")
+	}
+
+	// the old access token is replaced by the new one:
+	err = RevokeAccessToken(ctx, data.AccessToken)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("oauth2RefreshTokenGrant error on revoke old access token")
+	}
+
 	var userRecord user_models.UserModel
+	err = user_models.UserFindOne(data.OwnerId.String(), &userRecord)
+	if err != nil {
+		return fmt.Errorf("oauth2RefreshTokenGrant error on find user: %w", err)
+	}
+
+	if !userRecord.CanLogin() {
+		return oauth2ErrorResponse(c, http.StatusBadRequest, "Refresh token inválido ou expirado.")
+	}
+
+	// keep the same session registry in token rotations, tokens created before the registry get a new one:
+	var registry *user_models.UserSessionModel
+	if data.SessionID != "" {
+		registry = &user_models.UserSessionModel{}
+		err = user_models.FindUserSession(data.SessionID, registry)
+		if err != nil {
+			return fmt.Errorf("oauth2RefreshTokenGrant error on find session: %w", err)
+		}
+
+		if !registry.IsValidFor(userRecord.GetID()) {
+			return oauth2ErrorResponse(c, http.StatusBadRequest, "Refresh token inválido ou expirado.")
+		}
+	}
+
+	var idToken string
+	if idTokenKeys != nil && clientID != "" && HasOpenIDScope(scopes) {
+		idToken, err = signIDToken(ctx, idTokenKeys, &userRecord, clientID, "", scopes)
+		if err != nil {
+			return err
+		}
+	}
+
+	newData, err := oauth2GenerateAndSaveSessionToken(ctx, &userRecord, registry, scopes, clientID)
+	if err != nil {
+		return err
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventTokenRefresh, userRecord.GetID(), nil)
+
+	resp := oauth2PasswordJSONResponse{
+		AccessToken:  &newData.AccessToken,
+		RefreshToken: &newData.RefreshToken,
+		ExpiresIn:    &newData.ExpiresIn,
+		Scope:        strings.Join(newData.Scopes, " "),
+		IDToken:      idToken,
+		User:         &userRecord,
+	}
+
+	return c.JSON(200, &resp)
+}
+
+// oauth2MfaOtpGrant - second step of password logins with two-factor authentication, accepts TOTP or recovery codes.
+// The scopes are not saved in the mfa_token and should be sent again in this grant
+func oauth2MfaOtpGrant(c echo.Context, mfaToken, otp string, scopes []string, clientID string) error {
+	ctx := c.(*bolo.RequestContext)
 
-	err = user_models.UserFindOneByUsername(body.Email, &userRecord)
+	challenge, err := user_models.FindMfaChallenge(mfaToken)
+	if err != nil {
+		return fmt.Errorf("oauth2MfaOtpGrant error on find challenge: %w", err)
+	}
+
+	if challenge == nil {
+		return oauth2ErrorResponse(c, http.StatusBadRequest, "mfa_token inválido ou expirado.")
+	}
+
+	throttle := security.GetAppLoginThrottle(ctx.App)
+	throttleKey := "mfa_" + *challenge.UserID
+
+	err = security.CheckLoginThrottle(throttle, throttleKey, c)
 	if err != nil {
 		return err
 	}
 
-	data, err := Oauth2GenerateAndSaveToken(ctx, &userRecord)
+	valid, err := user_models.CompleteMfaChallenge(challenge, otp)
+	if err != nil {
+		return fmt.Errorf("oauth2MfaOtpGrant error on check code: %w", err)
+	}
+
+	if !valid {
+		onLoginFail(throttle, throttleKey, c)
+		user_models.RecordLoginFailure(ctx, *challenge.UserID, "", "oauth2", "invalid_mfa_code")
+		return oauth2ErrorResponse(c, http.StatusBadRequest, "Código de verificação inválido.")
+	}
+
+	var userRecord user_models.UserModel
+	err = user_models.UserFindOne(*challenge.UserID, &userRecord)
+	if err != nil {
+		return fmt.Errorf("oauth2MfaOtpGrant error on find user: %w", err)
+	}
+
+	if !userRecord.CanLogin() {
+		return oauth2ErrorResponse(c, http.StatusBadRequest, "mfa_token inválido ou expirado.")
+	}
+
+	user_models.OnUserLoginSuccess(ctx, &userRecord, throttleKey)
+
+	data, err := oauth2GenerateAndSaveSessionToken(ctx, &userRecord, nil, scopes, clientID)
 	if err != nil {
 		return err
 	}
 
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventLogin, userRecord.GetID(), map[string]interface{}{
+		"method": "oauth2",
+		"mfa":    true,
+	})
+
 	resp := oauth2PasswordJSONResponse{
 		AccessToken:  &data.AccessToken,
 		RefreshToken: &data.RefreshToken,
 		ExpiresIn:    &data.ExpiresIn,
+		Scope:        strings.Join(data.Scopes, " "),
 		User:         &userRecord,
 	}
 
 	return c.JSON(200, &resp)
 }
+
+// oauth2AuthorizationCodeGrant - exchange one authorization code from the /oauth/authorize for the user tokens.
+// The code is consumed in the first use and requires the same client, redirect_uri and the PKCE code_verifier
+func oauth2AuthorizationCodeGrant(c echo.Context, client *user_models.OAuthClientModel, code, redirectURI, codeVerifier string) error {
+	ctx := c.(*bolo.RequestContext)
+
+	if client == nil {
+		return oauth2InvalidClientResponse(c, false)
+	}
+
+	idTokenKeys, err := getGrantIDTokenSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	strData, err := ConsumeAuthorizationCode(code)
+	if err != nil {
+		if errors.Is(err, user_helpers.ErrKVNotFound) {
+			return oauth2ErrorResponse(c, http.StatusBadRequest, "invalid_grant")
+		}
+
+		return fmt.Errorf("oauth2AuthorizationCodeGrant error on get code: %w", err)
+	}
+
+	var r AuthorizationRequest
+	err = json.Unmarshal([]byte(strData), &r)
+	if err != nil {
+		return fmt.Errorf("oauth2AuthorizationCodeGrant error on parse code data: %w", err)
+	}
+
+	if r.ClientID != client.ClientID || r.RedirectURIParam != redirectURI || !VerifyPKCE(codeVerifier, r.CodeChallenge) {
+		return oauth2ErrorResponse(c, http.StatusBadRequest, "invalid_grant")
+	}
+
+	var userRecord user_models.UserModel
+	err = user_models.UserFindOne(r.UserID, &userRecord)
+	if err != nil {
+		return fmt.Errorf("oauth2AuthorizationCodeGrant error on find user: %w", err)
+	}
+
+	if !userRecord.CanLogin() || userRecord.IsLocked() {
+		return oauth2ErrorResponse(c, http.StatusBadRequest, "invalid_grant")
+	}
+
+	var idToken string
+	if idTokenKeys != nil && HasOpenIDScope(r.Scopes) {
+		idToken, err = signIDToken(ctx, idTokenKeys, &userRecord, client.ClientID, r.Nonce, r.Scopes)
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := oauth2GenerateAndSaveSessionToken(ctx, &userRecord, nil, r.Scopes, client.ClientID)
+	if err != nil {
+		return err
+	}
+
+	user_models.RecordAuthEvent(ctx, user_models.AuthEventLogin, userRecord.GetID(), map[string]interface{}{
+		"method": "authorization_code",
+	})
+
+	resp := oauth2PasswordJSONResponse{
+		AccessToken:  &data.AccessToken,
+		RefreshToken: &data.RefreshToken,
+		ExpiresIn:    &data.ExpiresIn,
+		Scope:        strings.Join(data.Scopes, " "),
+		IDToken:      idToken,
+		User:         &userRecord,
+	}
+
+	return c.JSON(200, &resp)
+}
+
+// getGrantIDTokenSigningKeys loads the ID token keys before one grant consumes the code or refresh token,
+// then one keys error does not invalidate them. Returns nil keys if the OpenID Connect is disabled
+func getGrantIDTokenSigningKeys(ctx *bolo.RequestContext) (*accessTokenKeys, error) {
+	if !IsOpenIDEnabled(ctx.App) {
+		return nil, nil
+	}
+
+	keys, err := getIDTokenSigningKeys(ctx.App)
+	if err != nil {
+		return nil, fmt.Errorf("getGrantIDTokenSigningKeys error on get keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+func onLoginFail(throttle security.LoginThrottleInterface, throttleKey string, c echo.Context) {
+	if throttle == nil {
+		return
+	}
+
+	err := throttle.OnLoginFail(throttleKey, c)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": fmt.Sprintf("%+v\n", err),
+		}).Error("oauth2PasswordGrant error on register login fail")
+	}
+}
+
+func oauth2ErrorResponse(c echo.Context, status int, message string) error {
+	result := oauth2PasswordJSONResponseError{}
+	result.Messages = append(result.Messages, bolo.BaseErrorResponseMessage{
+		Status:  "danger",
+		Message: message,
+	})
+	return c.JSON(status, &result)
+}
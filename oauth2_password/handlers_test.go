@@ -0,0 +1,560 @@
+package user_oauth2_password_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	user_models "github.com/go-bolo/user/models"
+	user_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/go-bolo/user/security"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+func requestToken(app bolo.App, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/auth/oauth2/token", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+
+	rec := httptest.NewRecorder()
+	app.GetRouter().ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestOauth2TokenHandler_RefreshToken(t *testing.T) {
+	app := GetAppInstance()
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	err = u.SetPassword("123456")
+	assert.NoError(t, err)
+
+	rec := requestToken(app, url.Values{
+		"grant_type": {"password"},
+		"email":      {u.Email},
+		"password":   {"123456"},
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var first tokenResponse
+	err = json.Unmarshal(rec.Body.Bytes(), &first)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, first.RefreshToken)
+
+	t.Run("refresh token can not be used as access token", func(t *testing.T) {
+		_, err := user_oauth2_password.GetAccessToken(first.RefreshToken)
+		assert.Error(t, err)
+	})
+
+	t.Run("access token can not be used as refresh token", func(t *testing.T) {
+		rec := requestToken(app, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {first.AccessToken},
+		})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	var second tokenResponse
+	t.Run("success rotate both tokens", func(t *testing.T) {
+		rec := requestToken(app, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {first.RefreshToken},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		err = json.Unmarshal(rec.Body.Bytes(), &second)
+		assert.NoError(t, err)
+		assert.NotEqual(t, first.AccessToken, second.AccessToken)
+		assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+		_, err := user_oauth2_password.GetAccessToken(first.AccessToken)
+		assert.Error(t, err, "the old access token should be deleted")
+
+		_, err = user_oauth2_password.GetAccessToken(second.AccessToken)
+		assert.NoError(t, err)
+	})
+
+	t.Run("error on replay the used refresh token", func(t *testing.T) {
+		rec := requestToken(app, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {first.RefreshToken},
+		})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("token rotation keeps the same user session", func(t *testing.T) {
+		sessions, err := user_models.FindUserSessionsByUserID(u.GetID())
+		assert.NoError(t, err)
+		assert.Len(t, sessions, 1)
+	})
+
+	t.Run("error on refresh tokens of revoked sessions", func(t *testing.T) {
+		err := user_models.DeleteUserSessions(u.GetID(), "")
+		assert.NoError(t, err)
+
+		rec := requestToken(app, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {second.RefreshToken},
+		})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("error on unsupported grant type", func(t *testing.T) {
+		rec := requestToken(app, url.Values{
+			"grant_type": {"implicit"},
+		})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestOauth2TokenHandler_DatabaseStorage(t *testing.T) {
+	app := GetAppInstance()
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	redisStorage := user_oauth2_password.Storage
+	user_oauth2_password.Storage = user_models.NewDatabaseKVStore()
+	defer func() {
+		user_oauth2_password.Storage.Close()
+		user_oauth2_password.Storage = redisStorage
+	}()
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	err = u.SetPassword("123456")
+	assert.NoError(t, err)
+
+	rec := requestToken(app, url.Values{
+		"grant_type": {"password"},
+		"email":      {u.Email},
+		"password":   {"123456"},
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var token tokenResponse
+	err = json.Unmarshal(rec.Body.Bytes(), &token)
+	assert.NoError(t, err)
+
+	_, err = user_oauth2_password.GetAccessToken(token.AccessToken)
+	assert.NoError(t, err)
+
+	rec = requestToken(app, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// the refresh token is consumed in the database store too:
+	rec = requestToken(app, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+	})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestOauth2TokenHandler_InactiveUser(t *testing.T) {
+	app := GetAppInstance()
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	err = u.SetPassword("123456")
+	assert.NoError(t, err)
+
+	t.Run("inactive users can not login", func(t *testing.T) {
+		rec := requestToken(app, url.Values{
+			"grant_type": {"password"},
+			"email":      {u.Email},
+			"password":   {"123456"},
+		})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Conta inativa ou bloqueada.")
+	})
+
+	t.Run("blocked users can not login", func(t *testing.T) {
+		err := app.GetDB().Model(&u).Updates(map[string]interface{}{"active": true, "blocked": true}).Error
+		assert.NoError(t, err)
+
+		rec := requestToken(app, url.Values{
+			"grant_type": {"password"},
+			"email":      {u.Email},
+			"password":   {"123456"},
+		})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestOauth2TokenHandler_MfaOtp(t *testing.T) {
+	app := GetAppInstance()
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	err = u.SetPassword("123456")
+	assert.NoError(t, err)
+
+	secret, err := security.GenerateTOTPSecret()
+	assert.NoError(t, err)
+
+	totp := user_models.UserTOTPModel{
+		UserID:    int64(u.ID),
+		Secret:    secret,
+		Confirmed: true,
+	}
+	err = totp.Save()
+	assert.NoError(t, err)
+	defer totp.Delete()
+
+	recoveryCodes, err := user_models.GenerateMfaRecoveryCodes(u.GetID())
+	assert.NoError(t, err)
+	defer user_models.DeleteUserMfaRecoveryCodes(u.GetID())
+
+	login := func(t *testing.T) string {
+		rec := requestToken(app, url.Values{
+			"grant_type": {"password"},
+			"email":      {u.Email},
+			"password":   {"123456"},
+		})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		var resp struct {
+			Error       string `json:"error"`
+			MfaToken    string `json:"mfa_token"`
+			AccessToken string `json:"access_token"`
+		}
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		assert.Equal(t, "mfa_required", resp.Error)
+		assert.NotEmpty(t, resp.MfaToken)
+		assert.Empty(t, resp.AccessToken)
+
+		return resp.MfaToken
+	}
+
+	t.Run("invalid mfa_token", func(t *testing.T) {
+		rec := requestToken(app, url.Values{
+			"grant_type": {"mfa_otp"},
+			"mfa_token":  {"invalid"},
+			"otp":        {"123456"},
+		})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("invalid otp", func(t *testing.T) {
+		mfaToken := login(t)
+
+		rec := requestToken(app, url.Values{
+			"grant_type": {"mfa_otp"},
+			"mfa_token":  {mfaToken},
+			"otp":        {"000000a"},
+		})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("success with totp code", func(t *testing.T) {
+		mfaToken := login(t)
+
+		code, err := security.GenerateTOTPCode(secret, time.Now())
+		assert.NoError(t, err)
+
+		rec := requestToken(app, url.Values{
+			"grant_type": {"mfa_otp"},
+			"mfa_token":  {mfaToken},
+			"otp":        {code},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var resp tokenResponse
+		err = json.Unmarshal(rec.Body.Bytes(), &resp)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, resp.AccessToken)
+
+		// the challenge is single use:
+		rec = requestToken(app, url.Values{
+			"grant_type": {"mfa_otp"},
+			"mfa_token":  {mfaToken},
+			"otp":        {code},
+		})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("login failures are only reset after the second factor", func(t *testing.T) {
+		rec := requestToken(app, url.Values{
+			"grant_type": {"password"},
+			"email":      {u.Email},
+			"password":   {"wrong-password"},
+		})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		mfaToken := login(t)
+
+		var record user_models.UserModel
+		err := user_models.UserFindOne(u.GetID(), &record)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, record.FailedLoginCount)
+
+		rec = requestToken(app, url.Values{
+			"grant_type": {"mfa_otp"},
+			"mfa_token":  {mfaToken},
+			"otp":        {recoveryCodes[1]},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		err = user_models.UserFindOne(u.GetID(), &record)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, record.FailedLoginCount)
+	})
+
+	t.Run("recovery code is single use", func(t *testing.T) {
+		mfaToken := login(t)
+
+		rec := requestToken(app, url.Values{
+			"grant_type": {"mfa_otp"},
+			"mfa_token":  {mfaToken},
+			"otp":        {recoveryCodes[0]},
+		})
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		mfaToken = login(t)
+
+		rec = requestToken(app, url.Values{
+			"grant_type": {"mfa_otp"},
+			"mfa_token":  {mfaToken},
+			"otp":        {recoveryCodes[0]},
+		})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestOauth2TokenHandler_PasswordRehash(t *testing.T) {
+	app := GetAppInstance()
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	// password migrated from one legacy system:
+	userID := int64(u.ID)
+	legacy := user_models.PasswordModel{
+		UserID:   &userID,
+		Password: "sha1$abc$0a16ed046558c7f76b63e5de16a8bb3b176f137a",
+	}
+	err = legacy.Save()
+	assert.NoError(t, err)
+
+	rec := requestToken(app, url.Values{
+		"grant_type": {"password"},
+		"email":      {u.Email},
+		"password":   {"wrong-secret"},
+	})
+	assert.NotEqual(t, http.StatusOK, rec.Code)
+
+	var record user_models.PasswordModel
+	err = user_models.FindPasswordByUserID(u.GetID(), &record)
+	assert.NoError(t, err)
+	assert.Equal(t, legacy.Password, record.Password, "invalid logins should not change the hash")
+
+	rec = requestToken(app, url.Values{
+		"grant_type": {"password"},
+		"email":      {u.Email},
+		"password":   {"legacy-secret"},
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	record = user_models.PasswordModel{}
+	err = user_models.FindPasswordByUserID(u.GetID(), &record)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(record.Password, "$argon2id$"), record.Password)
+	assert.False(t, record.NeedsRehash())
+
+	valid, err := user_models.ValidUsernamePassword(u.Email, "legacy-secret")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+}
+
+func TestOauth2TokenHandler_InvalidCredentials(t *testing.T) {
+	app := GetAppInstance()
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	withPassword := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := withPassword.Save(ctx)
+	assert.NoError(t, err)
+	defer withPassword.Delete()
+
+	err = withPassword.SetPassword("correct-horse-battery")
+	assert.NoError(t, err)
+
+	withoutPassword := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err = withoutPassword.Save(ctx)
+	assert.NoError(t, err)
+	defer withoutPassword.Delete()
+
+	wrongPassword := requestToken(app, url.Values{
+		"grant_type": {"password"},
+		"email":      {withPassword.Email},
+		"password":   {"wrong-password"},
+	})
+	assert.Equal(t, http.StatusBadRequest, wrongPassword.Code)
+	assert.Contains(t, wrongPassword.Body.String(), security.InvalidCredentialsMessage)
+
+	// unknown users and users without password receive the same response:
+	for _, email := range []string{withoutPassword.Email, gofakeit.Email()} {
+		rec := requestToken(app, url.Values{
+			"grant_type": {"password"},
+			"email":      {email},
+			"password":   {"wrong-password"},
+		})
+		assert.Equal(t, wrongPassword.Code, rec.Code)
+		assert.Equal(t, wrongPassword.Body.String(), rec.Body.String())
+	}
+
+	// the real reason is only in the auth events:
+	for u, reason := range map[*user_models.UserModel]string{
+		&withPassword:    "invalid_password",
+		&withoutPassword: "password_not_set",
+	} {
+		events, _, err := user_models.QueryAuthEvents(&user_models.AuthEventQueryOpts{
+			UserID: u.GetID(),
+			Type:   user_models.AuthEventLoginFailed,
+			Limit:  10,
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, events, 1) {
+			assert.Contains(t, string(events[0].Metadata), `"reason":"`+reason+`"`)
+		}
+	}
+}
+
+func TestOauth2TokenHandler_AccountLockout(t *testing.T) {
+	t.Setenv("AUTH_LOCKOUT_MAX_FAILURES", "3")
+
+	app := GetAppInstance()
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	err = u.SetPassword("correct-horse-battery")
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		rec := requestToken(app, url.Values{
+			"grant_type": {"password"},
+			"email":      {u.Email},
+			"password":   {"wrong-password"},
+		})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+
+	rec := requestToken(app, url.Values{
+		"grant_type": {"password"},
+		"email":      {u.Email},
+		"password":   {"wrong-password"},
+	})
+	assert.Equal(t, http.StatusLocked, rec.Code)
+
+	resp := struct {
+		Error       string     `json:"error"`
+		LockedUntil *time.Time `json:"locked_until"`
+	}{}
+	err = json.Unmarshal(rec.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	assert.Equal(t, "account_locked", resp.Error)
+	assert.NotNil(t, resp.LockedUntil)
+
+	// the valid password is also rejected while locked:
+	rec = requestToken(app, url.Values{
+		"grant_type": {"password"},
+		"email":      {u.Email},
+		"password":   {"correct-horse-battery"},
+	})
+	assert.Equal(t, http.StatusLocked, rec.Code)
+
+	var record user_models.UserModel
+	err = user_models.UserFindOne(u.GetID(), &record)
+	assert.NoError(t, err)
+	assert.True(t, record.IsLocked())
+	assert.Equal(t, 1, record.LockoutCount)
+	assert.NotEmpty(t, record.LastFailedLoginIP)
+
+	events, count, err := user_models.QueryAuthEvents(&user_models.AuthEventQueryOpts{
+		UserID: u.GetID(),
+		Limit:  10,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), count, "3 invalid passwords, 1 lock and 1 login in locked account")
+	if assert.NotEmpty(t, events) {
+		assert.Equal(t, user_models.AuthEventLoginFailed, events[0].Type)
+	}
+
+	err = record.ResetLoginFailures()
+	assert.NoError(t, err)
+
+	rec = requestToken(app, url.Values{
+		"grant_type": {"password"},
+		"email":      {u.Email},
+		"password":   {"correct-horse-battery"},
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
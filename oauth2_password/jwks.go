@@ -0,0 +1,144 @@
+package user_oauth2_password
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-bolo/bolo"
+	"github.com/labstack/echo/v4"
+)
+
+// JSONWebKey - public key of one signing key in the JWK format (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	// RSA keys:
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519 keys:
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JSONWebKeySet - response of the /.well-known/jwks.json endpoint
+type JSONWebKeySet struct {
+	Keys []*JSONWebKey `json:"keys"`
+}
+
+// GetJSONWebKeySet returns the public keys accepted in the signed access tokens,
+// HS256 secrets are never published
+func GetJSONWebKeySet(app bolo.App) (*JSONWebKeySet, error) {
+	set := JSONWebKeySet{Keys: []*JSONWebKey{}}
+
+	keys := []*accessTokenKeys{}
+	if IsJWTDatabaseKeysEnabled(app) {
+		managed, err := DefaultKeyManager.PublicKeys(app)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = managed
+	} else {
+		if getJWTAlgorithm(app) == jwt.SigningMethodHS256.Alg() {
+			return &set, nil
+		}
+
+		k, err := getConfigurationAccessTokenKeys(app)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, k)
+	}
+
+	for _, k := range keys {
+		jwk, err := newJSONWebKey(k.verifyKey)
+		if err != nil {
+			return nil, err
+		}
+
+		jwk.Alg = k.method.Alg()
+		jwk.Kid = k.kid
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return &set, nil
+}
+
+// JWKSHandler - publish the public signing keys for the services that verify the access tokens
+func JWKSHandler(c echo.Context) error {
+	ctx := c.(*bolo.RequestContext)
+
+	set, err := GetJSONWebKeySet(ctx.App)
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+
+	return c.JSON(http.StatusOK, set)
+}
+
+func newJSONWebKey(publicKey interface{}) (*JSONWebKey, error) {
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		return &JSONWebKey{
+			Kty: "RSA",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return &JSONWebKey{
+			Kty: "OKP",
+			Use: "sig",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(k),
+		}, nil
+	default:
+		return nil, errors.New("newJSONWebKey unsupported public key type")
+	}
+}
+
+// jwkThumbprint returns the RFC 7638 thumbprint of one public key, used as kid
+func jwkThumbprint(publicKey interface{}) (string, error) {
+	jwk, err := newJSONWebKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	// only the required members, in lexicographic order:
+	var members interface{}
+	if jwk.Kty == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,406 @@
+package user_oauth2_password
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-bolo/bolo"
+)
+
+const (
+	// JWTKeysConfiguration - signing keys from the OAUTH2_JWT_SECRET or OAUTH2_JWT_PRIVATE_KEY configurations, the default
+	JWTKeysConfiguration = "config"
+	// JWTKeysDatabase - signing keys generated, rotated and saved in the database by the KeyManager
+	JWTKeysDatabase = "database"
+)
+
+const (
+	// AccessTokenFormatOpaque - random access tokens saved in the redis storage, the default format
+	AccessTokenFormatOpaque = "opaque"
+	// AccessTokenFormatJWT - signed access tokens validated without the storage and database
+	AccessTokenFormatJWT = "jwt"
+)
+
+// AccessTokenClaims - claims of the signed JWT access tokens
+type AccessTokenClaims struct {
+	Roles     []string `json:"roles"`
+	Scopes    []string `json:"scopes"`
+	SessionID string   `json:"sid,omitempty"`
+	Username  string   `json:"preferred_username,omitempty"`
+	Email     string   `json:"email,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	// oauth2 client of the token, client_credentials tokens have the client_id as sub
+	ClientID string `json:"client_id,omitempty"`
+	jwt.StandardClaims
+}
+
+// IsClientToken returns true for client_credentials tokens, without user
+func (c *AccessTokenClaims) IsClientToken() bool {
+	return c.ClientID != "" && c.Subject == c.ClientID
+}
+
+// IsJWTAccessTokenEnabled returns true if the OAUTH2_ACCESS_TOKEN_FORMAT configuration is jwt
+func IsJWTAccessTokenEnabled(app bolo.App) bool {
+	format := app.GetConfiguration().GetF("OAUTH2_ACCESS_TOKEN_FORMAT", AccessTokenFormatOpaque)
+	return strings.EqualFold(format, AccessTokenFormatJWT)
+}
+
+// IsJWTDatabaseKeysEnabled returns true if the OAUTH2_JWT_KEYS configuration is database
+func IsJWTDatabaseKeysEnabled(app bolo.App) bool {
+	return strings.EqualFold(app.GetConfiguration().GetF("OAUTH2_JWT_KEYS", JWTKeysConfiguration), JWTKeysDatabase)
+}
+
+// getJWTAlgorithm returns the OAUTH2_JWT_ALGORITHM, database keys are asymmetric then the default is RS256
+func getJWTAlgorithm(app bolo.App) string {
+	if IsJWTDatabaseKeysEnabled(app) {
+		return app.GetConfiguration().GetF("OAUTH2_JWT_ALGORITHM", jwt.SigningMethodRS256.Alg())
+	}
+
+	return app.GetConfiguration().GetF("OAUTH2_JWT_ALGORITHM", jwt.SigningMethodHS256.Alg())
+}
+
+// isJWTAccessTokenDenylistEnabled returns true if revoked signed access tokens should be checked in the storage
+func isJWTAccessTokenDenylistEnabled(app bolo.App) bool {
+	return app.GetConfiguration().GetBoolF("OAUTH2_JWT_DENYLIST", false)
+}
+
+// isJWTUserCheckEnabled returns true if the signed access tokens user and session are checked in the database
+func isJWTUserCheckEnabled(app bolo.App) bool {
+	return app.GetConfiguration().GetBoolF("OAUTH2_JWT_CHECK_USER", false)
+}
+
+// isJWTAccessToken returns true if the token has the JWT compact format, opaque tokens don't have dots
+func isJWTAccessToken(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func getJWTAccessTokenIssuer(ctx *bolo.RequestContext) string {
+	return ctx.App.GetConfiguration().GetF("OAUTH2_JWT_ISSUER", ctx.AppOrigin)
+}
+
+// SignJWTAccessToken creates the signed access token of one token data, the data.ID is the token jti.
+// The user is nil in client_credentials tokens
+func SignJWTAccessToken(ctx *bolo.RequestContext, u bolo.UserInterface, data *Oauth2TokenData) (string, error) {
+	keys, err := getAccessTokenSigningKeys(ctx.App)
+	if err != nil {
+		return "", err
+	}
+
+	if keys.signKey == nil {
+		return "", errors.New("SignJWTAccessToken the private key is required to sign access tokens")
+	}
+
+	claims := AccessTokenClaims{
+		Roles:     []string{},
+		Scopes:    data.Scopes,
+		SessionID: data.SessionID,
+		ClientID:  data.ClientID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        data.ID,
+			Subject:   data.ClientID,
+			Issuer:    getJWTAccessTokenIssuer(ctx),
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: data.ExpireDate.Unix(),
+		},
+	}
+
+	if u != nil {
+		claims.Roles = u.GetRoles()
+		claims.Username = u.GetUsername()
+		claims.Email = u.GetEmail()
+		claims.Name = u.GetDisplayName()
+		claims.Subject = u.GetID()
+	}
+
+	t := jwt.NewWithClaims(keys.method, claims)
+	if keys.kid != "" {
+		t.Header["kid"] = keys.kid
+	}
+
+	token, err := t.SignedString(keys.signKey)
+	if err != nil {
+		return "", fmt.Errorf("SignJWTAccessToken error on sign: %w", err)
+	}
+
+	return token, nil
+}
+
+// ParseJWTAccessToken validates the signature, algorithm, issuer and expiration of one signed access token
+func ParseJWTAccessToken(ctx *bolo.RequestContext, token string) (*AccessTokenClaims, error) {
+	claims := AccessTokenClaims{}
+	_, err := jwt.ParseWithClaims(token, &claims, accessTokenKeyFunc(ctx.App))
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.ExpiresAt == 0 || claims.Subject == "" || claims.Id == "" {
+		return nil, errors.New("ParseJWTAccessToken the exp, sub and jti claims are required")
+	}
+
+	if !claims.VerifyIssuer(getJWTAccessTokenIssuer(ctx), true) {
+		return nil, errors.New("ParseJWTAccessToken invalid issuer")
+	}
+
+	return &claims, nil
+}
+
+// accessTokenKeyFunc returns the verification key of one token kid header, used by the access and ID tokens
+func accessTokenKeyFunc(app bolo.App) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		keys, err := getAccessTokenVerificationKeys(app, kid)
+		if err != nil {
+			return nil, err
+		}
+
+		// only accept the key algorithm, then one public key can not be used as HMAC secret:
+		if t.Method.Alg() != keys.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+
+		return keys.verifyKey, nil
+	}
+}
+
+// RevokeAccessToken removes one opaque access token from the storage or
+// adds the jti of one signed access token to the denylist, if enabled
+func RevokeAccessToken(ctx *bolo.RequestContext, token string) error {
+	if !isJWTAccessToken(token) {
+		return DeleteAccessToken(ctx, token)
+	}
+
+	if !IsJWTAccessTokenEnabled(ctx.App) || !isJWTAccessTokenDenylistEnabled(ctx.App) {
+		return nil
+	}
+
+	claims, err := ParseJWTAccessToken(ctx, token)
+	if err != nil {
+		// invalid or expired tokens can not be used:
+		return nil
+	}
+
+	return DenyAccessTokenID(claims.Id, time.Unix(claims.ExpiresAt, 0))
+}
+
+type accessTokenKeys struct {
+	method jwt.SigningMethod
+	// public key thumbprint, empty for HS256 keys
+	kid       string
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// parsed keys, the configuration is compared in every call to allow configuration changes in tests
+var accessTokenKeysCache struct {
+	sync.Mutex
+	configuration string
+	keys          *accessTokenKeys
+}
+
+// getAccessTokenSigningKeys returns the key used to sign new access tokens
+func getAccessTokenSigningKeys(app bolo.App) (*accessTokenKeys, error) {
+	if IsJWTDatabaseKeysEnabled(app) {
+		return DefaultKeyManager.SigningKeys(app)
+	}
+
+	return getConfigurationAccessTokenKeys(app)
+}
+
+// getAccessTokenVerificationKeys returns the key of one access token kid header
+func getAccessTokenVerificationKeys(app bolo.App, kid string) (*accessTokenKeys, error) {
+	if IsJWTDatabaseKeysEnabled(app) {
+		return DefaultKeyManager.VerificationKeys(app, kid)
+	}
+
+	return getConfigurationAccessTokenKeys(app)
+}
+
+// getConfigurationAccessTokenKeys loads the keys of the OAUTH2_JWT_ALGORITHM (HS256, RS256 or EdDSA) from the
+// OAUTH2_JWT_SECRET or the OAUTH2_JWT_PRIVATE_KEY and optional OAUTH2_JWT_PUBLIC_KEY PEM configurations
+func getConfigurationAccessTokenKeys(app bolo.App) (*accessTokenKeys, error) {
+	cfgs := app.GetConfiguration()
+
+	algorithm := getJWTAlgorithm(app)
+	secret := cfgs.Get("OAUTH2_JWT_SECRET")
+	privateKey := pemFromConfiguration(cfgs.Get("OAUTH2_JWT_PRIVATE_KEY"))
+	publicKey := pemFromConfiguration(cfgs.Get("OAUTH2_JWT_PUBLIC_KEY"))
+
+	configuration := strings.Join([]string{algorithm, secret, privateKey, publicKey}, "|")
+
+	accessTokenKeysCache.Lock()
+	defer accessTokenKeysCache.Unlock()
+
+	if accessTokenKeysCache.keys != nil && accessTokenKeysCache.configuration == configuration {
+		return accessTokenKeysCache.keys, nil
+	}
+
+	keys := accessTokenKeys{}
+
+	switch algorithm {
+	case jwt.SigningMethodHS256.Alg():
+		if secret == "" {
+			return nil, errors.New("getConfigurationAccessTokenKeys the OAUTH2_JWT_SECRET configuration is required for HS256")
+		}
+
+		keys.method = jwt.SigningMethodHS256
+		keys.signKey = []byte(secret)
+		keys.verifyKey = []byte(secret)
+	case jwt.SigningMethodRS256.Alg():
+		keys.method = jwt.SigningMethodRS256
+
+		if privateKey != "" {
+			k, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(privateKey))
+			if err != nil {
+				return nil, fmt.Errorf("getConfigurationAccessTokenKeys error on parse the RS256 private key: %w", err)
+			}
+
+			keys.signKey = k
+			keys.verifyKey = &k.PublicKey
+		}
+
+		if publicKey != "" {
+			k, err := jwt.ParseRSAPublicKeyFromPEM([]byte(publicKey))
+			if err != nil {
+				return nil, fmt.Errorf("getConfigurationAccessTokenKeys error on parse the RS256 public key: %w", err)
+			}
+
+			keys.verifyKey = k
+		}
+	case SigningMethodEdDSA.Alg():
+		keys.method = SigningMethodEdDSA
+
+		if privateKey != "" {
+			k, err := parseEd25519PrivateKeyFromPEM([]byte(privateKey))
+			if err != nil {
+				return nil, err
+			}
+
+			keys.signKey = k
+			keys.verifyKey = k.Public()
+		}
+
+		if publicKey != "" {
+			k, err := parseEd25519PublicKeyFromPEM([]byte(publicKey))
+			if err != nil {
+				return nil, err
+			}
+
+			keys.verifyKey = k
+		}
+	default:
+		return nil, fmt.Errorf("getConfigurationAccessTokenKeys unsupported OAUTH2_JWT_ALGORITHM %s", algorithm)
+	}
+
+	if keys.verifyKey == nil {
+		return nil, fmt.Errorf("getConfigurationAccessTokenKeys the OAUTH2_JWT_PRIVATE_KEY or OAUTH2_JWT_PUBLIC_KEY configuration is required for %s", algorithm)
+	}
+
+	if keys.method != jwt.SigningMethodHS256 {
+		kid, err := jwkThumbprint(keys.verifyKey)
+		if err != nil {
+			return nil, err
+		}
+
+		keys.kid = kid
+	}
+
+	accessTokenKeysCache.configuration = configuration
+	accessTokenKeysCache.keys = &keys
+
+	return &keys, nil
+}
+
+// pemFromConfiguration allows PEM keys with escaped line breaks in environment variables
+func pemFromConfiguration(v string) string {
+	return strings.ReplaceAll(strings.TrimSpace(v), `\n`, "\n")
+}
+
+func parseEd25519PrivateKeyFromPEM(key []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("parseEd25519PrivateKeyFromPEM invalid PEM")
+	}
+
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parseEd25519PrivateKeyFromPEM error on parse: %w", err)
+	}
+
+	privateKey, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("parseEd25519PrivateKeyFromPEM not is one Ed25519 private key")
+	}
+
+	return privateKey, nil
+}
+
+func parseEd25519PublicKeyFromPEM(key []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("parseEd25519PublicKeyFromPEM invalid PEM")
+	}
+
+	k, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parseEd25519PublicKeyFromPEM error on parse: %w", err)
+	}
+
+	publicKey, ok := k.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("parseEd25519PublicKeyFromPEM not is one Ed25519 public key")
+	}
+
+	return publicKey, nil
+}
+
+// SigningMethodEdDSA - Ed25519 signing method, not available in the jwt-go v3
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+var errEdDSAVerification = errors.New("crypto/ed25519: verification error")
+
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return errEdDSAVerification
+	}
+
+	return nil
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
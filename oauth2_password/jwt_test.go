@@ -0,0 +1,244 @@
+package user_oauth2_password_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	user_models "github.com/go-bolo/user/models"
+	user_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type jwtTestMeResponse struct {
+	ID              string   `json:"id"`
+	Email           string   `json:"email"`
+	Roles           []string `json:"roles"`
+	FromTokenClaims bool     `json:"fromTokenClaims"`
+}
+
+func requestJWTTestMe(app bolo.App, accessToken string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/jwt-test/me", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+	req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+
+	rec := httptest.NewRecorder()
+	app.GetRouter().ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestOauth2TokenHandler_JWTAccessToken(t *testing.T) {
+	app := GetAppInstance()
+
+	app.GetRouter().GET("/jwt-test/me", func(c echo.Context) error {
+		ctx := c.(*bolo.RequestContext)
+		if !ctx.IsAuthenticated {
+			return c.NoContent(http.StatusForbidden)
+		}
+
+		u := ctx.AuthenticatedUser.(*user_models.UserModel)
+
+		return c.JSON(http.StatusOK, &jwtTestMeResponse{
+			ID:              u.GetID(),
+			Email:           u.Email,
+			Roles:           ctx.Roles,
+			FromTokenClaims: u.IsFromTokenClaims(),
+		})
+	})
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	rsaPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	assert.NoError(t, err)
+	edPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDER})
+
+	tests := []struct {
+		name      string
+		algorithm string
+		secret    string
+		key       string
+	}{
+		{name: "HS256", algorithm: "HS256", secret: "a-jwt-secret-with-more-than-32-chars"},
+		// escaped line breaks, like in environment variables:
+		{name: "RS256", algorithm: "RS256", key: strings.ReplaceAll(string(rsaPEM), "\n", `\n`)},
+		{name: "EdDSA", algorithm: "EdDSA", key: string(edPEM)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OAUTH2_ACCESS_TOKEN_FORMAT", "jwt")
+			t.Setenv("OAUTH2_JWT_DENYLIST", "true")
+			t.Setenv("OAUTH2_JWT_ALGORITHM", tt.algorithm)
+			t.Setenv("OAUTH2_JWT_SECRET", tt.secret)
+			t.Setenv("OAUTH2_JWT_PRIVATE_KEY", tt.key)
+
+			ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+			u := user_models.UserModel{
+				Username: gofakeit.UUID(),
+				Email:    gofakeit.Email(),
+				Active:   true,
+				Roles:    []string{"editor"},
+			}
+			err := u.Save(ctx)
+			assert.NoError(t, err)
+			defer u.Delete()
+
+			err = u.SetPassword("123456")
+			assert.NoError(t, err)
+
+			rec := requestToken(app, url.Values{
+				"grant_type": {"password"},
+				"email":      {u.Email},
+				"password":   {"123456"},
+			})
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			var first tokenResponse
+			err = json.Unmarshal(rec.Body.Bytes(), &first)
+			assert.NoError(t, err)
+
+			claims, err := user_oauth2_password.ParseJWTAccessToken(ctx, first.AccessToken)
+			assert.NoError(t, err)
+			assert.Equal(t, u.GetID(), claims.Subject)
+			assert.Equal(t, []string{"editor"}, claims.Roles)
+			assert.NotEmpty(t, claims.Id)
+			assert.NotEmpty(t, claims.SessionID)
+			assert.NotZero(t, claims.ExpiresAt)
+
+			t.Run("access token is not saved in the storage", func(t *testing.T) {
+				_, err := user_oauth2_password.GetAccessToken(first.AccessToken)
+				assert.Error(t, err)
+			})
+
+			t.Run("authenticate with the token claims user", func(t *testing.T) {
+				rec := requestJWTTestMe(app, first.AccessToken)
+				assert.Equal(t, http.StatusOK, rec.Code)
+
+				var me jwtTestMeResponse
+				err := json.Unmarshal(rec.Body.Bytes(), &me)
+				assert.NoError(t, err)
+				assert.Equal(t, u.GetID(), me.ID)
+				assert.Equal(t, u.Email, me.Email)
+				assert.True(t, me.FromTokenClaims)
+				assert.Contains(t, me.Roles, "editor")
+				assert.Contains(t, me.Roles, "authenticated")
+			})
+
+			t.Run("reject tampered token", func(t *testing.T) {
+				parts := strings.Split(first.AccessToken, ".")
+				tampered := parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2]))
+
+				rec := requestJWTTestMe(app, tampered)
+				assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			})
+
+			t.Run("refresh denies the old access token", func(t *testing.T) {
+				rec := requestToken(app, url.Values{
+					"grant_type":    {"refresh_token"},
+					"refresh_token": {first.RefreshToken},
+				})
+				assert.Equal(t, http.StatusOK, rec.Code)
+
+				var second tokenResponse
+				err := json.Unmarshal(rec.Body.Bytes(), &second)
+				assert.NoError(t, err)
+				assert.NotEqual(t, first.AccessToken, second.AccessToken)
+
+				rec = requestJWTTestMe(app, first.AccessToken)
+				assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+				rec = requestJWTTestMe(app, second.AccessToken)
+				assert.Equal(t, http.StatusOK, rec.Code)
+			})
+
+			t.Run("check the database user and session with OAUTH2_JWT_CHECK_USER", func(t *testing.T) {
+				rec := requestToken(app, url.Values{
+					"grant_type": {"password"},
+					"email":      {u.Email},
+					"password":   {"123456"},
+				})
+				assert.Equal(t, http.StatusOK, rec.Code)
+
+				var token tokenResponse
+				err := json.Unmarshal(rec.Body.Bytes(), &token)
+				assert.NoError(t, err)
+
+				err = app.GetDB().Model(&u).Update("blocked", true).Error
+				assert.NoError(t, err)
+
+				// validated without the database by default:
+				rec = requestJWTTestMe(app, token.AccessToken)
+				assert.Equal(t, http.StatusOK, rec.Code)
+
+				t.Setenv("OAUTH2_JWT_CHECK_USER", "true")
+
+				rec = requestJWTTestMe(app, token.AccessToken)
+				assert.Equal(t, http.StatusForbidden, rec.Code)
+
+				err = app.GetDB().Model(&u).Update("blocked", false).Error
+				assert.NoError(t, err)
+
+				err = u.SetRoles([]string{"reviewer"})
+				assert.NoError(t, err)
+
+				rec = requestJWTTestMe(app, token.AccessToken)
+				assert.Equal(t, http.StatusOK, rec.Code)
+
+				var me jwtTestMeResponse
+				err = json.Unmarshal(rec.Body.Bytes(), &me)
+				assert.NoError(t, err)
+				assert.False(t, me.FromTokenClaims)
+				assert.Contains(t, me.Roles, "reviewer")
+				assert.NotContains(t, me.Roles, "editor")
+
+				err = user_models.DeleteUserSessions(u.GetID(), "")
+				assert.NoError(t, err)
+
+				rec = requestJWTTestMe(app, token.AccessToken)
+				assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			})
+		})
+	}
+
+	t.Run("reject tokens signed with other algorithm", func(t *testing.T) {
+		t.Setenv("OAUTH2_ACCESS_TOKEN_FORMAT", "jwt")
+		t.Setenv("OAUTH2_JWT_ALGORITHM", "HS256")
+		t.Setenv("OAUTH2_JWT_SECRET", "a-jwt-secret-with-more-than-32-chars")
+
+		ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+		u := user_models.UserModel{ID: 10, Username: gofakeit.UUID(), Email: gofakeit.Email()}
+		data, err := user_oauth2_password.Oauth2GenerateToken(ctx, &u)
+		assert.NoError(t, err)
+		data.ID = gofakeit.UUID()
+
+		t.Setenv("OAUTH2_JWT_ALGORITHM", "EdDSA")
+		t.Setenv("OAUTH2_JWT_PRIVATE_KEY", string(edPEM))
+		token, err := user_oauth2_password.SignJWTAccessToken(ctx, &u, &data)
+		assert.NoError(t, err)
+
+		t.Setenv("OAUTH2_JWT_ALGORITHM", "HS256")
+		_, err = user_oauth2_password.ParseJWTAccessToken(ctx, token)
+		assert.Error(t, err)
+
+		rec := requestJWTTestMe(app, token)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
@@ -0,0 +1,495 @@
+package user_oauth2_password
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-bolo/bolo"
+	user_models "github.com/go-bolo/user/models"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrSigningKeyNotFound - the token kid is unknown or the key is retired
+var ErrSigningKeyNotFound = errors.New("signing key not found")
+
+// ErrSigningKeyRotated - the active key was rotated by other app instance in the same time
+var ErrSigningKeyRotated = errors.New("signing key already rotated")
+
+// encryptedSigningKeyPEMType - PEM type of the private keys encrypted with the OAUTH2_JWT_KEY_ENCRYPTION_KEY,
+// the bytes are the AES-GCM nonce and the sealed PKCS8 key
+const encryptedSigningKeyPEMType = "ENCRYPTED SIGNING KEY"
+
+// KeyManagerCacheTTL - time to reload the keys from the database, keys rotated by other app instances are loaded after it
+var KeyManagerCacheTTL = time.Minute
+
+// keyManagerReloadInterval - min time between reloads caused by unknown kids
+var keyManagerReloadInterval = 5 * time.Second
+
+// DefaultKeyManager - key manager used by the oauth2 handlers and middleware with OAUTH2_JWT_KEYS=database
+var DefaultKeyManager = &KeyManager{}
+
+// KeyManager - signing keys of the JWT access tokens saved in the signing_keys table.
+// The active key is rotated after OAUTH2_JWT_KEY_ROTATION days, or with Rotate, and the rotated keys
+// are accepted for OAUTH2_JWT_KEY_RETIRE_AFTER minutes, then rotations never invalidate access tokens.
+// Private keys are saved encrypted with the OAUTH2_JWT_KEY_ENCRYPTION_KEY
+type KeyManager struct {
+	mu       sync.Mutex
+	keys     []*managedSigningKey
+	loadedAt time.Time
+}
+
+type managedSigningKey struct {
+	accessTokenKeys
+	id       uint64
+	status   string
+	notAfter time.Time
+}
+
+// SigningKeys returns the active key, the key is created or rotated if needed
+func (m *KeyManager) SigningKeys(app bolo.App) (*accessTokenKeys, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, err := m.ensureActiveKey(app)
+	if err != nil {
+		return nil, err
+	}
+
+	return &k.accessTokenKeys, nil
+}
+
+// VerificationKeys returns the active or inactive key with the kid
+func (m *KeyManager) VerificationKeys(app bolo.App, kid string) (*accessTokenKeys, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := m.load(app, false)
+	if err != nil {
+		return nil, err
+	}
+
+	k := m.findKey(kid)
+	if k == nil && time.Since(m.loadedAt) > keyManagerReloadInterval {
+		// the key may be created by other app instance:
+		err = m.load(app, true)
+		if err != nil {
+			return nil, err
+		}
+
+		k = m.findKey(kid)
+	}
+
+	if k == nil {
+		return nil, ErrSigningKeyNotFound
+	}
+
+	return &k.accessTokenKeys, nil
+}
+
+// PublicKeys returns the active and inactive keys, published in the JWKS endpoint
+func (m *KeyManager) PublicKeys(app bolo.App) ([]*accessTokenKeys, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.ensureActiveKey(app)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []*accessTokenKeys{}
+	for _, k := range m.keys {
+		if k.isAccepted() {
+			keys = append(keys, &k.accessTokenKeys)
+		}
+	}
+
+	return keys, nil
+}
+
+// Rotate creates one new active key, the current active key is only used to verify tokens until the retirement
+func (m *KeyManager) Rotate(app bolo.App) (*user_models.SigningKeyModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := m.load(app, true)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := m.rotate(app, m.activeKey())
+	if err != nil {
+		return nil, err
+	}
+
+	if record == nil {
+		return nil, ErrSigningKeyRotated
+	}
+
+	return record, nil
+}
+
+// Reset clears the keys cache
+func (m *KeyManager) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys = nil
+	m.loadedAt = time.Time{}
+}
+
+func (m *KeyManager) ensureActiveKey(app bolo.App) (*managedSigningKey, error) {
+	err := m.load(app, false)
+	if err != nil {
+		return nil, err
+	}
+
+	active := m.activeKey()
+	if active != nil && time.Now().Before(active.notAfter) {
+		return active, nil
+	}
+
+	_, err = m.rotate(app, active)
+	if err != nil {
+		return nil, err
+	}
+
+	active = m.activeKey()
+	if active == nil {
+		return nil, errors.New("KeyManager.ensureActiveKey active key not found after the rotation")
+	}
+
+	return active, nil
+}
+
+func (m *KeyManager) rotate(app bolo.App, current *managedSigningKey) (*user_models.SigningKeyModel, error) {
+	cfgs := app.GetConfiguration()
+
+	algorithm := getJWTAlgorithm(app)
+	rotation := time.Duration(cfgs.GetInt64F("OAUTH2_JWT_KEY_ROTATION", 30)) * 24 * time.Hour
+	if rotation <= 0 {
+		// only manual rotations:
+		rotation = 100 * 365 * 24 * time.Hour
+	}
+
+	// rotated keys should verify all the access tokens signed before the rotation:
+	retireAfter := time.Duration(cfgs.GetInt64F("OAUTH2_JWT_KEY_RETIRE_AFTER", 1440)) * time.Minute
+	accessTokenExpiration := time.Duration(cfgs.GetInt64F("OAUTH2_ACCESS_TOKEN_EXPIRATION", 30)) * time.Minute
+	if retireAfter < accessTokenExpiration {
+		retireAfter = accessTokenExpiration
+	}
+
+	kek, err := getSigningKeyEncryptionKey(app)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := generateSigningKey(algorithm, time.Now().Add(rotation), kek)
+	if err != nil {
+		return nil, err
+	}
+
+	if current != nil {
+		rotated, err := user_models.DeactivateSigningKey(current.id, time.Now().Add(retireAfter))
+		if err != nil {
+			return nil, err
+		}
+
+		if !rotated {
+			// rotated by other app instance:
+			return nil, m.load(app, true)
+		}
+	}
+
+	err = record.Save()
+	if err != nil {
+		// the activeSlot unique index only allows one active key, like the first key created by other app instance:
+		loadErr := m.load(app, true)
+		if loadErr == nil && m.activeKey() != nil {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("KeyManager.rotate error on save key: %w", err)
+	}
+
+	err = user_models.RetireExpiredSigningKeys()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("KeyManager.rotate error on retire expired keys")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"kid":       record.Kid,
+		"algorithm": record.Algorithm,
+	}).Info("KeyManager.rotate new signing key")
+
+	return record, m.load(app, true)
+}
+
+func (m *KeyManager) load(app bolo.App, force bool) error {
+	if !force && m.keys != nil && time.Since(m.loadedAt) < KeyManagerCacheTTL {
+		return nil
+	}
+
+	kek, err := getSigningKeyEncryptionKey(app)
+	if err != nil {
+		return err
+	}
+
+	records, err := user_models.FindSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	keys := []*managedSigningKey{}
+	for _, r := range records {
+		k, err := parseSigningKeyRecord(r, kek)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"kid":   r.Kid,
+				"error": err,
+			}).Error("KeyManager.load error on parse key")
+			continue
+		}
+
+		keys = append(keys, k)
+	}
+
+	m.keys = keys
+	m.loadedAt = time.Now()
+
+	return nil
+}
+
+// activeKey returns the newest active key, keys are loaded in the newest first order
+func (m *KeyManager) activeKey() *managedSigningKey {
+	for _, k := range m.keys {
+		if k.status == user_models.SigningKeyStatusActive {
+			return k
+		}
+	}
+
+	return nil
+}
+
+func (m *KeyManager) findKey(kid string) *managedSigningKey {
+	for _, k := range m.keys {
+		if k.kid == kid && k.isAccepted() {
+			return k
+		}
+	}
+
+	return nil
+}
+
+// isAccepted returns false for inactive keys after the retirement time, before the status update
+func (k *managedSigningKey) isAccepted() bool {
+	return k.status == user_models.SigningKeyStatusActive || time.Now().Before(k.notAfter)
+}
+
+// generateSigningKey creates one new active RS256 or EdDSA key record, the private key is encrypted with the kek
+func generateSigningKey(algorithm string, notAfter time.Time, kek []byte) (*user_models.SigningKeyModel, error) {
+	var privateKey interface{}
+	var publicKey interface{}
+
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("generateSigningKey error on generate RSA key: %w", err)
+		}
+
+		privateKey = k
+		publicKey = &k.PublicKey
+	case SigningMethodEdDSA.Alg():
+		pub, k, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("generateSigningKey error on generate Ed25519 key: %w", err)
+		}
+
+		privateKey = k
+		publicKey = pub
+	default:
+		return nil, fmt.Errorf("generateSigningKey database keys require the RS256 or EdDSA algorithm, got %s", algorithm)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("generateSigningKey error on encode private key: %w", err)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("generateSigningKey error on encode public key: %w", err)
+	}
+
+	kid, err := jwkThumbprint(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := encryptSigningKey(kek, kid, privateDER)
+	if err != nil {
+		return nil, err
+	}
+
+	active := true
+
+	return &user_models.SigningKeyModel{
+		Kid:        kid,
+		Algorithm:  algorithm,
+		Status:     user_models.SigningKeyStatusActive,
+		ActiveSlot: &active,
+		PrivateKey: encrypted,
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+		NotAfter:   notAfter,
+	}, nil
+}
+
+func parseSigningKeyRecord(r *user_models.SigningKeyModel, kek []byte) (*managedSigningKey, error) {
+	method := jwt.GetSigningMethod(r.Algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("parseSigningKeyRecord unsupported algorithm %s", r.Algorithm)
+	}
+
+	block, _ := pem.Decode([]byte(r.PrivateKey))
+	if block == nil {
+		return nil, errors.New("parseSigningKeyRecord invalid private key PEM")
+	}
+
+	privateDER := block.Bytes
+
+	switch block.Type {
+	case encryptedSigningKeyPEMType:
+		der, err := decryptSigningKey(kek, r.Kid, block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		privateDER = der
+	case "PRIVATE KEY":
+		// keys saved before the encryption are encrypted in the first load:
+		encrypted, err := encryptSigningKey(kek, r.Kid, privateDER)
+		if err != nil {
+			return nil, err
+		}
+
+		err = user_models.UpdateSigningKeyPrivateKey(r.ID, encrypted)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("parseSigningKeyRecord unsupported private key PEM type %s", block.Type)
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(privateDER)
+	if err != nil {
+		return nil, fmt.Errorf("parseSigningKeyRecord error on parse private key: %w", err)
+	}
+
+	k := managedSigningKey{
+		accessTokenKeys: accessTokenKeys{
+			method:  method,
+			kid:     r.Kid,
+			signKey: privateKey,
+		},
+		id:       r.ID,
+		status:   r.Status,
+		notAfter: r.NotAfter,
+	}
+
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		k.verifyKey = &key.PublicKey
+	case ed25519.PrivateKey:
+		k.verifyKey = key.Public()
+	default:
+		return nil, errors.New("parseSigningKeyRecord unsupported private key type")
+	}
+
+	return &k, nil
+}
+
+// getSigningKeyEncryptionKey returns the OAUTH2_JWT_KEY_ENCRYPTION_KEY, one base64 encoded AES-256 key
+// required to save and load the database keys
+func getSigningKeyEncryptionKey(app bolo.App) ([]byte, error) {
+	v := app.GetConfiguration().Get("OAUTH2_JWT_KEY_ENCRYPTION_KEY")
+	if v == "" {
+		return nil, errors.New("the OAUTH2_JWT_KEY_ENCRYPTION_KEY configuration is required for the database keys")
+	}
+
+	kek, err := base64.StdEncoding.DecodeString(v)
+	if err != nil || len(kek) != 32 {
+		return nil, errors.New("the OAUTH2_JWT_KEY_ENCRYPTION_KEY should be 32 bytes encoded in base64")
+	}
+
+	return kek, nil
+}
+
+// CheckSigningKeyEncryptionConfiguration returns one error if the database keys are enabled without one valid
+// OAUTH2_JWT_KEY_ENCRYPTION_KEY, used in the plugin Init
+func CheckSigningKeyEncryptionConfiguration(app bolo.App) error {
+	if !IsJWTDatabaseKeysEnabled(app) {
+		return nil
+	}
+
+	_, err := getSigningKeyEncryptionKey(app)
+	return err
+}
+
+// encryptSigningKey seals one PKCS8 private key with AES-GCM, the kid is the additional data then one
+// encrypted key can not be copied to other record
+func encryptSigningKey(kek []byte, kid string, privateDER []byte) (string, error) {
+	gcm, err := newSigningKeyCipher(kek)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", fmt.Errorf("encryptSigningKey error on generate nonce: %w", err)
+	}
+
+	sealed := gcm.Seal(nonce, nonce, privateDER, []byte(kid))
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: encryptedSigningKeyPEMType, Bytes: sealed})), nil
+}
+
+func decryptSigningKey(kek []byte, kid string, sealed []byte) ([]byte, error) {
+	gcm, err := newSigningKeyCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("decryptSigningKey invalid encrypted key")
+	}
+
+	privateDER, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(kid))
+	if err != nil {
+		return nil, fmt.Errorf("decryptSigningKey error on decrypt, check the OAUTH2_JWT_KEY_ENCRYPTION_KEY: %w", err)
+	}
+
+	return privateDER, nil
+}
+
+func newSigningKeyCipher(kek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("newSigningKeyCipher error on create cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
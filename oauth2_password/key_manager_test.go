@@ -0,0 +1,186 @@
+package user_oauth2_password_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-bolo/bolo"
+	user_models "github.com/go-bolo/user/models"
+	user_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/stretchr/testify/assert"
+)
+
+func requestJWKS(app bolo.App) (*httptest.ResponseRecorder, *user_oauth2_password.JSONWebKeySet) {
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	rec := httptest.NewRecorder()
+	app.GetRouter().ServeHTTP(rec, req)
+
+	var set user_oauth2_password.JSONWebKeySet
+	json.Unmarshal(rec.Body.Bytes(), &set)
+
+	return rec, &set
+}
+
+func tokenKid(t *testing.T, token string) string {
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, &jwt.MapClaims{})
+	assert.NoError(t, err)
+
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestKeyManager(t *testing.T) {
+	app := GetAppInstance()
+
+	for _, algorithm := range []string{"RS256", "EdDSA"} {
+		t.Run(algorithm, func(t *testing.T) {
+			t.Setenv("OAUTH2_ACCESS_TOKEN_FORMAT", "jwt")
+			t.Setenv("OAUTH2_JWT_KEYS", "database")
+			t.Setenv("OAUTH2_JWT_ALGORITHM", algorithm)
+			t.Setenv("OAUTH2_JWT_KEY_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+
+			m := user_oauth2_password.DefaultKeyManager
+			m.Reset()
+			defer m.Reset()
+
+			db := app.GetDB()
+			err := db.Where("1 = 1").Delete(&user_models.SigningKeyModel{}).Error
+			assert.NoError(t, err)
+
+			ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+			u := user_models.UserModel{Username: gofakeit.UUID(), Email: gofakeit.Email()}
+			err = u.Save(ctx)
+			assert.NoError(t, err)
+			defer u.Delete()
+
+			first, err := user_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+			assert.NoError(t, err)
+			firstKid := tokenKid(t, first.AccessToken)
+
+			t.Run("first token creates the active key", func(t *testing.T) {
+				assert.NotEmpty(t, firstKid)
+
+				rec, set := requestJWKS(app)
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.NotContains(t, rec.Body.String(), "PRIVATE")
+				if assert.Len(t, set.Keys, 1) {
+					assert.Equal(t, firstKid, set.Keys[0].Kid)
+					assert.Equal(t, algorithm, set.Keys[0].Alg)
+					assert.Equal(t, "sig", set.Keys[0].Use)
+				}
+			})
+
+			t.Run("private keys are saved encrypted", func(t *testing.T) {
+				records, err := user_models.FindSigningKeys()
+				assert.NoError(t, err)
+				if assert.Len(t, records, 1) {
+					assert.NotContains(t, records[0].PrivateKey, "PRIVATE KEY")
+					assert.Contains(t, records[0].PrivateKey, "ENCRYPTED SIGNING KEY")
+				}
+
+				t.Setenv("OAUTH2_JWT_KEY_ENCRYPTION_KEY", "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+				other := &user_oauth2_password.KeyManager{}
+				_, err = other.VerificationKeys(app, firstKid)
+				assert.ErrorIs(t, err, user_oauth2_password.ErrSigningKeyNotFound)
+			})
+
+			var second user_oauth2_password.Oauth2TokenData
+			t.Run("rotation keeps the old tokens valid", func(t *testing.T) {
+				record, err := m.Rotate(app)
+				assert.NoError(t, err)
+				assert.NotEqual(t, firstKid, record.Kid)
+
+				second, err = user_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+				assert.NoError(t, err)
+				assert.Equal(t, record.Kid, tokenKid(t, second.AccessToken))
+
+				_, err = user_oauth2_password.ParseJWTAccessToken(ctx, first.AccessToken)
+				assert.NoError(t, err)
+				_, err = user_oauth2_password.ParseJWTAccessToken(ctx, second.AccessToken)
+				assert.NoError(t, err)
+
+				_, set := requestJWKS(app)
+				assert.Len(t, set.Keys, 2)
+			})
+
+			t.Run("expired active key is rotated on sign", func(t *testing.T) {
+				err := db.Model(&user_models.SigningKeyModel{}).
+					Where("status = ?", user_models.SigningKeyStatusActive).
+					Update("notAfter", time.Now().Add(-time.Minute)).Error
+				assert.NoError(t, err)
+				m.Reset()
+
+				third, err := user_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+				assert.NoError(t, err)
+
+				kid := tokenKid(t, third.AccessToken)
+				assert.NotEqual(t, firstKid, kid)
+				assert.NotEqual(t, tokenKid(t, second.AccessToken), kid)
+
+				_, err = user_oauth2_password.ParseJWTAccessToken(ctx, second.AccessToken)
+				assert.NoError(t, err)
+			})
+
+			t.Run("retired key tokens are rejected", func(t *testing.T) {
+				err := db.Model(&user_models.SigningKeyModel{}).
+					Where("kid = ?", firstKid).
+					Update("notAfter", time.Now().Add(-time.Minute)).Error
+				assert.NoError(t, err)
+				m.Reset()
+
+				_, err = user_oauth2_password.ParseJWTAccessToken(ctx, first.AccessToken)
+				assert.Error(t, err)
+
+				_, set := requestJWKS(app)
+				for _, k := range set.Keys {
+					assert.NotEqual(t, firstKid, k.Kid)
+				}
+			})
+		})
+	}
+}
+
+func TestKeyManager_FirstKey(t *testing.T) {
+	app := GetAppInstance()
+
+	t.Setenv("OAUTH2_ACCESS_TOKEN_FORMAT", "jwt")
+	t.Setenv("OAUTH2_JWT_KEYS", "database")
+	t.Setenv("OAUTH2_JWT_ALGORITHM", "EdDSA")
+	t.Setenv("OAUTH2_JWT_KEY_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+
+	err := app.GetDB().Where("1 = 1").Delete(&user_models.SigningKeyModel{}).Error
+	assert.NoError(t, err)
+
+	t.Run("one instance reuses the first key created by other instance", func(t *testing.T) {
+		a := &user_oauth2_password.KeyManager{}
+		b := &user_oauth2_password.KeyManager{}
+
+		// b loads the empty keys table before a creates the first key:
+		_, err := b.VerificationKeys(app, "unknown")
+		assert.ErrorIs(t, err, user_oauth2_password.ErrSigningKeyNotFound)
+
+		_, err = a.SigningKeys(app)
+		assert.NoError(t, err)
+
+		_, err = b.SigningKeys(app)
+		assert.NoError(t, err)
+
+		records, err := user_models.FindSigningKeys()
+		assert.NoError(t, err)
+		assert.Len(t, records, 1)
+	})
+
+	t.Run("database keys require the encryption key", func(t *testing.T) {
+		t.Setenv("OAUTH2_JWT_KEY_ENCRYPTION_KEY", "")
+		assert.Error(t, user_oauth2_password.CheckSigningKeyEncryptionConfiguration(app))
+
+		t.Setenv("OAUTH2_JWT_KEY_ENCRYPTION_KEY", "c2hvcnQ=")
+		assert.Error(t, user_oauth2_password.CheckSigningKeyEncryptionConfiguration(app))
+	})
+}
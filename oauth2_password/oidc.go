@@ -0,0 +1,217 @@
+package user_oauth2_password
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-bolo/bolo"
+	user_models "github.com/go-bolo/user/models"
+)
+
+const (
+	// OpenIDScope - requests the ID token and the userinfo access
+	OpenIDScope = "openid"
+	// ProfileScope - releases the name, nickname, preferred_username, locale and updated_at claims
+	ProfileScope = "profile"
+	// EmailScope - releases the email and email_verified claims
+	EmailScope = "email"
+	// PhoneScope - releases the phone_number claim
+	PhoneScope = "phone"
+)
+
+// OpenIDScopes - OpenID Connect standard scopes, valid with the OAUTH2_OPENID_ENABLED and without permissions unless
+// configured in the OAUTH2_SCOPES
+var OpenIDScopes = []string{OpenIDScope, ProfileScope, EmailScope, PhoneScope}
+
+// OpenIDClaims - user claims released by the OpenIDScopes, the sub is always released
+var OpenIDClaims = []string{"sub", "name", "nickname", "preferred_username", "locale", "updated_at", "email", "email_verified", "phone_number"}
+
+// IsOpenIDScope returns true if the scope is in the OpenIDScopes
+func IsOpenIDScope(scope string) bool {
+	for _, s := range OpenIDScopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsOpenIDEnabled returns true if the OpenID Connect provider is enabled with the OAUTH2_OPENID_ENABLED configuration
+func IsOpenIDEnabled(app bolo.App) bool {
+	return app.GetConfiguration().GetBoolF("OAUTH2_OPENID_ENABLED", false)
+}
+
+// CheckIDTokenSigningConfiguration returns one error if the OAUTH2_JWT_* configurations can not sign ID tokens,
+// used in the plugin Init to not start one OpenID Connect provider without valid keys
+func CheckIDTokenSigningConfiguration(app bolo.App) error {
+	err := checkIDTokenSigningAlgorithm(app)
+	if err != nil {
+		return err
+	}
+
+	// database keys are created in the first use, after the migrations:
+	if IsJWTDatabaseKeysEnabled(app) {
+		return nil
+	}
+
+	_, err = getIDTokenSigningKeys(app)
+	return err
+}
+
+// checkIDTokenSigningAlgorithm only accepts asymmetric algorithms, clients verify the ID tokens with the published
+// public keys and one HS256 secret would have to be shared with all clients
+func checkIDTokenSigningAlgorithm(app bolo.App) error {
+	switch algorithm := getJWTAlgorithm(app); algorithm {
+	case jwt.SigningMethodRS256.Alg(), SigningMethodEdDSA.Alg():
+		return nil
+	default:
+		return fmt.Errorf("the OAUTH2_JWT_ALGORITHM %s can not sign ID tokens, use RS256 or EdDSA", algorithm)
+	}
+}
+
+// getIDTokenSigningKeys returns the private key used to sign new ID tokens
+func getIDTokenSigningKeys(app bolo.App) (*accessTokenKeys, error) {
+	err := checkIDTokenSigningAlgorithm(app)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := getAccessTokenSigningKeys(app)
+	if err != nil {
+		return nil, err
+	}
+
+	if keys.signKey == nil {
+		return nil, errors.New("the OAUTH2_JWT_PRIVATE_KEY configuration is required to sign ID tokens")
+	}
+
+	return keys, nil
+}
+
+// HasOpenIDScope returns true if the scopes have the openid scope
+func HasOpenIDScope(scopes []string) bool {
+	for _, s := range scopes {
+		if s == OpenIDScope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetOpenIDIssuer returns the iss of the ID tokens, the same of the signed access tokens
+func GetOpenIDIssuer(ctx *bolo.RequestContext) string {
+	return getJWTAccessTokenIssuer(ctx)
+}
+
+// GetIDTokenSigningAlgorithm returns the alg of the ID tokens, see the OAUTH2_JWT_ALGORITHM configuration
+func GetIDTokenSigningAlgorithm(app bolo.App) string {
+	return getJWTAlgorithm(app)
+}
+
+// GetOpenIDUserClaims returns the user claims released by the scopes, empty values are not released
+func GetOpenIDUserClaims(u *user_models.UserModel, scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": u.GetID(),
+	}
+
+	set := func(name, value string) {
+		if value != "" {
+			claims[name] = value
+		}
+	}
+
+	for _, s := range scopes {
+		switch s {
+		case ProfileScope:
+			name := u.FullName
+			if name == "" {
+				name = u.DisplayName
+			}
+
+			set("name", name)
+			set("nickname", u.DisplayName)
+			set("preferred_username", u.Username)
+			set("locale", u.Language)
+
+			if !u.UpdatedAt.IsZero() {
+				claims["updated_at"] = u.UpdatedAt.Unix()
+			}
+		case EmailScope:
+			if u.Email != "" {
+				claims["email"] = u.Email
+				claims["email_verified"] = u.EmailVerified
+			}
+		case PhoneScope:
+			set("phone_number", u.Phone)
+		}
+	}
+
+	return claims
+}
+
+// SignIDToken creates the ID token of one user to the client, with the claims of the scopes and the authorization nonce.
+// ID tokens are only signed with RS256 or EdDSA keys
+func SignIDToken(ctx *bolo.RequestContext, u *user_models.UserModel, clientID, nonce string, scopes []string) (string, error) {
+	keys, err := getIDTokenSigningKeys(ctx.App)
+	if err != nil {
+		return "", fmt.Errorf("SignIDToken error on get keys: %w", err)
+	}
+
+	return signIDToken(ctx, keys, u, clientID, nonce, scopes)
+}
+
+func signIDToken(ctx *bolo.RequestContext, keys *accessTokenKeys, u *user_models.UserModel, clientID, nonce string, scopes []string) (string, error) {
+	expireD := time.Duration(ctx.App.GetConfiguration().GetInt64F("OAUTH2_ID_TOKEN_EXPIRATION", 60)) * time.Minute
+	now := time.Now()
+
+	claims := jwt.MapClaims{}
+	for k, v := range GetOpenIDUserClaims(u, scopes) {
+		claims[k] = v
+	}
+
+	claims["iss"] = GetOpenIDIssuer(ctx)
+	claims["aud"] = clientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(expireD).Unix()
+
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	t := jwt.NewWithClaims(keys.method, claims)
+	if keys.kid != "" {
+		t.Header["kid"] = keys.kid
+	}
+
+	token, err := t.SignedString(keys.signKey)
+	if err != nil {
+		return "", fmt.Errorf("SignIDToken error on sign: %w", err)
+	}
+
+	return token, nil
+}
+
+// ParseIDTokenHint validates the signature and issuer of one ID token sent as id_token_hint, expired tokens are accepted
+func ParseIDTokenHint(ctx *bolo.RequestContext, token string) (*jwt.StandardClaims, error) {
+	claims := jwt.StandardClaims{}
+	parser := jwt.Parser{SkipClaimsValidation: true}
+
+	_, err := parser.ParseWithClaims(token, &claims, accessTokenKeyFunc(ctx.App))
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Subject == "" || claims.Audience == "" {
+		return nil, errors.New("ParseIDTokenHint the sub and aud claims are required")
+	}
+
+	if !claims.VerifyIssuer(GetOpenIDIssuer(ctx), true) {
+		return nil, errors.New("ParseIDTokenHint invalid issuer")
+	}
+
+	return &claims, nil
+}
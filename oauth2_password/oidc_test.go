@@ -0,0 +1,92 @@
+package user_oauth2_password_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/go-bolo/bolo"
+	user_models "github.com/go-bolo/user/models"
+	user_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenIDScopes(t *testing.T) {
+	t.Setenv("OAUTH2_SCOPES", `{"read": ["find_user"]}`)
+
+	app := GetAppInstance()
+
+	t.Run("the standard scopes are valid if the OpenID Connect is enabled", func(t *testing.T) {
+		err := user_oauth2_password.ValidateScopes(app, []string{"openid", "read"})
+		assert.ErrorIs(t, err, user_oauth2_password.ErrInvalidScope)
+
+		t.Setenv("OAUTH2_OPENID_ENABLED", "true")
+
+		err = user_oauth2_password.ValidateScopes(app, []string{"openid", "profile", "email", "phone", "read"})
+		assert.NoError(t, err)
+
+		err = user_oauth2_password.ValidateScopes(app, []string{"openid", "address"})
+		assert.ErrorIs(t, err, user_oauth2_password.ErrInvalidScope)
+	})
+
+	t.Run("the claims are released by the scopes", func(t *testing.T) {
+		u := user_models.UserModel{
+			ID:          10,
+			Username:    "alice",
+			Email:       "alice@example.com",
+			DisplayName: "Alice",
+			Phone:       "+5511999999999",
+		}
+
+		claims := user_oauth2_password.GetOpenIDUserClaims(&u, []string{"openid"})
+		assert.Equal(t, map[string]interface{}{"sub": "10"}, claims)
+
+		claims = user_oauth2_password.GetOpenIDUserClaims(&u, []string{"openid", "profile", "email"})
+		assert.Equal(t, "Alice", claims["name"])
+		assert.Equal(t, "alice", claims["preferred_username"])
+		assert.Equal(t, "alice@example.com", claims["email"])
+		assert.Equal(t, false, claims["email_verified"])
+		assert.Nil(t, claims["locale"])
+		assert.Nil(t, claims["phone_number"])
+
+		u.Active = true
+		claims = user_oauth2_password.GetOpenIDUserClaims(&u, []string{"openid", "email"})
+		assert.Equal(t, false, claims["email_verified"])
+
+		u.EmailVerified = true
+		claims = user_oauth2_password.GetOpenIDUserClaims(&u, []string{"openid", "email"})
+		assert.Equal(t, true, claims["email_verified"])
+	})
+
+	t.Run("ID tokens require the signing keys", func(t *testing.T) {
+		ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+		_, err := user_oauth2_password.SignIDToken(ctx, &user_models.UserModel{ID: 10}, "client", "", []string{"openid"})
+		assert.Error(t, err)
+
+		// HMAC secrets can not sign ID tokens:
+		t.Setenv("OAUTH2_JWT_ALGORITHM", "HS256")
+		t.Setenv("OAUTH2_JWT_SECRET", "a-jwt-secret-with-more-than-32-chars")
+
+		_, err = user_oauth2_password.SignIDToken(ctx, &user_models.UserModel{ID: 10}, "client", "", []string{"openid"})
+		assert.Error(t, err)
+		assert.Error(t, user_oauth2_password.CheckIDTokenSigningConfiguration(app))
+
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.NoError(t, err)
+
+		t.Setenv("OAUTH2_JWT_ALGORITHM", "RS256")
+		t.Setenv("OAUTH2_JWT_PRIVATE_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})))
+		assert.NoError(t, user_oauth2_password.CheckIDTokenSigningConfiguration(app))
+
+		token, err := user_oauth2_password.SignIDToken(ctx, &user_models.UserModel{ID: 10}, "client", "abc", []string{"openid"})
+		assert.NoError(t, err)
+
+		claims, err := user_oauth2_password.ParseIDTokenHint(ctx, token)
+		assert.NoError(t, err)
+		assert.Equal(t, "10", claims.Subject)
+		assert.Equal(t, "client", claims.Audience)
+	})
+}
@@ -0,0 +1,164 @@
+package user_oauth2_password
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-bolo/bolo"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// TokenScopesKey - request context key with the scopes of the oauth2 token used in the request
+const TokenScopesKey = "oauth2TokenScopes"
+
+// ErrInvalidScope - one requested scope is not in the OAUTH2_SCOPES configuration
+var ErrInvalidScope = errors.New("invalid_scope")
+
+// parsed OAUTH2_SCOPES, the configuration is compared in every call to allow configuration changes in tests
+var scopesCache struct {
+	sync.Mutex
+	configuration string
+	scopes        map[string][]string
+}
+
+// GetScopes returns the scope to permissions map from the OAUTH2_SCOPES JSON configuration,
+// like {"read": ["find_user", "find_content"]}
+func GetScopes(app bolo.App) (map[string][]string, error) {
+	configuration := strings.TrimSpace(app.GetConfiguration().Get("OAUTH2_SCOPES"))
+
+	scopesCache.Lock()
+	defer scopesCache.Unlock()
+
+	if scopesCache.scopes != nil && scopesCache.configuration == configuration {
+		return scopesCache.scopes, nil
+	}
+
+	scopes := map[string][]string{}
+	if configuration != "" {
+		err := json.Unmarshal([]byte(configuration), &scopes)
+		if err != nil {
+			return nil, fmt.Errorf("GetScopes error on parse the OAUTH2_SCOPES configuration: %w", err)
+		}
+	}
+
+	scopesCache.configuration = configuration
+	scopesCache.scopes = scopes
+
+	return scopes, nil
+}
+
+// ParseScope splits the space separated oauth2 scope param, without duplicates
+func ParseScope(scope string) []string {
+	scopes := []string{}
+	seen := map[string]bool{}
+
+	for _, s := range strings.Fields(scope) {
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+
+	sort.Strings(scopes)
+
+	return scopes
+}
+
+// ValidateScopes returns the ErrInvalidScope if one of the scopes is not configured, the OpenIDScopes are valid if the
+// OpenID Connect provider is enabled
+func ValidateScopes(app bolo.App, scopes []string) error {
+	if len(scopes) == 0 {
+		return nil
+	}
+
+	configured, err := GetScopes(app)
+	if err != nil {
+		return err
+	}
+
+	openID := IsOpenIDEnabled(app)
+
+	for _, s := range scopes {
+		if _, ok := configured[s]; !ok && !(openID && IsOpenIDScope(s)) {
+			return ErrInvalidScope
+		}
+	}
+
+	return nil
+}
+
+// isScopeSubset returns true if all the requested scopes are in the granted scopes,
+// tokens without scopes have the full user rights
+func isScopeSubset(requested, granted []string) bool {
+	if len(granted) == 0 {
+		return true
+	}
+
+	for _, r := range requested {
+		found := false
+		for _, g := range granted {
+			if r == g {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// HasTokenScopes returns true if the request is authenticated with one oauth2 token restricted by scopes
+func HasTokenScopes(c echo.Context) bool {
+	scopes, _ := c.Get(TokenScopesKey).([]string)
+	return len(scopes) > 0
+}
+
+// narrowToTokenScopes restricts the ctx.Can checks to the permissions of the token scopes,
+// the request permissions are the intersection of the user roles and the scopes permissions
+func narrowToTokenScopes(ctx *bolo.RequestContext, scopes []string) {
+	if len(scopes) == 0 {
+		return
+	}
+
+	ctx.Set(TokenScopesKey, scopes)
+	ctx.App = &tokenScopedApp{App: ctx.App, permissions: getScopesPermissions(ctx.App, scopes)}
+}
+
+// getScopesPermissions returns the permissions of all the scopes
+func getScopesPermissions(app bolo.App, scopes []string) map[string]bool {
+	permissions := map[string]bool{}
+
+	configured, err := GetScopes(app)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("getScopesPermissions error on get scopes, the token will not have permissions")
+	}
+
+	for _, s := range scopes {
+		for _, p := range configured[s] {
+			permissions[p] = true
+		}
+	}
+
+	return permissions
+}
+
+// tokenScopedApp - request app of the oauth2 tokens with scopes, only the scopes permissions are allowed
+type tokenScopedApp struct {
+	bolo.App
+	permissions map[string]bool
+}
+
+func (a *tokenScopedApp) Can(permission string, userRoles []string) bool {
+	return a.permissions[permission] && a.App.Can(permission, userRoles)
+}
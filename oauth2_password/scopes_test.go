@@ -0,0 +1,144 @@
+package user_oauth2_password_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	user_models "github.com/go-bolo/user/models"
+	user_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type scopesTestCanResponse struct {
+	FindUser   bool `json:"find_user"`
+	UpdateUser bool `json:"update_user"`
+}
+
+func requestScopesTestCan(app bolo.App, accessToken string) scopesTestCanResponse {
+	req := httptest.NewRequest(http.MethodGet, "/scopes-test/can", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+	req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+
+	rec := httptest.NewRecorder()
+	app.GetRouter().ServeHTTP(rec, req)
+
+	var resp scopesTestCanResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+
+	return resp
+}
+
+func TestOauth2TokenHandler_Scopes(t *testing.T) {
+	t.Setenv("OAUTH2_SCOPES", `{"read": ["find_user"], "write": ["update_user"]}`)
+
+	app := GetAppInstance()
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	app.GetRouter().GET("/scopes-test/can", func(c echo.Context) error {
+		ctx := c.(*bolo.RequestContext)
+
+		return c.JSON(http.StatusOK, &scopesTestCanResponse{
+			FindUser:   ctx.Can("find_user"),
+			UpdateUser: ctx.Can("update_user"),
+		})
+	})
+
+	u := user_models.UserModel{
+		Username: gofakeit.UUID(),
+		Email:    gofakeit.Email(),
+		Active:   true,
+		Roles:    []string{"administrator"},
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+
+	err = u.SetPassword("123456")
+	assert.NoError(t, err)
+
+	login := func(scope string) (*httptest.ResponseRecorder, tokenResponse) {
+		rec := requestToken(app, url.Values{
+			"grant_type": {"password"},
+			"email":      {u.Email},
+			"password":   {"123456"},
+			"scope":      {scope},
+		})
+
+		var resp tokenResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+
+		return rec, resp
+	}
+
+	t.Run("tokens without scopes have the user permissions", func(t *testing.T) {
+		rec, token := login("")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		can := requestScopesTestCan(app, token.AccessToken)
+		assert.True(t, can.FindUser)
+		assert.True(t, can.UpdateUser)
+	})
+
+	t.Run("reject unknown scopes", func(t *testing.T) {
+		rec, _ := login("read admin")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid_scope")
+	})
+
+	t.Run("read only token", func(t *testing.T) {
+		rec, token := login("read")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"scope":"read"`)
+
+		can := requestScopesTestCan(app, token.AccessToken)
+		assert.True(t, can.FindUser)
+		assert.False(t, can.UpdateUser)
+
+		t.Run("refresh keeps the scopes", func(t *testing.T) {
+			rec := requestToken(app, url.Values{
+				"grant_type":    {"refresh_token"},
+				"refresh_token": {token.RefreshToken},
+			})
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			var refreshed tokenResponse
+			err := json.Unmarshal(rec.Body.Bytes(), &refreshed)
+			assert.NoError(t, err)
+
+			can := requestScopesTestCan(app, refreshed.AccessToken)
+			assert.True(t, can.FindUser)
+			assert.False(t, can.UpdateUser)
+
+			t.Run("refresh can not add scopes", func(t *testing.T) {
+				rec := requestToken(app, url.Values{
+					"grant_type":    {"refresh_token"},
+					"refresh_token": {refreshed.RefreshToken},
+					"scope":         {"read write"},
+				})
+				assert.Equal(t, http.StatusBadRequest, rec.Code)
+			})
+		})
+	})
+
+	t.Run("signed access tokens carry the scopes", func(t *testing.T) {
+		t.Setenv("OAUTH2_ACCESS_TOKEN_FORMAT", "jwt")
+		t.Setenv("OAUTH2_JWT_SECRET", "a-jwt-secret-with-more-than-32-chars")
+
+		rec, token := login("write")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		claims, err := user_oauth2_password.ParseJWTAccessToken(ctx, token.AccessToken)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"write"}, claims.Scopes)
+
+		can := requestScopesTestCan(app, token.AccessToken)
+		assert.False(t, can.FindUser)
+		assert.True(t, can.UpdateUser)
+	})
+}
@@ -0,0 +1,82 @@
+package user_oauth2_password_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-bolo/bolo"
+	user_models "github.com/go-bolo/user/models"
+	user_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+var appInstance bolo.App
+
+func GetAppInstance() bolo.App {
+	if appInstance != nil {
+		return appInstance
+	}
+
+	opts := &bolo.AppOptions{
+		GormOptions: &gorm.Config{},
+	}
+
+	var app bolo.App
+	if bolo.GetApp() == nil {
+		app = bolo.Init(opts)
+	} else {
+		app = bolo.NewApp(opts)
+	}
+
+	app.RegisterPlugin(user_oauth2_password.NewPlugin(&user_oauth2_password.PluginCfgs{}))
+
+	err := app.Bootstrap()
+	if err != nil {
+		panic(err)
+	}
+
+	err = app.GetDB().AutoMigrate(
+		&user_models.UserModel{},
+		&user_models.PasswordModel{},
+		&user_models.AuthTokenModel{},
+		&user_models.UserTOTPModel{},
+		&user_models.MfaRecoveryCodeModel{},
+		&user_models.UserSessionModel{},
+		&user_models.AuthEventModel{},
+		&user_models.UserRoleModel{},
+		&user_models.WebAuthnCredentialModel{},
+		&user_models.WebAuthnChallengeModel{},
+		&user_models.SigningKeyModel{},
+		&user_models.PersonalAccessTokenModel{},
+		&user_models.OAuthClientModel{},
+		&user_models.KVStoreModel{},
+	)
+	if err != nil {
+		panic(errors.Wrap(err, "oauth2_password.GetAppInstance Error on run auto migration"))
+	}
+
+	appInstance = app
+
+	return app
+}
+
+func TestMain(m *testing.M) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+
+	defer mr.Close()
+
+	os.Setenv("SITE_OAUTH2_ADDR_WRITER", mr.Addr())
+	os.Setenv("SITE_OAUTH2_ADDR_READER", mr.Addr())
+	os.Setenv("AUTH_THROTTLE_REDIS_ADDR_WRITER", mr.Addr())
+	os.Setenv("AUTH_THROTTLE_REDIS_ADDR_READER", mr.Addr())
+	os.Setenv("DB_URI", "file::memory:?cache=shared")
+	os.Setenv("DB_ENGINE", "sqlite")
+	os.Setenv("TEMPLATE_DISABLE", "true")
+
+	os.Exit(m.Run())
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/go-bolo/bolo"
+	user_helpers "github.com/go-bolo/user/helpers"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -13,18 +14,37 @@ var storageInitialized bool
 // var refreshTokenPrefix string = "RT:"
 
 var accessTokenPrefix string = ""
-var refreshTokenPrefix string = ""
+
+// refresh tokens use their own key space, then one refresh token can not be used as access token
+var refreshTokenPrefix string = "RT:"
+
+// revoked signed access token ids, see the DenyAccessTokenID
+var deniedAccessTokenPrefix string = "JTI:"
+
+// authorization codes and the pending authorization requests of the consent screen
+var authorizationCodePrefix string = "AC:"
+var authorizationRequestPrefix string = "AR:"
 
 var (
 	// StorageDBWriter - Oauth tokens redis cache connection
 	StorageDBWriter *redis.Client
 	// StorageDBReader - Oauth tokens redis cache connection
 	StorageDBReader *redis.Client
+	// Storage of the tokens, codes and authorization requests, set in PluginCfgs to use other store.
+	// The default is the redis store with the StorageDBWriter and StorageDBReader connections
+	Storage user_helpers.KVStore
+	// redis connections created in the initStorage, closed in the closeStorage
+	storageClients []*redis.Client
 )
 
-// initStorage - Start the redis cache connection
+// initStorage - Start the redis cache connection if the Storage is not set
 func initStorage(app bolo.App) {
 	if !storageInitialized {
+		if Storage != nil {
+			storageInitialized = true
+			return
+		}
+
 		cfgs := app.GetConfiguration()
 
 		addrWriter := cfgs.Get("SITE_OAUTH2_ADDR_WRITER")
@@ -38,6 +58,7 @@ func initStorage(app bolo.App) {
 				Password: password,
 				DB:       db,
 			})
+			storageClients = append(storageClients, StorageDBWriter)
 		}
 
 		if StorageDBReader == nil {
@@ -46,15 +67,54 @@ func initStorage(app bolo.App) {
 				Password: password,
 				DB:       db,
 			})
+			storageClients = append(storageClients, StorageDBReader)
 		}
 
+		Storage = user_helpers.NewRedisKVStore(StorageDBWriter, StorageDBReader)
 		storageInitialized = true
 	}
 }
 
+// closeStorage closes the Storage and the redis connections created in the initStorage,
+// then the next app starts one new storage
+func closeStorage() error {
+	if !storageInitialized {
+		return nil
+	}
+
+	err := Storage.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, client := range storageClients {
+		err = client.Close()
+		if err != nil {
+			return err
+		}
+
+		if client == StorageDBWriter {
+			StorageDBWriter = nil
+		}
+
+		if client == StorageDBReader {
+			StorageDBReader = nil
+		}
+	}
+
+	if _, ok := Storage.(*user_helpers.RedisKVStore); ok {
+		Storage = nil
+	}
+
+	storageClients = nil
+	storageInitialized = false
+
+	return nil
+}
+
 func GetAccessToken(accessToken string) (string, error) {
 	key := accessTokenPrefix + accessToken
-	return StorageDBReader.Get(ctx, key).Result()
+	return Storage.Get(key)
 }
 
 func SetAccessToken(c *bolo.RequestContext, accessToken string, value string) error {
@@ -63,17 +123,28 @@ func SetAccessToken(c *bolo.RequestContext, accessToken string, value string) er
 
 	key := accessTokenPrefix + accessToken
 	expire := time.Duration(expiration) * time.Minute
-	return StorageDBWriter.Set(ctx, key, value, expire).Err()
+	return Storage.Set(key, value, expire)
 }
 
 func DeleteAccessToken(c *bolo.RequestContext, accessToken string) error {
 	key := accessTokenPrefix + accessToken
-	return StorageDBWriter.Del(ctx, key).Err()
+	return Storage.Del(key)
 }
 
 func GetRefreshToken(refreshToken string) (string, error) {
 	key := refreshTokenPrefix + refreshToken
-	return StorageDBReader.Get(ctx, key).Result()
+	return Storage.Get(key)
+}
+
+// ConsumeRefreshToken - get and delete the refresh token in one operation, a refresh token can only be used once
+func ConsumeRefreshToken(refreshToken string) (string, error) {
+	key := refreshTokenPrefix + refreshToken
+	return Storage.GetDel(key)
+}
+
+func DeleteRefreshToken(c *bolo.RequestContext, refreshToken string) error {
+	key := refreshTokenPrefix + refreshToken
+	return Storage.Del(key)
 }
 
 func SetRefreshToken(c *bolo.RequestContext, refreshToken string, value string) error {
@@ -82,5 +153,55 @@ func SetRefreshToken(c *bolo.RequestContext, refreshToken string, value string)
 
 	key := refreshTokenPrefix + refreshToken
 	expire := time.Duration(expiration) * time.Minute
-	return StorageDBWriter.Set(ctx, key, value, expire).Err()
+	return Storage.Set(key, value, expire)
+}
+
+// DenyAccessTokenID adds one signed access token id to the denylist until the token expiration
+func DenyAccessTokenID(jti string, expiresAt time.Time) error {
+	expire := time.Until(expiresAt)
+	if expire <= 0 {
+		return nil
+	}
+
+	key := deniedAccessTokenPrefix + jti
+	return Storage.Set(key, "1", expire)
+}
+
+// IsAccessTokenIDDenied returns true if the signed access token id is in the denylist
+func IsAccessTokenIDDenied(jti string) (bool, error) {
+	key := deniedAccessTokenPrefix + jti
+	n, err := Storage.Exists(key)
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// SetAuthorizationCode saves one authorization code until the OAUTH2_AUTHORIZATION_CODE_EXPIRATION
+func SetAuthorizationCode(c *bolo.RequestContext, code string, value string) error {
+	cfgs := c.App.GetConfiguration()
+	expiration := cfgs.GetInt64F("OAUTH2_AUTHORIZATION_CODE_EXPIRATION", 1)
+
+	key := authorizationCodePrefix + code
+	expire := time.Duration(expiration) * time.Minute
+	return Storage.Set(key, value, expire)
+}
+
+// ConsumeAuthorizationCode - get and delete the authorization code in one operation, a code can only be used once
+func ConsumeAuthorizationCode(code string) (string, error) {
+	key := authorizationCodePrefix + code
+	return Storage.GetDel(key)
+}
+
+// SetAuthorizationRequest saves one validated authorization request until the user consent
+func SetAuthorizationRequest(c *bolo.RequestContext, consentToken string, value string, expire time.Duration) error {
+	key := authorizationRequestPrefix + consentToken
+	return Storage.Set(key, value, expire)
+}
+
+// ConsumeAuthorizationRequest - get and delete one authorization request, each consent can only be answered once
+func ConsumeAuthorizationRequest(consentToken string) (string, error) {
+	key := authorizationRequestPrefix + consentToken
+	return Storage.GetDel(key)
 }
@@ -0,0 +1,40 @@
+package user
+
+import (
+	"github.com/go-bolo/bolo"
+	user_models "github.com/go-bolo/user/models"
+	"github.com/go-bolo/user/security"
+	"github.com/pkg/errors"
+)
+
+// ValidateNewPassword checks the new user password with the app password policy and the user password history.
+// The user ID can be empty for new users
+func ValidateNewPassword(app bolo.App, u *user_models.UserModel, field, password string) ([]*bolo.ValidationFieldError, error) {
+	policy := security.GetAppPasswordPolicy(app)
+
+	errs := policy.Validate(field, password, u.Username, u.Email)
+	if len(errs) > 0 {
+		return errs, nil
+	}
+
+	if u.ID == 0 {
+		return nil, nil
+	}
+
+	reused, err := user_models.IsPasswordInHistory(u.GetID(), password, policy.GetHistorySize())
+	if err != nil {
+		return nil, errors.Wrap(err, "ValidateNewPassword error on check password history")
+	}
+
+	if reused {
+		return []*bolo.ValidationFieldError{
+			{
+				Field:   field,
+				Tag:     "history",
+				Message: "auth.password.reused",
+			},
+		}, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,131 @@
+package user_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-bolo/bolo"
+	"github.com/go-bolo/user"
+	user_models "github.com/go-bolo/user/models"
+	auth_oauth2_password "github.com/go-bolo/user/oauth2_password"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangeOwnPasswordApi_PasswordPolicy(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+	ctx := app.NewRequestContext(&bolo.RequestContextOpts{App: app})
+
+	u := user_models.UserModel{
+		Username: "policyuser" + gofakeit.DigitN(6),
+		Email:    strings.ToLower(gofakeit.Email()),
+		Active:   true,
+	}
+	err := u.Save(ctx)
+	assert.NoError(t, err)
+	defer u.Delete()
+	defer user_models.DeleteUserSessions(u.GetID(), "")
+	defer user_models.DeleteUserPasswordHistory(u.GetID())
+
+	err = u.SetPassword("first-strong-password")
+	assert.NoError(t, err)
+
+	token, err := auth_oauth2_password.Oauth2GenerateAndSaveToken(ctx, &u)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		password       string
+		newPassword    string
+		expectedStatus int
+		expectedTags   []string
+	}{
+		{
+			name:           "error with short password",
+			password:       "first-strong-password",
+			newPassword:    "abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedTags:   []string{"min"},
+		},
+		{
+			name:           "error with common password",
+			password:       "first-strong-password",
+			newPassword:    "password123",
+			expectedStatus: http.StatusBadRequest,
+			expectedTags:   []string{"common"},
+		},
+		{
+			name:           "error with the username",
+			password:       "first-strong-password",
+			newPassword:    u.Username + "-secret",
+			expectedStatus: http.StatusBadRequest,
+			expectedTags:   []string{"userdata"},
+		},
+		{
+			name:           "error with the current password",
+			password:       "first-strong-password",
+			newPassword:    "first-strong-password",
+			expectedStatus: http.StatusBadRequest,
+			expectedTags:   []string{"history"},
+		},
+		{
+			name:           "success",
+			password:       "first-strong-password",
+			newPassword:    "second-strong-password",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "error with one old password",
+			password:       "second-strong-password",
+			newPassword:    "first-strong-password",
+			expectedStatus: http.StatusBadRequest,
+			expectedTags:   []string{"history"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(user.ChangeOwnPasswordBody{
+				Password:     tt.password,
+				NewPassword:  tt.newPassword,
+				RNewPassword: tt.newPassword,
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v2/auth/change-password", strings.NewReader(string(body)))
+			req.Header.Set(echo.HeaderAccept, "application/json")
+			req.Header.Set(echo.HeaderContentType, "application/json")
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token.AccessToken)
+
+			rec := httptest.NewRecorder()
+			app.GetRouter().ServeHTTP(rec, req)
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			if len(tt.expectedTags) > 0 {
+				var resp bolo.ValidationResponse
+				err := json.Unmarshal(rec.Body.Bytes(), &resp)
+				assert.NoError(t, err)
+
+				tags := []string{}
+				for _, e := range resp.Errors {
+					assert.Equal(t, "newPassword", e.Field)
+					tags = append(tags, e.Tag)
+				}
+				assert.Equal(t, tt.expectedTags, tags)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package security
+
+import (
+	"time"
+
+	"github.com/go-bolo/bolo"
+)
+
+// Default account lockout values, used if the related configuration is not set
+var (
+	// login failures from any IP before the account lock
+	LockoutMaxFailures = 10
+	// first lock duration, it doubles on each new lock until the LockoutMaxDuration
+	LockoutDuration    = time.Minute * 15
+	LockoutMaxDuration = time.Hour * 24
+	// failures older than this are not counted
+	LockoutResetTime = time.Hour
+)
+
+// AccountLockoutProvider is implemented by plugins that provide the app account lockout, like the AuthPlugin
+type AccountLockoutProvider interface {
+	GetAccountLockout() *AccountLockout
+}
+
+// GetAppAccountLockout returns the account lockout from the auth plugin or the lockout from the app configuration
+func GetAppAccountLockout(app bolo.App) *AccountLockout {
+	if p, ok := app.GetPlugin("auth").(AccountLockoutProvider); ok {
+		if l := p.GetAccountLockout(); l != nil {
+			return l
+		}
+	}
+
+	return NewAccountLockout(app)
+}
+
+func NewAccountLockout(app bolo.App) *AccountLockout {
+	cfgs := app.GetConfiguration()
+
+	return &AccountLockout{
+		MaxFailures: cfgs.GetIntF("AUTH_LOCKOUT_MAX_FAILURES", LockoutMaxFailures),
+		Duration:    time.Duration(cfgs.GetInt64F("AUTH_LOCKOUT_DURATION", int64(LockoutDuration/time.Minute))) * time.Minute,
+		MaxDuration: time.Duration(cfgs.GetInt64F("AUTH_LOCKOUT_MAX_DURATION", int64(LockoutMaxDuration/time.Minute))) * time.Minute,
+		ResetTime:   time.Duration(cfgs.GetInt64F("AUTH_LOCKOUT_RESET_TIME", int64(LockoutResetTime/time.Minute))) * time.Minute,
+	}
+}
+
+// AccountLockout - durable account lock after repeated login failures from any IP, saved in the user record.
+// The LoginThrottle blocks the IP and account pairs for some minutes, the lockout protects the account from distributed attempts
+type AccountLockout struct {
+	// 0 disables the lockout
+	MaxFailures int
+	Duration    time.Duration
+	MaxDuration time.Duration
+	ResetTime   time.Duration
+}
+
+func (l *AccountLockout) IsEnabled() bool {
+	return l != nil && l.MaxFailures > 0
+}
+
+// GetLockDuration returns the duration of the next lock, previousLocks is the number of locks since the last valid login
+func (l *AccountLockout) GetLockDuration(previousLocks int) time.Duration {
+	d := l.Duration
+	for i := 0; i < previousLocks && d < l.MaxDuration; i++ {
+		d *= 2
+	}
+
+	if l.MaxDuration > 0 && d > l.MaxDuration {
+		d = l.MaxDuration
+	}
+
+	return d
+}
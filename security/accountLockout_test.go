@@ -0,0 +1,29 @@
+package security_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-bolo/user/security"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountLockout_GetLockDuration(t *testing.T) {
+	l := security.AccountLockout{
+		MaxFailures: 10,
+		Duration:    time.Minute * 15,
+		MaxDuration: time.Hour,
+	}
+
+	assert.True(t, l.IsEnabled())
+	assert.Equal(t, time.Minute*15, l.GetLockDuration(0))
+	assert.Equal(t, time.Minute*30, l.GetLockDuration(1))
+	assert.Equal(t, time.Hour, l.GetLockDuration(2))
+	assert.Equal(t, time.Hour, l.GetLockDuration(20), "should be limited to the max duration")
+
+	disabled := security.AccountLockout{}
+	assert.False(t, disabled.IsEnabled())
+
+	var empty *security.AccountLockout
+	assert.False(t, empty.IsEnabled())
+}
@@ -2,31 +2,94 @@ package security
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-bolo/bolo"
+	user_helpers "github.com/go-bolo/user/helpers"
 	"github.com/labstack/echo/v4"
 	"github.com/redis/go-redis/v9"
 )
 
+// Default throttle values, used if the related configuration is not set
 var (
 	MaxErrors = 3
 	ResetTime = time.Minute * 10
 	WaitTime  = time.Minute * 10
+	// max login errors for one account from any IP
+	AccountMaxErrors = 10
+	// max login errors from one IP for any account
+	IPMaxErrors = 30
 )
 
+// InvalidCredentialsMessage is the only login error for unknown users, users without password and wrong passwords,
+// then the response does not show if the account exists. The real reason is saved in the login_failed auth event
+var InvalidCredentialsMessage = "Email ou senha incorretos."
+
 type LoginThrottleInterface interface {
 	CanLogin(userID string, c echo.Context) (bool, error)
 	OnLoginFail(userID string, c echo.Context) error
 	OnLoginSuccess(userID string, c echo.Context) error
 	BuildKey(ip, userID string) string
+	// Time to wait before the next login attempt, zero if the login is allowed
+	GetRetryAfter(userID string, c echo.Context) time.Duration
+}
+
+// LoginThrottleProvider is implemented by plugins that provide the app login throttle, like the AuthPlugin
+type LoginThrottleProvider interface {
+	GetLoginThrottle() LoginThrottleInterface
+}
+
+// GetAppLoginThrottle returns the login throttle from the auth plugin or nil if it is not avaible
+func GetAppLoginThrottle(app bolo.App) LoginThrottleInterface {
+	if p, ok := app.GetPlugin("auth").(LoginThrottleProvider); ok {
+		return p.GetLoginThrottle()
+	}
+
+	return nil
+}
+
+// CheckLoginThrottle returns a 429 error with the Retry-After header if the login is blocked
+func CheckLoginThrottle(l LoginThrottleInterface, userID string, c echo.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	can, err := l.CanLogin(userID, c)
+	if err != nil {
+		return err
+	}
+
+	if can {
+		return nil
+	}
+
+	retryAfter := int64(math.Ceil(l.GetRetryAfter(userID, c).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	c.Response().Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+
+	return &bolo.HTTPError{
+		Code:     http.StatusTooManyRequests,
+		Message:  "auth.login.too-many-attempts",
+		Internal: errors.New("auth.login.too-many-attempts ip=" + c.RealIP() + " user=" + userID),
+	}
 }
 
+// NewLoginThrottle creates the login throttle with the AUTH_THROTTLE_* configurations and the redis store,
+// the redis connections are closed in the throttle Close
 func NewLoginThrottle(app bolo.App) *LoginThrottle {
-	db := app.GetConfiguration().GetIntF("SITE_OAUTH2_DB", 1)
-	writerAddr := app.GetConfiguration().GetF("AUTH_THROTTLE_REDIS_ADDR_WRITER", "127.0.0.1:6379")
-	readerAddr := app.GetConfiguration().GetF("AUTH_THROTTLE_REDIS_ADDR_READER", "127.0.0.1:6379")
+	cfgs := app.GetConfiguration()
+
+	db := cfgs.GetIntF("SITE_OAUTH2_DB", 1)
+	writerAddr := cfgs.GetF("AUTH_THROTTLE_REDIS_ADDR_WRITER", "127.0.0.1:6379")
+	readerAddr := cfgs.GetF("AUTH_THROTTLE_REDIS_ADDR_READER", "127.0.0.1:6379")
 
 	DBWriter := redis.NewClient(&redis.Options{
 		Addr: writerAddr, // ex localhost:6379
@@ -38,9 +101,24 @@ func NewLoginThrottle(app bolo.App) *LoginThrottle {
 		DB:   db,
 	})
 
+	l := NewLoginThrottleWithStore(app, user_helpers.NewRedisKVStore(DBWriter, DBReader))
+	l.clients = []*redis.Client{DBWriter, DBReader}
+
+	return l
+}
+
+// NewLoginThrottleWithStore creates the login throttle with the AUTH_THROTTLE_* configurations and one store,
+// like the user_helpers.MemoryKVStore or the user_models.DatabaseKVStore for apps without redis
+func NewLoginThrottleWithStore(app bolo.App, store user_helpers.KVStore) *LoginThrottle {
+	cfgs := app.GetConfiguration()
+
 	return &LoginThrottle{
-		DBWriter: DBWriter,
-		DBReader: DBReader,
+		Store:            store,
+		MaxErrors:        cfgs.GetIntF("AUTH_THROTTLE_MAX_ERRORS", MaxErrors),
+		AccountMaxErrors: cfgs.GetIntF("AUTH_THROTTLE_ACCOUNT_MAX_ERRORS", AccountMaxErrors),
+		IPMaxErrors:      cfgs.GetIntF("AUTH_THROTTLE_IP_MAX_ERRORS", IPMaxErrors),
+		WaitTime:         time.Duration(cfgs.GetInt64F("AUTH_THROTTLE_WAIT_TIME", int64(WaitTime/time.Minute))) * time.Minute,
+		ResetTime:        time.Duration(cfgs.GetInt64F("AUTH_THROTTLE_RESET_TIME", int64(ResetTime/time.Minute))) * time.Minute,
 	}
 }
 
@@ -49,117 +127,189 @@ type DBRedisInterface interface {
 }
 
 type LoginThrottle struct {
-	DBWriter *redis.Client
-	DBReader *redis.Client
+	// Store of the login errors count and the blocked keys
+	Store user_helpers.KVStore
+
+	// max errors for the IP and account pair
+	MaxErrors int
+	// max errors for one account from all IPs
+	AccountMaxErrors int
+	// max errors from one IP for all accounts
+	IPMaxErrors int
+	WaitTime    time.Duration
+	ResetTime   time.Duration
+
+	// redis connections created in the NewLoginThrottle
+	clients []*redis.Client
 }
 
 func (l *LoginThrottle) BuildKey(ip, userID string) string {
 	return ip + "_" + userID
 }
 
-func (l *LoginThrottle) GetAccessRegistry(key string) *LoginThrottleStatus {
-	ctx := context.Background()
+func (l *LoginThrottle) buildAccountKey(userID string) string {
+	return "account_" + userID
+}
 
-	v, err := l.DBReader.Get(ctx, key).Result()
-	if err != nil {
-		return nil
+func (l *LoginThrottle) buildIPKey(ip string) string {
+	return "ip_" + ip
+}
+
+// registry key with the max errors allowed for it
+type loginThrottleKey struct {
+	key       string
+	maxErrors int
+}
+
+// getKeys returns the keys to check, the account keys are skipped if the userID is unknown
+func (l *LoginThrottle) getKeys(userID string, c echo.Context) []loginThrottleKey {
+	ip := c.RealIP()
+
+	keys := []loginThrottleKey{}
+
+	if userID != "" {
+		keys = append(keys,
+			loginThrottleKey{key: l.BuildKey(ip, userID), maxErrors: l.getMaxErrors(l.MaxErrors, MaxErrors)},
+			loginThrottleKey{key: l.buildAccountKey(userID), maxErrors: l.getMaxErrors(l.AccountMaxErrors, AccountMaxErrors)},
+		)
 	}
 
-	lts := LoginThrottleStatus{}
-	err = json.Unmarshal([]byte(v), &lts)
-	if err != nil {
-		return nil
+	keys = append(keys, loginThrottleKey{key: l.buildIPKey(ip), maxErrors: l.getMaxErrors(l.IPMaxErrors, IPMaxErrors)})
+
+	return keys
+}
+
+func (l *LoginThrottle) getMaxErrors(v, fallback int) int {
+	if v > 0 {
+		return v
 	}
 
-	return &lts
+	return fallback
 }
 
-func (l *LoginThrottle) SetAccessRegistry(lts *LoginThrottleStatus) error {
-	ctx := context.Background()
-	err := l.DBReader.Set(ctx, lts.Key, lts.ToJSON(), ResetTime).Err()
-	if err != nil {
-		return nil
+func (l *LoginThrottle) getWaitTime() time.Duration {
+	if l.WaitTime > 0 {
+		return l.WaitTime
 	}
 
-	return nil
+	return WaitTime
 }
 
-func (l *LoginThrottle) CanLogin(userID string, c echo.Context) (bool, error) {
-	ip := c.RealIP()
-	key := l.BuildKey(ip, userID)
+func (l *LoginThrottle) getResetTime() time.Duration {
+	if l.ResetTime > 0 {
+		return l.ResetTime
+	}
+
+	return ResetTime
+}
+
+// counterKey and blockedKey - the store keys of one throttle key, the count of errors and the block flag
+func (l *LoginThrottle) counterKey(key string) string {
+	return "login_throttle:" + key
+}
+
+func (l *LoginThrottle) blockedKey(key string) string {
+	return "login_throttle:" + key + ":blocked"
+}
 
-	f := l.GetAccessRegistry(key)
+// CanLogin returns false if one of the keys is blocked, store errors are returned then the caller can fail closed
+func (l *LoginThrottle) CanLogin(userID string, c echo.Context) (bool, error) {
+	keys := []string{}
+	for _, k := range l.getKeys(userID, c) {
+		keys = append(keys, l.blockedKey(k.key))
+	}
 
-	if f == nil || f.Can() {
-		return true, nil
+	blocked, err := l.Store.Exists(keys...)
+	if err != nil {
+		return false, fmt.Errorf("LoginThrottle.CanLogin error on check blocked keys: %w", err)
 	}
 
-	return false, nil
+	return blocked == 0, nil
 }
 
-func (l *LoginThrottle) OnLoginFail(userID string, c echo.Context) error {
-	ip := c.RealIP()
-	key := l.BuildKey(ip, userID)
+func (l *LoginThrottle) GetRetryAfter(userID string, c echo.Context) time.Duration {
+	var retryAfter time.Duration
 
-	f := l.GetAccessRegistry(key)
-	if f == nil {
-		f = &LoginThrottleStatus{
-			Key:   key,
-			Count: 0,
-		}
-	} else {
-		if f.Count >= 3 {
-			f.WaitTime = time.Now().Add(WaitTime).Unix()
+	for _, k := range l.getKeys(userID, c) {
+		wait, err := l.Store.TTL(l.blockedKey(k.key))
+		if err != nil {
+			continue
 		}
 
-		f.Count++
+		if wait > retryAfter {
+			retryAfter = wait
+		}
 	}
 
-	return l.SetAccessRegistry(f)
+	return retryAfter
 }
 
-func (l *LoginThrottle) OnLoginSuccess(userID string, c echo.Context) error {
-	ip := c.RealIP()
-	key := l.BuildKey(ip, userID)
+// OnLoginFail counts one login error in each key and blocks the keys that reach the max errors,
+// parallel requests are all counted with the store atomic Incr
+func (l *LoginThrottle) OnLoginFail(userID string, c echo.Context) error {
+	for _, k := range l.getKeys(userID, c) {
+		count, err := l.Store.Incr(l.counterKey(k.key), l.getResetTime())
+		if err != nil {
+			return fmt.Errorf("LoginThrottle.OnLoginFail error on count login error: %w", err)
+		}
 
-	f := l.GetAccessRegistry(key)
-	if f != nil {
-		f.Count = 0
-		f.WaitTime = 0
-		return l.SetAccessRegistry(f)
+		if count < int64(k.maxErrors) {
+			continue
+		}
+
+		err = l.Store.Set(l.blockedKey(k.key), "1", l.getWaitTime())
+		if err != nil {
+			return fmt.Errorf("LoginThrottle.OnLoginFail error on block key: %w", err)
+		}
+
+		// keep the count while the login is blocked:
+		ttl := l.getResetTime()
+		if l.getWaitTime() > ttl {
+			ttl = l.getWaitTime()
+		}
+
+		err = l.Store.Expire(l.counterKey(k.key), ttl)
+		if err != nil {
+			return fmt.Errorf("LoginThrottle.OnLoginFail error on update count expiration: %w", err)
+		}
 	}
 
 	return nil
 }
 
-type LoginThrottleStatus struct {
-	Key   string `json:"key"`
-	Count int    `json:"count"`
-	// unix timestamp
-	WaitTime int64 `json:"wait_time"`
-}
-
-func (lts *LoginThrottleStatus) Can() bool {
-	now := time.Now().Unix()
+func (l *LoginThrottle) OnLoginSuccess(userID string, c echo.Context) error {
+	if userID == "" {
+		return nil
+	}
 
-	if now > lts.WaitTime {
-		return true
+	// the IP registry is not reset here, one valid account should not unlock the IP for others:
+	ip := c.RealIP()
+	keys := []string{}
+	for _, key := range []string{l.BuildKey(ip, userID), l.buildAccountKey(userID)} {
+		keys = append(keys, l.counterKey(key), l.blockedKey(key))
 	}
 
-	lts.OnError()
+	err := l.Store.Del(keys...)
+	if err != nil {
+		return fmt.Errorf("LoginThrottle.OnLoginSuccess error on reset keys: %w", err)
+	}
 
-	return false
+	return nil
 }
 
-func (lts *LoginThrottleStatus) OnError() {
-	lts.Count++
+// Close closes the store and the redis connections created in the NewLoginThrottle
+func (l *LoginThrottle) Close() error {
+	err := l.Store.Close()
+	if err != nil {
+		return err
+	}
 
-	if lts.Count >= MaxErrors {
-		lts.WaitTime = time.Now().Add(WaitTime).Unix()
+	for _, client := range l.clients {
+		err = client.Close()
+		if err != nil {
+			return err
+		}
 	}
-}
 
-func (lts *LoginThrottleStatus) ToJSON() string {
-	jsonString, _ := json.Marshal(lts)
-	return string(jsonString)
+	return nil
 }
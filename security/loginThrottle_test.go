@@ -1,17 +1,23 @@
 package security_test
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-bolo/bolo"
+	user_helpers "github.com/go-bolo/user/helpers"
 	"github.com/go-bolo/user/security"
 	"github.com/go-redis/redismock/v9"
 	"github.com/labstack/echo/v4"
 	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
 )
 
 func NewLoginThottleBaseTestContext(userID, ip string) (*security.LoginThrottle, echo.Context, redismock.ClientMock) {
@@ -19,8 +25,7 @@ func NewLoginThottleBaseTestContext(userID, ip string) (*security.LoginThrottle,
 	app := GetAppInstance()
 
 	lInstance := security.NewLoginThrottle(app)
-	lInstance.DBWriter = db
-	lInstance.DBReader = db
+	lInstance.Store = user_helpers.NewRedisKVStore(db, db)
 
 	e := app.GetRouter()
 
@@ -45,17 +50,23 @@ func TestLoginThottle_canLogin(t *testing.T) {
 
 	lInstance, user1Ctx, redisMock := NewLoginThottleBaseTestContext(userID, ip)
 
+	blockedKeys := []string{
+		"login_throttle:" + lInstance.BuildKey(ip, userID) + ":blocked",
+		"login_throttle:account_" + userID + ":blocked",
+		"login_throttle:ip_" + ip + ":blocked",
+	}
+
 	type args struct {
 		c echo.Context
 	}
 	tests := []struct {
-		name             string
-		l                security.LoginThrottleInterface
-		args             args
-		want             bool
-		wantErr          bool
-		redisRecordIsNil bool
-		redisStoredData  *security.LoginThrottleStatus
+		name         string
+		l            security.LoginThrottleInterface
+		args         args
+		want         bool
+		wantErr      bool
+		blockedCount int64
+		redisErr     error
 	}{
 		{
 			name: "success",
@@ -63,9 +74,8 @@ func TestLoginThottle_canLogin(t *testing.T) {
 			args: args{
 				c: user1Ctx,
 			},
-			want:             true,
-			wantErr:          false,
-			redisRecordIsNil: true,
+			want:    true,
+			wantErr: false,
 		},
 		{
 			name: "error on locked by login errors count",
@@ -73,21 +83,27 @@ func TestLoginThottle_canLogin(t *testing.T) {
 			args: args{
 				c: user1Ctx,
 			},
-			want:             false,
-			wantErr:          false,
-			redisRecordIsNil: false,
-			redisStoredData: &security.LoginThrottleStatus{
-				Count:    3,
-				WaitTime: time.Now().Add(time.Minute * 2).Unix(),
+			want:         false,
+			wantErr:      false,
+			blockedCount: 1,
+		},
+		{
+			name: "redis errors are returned",
+			l:    lInstance,
+			args: args{
+				c: user1Ctx,
 			},
+			want:     false,
+			wantErr:  true,
+			redisErr: errors.New("connection refused"),
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if tt.redisRecordIsNil {
-				redisMock.ExpectGet(tt.l.BuildKey(ip, userID)).RedisNil()
+			if tt.redisErr != nil {
+				redisMock.ExpectExists(blockedKeys...).SetErr(tt.redisErr)
 			} else {
-				redisMock.ExpectGet(tt.l.BuildKey(ip, userID)).SetVal(tt.redisStoredData.ToJSON())
+				redisMock.ExpectExists(blockedKeys...).SetVal(tt.blockedCount)
 			}
 
 			got, err := tt.l.CanLogin(userID, tt.args.c)
@@ -103,67 +119,48 @@ func TestLoginThottle_canLogin(t *testing.T) {
 }
 
 func TestLoginThottle_onLoginFail(t *testing.T) {
-	db, mock := redismock.NewClientMock()
 	app := GetAppInstance()
+	s := miniredis.RunT(t)
+	db := redis.NewClient(&redis.Options{Addr: s.Addr()})
 
-	userID := "11"
+	l := security.NewLoginThrottle(app)
+	l.Store = user_helpers.NewRedisKVStore(db, db)
+	l.MaxErrors = 3
 
-	lInstance := security.NewLoginThrottle(app)
-	lInstance.DBWriter = db
-	lInstance.DBReader = db
+	req := httptest.NewRequest(http.MethodPost, "/any", nil)
+	req.Header.Add("X-Forwarded-For", "127.0.0.1")
+	c := app.GetRouter().NewContext(req, httptest.NewRecorder())
 
-	e := app.GetRouter()
+	t.Run("parallel errors are all counted", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.Nil(t, l.OnLoginFail("11", c))
+			}()
+		}
+		wg.Wait()
 
-	payload := `{}`
-	req, err := http.NewRequest(http.MethodPost, "/any", strings.NewReader(payload))
-	if err != nil {
-		t.Errorf("TestCreateOne error: %v", err)
-	}
+		count, err := db.Get(context.Background(), "login_throttle:"+l.BuildKey("127.0.0.1", "11")).Int()
+		assert.NoError(t, err)
+		assert.Equal(t, 10, count)
 
-	req.Header.Add("X-Forwarded-For", "127.0.0.1")
+		can, err := l.CanLogin("11", c)
+		assert.NoError(t, err)
+		assert.False(t, can)
+	})
 
-	res := httptest.NewRecorder()
+	t.Run("redis errors are returned", func(t *testing.T) {
+		s.Close()
+		defer s.Restart()
 
-	user1Ctx := e.NewContext(req, res)
+		assert.Error(t, l.OnLoginFail("12", c))
 
-	type args struct {
-		userID string
-		c      echo.Context
-	}
-	tests := []struct {
-		name             string
-		l                security.LoginThrottleInterface
-		args             args
-		want             bool
-		wantErr          bool
-		redisRecordIsNil bool
-		redisStoredData  *security.LoginThrottleStatus
-	}{
-		{
-			name: "success",
-			l:    lInstance,
-			args: args{
-				c:      user1Ctx,
-				userID: "13",
-			},
-			want:             true,
-			wantErr:          false,
-			redisRecordIsNil: true,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if tt.redisRecordIsNil {
-				mock.ExpectGet("127.0.0.1").RedisNil()
-			} else {
-				mock.ExpectGet("127.0.0.1").SetVal(tt.redisStoredData.ToJSON())
-			}
-
-			if err := tt.l.OnLoginFail(userID, tt.args.c); (err != nil) != tt.wantErr {
-				t.Errorf("LoginThottle.onLoginFail() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
+		_, err := l.CanLogin("12", c)
+		assert.Error(t, err)
+		assert.Error(t, security.CheckLoginThrottle(l, "12", c))
+	})
 }
 
 func TestLoginThottle_onLoginSuccess(t *testing.T) {
@@ -189,6 +186,88 @@ func TestLoginThottle_onLoginSuccess(t *testing.T) {
 	}
 }
 
+func TestCheckLoginThrottle(t *testing.T) {
+	s := miniredis.RunT(t)
+	db := redis.NewClient(&redis.Options{Addr: s.Addr()})
+
+	t.Run("redis store", func(t *testing.T) {
+		testCheckLoginThrottle(t, user_helpers.NewRedisKVStore(db, db))
+	})
+
+	t.Run("memory store", func(t *testing.T) {
+		store := user_helpers.NewMemoryKVStore()
+		defer store.Close()
+
+		testCheckLoginThrottle(t, store)
+	})
+}
+
+func testCheckLoginThrottle(t *testing.T, store user_helpers.KVStore) {
+	app := GetAppInstance()
+
+	l := &security.LoginThrottle{
+		Store:            store,
+		MaxErrors:        2,
+		AccountMaxErrors: 3,
+		IPMaxErrors:      5,
+		WaitTime:         time.Minute,
+		ResetTime:        time.Minute,
+	}
+
+	newCtx := func(ip string) echo.Context {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.Header.Add("X-Forwarded-For", ip)
+		return app.GetRouter().NewContext(req, httptest.NewRecorder())
+	}
+
+	t.Run("block the ip and account pair after max errors", func(t *testing.T) {
+		c := newCtx("10.0.0.1")
+		assert.Nil(t, security.CheckLoginThrottle(l, "a@a.com", c))
+
+		assert.Nil(t, l.OnLoginFail("a@a.com", c))
+		assert.Nil(t, security.CheckLoginThrottle(l, "a@a.com", c))
+		assert.Nil(t, l.OnLoginFail("a@a.com", c))
+
+		err := security.CheckLoginThrottle(l, "a@a.com", c)
+		assert.NotNil(t, err)
+		he, ok := err.(*bolo.HTTPError)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusTooManyRequests, he.Code)
+		assert.Equal(t, "60", c.Response().Header().Get("Retry-After"))
+
+		// other accounts from the same ip still can login:
+		assert.Nil(t, security.CheckLoginThrottle(l, "b@b.com", newCtx("10.0.0.1")))
+	})
+
+	t.Run("block the account for all ips after account max errors", func(t *testing.T) {
+		assert.Nil(t, l.OnLoginFail("c@c.com", newCtx("10.0.0.2")))
+		assert.Nil(t, l.OnLoginFail("c@c.com", newCtx("10.0.0.3")))
+		assert.Nil(t, security.CheckLoginThrottle(l, "c@c.com", newCtx("10.0.0.4")))
+		assert.Nil(t, l.OnLoginFail("c@c.com", newCtx("10.0.0.4")))
+
+		assert.NotNil(t, security.CheckLoginThrottle(l, "c@c.com", newCtx("10.0.0.5")))
+	})
+
+	t.Run("block the ip for all accounts after ip max errors", func(t *testing.T) {
+		for _, u := range []string{"d1", "d2", "d3", "d4", "d5"} {
+			assert.Nil(t, l.OnLoginFail(u, newCtx("10.0.0.6")))
+		}
+
+		assert.NotNil(t, security.CheckLoginThrottle(l, "new-user", newCtx("10.0.0.6")))
+		assert.NotNil(t, security.CheckLoginThrottle(l, "", newCtx("10.0.0.6")))
+	})
+
+	t.Run("reset the account on login success", func(t *testing.T) {
+		c := newCtx("10.0.0.7")
+		assert.Nil(t, l.OnLoginFail("e@e.com", c))
+		assert.Nil(t, l.OnLoginFail("e@e.com", c))
+		assert.NotNil(t, security.CheckLoginThrottle(l, "e@e.com", c))
+
+		assert.Nil(t, l.OnLoginSuccess("e@e.com", c))
+		assert.Nil(t, security.CheckLoginThrottle(l, "e@e.com", newCtx("10.0.0.7")))
+	})
+}
+
 func NewTestLoginThrottle(app bolo.App) *security.LoginThrottle {
 	db := app.GetConfiguration().GetIntF("SITE_OAUTH2_DB", 1)
 	writerAddr := app.GetConfiguration().GetF("AUTH_THROTTLE_REDIS_ADDR_WRITER", "127.0.0.1:6379")
@@ -205,7 +284,6 @@ func NewTestLoginThrottle(app bolo.App) *security.LoginThrottle {
 	})
 
 	return &security.LoginThrottle{
-		DBWriter: DBWriter,
-		DBReader: DBReader,
+		Store: user_helpers.NewRedisKVStore(DBWriter, DBReader),
 	}
 }
@@ -0,0 +1,565 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-bolo/bolo"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Default password hashing values, used if the related configuration is not set
+var (
+	PasswordHasherName = "argon2id"
+	BcryptCost         = bcrypt.DefaultCost
+	// OWASP recommended argon2id parameters, memory in KiB
+	Argon2Time    uint32 = 2
+	Argon2Memory  uint32 = 19 * 1024
+	Argon2Threads uint8  = 1
+	// scrypt N is 2^ScryptLogN
+	ScryptLogN = 15
+	ScryptR    = 8
+	ScryptP    = 1
+	// pbkdf2 is only used to verify and upgrade legacy hashes
+	Pbkdf2Iterations = 600000
+)
+
+var (
+	// ErrPasswordMismatch is the bcrypt error to keep the old mismatch checks working with all hashers
+	ErrPasswordMismatch       = bcrypt.ErrMismatchedHashAndPassword
+	ErrPasswordHasherNotFound = errors.New("password hasher not found for the hash")
+	ErrInvalidPasswordHash    = errors.New("invalid password hash format")
+)
+
+// PasswordHasher - one password hash algorithm, selected by the hash prefixes
+type PasswordHasher interface {
+	GetName() string
+	// Hash prefixes handled by this hasher, like "$2a$" for bcrypt
+	GetPrefixes() []string
+	Hash(password string) (string, error)
+	// Verify returns ErrPasswordMismatch if the password does not match the hash
+	Verify(password, hash string) error
+	// NeedsRehash returns true if the hash was created with outdated parameters
+	NeedsRehash(hash string) bool
+}
+
+// PasswordHasherRegistry - hashers by hash prefix and the default hasher used for new hashes
+type PasswordHasherRegistry struct {
+	mu          sync.RWMutex
+	hashers     map[string]PasswordHasher
+	prefixes    []string
+	defaultName string
+}
+
+func NewPasswordHasherRegistry() *PasswordHasherRegistry {
+	return &PasswordHasherRegistry{
+		hashers: map[string]PasswordHasher{},
+	}
+}
+
+// Register adds or replaces the hasher for all its prefixes
+func (r *PasswordHasherRegistry) Register(h PasswordHasher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, prefix := range h.GetPrefixes() {
+		if _, ok := r.hashers[prefix]; !ok {
+			r.prefixes = append(r.prefixes, prefix)
+		}
+		r.hashers[prefix] = h
+	}
+
+	// longest prefixes first:
+	sort.Slice(r.prefixes, func(i, j int) bool {
+		return len(r.prefixes[i]) > len(r.prefixes[j])
+	})
+}
+
+// SetDefault sets the hasher used for new hashes, it should be registered
+func (r *PasswordHasherRegistry) SetDefault(name string) error {
+	if r.GetByName(name) == nil {
+		return fmt.Errorf("password hasher %s is not registered", name)
+	}
+
+	r.mu.Lock()
+	r.defaultName = name
+	r.mu.Unlock()
+
+	return nil
+}
+
+func (r *PasswordHasherRegistry) GetDefault() PasswordHasher {
+	r.mu.RLock()
+	name := r.defaultName
+	r.mu.RUnlock()
+
+	return r.GetByName(name)
+}
+
+func (r *PasswordHasherRegistry) GetByName(name string) PasswordHasher {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, h := range r.hashers {
+		if h.GetName() == name {
+			return h
+		}
+	}
+
+	return nil
+}
+
+// GetForHash returns the hasher with the longest prefix of the hash or nil
+func (r *PasswordHasherRegistry) GetForHash(hash string) PasswordHasher {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, prefix := range r.prefixes {
+		if strings.HasPrefix(hash, prefix) {
+			return r.hashers[prefix]
+		}
+	}
+
+	return nil
+}
+
+func (r *PasswordHasherRegistry) Hash(password string) (string, error) {
+	h := r.GetDefault()
+	if h == nil {
+		return "", errors.New("default password hasher not set")
+	}
+
+	return h.Hash(password)
+}
+
+func (r *PasswordHasherRegistry) Verify(password, hash string) error {
+	h := r.GetForHash(hash)
+	if h == nil {
+		return ErrPasswordHasherNotFound
+	}
+
+	return h.Verify(password, hash)
+}
+
+// NeedsRehash returns true if the hash is not from the default hasher or uses outdated parameters
+func (r *PasswordHasherRegistry) NeedsRehash(hash string) bool {
+	h := r.GetForHash(hash)
+	d := r.GetDefault()
+
+	if h == nil || d == nil || h.GetName() != d.GetName() {
+		return true
+	}
+
+	return h.NeedsRehash(hash)
+}
+
+// PasswordHashers is the registry used by the password model
+var PasswordHashers = newDefaultPasswordHasherRegistry()
+
+func newDefaultPasswordHasherRegistry() *PasswordHasherRegistry {
+	r := NewPasswordHasherRegistry()
+	r.Register(&BcryptHasher{Cost: BcryptCost})
+	r.Register(&Argon2idHasher{Time: Argon2Time, Memory: Argon2Memory, Threads: Argon2Threads})
+	r.Register(&ScryptHasher{LogN: ScryptLogN, R: ScryptR, P: ScryptP})
+	r.Register(&Pbkdf2Hasher{Iterations: Pbkdf2Iterations})
+	r.Register(&LegacySHAHasher{})
+	_ = r.SetDefault(PasswordHasherName)
+
+	return r
+}
+
+// ConfigurePasswordHashers registers the hashers with the AUTH_PASSWORD_* cost configurations and sets the default one
+func ConfigurePasswordHashers(app bolo.App) error {
+	cfgs := app.GetConfiguration()
+
+	PasswordHashers.Register(&BcryptHasher{
+		Cost: cfgs.GetIntF("AUTH_PASSWORD_BCRYPT_COST", BcryptCost),
+	})
+	PasswordHashers.Register(&Argon2idHasher{
+		Time:    uint32(cfgs.GetIntF("AUTH_PASSWORD_ARGON2_TIME", int(Argon2Time))),
+		Memory:  uint32(cfgs.GetIntF("AUTH_PASSWORD_ARGON2_MEMORY", int(Argon2Memory))),
+		Threads: uint8(cfgs.GetIntF("AUTH_PASSWORD_ARGON2_THREADS", int(Argon2Threads))),
+	})
+	PasswordHashers.Register(&ScryptHasher{
+		LogN: cfgs.GetIntF("AUTH_PASSWORD_SCRYPT_LOG_N", ScryptLogN),
+		R:    cfgs.GetIntF("AUTH_PASSWORD_SCRYPT_R", ScryptR),
+		P:    cfgs.GetIntF("AUTH_PASSWORD_SCRYPT_P", ScryptP),
+	})
+
+	return PasswordHashers.SetDefault(cfgs.GetF("AUTH_PASSWORD_HASHER", PasswordHasherName))
+}
+
+// HashPassword creates one hash with the default hasher
+func HashPassword(password string) (string, error) {
+	return PasswordHashers.Hash(password)
+}
+
+// VerifyPassword checks the password with the hasher of the hash prefix
+func VerifyPassword(password, hash string) error {
+	return PasswordHashers.Verify(password, hash)
+}
+
+// PasswordNeedsRehash checks if the hash should be upgraded to the default hasher and parameters
+func PasswordNeedsRehash(hash string) bool {
+	return PasswordHashers.NeedsRehash(hash)
+}
+
+// BcryptHasher - bcrypt hashes like $2a$10$...
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) GetName() string {
+	return "bcrypt"
+}
+
+func (h *BcryptHasher) GetPrefixes() []string {
+	return []string{"$2a$", "$2b$", "$2y$"}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", fmt.Errorf("error on bcrypt.GenerateFromPassword: %w", err)
+	}
+
+	return string(hashed), nil
+}
+
+func (h *BcryptHasher) Verify(password, hash string) error {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
+
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true
+	}
+
+	cfgCost := h.Cost
+	if cfgCost == 0 {
+		cfgCost = bcrypt.DefaultCost
+	}
+
+	return cost != cfgCost
+}
+
+// Argon2idHasher - argon2id hashes in the PHC format: $argon2id$v=19$m=19456,t=2,p=1$salt$hash
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+const argon2KeyLength = 32
+
+func (h *Argon2idHasher) GetName() string {
+	return "argon2id"
+}
+
+func (h *Argon2idHasher) GetPrefixes() []string {
+	return []string{"$argon2id$"}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt, err := randomSalt(16)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(password, hash string) error {
+	memory, time, threads, salt, key, err := h.decode(hash)
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	memory, time, threads, _, _, err := h.decode(hash)
+	if err != nil {
+		return true
+	}
+
+	return memory != h.Memory || time != h.Time || threads != h.Threads
+}
+
+func (h *Argon2idHasher) decode(hash string) (memory, time uint32, threads uint8, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return 0, 0, 0, nil, nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return 0, 0, 0, nil, nil, ErrInvalidPasswordHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads)
+	if err != nil {
+		return 0, 0, 0, nil, nil, ErrInvalidPasswordHash
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return 0, 0, 0, nil, nil, ErrInvalidPasswordHash
+	}
+
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return 0, 0, 0, nil, nil, ErrInvalidPasswordHash
+	}
+
+	return memory, time, threads, salt, key, nil
+}
+
+// ScryptHasher - scrypt hashes in the PHC like format: $scrypt$ln=15,r=8,p=1$salt$hash
+type ScryptHasher struct {
+	LogN int
+	R    int
+	P    int
+}
+
+const scryptKeyLength = 32
+
+func (h *ScryptHasher) GetName() string {
+	return "scrypt"
+}
+
+func (h *ScryptHasher) GetPrefixes() []string {
+	return []string{"$scrypt$"}
+}
+
+func (h *ScryptHasher) Hash(password string) (string, error) {
+	salt, err := randomSalt(16)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := scrypt.Key([]byte(password), salt, 1<<h.LogN, h.R, h.P, scryptKeyLength)
+	if err != nil {
+		return "", fmt.Errorf("error on scrypt.Key: %w", err)
+	}
+
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s",
+		h.LogN, h.R, h.P,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *ScryptHasher) Verify(password, hash string) error {
+	logN, r, p, salt, key, err := h.decode(hash)
+	if err != nil {
+		return err
+	}
+
+	other, err := scrypt.Key([]byte(password), salt, 1<<logN, r, p, len(key))
+	if err != nil {
+		return fmt.Errorf("error on scrypt.Key: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+func (h *ScryptHasher) NeedsRehash(hash string) bool {
+	logN, r, p, _, _, err := h.decode(hash)
+	if err != nil {
+		return true
+	}
+
+	return logN != h.LogN || r != h.R || p != h.P
+}
+
+func (h *ScryptHasher) decode(hash string) (logN, r, p int, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 {
+		return 0, 0, 0, nil, nil, ErrInvalidPasswordHash
+	}
+
+	_, err = fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &logN, &r, &p)
+	if err != nil || logN <= 0 || logN > 30 {
+		return 0, 0, 0, nil, nil, ErrInvalidPasswordHash
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return 0, 0, 0, nil, nil, ErrInvalidPasswordHash
+	}
+
+	key, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(key) == 0 {
+		return 0, 0, 0, nil, nil, ErrInvalidPasswordHash
+	}
+
+	return logN, r, p, salt, key, nil
+}
+
+// Pbkdf2Hasher - legacy pbkdf2 hashes in the Django format: pbkdf2_sha256$iterations$salt$base64hash
+type Pbkdf2Hasher struct {
+	Iterations int
+}
+
+func (h *Pbkdf2Hasher) GetName() string {
+	return "pbkdf2"
+}
+
+func (h *Pbkdf2Hasher) GetPrefixes() []string {
+	return []string{"pbkdf2_sha1$", "pbkdf2_sha256$", "pbkdf2_sha512$"}
+}
+
+func (h *Pbkdf2Hasher) Hash(password string) (string, error) {
+	salt, err := randomSalt(16)
+	if err != nil {
+		return "", err
+	}
+
+	saltStr := base64.RawURLEncoding.EncodeToString(salt)
+	key := pbkdf2.Key([]byte(password), []byte(saltStr), h.Iterations, sha256.Size, sha256.New)
+
+	return fmt.Sprintf("pbkdf2_sha256$%d$%s$%s", h.Iterations, saltStr, base64.StdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Pbkdf2Hasher) Verify(password, hash string) error {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 {
+		return ErrInvalidPasswordHash
+	}
+
+	hashFunc, _ := legacyHashFunc(strings.TrimPrefix(parts[0], "pbkdf2_"))
+	if hashFunc == nil {
+		return ErrInvalidPasswordHash
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return ErrInvalidPasswordHash
+	}
+
+	key, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return ErrInvalidPasswordHash
+	}
+
+	other := pbkdf2.Key([]byte(password), []byte(parts[2]), iterations, len(key), hashFunc)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+func (h *Pbkdf2Hasher) NeedsRehash(hash string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2_sha256" {
+		return true
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+
+	return err != nil || iterations < h.Iterations
+}
+
+// LegacySHAHasher - legacy salted hex digests like sha1$salt$hexhash, the digest is hash(salt + password).
+// It is only used to verify migrated users, new hashes are never created with it
+type LegacySHAHasher struct{}
+
+func (h *LegacySHAHasher) GetName() string {
+	return "sha"
+}
+
+func (h *LegacySHAHasher) GetPrefixes() []string {
+	return []string{"sha1$", "sha256$", "sha512$"}
+}
+
+func (h *LegacySHAHasher) Hash(password string) (string, error) {
+	return "", errors.New("legacy sha hasher can not be used to create new hashes")
+}
+
+func (h *LegacySHAHasher) Verify(password, hash string) error {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 3 {
+		return ErrInvalidPasswordHash
+	}
+
+	hashFunc, _ := legacyHashFunc(parts[0])
+	if hashFunc == nil {
+		return ErrInvalidPasswordHash
+	}
+
+	expected, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return ErrInvalidPasswordHash
+	}
+
+	d := hashFunc()
+	d.Write([]byte(parts[1] + password))
+
+	if subtle.ConstantTimeCompare(expected, d.Sum(nil)) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+func (h *LegacySHAHasher) NeedsRehash(hash string) bool {
+	return true
+}
+
+func legacyHashFunc(name string) (func() hash.Hash, int) {
+	switch name {
+	case "sha1":
+		return sha1.New, sha1.Size
+	case "sha256":
+		return sha256.New, sha256.Size
+	case "sha512":
+		return sha512.New, sha512.Size
+	}
+
+	return nil, 0
+}
+
+func randomSalt(size int) ([]byte, error) {
+	salt := make([]byte, size)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, fmt.Errorf("error on generate salt: %w", err)
+	}
+
+	return salt, nil
+}
@@ -0,0 +1,92 @@
+package security_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-bolo/user/security"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPasswordHasherRegistry() *security.PasswordHasherRegistry {
+	r := security.NewPasswordHasherRegistry()
+	r.Register(&security.BcryptHasher{Cost: 4})
+	r.Register(&security.Argon2idHasher{Time: 1, Memory: 1024, Threads: 1})
+	r.Register(&security.ScryptHasher{LogN: 4, R: 8, P: 1})
+	r.Register(&security.Pbkdf2Hasher{Iterations: 1000})
+	r.Register(&security.LegacySHAHasher{})
+
+	return r
+}
+
+func TestPasswordHasherRegistry_HashAndVerify(t *testing.T) {
+	r := newTestPasswordHasherRegistry()
+
+	tests := []struct {
+		hasher string
+		prefix string
+	}{
+		{hasher: "bcrypt", prefix: "$2a$04$"},
+		{hasher: "argon2id", prefix: "$argon2id$v=19$m=1024,t=1,p=1$"},
+		{hasher: "scrypt", prefix: "$scrypt$ln=4,r=8,p=1$"},
+		{hasher: "pbkdf2", prefix: "pbkdf2_sha256$1000$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hasher, func(t *testing.T) {
+			err := r.SetDefault(tt.hasher)
+			assert.NoError(t, err)
+
+			hash, err := r.Hash("a-secret")
+			assert.NoError(t, err)
+			assert.True(t, strings.HasPrefix(hash, tt.prefix), hash)
+
+			assert.NoError(t, r.Verify("a-secret", hash))
+			assert.ErrorIs(t, r.Verify("other-secret", hash), security.ErrPasswordMismatch)
+			assert.False(t, r.NeedsRehash(hash))
+		})
+	}
+}
+
+func TestPasswordHasherRegistry_LegacyHashes(t *testing.T) {
+	r := newTestPasswordHasherRegistry()
+	err := r.SetDefault("argon2id")
+	assert.NoError(t, err)
+
+	hashes := []string{
+		"sha1$abc$0a16ed046558c7f76b63e5de16a8bb3b176f137a",
+		"pbkdf2_sha256$1000$somesalt$3tUGMbIGNzjUN4BM53iqLApVCDTwV55F/TybIXwbDu8=",
+	}
+	for _, hash := range hashes {
+		assert.NoError(t, r.Verify("legacy-secret", hash))
+		assert.ErrorIs(t, r.Verify("other-secret", hash), security.ErrPasswordMismatch)
+		assert.True(t, r.NeedsRehash(hash), "legacy hashes should be upgraded")
+	}
+
+	assert.ErrorIs(t, r.Verify("legacy-secret", "md5$abc$123"), security.ErrPasswordHasherNotFound)
+}
+
+func TestPasswordHasherRegistry_NeedsRehash(t *testing.T) {
+	r := newTestPasswordHasherRegistry()
+	err := r.SetDefault("bcrypt")
+	assert.NoError(t, err)
+
+	hash, err := r.Hash("a-secret")
+	assert.NoError(t, err)
+	assert.False(t, r.NeedsRehash(hash))
+
+	t.Run("outdated cost", func(t *testing.T) {
+		r.Register(&security.BcryptHasher{Cost: 5})
+		assert.True(t, r.NeedsRehash(hash))
+		assert.NoError(t, r.Verify("a-secret", hash))
+	})
+
+	t.Run("outdated algorithm", func(t *testing.T) {
+		err := r.SetDefault("argon2id")
+		assert.NoError(t, err)
+		assert.True(t, r.NeedsRehash(hash))
+	})
+
+	t.Run("error with one unknown default hasher", func(t *testing.T) {
+		assert.Error(t, r.SetDefault("md5"))
+	})
+}
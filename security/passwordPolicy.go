@@ -0,0 +1,193 @@
+package security
+
+import (
+	"bufio"
+	_ "embed"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/go-bolo/bolo"
+)
+
+// Default password policy values, used if the related configuration is not set
+var (
+	PasswordMinLength = 8
+	// bcrypt only uses the first 72 bytes of the password
+	PasswordMaxLength   = 72
+	PasswordHistorySize = 5
+)
+
+//go:embed common-passwords.txt
+var commonPasswordsList string
+
+var (
+	commonPasswords     map[string]struct{}
+	commonPasswordsOnce sync.Once
+)
+
+// PasswordPolicyInterface validates new passwords before they are saved
+type PasswordPolicyInterface interface {
+	// Validate returns the policy violations for the field, userData are values like username and email that should not be in the password
+	Validate(field, password string, userData ...string) []*bolo.ValidationFieldError
+	// Number of previous passwords that can not be reused, 0 to disable
+	GetHistorySize() int
+}
+
+// PasswordPolicyProvider is implemented by plugins that provide the app password policy, like the AuthPlugin
+type PasswordPolicyProvider interface {
+	GetPasswordPolicy() PasswordPolicyInterface
+}
+
+// GetAppPasswordPolicy returns the password policy from the auth plugin or the policy from the app configuration
+func GetAppPasswordPolicy(app bolo.App) PasswordPolicyInterface {
+	if p, ok := app.GetPlugin("auth").(PasswordPolicyProvider); ok {
+		if policy := p.GetPasswordPolicy(); policy != nil {
+			return policy
+		}
+	}
+
+	return NewPasswordPolicy(app)
+}
+
+func NewPasswordPolicy(app bolo.App) *PasswordPolicy {
+	cfgs := app.GetConfiguration()
+
+	return &PasswordPolicy{
+		MinLength:            cfgs.GetIntF("AUTH_PASSWORD_MIN_LENGTH", PasswordMinLength),
+		MaxLength:            cfgs.GetIntF("AUTH_PASSWORD_MAX_LENGTH", PasswordMaxLength),
+		RequireLowercase:     cfgs.GetBoolF("AUTH_PASSWORD_REQUIRE_LOWERCASE", false),
+		RequireUppercase:     cfgs.GetBoolF("AUTH_PASSWORD_REQUIRE_UPPERCASE", false),
+		RequireDigit:         cfgs.GetBoolF("AUTH_PASSWORD_REQUIRE_DIGIT", false),
+		RequireSymbol:        cfgs.GetBoolF("AUTH_PASSWORD_REQUIRE_SYMBOL", false),
+		BlockCommonPasswords: cfgs.GetBoolF("AUTH_PASSWORD_BLOCK_COMMON", true),
+		BlockUserData:        cfgs.GetBoolF("AUTH_PASSWORD_BLOCK_USER_DATA", true),
+		HistorySize:          cfgs.GetIntF("AUTH_PASSWORD_HISTORY_SIZE", PasswordHistorySize),
+	}
+}
+
+type PasswordPolicy struct {
+	MinLength int
+	// max length in bytes, values bigger than 72 are ignored because of the bcrypt limit
+	MaxLength        int
+	RequireLowercase bool
+	RequireUppercase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	// reject passwords from the bundled common passwords list
+	BlockCommonPasswords bool
+	// reject passwords that contain the username or email
+	BlockUserData bool
+	HistorySize   int
+}
+
+func (p *PasswordPolicy) GetHistorySize() int {
+	return p.HistorySize
+}
+
+func (p *PasswordPolicy) Validate(field, password string, userData ...string) []*bolo.ValidationFieldError {
+	errs := []*bolo.ValidationFieldError{}
+
+	addError := func(tag, value, message string) {
+		errs = append(errs, &bolo.ValidationFieldError{
+			Field:   field,
+			Tag:     tag,
+			Value:   value,
+			Message: message,
+		})
+	}
+
+	if p.MinLength > 0 && len([]rune(password)) < p.MinLength {
+		addError("min", strconv.Itoa(p.MinLength), "auth.password.min-length")
+	}
+
+	maxLength := p.MaxLength
+	if maxLength <= 0 || maxLength > 72 {
+		maxLength = 72
+	}
+
+	if len(password) > maxLength {
+		addError("max", strconv.Itoa(maxLength), "auth.password.max-length")
+	}
+
+	var hasLower, hasUpper, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireLowercase && !hasLower {
+		addError("lowercase", "", "auth.password.lowercase-required")
+	}
+
+	if p.RequireUppercase && !hasUpper {
+		addError("uppercase", "", "auth.password.uppercase-required")
+	}
+
+	if p.RequireDigit && !hasDigit {
+		addError("digit", "", "auth.password.digit-required")
+	}
+
+	if p.RequireSymbol && !hasSymbol {
+		addError("symbol", "", "auth.password.symbol-required")
+	}
+
+	if p.BlockCommonPasswords && IsCommonPassword(password) {
+		addError("common", "", "auth.password.too-common")
+	}
+
+	if p.BlockUserData && passwordContainsUserData(password, userData) {
+		addError("userdata", "", "auth.password.contains-user-data")
+	}
+
+	return errs
+}
+
+// IsCommonPassword checks if the password is in the bundled common passwords list, case insensitive
+func IsCommonPassword(password string) bool {
+	commonPasswordsOnce.Do(func() {
+		commonPasswords = map[string]struct{}{}
+
+		scanner := bufio.NewScanner(strings.NewReader(commonPasswordsList))
+		for scanner.Scan() {
+			v := strings.TrimSpace(scanner.Text())
+			if v != "" && !strings.HasPrefix(v, "#") {
+				commonPasswords[strings.ToLower(v)] = struct{}{}
+			}
+		}
+	})
+
+	_, ok := commonPasswords[strings.ToLower(password)]
+	return ok
+}
+
+// passwordContainsUserData checks the user data and the email local part, values with less than 3 chars are ignored
+func passwordContainsUserData(password string, userData []string) bool {
+	password = strings.ToLower(password)
+
+	for _, v := range userData {
+		v = strings.ToLower(strings.TrimSpace(v))
+
+		values := []string{v}
+		if i := strings.Index(v, "@"); i > 0 {
+			values = append(values, v[:i])
+		}
+
+		for _, value := range values {
+			if len(value) >= 3 && strings.Contains(password, value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
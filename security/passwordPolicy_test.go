@@ -0,0 +1,53 @@
+package security_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-bolo/user/security"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	policy := security.PasswordPolicy{
+		MinLength:            8,
+		MaxLength:            72,
+		RequireUppercase:     true,
+		RequireDigit:         true,
+		BlockCommonPasswords: true,
+		BlockUserData:        true,
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		wantTags []string
+	}{
+		{name: "valid", password: "Horse-battery-9", wantTags: []string{}},
+		{name: "too short", password: "Ab1", wantTags: []string{"min"}},
+		{name: "too long", password: "A1" + strings.Repeat("a", 71), wantTags: []string{"max"}},
+		{name: "without uppercase and digit", password: "horse-battery", wantTags: []string{"uppercase", "digit"}},
+		{name: "common password", password: "Password1", wantTags: []string{"common"}},
+		{name: "with username", password: "Alberto-2024x", wantTags: []string{"userdata"}},
+		{name: "with email local part", password: "X9-souza.dev", wantTags: []string{"userdata"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := policy.Validate("password", tt.password, "alberto", "souza.dev@example.com")
+
+			tags := []string{}
+			for _, e := range errs {
+				assert.Equal(t, "password", e.Field)
+				tags = append(tags, e.Tag)
+			}
+
+			assert.Equal(t, tt.wantTags, tags)
+		})
+	}
+}
+
+func TestIsCommonPassword(t *testing.T) {
+	assert.True(t, security.IsCommonPassword("123456"))
+	assert.True(t, security.IsCommonPassword("QWERTY"))
+	assert.False(t, security.IsCommonPassword("Horse-battery-9"))
+}
@@ -0,0 +1,65 @@
+package security_test
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-bolo/user/security"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateTOTPCode(t *testing.T) {
+	// RFC 6238 SHA1 test secret:
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		name string
+		time int64
+		want string
+	}{
+		{name: "59", time: 59, want: "287082"},
+		{name: "1111111109", time: 1111111109, want: "081804"},
+		{name: "1234567890", time: 1234567890, want: "005924"},
+		{name: "2000000000", time: 2000000000, want: "279037"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := security.GenerateTOTPCode(secret, time.Unix(tt.time, 0))
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidateTOTPCode(t *testing.T) {
+	secret, err := security.GenerateTOTPSecret()
+	assert.Nil(t, err)
+
+	now := time.Unix(1700000000, 0)
+
+	code, err := security.GenerateTOTPCode(secret, now)
+	assert.Nil(t, err)
+
+	valid, step := security.ValidateTOTPCode(secret, code, now)
+	assert.True(t, valid)
+	assert.Equal(t, security.GetTOTPStep(now), step)
+
+	valid, _ = security.ValidateTOTPCode(secret, code, now.Add(security.TOTPPeriod*time.Second))
+	assert.True(t, valid, "should accept the previous step")
+
+	valid, _ = security.ValidateTOTPCode(secret, code, now.Add(3*security.TOTPPeriod*time.Second))
+	assert.False(t, valid, "should reject old codes")
+
+	valid, _ = security.ValidateTOTPCode(secret, "12345", now)
+	assert.False(t, valid)
+}
+
+func TestBuildTOTPProvisioningURI(t *testing.T) {
+	uri := security.BuildTOTPProvisioningURI("My Site", "user@example.com", "ABC")
+
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/My%20Site:user@example.com?"))
+	assert.Contains(t, uri, "secret=ABC")
+	assert.Contains(t, uri, "issuer=My+Site")
+}
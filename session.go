@@ -3,13 +3,16 @@ package user
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/go-bolo/bolo"
 	user_helpers "github.com/go-bolo/user/helpers"
+	user_models "github.com/go-bolo/user/models"
 	"github.com/gorilla/sessions"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
 )
 
 var sessionInitialized bool
@@ -61,7 +64,26 @@ func SetUserSession(app bolo.App, c echo.Context, user bolo.UserInterface) (*ses
 
 	sess.Options = user_helpers.GetSessionOptions(app)
 
+	// one new login replaces the old session registry:
+	if sid := getSessionRegistryID(sess); sid != "" {
+		deleteUserSessionRegistry(sid)
+	}
+
+	registry, err := user_models.CreateUserSession(
+		user.GetID(),
+		user_models.UserSessionTypeSession,
+		c.RealIP(),
+		c.Request().UserAgent(),
+		time.Now().Add(time.Duration(sess.Options.MaxAge)*time.Second),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error on register user session: %w", err)
+	}
+
 	sess.Values["uid"] = user.GetID()
+	sess.Values["sid"] = registry.GetID()
+	c.Set(user_models.CurrentUserSessionIDKey, registry.GetID())
+
 	err = sess.Save(c.Request(), c.Response())
 	if err != nil {
 		return nil, err
@@ -78,7 +100,12 @@ func DeleteUserSession(c echo.Context) error {
 		}
 	}
 
+	if sid := getSessionRegistryID(sess); sid != "" {
+		deleteUserSessionRegistry(sid)
+	}
+
 	sess.Values["uid"] = 0
+	delete(sess.Values, "sid")
 	sess.Options.MaxAge = -1
 	err = sess.Save(c.Request(), c.Response())
 	if err != nil {
@@ -87,3 +114,24 @@ func DeleteUserSession(c echo.Context) error {
 
 	return nil
 }
+
+// getSessionRegistryID returns the user_sessions registry id saved in the session
+func getSessionRegistryID(sess *sessions.Session) string {
+	sid, _ := sess.Values["sid"].(string)
+	return sid
+}
+
+func deleteUserSessionRegistry(sid string) {
+	registry := user_models.UserSessionModel{}
+	err := user_models.FindUserSession(sid, &registry)
+	if err == nil && registry.ID != 0 {
+		err = registry.Delete()
+	}
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"sid":   sid,
+		}).Error("deleteUserSessionRegistry error on delete session registry")
+	}
+}
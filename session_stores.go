@@ -0,0 +1,319 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-bolo/bolo"
+	user_helpers "github.com/go-bolo/user/helpers"
+	user_models "github.com/go-bolo/user/models"
+	"github.com/gorilla/sessions"
+	"github.com/rbcervilla/redisstore/v9"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// SessionStoreFactory creates the session store used by the AuthPlugin session middleware
+type SessionStoreFactory func(app bolo.App) (sessions.Store, error)
+
+// Interval to delete expired sessions in the server side stores
+var SessionStoreCleanupInterval = 30 * time.Minute
+
+// RedisSessionStoreFactory - default session store, saves the sessions in the SITE_SESSION_ADDR_* redis
+func RedisSessionStoreFactory(app bolo.App) (sessions.Store, error) {
+	initRedisSession()
+
+	store, err := redisstore.NewRedisStore(context.Background(), SessionDBWriter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create redis session store: %w", err)
+	}
+
+	return store, nil
+}
+
+// DatabaseSessionStoreFactory saves the sessions in the sessions table with the app database connection
+func DatabaseSessionStoreFactory(app bolo.App) (sessions.Store, error) {
+	return newServerSideSessionStore(app, &databaseSessionBackend{db: app.GetDB()}), nil
+}
+
+// MemorySessionStoreFactory keeps the sessions in the process memory, only for tests and development
+func MemorySessionStoreFactory(app bolo.App) (sessions.Store, error) {
+	return newServerSideSessionStore(app, &memorySessionBackend{
+		records: make(map[string]memorySessionRecord),
+	}), nil
+}
+
+// CookieSessionStoreFactory saves all the session data in one signed cookie.
+// SITE_SESSION_SECRET is required and SITE_SESSION_ENCRYPTION_KEY (16, 24 or 32 bytes) enables the encryption
+func CookieSessionStoreFactory(app bolo.App) (sessions.Store, error) {
+	cfgs := app.GetConfiguration()
+
+	secret := cfgs.Get("SITE_SESSION_SECRET")
+	if len(secret) < 32 {
+		return nil, errors.New("CookieSessionStoreFactory: SITE_SESSION_SECRET should have at least 32 chars")
+	}
+
+	keys := [][]byte{[]byte(secret)}
+	if encryptionKey := cfgs.Get("SITE_SESSION_ENCRYPTION_KEY"); encryptionKey != "" {
+		switch len(encryptionKey) {
+		case 16, 24, 32:
+			keys = append(keys, []byte(encryptionKey))
+		default:
+			return nil, errors.New("CookieSessionStoreFactory: SITE_SESSION_ENCRYPTION_KEY should have 16, 24 or 32 chars")
+		}
+	}
+
+	store := sessions.NewCookieStore(keys...)
+	store.Options = user_helpers.GetSessionOptions(app)
+
+	return store, nil
+}
+
+// sessionBackend is the storage used by the serverSideSessionStore
+type sessionBackend interface {
+	Load(id string) ([]byte, error)
+	Save(id string, data []byte, expiresAt time.Time) error
+	Delete(id string) error
+	DeleteExpired() error
+}
+
+// serverSideSessionStore keeps the session data in one backend and only the random session id in the cookie
+type serverSideSessionStore struct {
+	backend sessionBackend
+	options sessions.Options
+
+	stopCleanup chan struct{}
+	closeOnce   sync.Once
+}
+
+func newServerSideSessionStore(app bolo.App, backend sessionBackend) *serverSideSessionStore {
+	s := serverSideSessionStore{
+		backend:     backend,
+		options:     *user_helpers.GetSessionOptions(app),
+		stopCleanup: make(chan struct{}),
+	}
+
+	go s.cleanup()
+
+	return &s
+}
+
+// Get returns a session for the given name after adding it to the registry.
+func (s *serverSideSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+func (s *serverSideSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil || c.Value == "" {
+		return session, nil
+	}
+
+	data, err := s.backend.Load(c.Value)
+	if err != nil {
+		return session, err
+	}
+
+	if data == nil {
+		// expired or deleted session, a new id will be created on save
+		return session, nil
+	}
+
+	err = gob.NewDecoder(bytes.NewBuffer(data)).Decode(&session.Values)
+	if err != nil {
+		return session, err
+	}
+
+	session.ID = c.Value
+	session.IsNew = false
+
+	return session, nil
+}
+
+// Save persists the session data and sets the session id cookie, sessions with MaxAge <= 0 are deleted
+func (s *serverSideSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			err := s.backend.Delete(session.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		id, err := generateSessionID()
+		if err != nil {
+			return err
+		}
+		session.ID = id
+	}
+
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(session.Values)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second)
+	err = s.backend.Save(session.ID, buf.Bytes(), expiresAt)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), session.ID, session.Options))
+	return nil
+}
+
+// Close stops the expired sessions cleanup
+func (s *serverSideSessionStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stopCleanup)
+	})
+
+	return nil
+}
+
+func (s *serverSideSessionStore) cleanup() {
+	ticker := time.NewTicker(SessionStoreCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCleanup:
+			return
+		case <-ticker.C:
+			err := s.backend.DeleteExpired()
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+				}).Error("serverSideSessionStore.cleanup error on delete expired sessions")
+			}
+		}
+	}
+}
+
+func generateSessionID() (string, error) {
+	k := make([]byte, 64)
+	if _, err := io.ReadFull(rand.Reader, k); err != nil {
+		return "", fmt.Errorf("failed to generate session id: %w", err)
+	}
+
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(k), "="), nil
+}
+
+type databaseSessionBackend struct {
+	db *gorm.DB
+}
+
+func (b *databaseSessionBackend) Load(id string) ([]byte, error) {
+	var record user_models.SessionModel
+
+	err := b.db.Where("id = ? AND expiresAt > ?", id, time.Now()).
+		First(&record).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("databaseSessionBackend error on load session: %w", err)
+	}
+
+	return base64.StdEncoding.DecodeString(record.Data)
+}
+
+func (b *databaseSessionBackend) Save(id string, data []byte, expiresAt time.Time) error {
+	record := user_models.SessionModel{
+		ID:        id,
+		Data:      base64.StdEncoding.EncodeToString(data),
+		ExpiresAt: expiresAt,
+	}
+
+	// upsert:
+	err := b.db.Save(&record).Error
+	if err != nil {
+		return fmt.Errorf("databaseSessionBackend error on save session: %w", err)
+	}
+
+	return nil
+}
+
+func (b *databaseSessionBackend) Delete(id string) error {
+	return b.db.Where("id = ?", id).Delete(&user_models.SessionModel{}).Error
+}
+
+func (b *databaseSessionBackend) DeleteExpired() error {
+	return b.db.Where("expiresAt < ?", time.Now()).Delete(&user_models.SessionModel{}).Error
+}
+
+type memorySessionRecord struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+type memorySessionBackend struct {
+	mu      sync.RWMutex
+	records map[string]memorySessionRecord
+}
+
+func (b *memorySessionBackend) Load(id string) ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	r, ok := b.records[id]
+	if !ok || time.Now().After(r.expiresAt) {
+		return nil, nil
+	}
+
+	return r.data, nil
+}
+
+func (b *memorySessionBackend) Save(id string, data []byte, expiresAt time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.records[id] = memorySessionRecord{data: data, expiresAt: expiresAt}
+	return nil
+}
+
+func (b *memorySessionBackend) Delete(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.records, id)
+	return nil
+}
+
+func (b *memorySessionBackend) DeleteExpired() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	for id, r := range b.records {
+		if now.After(r.expiresAt) {
+			delete(b.records, id)
+		}
+	}
+
+	return nil
+}
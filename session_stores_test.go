@@ -0,0 +1,196 @@
+package user_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-bolo/user"
+	user_helpers "github.com/go-bolo/user/helpers"
+	user_models "github.com/go-bolo/user/models"
+	"github.com/go-bolo/user/security"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionStoreFactories(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+
+	tests := []struct {
+		name    string
+		factory user.SessionStoreFactory
+	}{
+		{name: "redis", factory: user.RedisSessionStoreFactory},
+		{name: "database", factory: user.DatabaseSessionStoreFactory},
+		{name: "memory", factory: user.MemorySessionStoreFactory},
+		{name: "cookie", factory: user.CookieSessionStoreFactory},
+	}
+
+	t.Run("cookie store requires the secret", func(t *testing.T) {
+		_, err := user.CookieSessionStoreFactory(app)
+		assert.Error(t, err)
+	})
+
+	os.Setenv("SITE_SESSION_SECRET", "a-session-secret-with-more-than-32-chars")
+	defer os.Unsetenv("SITE_SESSION_SECRET")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := tt.factory(app)
+			assert.NoError(t, err)
+
+			// save:
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rec := httptest.NewRecorder()
+
+			sess, err := store.Get(req, "session")
+			assert.NoError(t, err)
+			assert.True(t, sess.IsNew)
+
+			sess.Values["uid"] = "10"
+			err = sess.Save(req, rec)
+			assert.NoError(t, err)
+
+			cookies := rec.Result().Cookies()
+			assert.Len(t, cookies, 1)
+
+			// load in the next request:
+			req = httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(cookies[0])
+
+			sess, err = store.Get(req, "session")
+			assert.NoError(t, err)
+			assert.False(t, sess.IsNew)
+			assert.Equal(t, "10", sess.Values["uid"])
+
+			// delete:
+			rec = httptest.NewRecorder()
+			sess.Options.MaxAge = -1
+			err = sess.Save(req, rec)
+			assert.NoError(t, err)
+
+			if tt.name != "cookie" {
+				// the server side data should be deleted even if the client keeps the old cookie
+				req = httptest.NewRequest(http.MethodGet, "/", nil)
+				req.AddCookie(cookies[0])
+
+				sess, err = store.Get(req, "session")
+				assert.NoError(t, err)
+				assert.Nil(t, sess.Values["uid"])
+			}
+		})
+	}
+}
+
+func TestKVStores(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	NewApp(t)
+
+	tests := []struct {
+		name  string
+		store user_helpers.KVStore
+	}{
+		{name: "redis", store: user_helpers.NewRedisKVStore(mockedDB, mockedDB)},
+		{name: "database", store: user_models.NewDatabaseKVStore()},
+		{name: "memory", store: user_helpers.NewMemoryKVStore()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := tt.store
+			defer store.Close()
+
+			_, err := store.Get("kv-test-missing")
+			assert.ErrorIs(t, err, user_helpers.ErrKVNotFound)
+
+			assert.NoError(t, store.Set("kv-test-key", "value", time.Minute))
+			v, err := store.Get("kv-test-key")
+			assert.NoError(t, err)
+			assert.Equal(t, "value", v)
+
+			ttl, err := store.TTL("kv-test-key")
+			assert.NoError(t, err)
+			assert.True(t, ttl > 0 && ttl <= time.Minute)
+
+			count, err := store.Exists("kv-test-key", "kv-test-missing")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1), count)
+
+			// only the first GetDel receives the value:
+			v, err = store.GetDel("kv-test-key")
+			assert.NoError(t, err)
+			assert.Equal(t, "value", v)
+			_, err = store.GetDel("kv-test-key")
+			assert.ErrorIs(t, err, user_helpers.ErrKVNotFound)
+
+			for i := 1; i <= 3; i++ {
+				count, err = store.Incr("kv-test-counter", time.Minute)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(i), count)
+			}
+
+			assert.NoError(t, store.Del("kv-test-counter"))
+			count, err = store.Exists("kv-test-counter")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(0), count)
+
+			// expired keys are not returned:
+			assert.NoError(t, store.Set("kv-test-expired", "value", time.Millisecond))
+			time.Sleep(20 * time.Millisecond)
+			if tt.name == "redis" {
+				s.FastForward(time.Second)
+			}
+
+			_, err = store.Get("kv-test-expired")
+			assert.ErrorIs(t, err, user_helpers.ErrKVNotFound)
+		})
+	}
+}
+
+func TestAuthPlugin_OnClose(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	mockedDB := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+	})
+
+	user.SessionDBWriter = mockedDB
+	user.SessionDBReader = mockedDB
+
+	app := NewApp(t)
+
+	sessionStore, err := user.MemorySessionStoreFactory(app)
+	assert.NoError(t, err)
+
+	throttle := security.NewLoginThrottle(app)
+
+	p := user.NewAuthPlugin(&user.AuthPluginCfgs{LoginThrottle: throttle})
+	p.SessionStore = sessionStore
+
+	assert.NoError(t, p.OnClose(app))
+
+	// the redis connections created by the login throttle are closed:
+	err = throttle.Store.(*user_helpers.RedisKVStore).Writer.Ping(context.Background()).Err()
+	assert.ErrorIs(t, err, redis.ErrClosed)
+}
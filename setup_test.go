@@ -55,6 +55,22 @@ func NewApp(t *testing.T) bolo.App {
 		&user_models.UserModel{},
 		&user_models.PasswordModel{},
 		&user_models.AuthTokenModel{},
+		&user_models.UserTOTPModel{},
+		&user_models.MfaRecoveryCodeModel{},
+		&user_models.UserSessionModel{},
+		&user_models.AuthEventModel{},
+		&user_models.SessionModel{},
+		&user_models.UserIdentityModel{},
+		&user_models.PasswordHistoryModel{},
+		&user_models.RoleModel{},
+		&user_models.UserRoleModel{},
+		&user_models.WebAuthnCredentialModel{},
+		&user_models.WebAuthnChallengeModel{},
+		&user_models.SigningKeyModel{},
+		&user_models.PersonalAccessTokenModel{},
+		&user_models.OAuthClientModel{},
+		&user_models.RolePermissionModel{},
+		&user_models.KVStoreModel{},
 		&system_settings.Settings{},
 		&emails.EmailModel{},
 		&emails.EmailTemplateModel{},
@@ -83,9 +99,13 @@ func TestMain(m *testing.M) {
 		panic(err)
 	}
 
+	// the login throttle fails closed without redis:
+	os.Setenv("AUTH_THROTTLE_REDIS_ADDR_WRITER", mr.Addr())
+	os.Setenv("AUTH_THROTTLE_REDIS_ADDR_READER", mr.Addr())
+
 	os.Setenv("DB_URI", "file::memory:?cache=shared")
 	os.Setenv("DB_ENGINE", "sqlite")
-	os.Setenv("TEMPLATE_FOLDER", "./_stubs/themes")
+	os.Setenv("TEMPLATE_FOLDER", "./testdata/stubs/theme")
 	// os.Setenv("LOG_QUERY", "1")
 
 	r := approvals.UseReporter(reporters.NewVSCodeReporter())
@@ -0,0 +1,139 @@
+package user_social
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/facebook"
+	"golang.org/x/oauth2/github"
+)
+
+type OAuth2ProviderConfig struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	Scopes       []string
+	// Optional callback URL, the default is {APP_ORIGIN}/auth/{name}/callback
+	RedirectURL string
+	// Disable the PKCE S256 challenge for providers without support for it
+	DisablePKCE  bool
+	ClaimMapping ClaimMapping
+	HTTPClient   *http.Client
+}
+
+// OAuth2Provider - generic OAuth2 provider with the profile from one user info endpoint
+type OAuth2Provider struct {
+	Config OAuth2ProviderConfig
+}
+
+func NewOAuth2Provider(cfg OAuth2ProviderConfig) *OAuth2Provider {
+	return &OAuth2Provider{Config: cfg}
+}
+
+func (p *OAuth2Provider) GetName() string {
+	return p.Config.Name
+}
+
+func (p *OAuth2Provider) GetRedirectURL() string {
+	return p.Config.RedirectURL
+}
+
+func (p *OAuth2Provider) AuthCodeURL(redirectURL, state, nonce, codeVerifier string) (string, error) {
+	opts := []oauth2.AuthCodeOption{}
+	if codeVerifier != "" && !p.Config.DisablePKCE {
+		opts = append(opts, oauth2.S256ChallengeOption(codeVerifier))
+	}
+
+	return p.oauth2Config(redirectURL).AuthCodeURL(state, opts...), nil
+}
+
+func (p *OAuth2Provider) Exchange(ctx context.Context, redirectURL, code, codeVerifier string) (*oauth2.Token, error) {
+	return exchangeCode(ctx, p.oauth2Config(redirectURL), p.Config.HTTPClient, code, codeVerifier, p.Config.DisablePKCE)
+}
+
+func (p *OAuth2Provider) GetProfile(ctx context.Context, token *oauth2.Token, nonce string) (*Profile, error) {
+	if p.Config.UserInfoURL == "" {
+		return nil, errors.New("OAuth2Provider.GetProfile: user info url not set")
+	}
+
+	claims := map[string]interface{}{}
+	err := fetchJSON(ctx, p.Config.HTTPClient, p.Config.UserInfoURL, token.AccessToken, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Config.ClaimMapping.MapProfile(p.GetName(), claims)
+}
+
+func (p *OAuth2Provider) oauth2Config(redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.Config.ClientID,
+		ClientSecret: p.Config.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  p.Config.AuthURL,
+			TokenURL: p.Config.TokenURL,
+		},
+		RedirectURL: redirectURL,
+		Scopes:      p.Config.Scopes,
+	}
+}
+
+func exchangeCode(ctx context.Context, cfg *oauth2.Config, client *http.Client, code, codeVerifier string, disablePKCE bool) (*oauth2.Token, error) {
+	if client != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+	}
+
+	opts := []oauth2.AuthCodeOption{}
+	if codeVerifier != "" && !disablePKCE {
+		opts = append(opts, oauth2.VerifierOption(codeVerifier))
+	}
+
+	return cfg.Exchange(ctx, code, opts...)
+}
+
+// NewFacebookProvider - Facebook login, the user info doesn't have one verified email claim then the
+// accounts are not linked by email
+func NewFacebookProvider(clientID, clientSecret, redirectURL string) *OAuth2Provider {
+	return NewOAuth2Provider(OAuth2ProviderConfig{
+		Name:         "facebook",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      facebook.Endpoint.AuthURL,
+		TokenURL:     facebook.Endpoint.TokenURL,
+		UserInfoURL:  "https://graph.facebook.com/me?fields=id,name,email,picture",
+		Scopes:       []string{"email", "public_profile"},
+		RedirectURL:  redirectURL,
+		ClaimMapping: ClaimMapping{
+			Subject:  "id",
+			Username: "id",
+			Email:    "email",
+			Name:     "name",
+			Picture:  "picture.data.url",
+		},
+	})
+}
+
+// NewGitHubProvider - GitHub login, the public profile email is not checked as verified
+func NewGitHubProvider(clientID, clientSecret, redirectURL string) *OAuth2Provider {
+	return NewOAuth2Provider(OAuth2ProviderConfig{
+		Name:         "github",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      github.Endpoint.AuthURL,
+		TokenURL:     github.Endpoint.TokenURL,
+		UserInfoURL:  "https://api.github.com/user",
+		Scopes:       []string{"read:user", "user:email"},
+		RedirectURL:  redirectURL,
+		ClaimMapping: ClaimMapping{
+			Subject: "id",
+			Email:   "email",
+			Name:    "name",
+			Picture: "avatar_url",
+		},
+	})
+}
@@ -0,0 +1,380 @@
+package user_social
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"golang.org/x/oauth2"
+)
+
+// Time to keep the discovery and JWKS documents in memory
+var OIDCCacheTTL = time.Hour
+
+type OIDCProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Default: openid, email and profile
+	Scopes []string
+	// Optional callback URL, the default is {APP_ORIGIN}/auth/{name}/callback
+	RedirectURL string
+	// Optional, the default is {Issuer}/.well-known/openid-configuration
+	DiscoveryURL string
+	DisablePKCE  bool
+	// Optional, the default is the DefaultClaimMapping
+	ClaimMapping *ClaimMapping
+	HTTPClient   *http.Client
+}
+
+// OIDCDiscovery - the used fields of the provider discovery document
+type OIDCDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// JSONWebKey - public JWK with RSA or EC key fields
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// OIDCProvider - OpenID Connect provider with discovery and ID token validation with the provider JWKS
+type OIDCProvider struct {
+	Config OIDCProviderConfig
+
+	mu          sync.Mutex
+	discovery   *OIDCDiscovery
+	discoveryAt time.Time
+	keys        map[string]interface{}
+	keysAt      time.Time
+}
+
+func NewOIDCProvider(cfg OIDCProviderConfig) *OIDCProvider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	if cfg.ClaimMapping == nil {
+		m := DefaultClaimMapping
+		cfg.ClaimMapping = &m
+	}
+
+	return &OIDCProvider{Config: cfg}
+}
+
+// NewGoogleProvider - Google OpenID Connect login
+func NewGoogleProvider(clientID, clientSecret, redirectURL string) *OIDCProvider {
+	return NewOIDCProvider(OIDCProviderConfig{
+		Name:         "google",
+		Issuer:       "https://accounts.google.com",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+	})
+}
+
+func (p *OIDCProvider) GetName() string {
+	return p.Config.Name
+}
+
+func (p *OIDCProvider) GetRedirectURL() string {
+	return p.Config.RedirectURL
+}
+
+func (p *OIDCProvider) AuthCodeURL(redirectURL, state, nonce, codeVerifier string) (string, error) {
+	cfg, err := p.oauth2Config(context.Background(), redirectURL)
+	if err != nil {
+		return "", err
+	}
+
+	opts := []oauth2.AuthCodeOption{}
+	if nonce != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", nonce))
+	}
+	if codeVerifier != "" && !p.Config.DisablePKCE {
+		opts = append(opts, oauth2.S256ChallengeOption(codeVerifier))
+	}
+
+	return cfg.AuthCodeURL(state, opts...), nil
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, redirectURL, code, codeVerifier string) (*oauth2.Token, error) {
+	cfg, err := p.oauth2Config(ctx, redirectURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return exchangeCode(ctx, cfg, p.Config.HTTPClient, code, codeVerifier, p.Config.DisablePKCE)
+}
+
+// GetProfile validates the ID token and maps its claims, the user info endpoint claims are added if available
+func (p *OIDCProvider) GetProfile(ctx context.Context, token *oauth2.Token, nonce string) (*Profile, error) {
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, errors.New("OIDCProvider.GetProfile: id_token not found in the token response")
+	}
+
+	claims, err := p.VerifyIDToken(ctx, rawIDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := p.GetDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.UserinfoEndpoint != "" && token.AccessToken != "" {
+		userInfo := map[string]interface{}{}
+		err = fetchJSON(ctx, p.Config.HTTPClient, d.UserinfoEndpoint, token.AccessToken, &userInfo)
+		if err != nil {
+			return nil, fmt.Errorf("OIDCProvider.GetProfile error on get user info: %w", err)
+		}
+
+		// the user info subject should be the same of the ID token:
+		if sub, _ := userInfo["sub"].(string); sub == claims["sub"] {
+			for k, v := range userInfo {
+				if _, ok := claims[k]; !ok {
+					claims[k] = v
+				}
+			}
+		}
+	}
+
+	return p.Config.ClaimMapping.MapProfile(p.GetName(), claims)
+}
+
+// VerifyIDToken checks the ID token signature, issuer, audience, expiration and nonce
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (map[string]interface{}, error) {
+	d, err := p.GetDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := jwt.Parser{
+		ValidMethods:  []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"},
+		UseJSONNumber: true,
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = parser.ParseWithClaims(rawIDToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.getKey(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("OIDCProvider.VerifyIDToken invalid token: %w", err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != d.Issuer {
+		return nil, errors.New("OIDCProvider.VerifyIDToken invalid issuer")
+	}
+
+	if !hasAudience(claims["aud"], p.Config.ClientID) {
+		return nil, errors.New("OIDCProvider.VerifyIDToken invalid audience")
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("OIDCProvider.VerifyIDToken token without expiration")
+	}
+
+	if nonce != "" {
+		if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+			return nil, errors.New("OIDCProvider.VerifyIDToken invalid nonce")
+		}
+	}
+
+	return claims, nil
+}
+
+// GetDiscovery returns the cached provider discovery document
+func (p *OIDCProvider) GetDiscovery(ctx context.Context) (*OIDCDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Since(p.discoveryAt) < OIDCCacheTTL {
+		return p.discovery, nil
+	}
+
+	url := p.Config.DiscoveryURL
+	if url == "" {
+		url = strings.TrimSuffix(p.Config.Issuer, "/") + "/.well-known/openid-configuration"
+	}
+
+	var d OIDCDiscovery
+	err := fetchJSON(ctx, p.Config.HTTPClient, url, "", &d)
+	if err != nil {
+		return nil, fmt.Errorf("OIDCProvider.GetDiscovery error on get discovery document: %w", err)
+	}
+
+	if d.Issuer != p.Config.Issuer {
+		return nil, fmt.Errorf("OIDCProvider.GetDiscovery issuer %s don't match the configured issuer", d.Issuer)
+	}
+
+	p.discovery = &d
+	p.discoveryAt = time.Now()
+
+	return p.discovery, nil
+}
+
+// getKey returns the JWKS key by kid, the keys are reloaded once for unknown kids to support key rotations
+func (p *OIDCProvider) getKey(ctx context.Context, kid string) (interface{}, error) {
+	d, err := p.GetDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key := p.findKey(kid); key != nil && time.Since(p.keysAt) < OIDCCacheTTL {
+		return key, nil
+	}
+
+	var set JSONWebKeySet
+	err = fetchJSON(ctx, p.Config.HTTPClient, d.JwksURI, "", &set)
+	if err != nil {
+		return nil, fmt.Errorf("OIDCProvider error on get jwks: %w", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = key
+	}
+
+	p.keys = keys
+	p.keysAt = time.Now()
+
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("OIDCProvider key %s not found", kid)
+}
+
+func (p *OIDCProvider) findKey(kid string) interface{} {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k
+		}
+	}
+
+	return p.keys[kid]
+}
+
+func (p *OIDCProvider) oauth2Config(ctx context.Context, redirectURL string) (*oauth2.Config, error) {
+	d, err := p.GetDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oauth2.Config{
+		ClientID:     p.Config.ClientID,
+		ClientSecret: p.Config.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  d.AuthorizationEndpoint,
+			TokenURL: d.TokenEndpoint,
+		},
+		RedirectURL: redirectURL,
+		Scopes:      p.Config.Scopes,
+	}, nil
+}
+
+// PublicKey parses the JWK in one *rsa.PublicKey or *ecdsa.PublicKey
+func (k *JSONWebKey) PublicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func hasAudience(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if s, _ := a.(string); s == clientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,131 @@
+package user_social_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	user_social "github.com/go-bolo/user/social"
+	"github.com/go-bolo/user/social/socialtest"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+func TestOIDCProvider_Flow(t *testing.T) {
+	idp := socialtest.NewStubIdP("client-id", "client-secret")
+	defer idp.Close()
+
+	idp.Claims = map[string]interface{}{
+		"sub":            "123",
+		"email":          "stub@example.com",
+		"email_verified": true,
+		"name":           "Stub User",
+	}
+
+	p := user_social.NewOIDCProvider(user_social.OIDCProviderConfig{
+		Name:         "stub",
+		Issuer:       idp.Issuer(),
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+	})
+
+	redirectURL := "http://localhost/auth/stub/callback"
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := p.AuthCodeURL(redirectURL, "state-1", "nonce-1", verifier)
+	assert.NoError(t, err)
+
+	// follow the provider authorization page redirect:
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(authURL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "state-1", callback.Query().Get("state"))
+
+	t.Run("error with wrong code verifier", func(t *testing.T) {
+		code := idp.IssueCode(redirectURL, "nonce-1", oauth2.S256ChallengeFromVerifier(verifier))
+
+		_, err := p.Exchange(context.Background(), redirectURL, code, "wrong-verifier-wrong-verifier-wrong-verifier")
+		assert.Error(t, err)
+	})
+
+	token, err := p.Exchange(context.Background(), redirectURL, callback.Query().Get("code"), verifier)
+	assert.NoError(t, err)
+
+	t.Run("error with invalid nonce", func(t *testing.T) {
+		_, err := p.GetProfile(context.Background(), token, "other-nonce")
+		assert.Error(t, err)
+	})
+
+	profile, err := p.GetProfile(context.Background(), token, "nonce-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "stub", profile.Provider)
+	assert.Equal(t, "123", profile.Subject)
+	assert.Equal(t, "stub@example.com", profile.Email)
+	assert.True(t, profile.EmailVerified)
+	assert.Equal(t, "Stub User", profile.Name)
+}
+
+func TestOIDCProvider_VerifyIDToken(t *testing.T) {
+	idp := socialtest.NewStubIdP("client-id", "client-secret")
+	defer idp.Close()
+
+	idp.Claims = map[string]interface{}{"sub": "123"}
+
+	t.Run("error with other audience", func(t *testing.T) {
+		p := user_social.NewOIDCProvider(user_social.OIDCProviderConfig{
+			Name:     "stub",
+			Issuer:   idp.Issuer(),
+			ClientID: "other-client",
+		})
+
+		_, err := p.VerifyIDToken(context.Background(), idp.SignIDToken(""), "")
+		assert.Error(t, err)
+	})
+
+	t.Run("error with token signed by other key", func(t *testing.T) {
+		other := socialtest.NewStubIdP("client-id", "client-secret")
+		defer other.Close()
+		other.Claims = map[string]interface{}{"sub": "123", "iss": idp.Issuer()}
+
+		p := user_social.NewOIDCProvider(user_social.OIDCProviderConfig{
+			Name:     "stub",
+			Issuer:   idp.Issuer(),
+			ClientID: "client-id",
+		})
+
+		_, err := p.VerifyIDToken(context.Background(), other.SignIDToken(""), "")
+		assert.Error(t, err)
+	})
+}
+
+func TestClaimMapping_MapProfile(t *testing.T) {
+	m := user_social.ClaimMapping{
+		Subject: "id",
+		Email:   "email",
+		Picture: "picture.data.url",
+	}
+
+	p, err := m.MapProfile("facebook", map[string]interface{}{
+		"id":    "10",
+		"email": "a@example.com",
+		"picture": map[string]interface{}{
+			"data": map[string]interface{}{"url": "http://example.com/a.png"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "10", p.Subject)
+	assert.Equal(t, "http://example.com/a.png", p.Picture)
+	assert.False(t, p.EmailVerified)
+
+	_, err = m.MapProfile("facebook", map[string]interface{}{})
+	assert.Error(t, err)
+}
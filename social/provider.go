@@ -0,0 +1,159 @@
+package user_social
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// Profile - user data returned by one social login provider
+type Profile struct {
+	Provider string `json:"provider"`
+	// Provider user id
+	Subject       string `json:"subject"`
+	Username      string `json:"username"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"emailVerified"`
+	Name          string `json:"name"`
+	GivenName     string `json:"givenName"`
+	FamilyName    string `json:"familyName"`
+	Picture       string `json:"picture"`
+
+	Raw map[string]interface{} `json:"-"`
+}
+
+// Provider - social login provider, one implementation per protocol and one registry per provider name
+type Provider interface {
+	GetName() string
+	// AuthCodeURL returns the provider authorization page URL used in the web redirect flow
+	AuthCodeURL(redirectURL, state, nonce, codeVerifier string) (string, error)
+	// Exchange changes the authorization code for the provider tokens, codeVerifier is optional
+	Exchange(ctx context.Context, redirectURL, code, codeVerifier string) (*oauth2.Token, error)
+	// GetProfile loads and maps the user profile, the nonce is checked in ID tokens if not empty
+	GetProfile(ctx context.Context, token *oauth2.Token, nonce string) (*Profile, error)
+	// GetRedirectURL returns the configured callback URL, empty to use the default app callback
+	GetRedirectURL() string
+}
+
+// ClaimMapping - profile claim names, nested claims are supported with dots like "picture.data.url"
+type ClaimMapping struct {
+	Subject       string
+	Username      string
+	Email         string
+	EmailVerified string
+	Name          string
+	GivenName     string
+	FamilyName    string
+	Picture       string
+}
+
+// DefaultClaimMapping - OpenID Connect standard claims
+var DefaultClaimMapping = ClaimMapping{
+	Subject:       "sub",
+	Email:         "email",
+	EmailVerified: "email_verified",
+	Name:          "name",
+	GivenName:     "given_name",
+	FamilyName:    "family_name",
+	Picture:       "picture",
+}
+
+// MapProfile builds the profile from the provider claims, the email is only verified with one verified email claim
+func (m *ClaimMapping) MapProfile(provider string, claims map[string]interface{}) (*Profile, error) {
+	p := Profile{
+		Provider:   provider,
+		Subject:    getStringClaim(claims, m.Subject),
+		Username:   getStringClaim(claims, m.Username),
+		Email:      strings.TrimSpace(getStringClaim(claims, m.Email)),
+		Name:       getStringClaim(claims, m.Name),
+		GivenName:  getStringClaim(claims, m.GivenName),
+		FamilyName: getStringClaim(claims, m.FamilyName),
+		Picture:    getStringClaim(claims, m.Picture),
+		Raw:        claims,
+	}
+
+	if p.Subject == "" {
+		return nil, fmt.Errorf("social provider %s: profile without subject", provider)
+	}
+
+	if p.Email != "" {
+		switch v := getClaim(claims, m.EmailVerified).(type) {
+		case bool:
+			p.EmailVerified = v
+		case string:
+			p.EmailVerified = v == "true"
+		}
+	}
+
+	return &p, nil
+}
+
+func getClaim(claims map[string]interface{}, path string) interface{} {
+	if path == "" {
+		return nil
+	}
+
+	var current interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+
+	return current
+}
+
+func getStringClaim(claims map[string]interface{}, path string) string {
+	switch v := getClaim(claims, path).(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case float64:
+		return fmt.Sprintf("%.0f", v)
+	}
+
+	return ""
+}
+
+// fetchJSON gets one JSON document, the token is sent as Bearer if not empty
+func fetchJSON(ctx context.Context, client *http.Client, url, accessToken string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := getHTTPClient(client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+
+	return decoder.Decode(target)
+}
+
+func getHTTPClient(client *http.Client) *http.Client {
+	if client != nil {
+		return client
+	}
+
+	return http.DefaultClient
+}